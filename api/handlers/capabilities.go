@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Key types of the owner, manufacturer and device CA keys the server signs
+// with, by the names protocol.ParseKeyType accepts
+var supportedKeyTypes = []string{"SECP256R1", "SECP384R1", "RSA2048RESTR", "RSAPKCS", "RSAPSS"}
+
+type CapabilitiesResponse struct {
+	KeyTypes []string `json:"key_types"`
+	// TLS 1.2 cipher suites accepted in http.tls.cipher_suites
+	CipherSuites []string `json:"tls_cipher_suites"`
+}
+
+// CapabilitiesHandler responds with the key types and TLS cipher suites
+// supported by the server build, against which configurations can be
+// checked before deploying them
+func CapabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	response := CapabilitiesResponse{KeyTypes: supportedKeyTypes}
+	for _, suite := range tls.CipherSuites() {
+		response.CipherSuites = append(response.CipherSuites, suite.Name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Error encoding capabilities response", "err", err)
+	}
+}
@@ -4,50 +4,246 @@
 package handlers
 
 import (
+	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/fido-device-onboard/go-fdo-server/internal/db"
 	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"gorm.io/gorm"
 )
 
+// MaxDeviceLabelLength is the maximum number of characters in a device label
+const MaxDeviceLabelLength = 128
+
+// DeviceLabel is the body of PUT /api/v1/owner/devices/{guid}/label and of
+// its response
+type DeviceLabel struct {
+	GUID  string `json:"guid,omitempty"`
+	Label string `json:"label"`
+}
+
+// DevicesResponse is the device listing. Devices whose records could not be
+// decoded are left out of Devices and reported in Warnings.
+type DevicesResponse struct {
+	Devices  []db.Device        `json:"devices"`
+	Warnings []db.DeviceWarning `json:"warnings,omitempty"`
+}
+
 // OwnerDevicesHandler returns the list of devices known to the owner service,
-// combining voucher metadata with onboarding (TO2) state.
+// combining voucher metadata with onboarding (TO2) state. Vouchers older
+// than onboardingExpiry whose device has not onboarded are reported as
+// expired, 0 disabling expiry. The devices are listed in a DevicesResponse,
+// with a warning for each row that could not be decoded.
 // Exposed as GET /api/v1/owner/devices.
-func OwnerDevicesHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+func OwnerDevicesHandler(onboardingExpiry time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		slog.Debug("Listing owner devices")
+
+		filters, err := deviceFilters(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+			return
+		}
+
+		devices, warnings, err := db.ListDevices(r.Context(), filters, onboardingExpiry)
+		if err != nil {
+			slog.Error("Error listing devices", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Internal server error")
+			return
+		}
+		for _, warning := range warnings {
+			slog.Warn("Skipping undecodable device", "guid", hex.EncodeToString(warning.GUID), "err", warning.Error)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(DevicesResponse{Devices: devices, Warnings: warnings}); err != nil {
+			slog.Error("Error encoding devices response", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Internal server error")
+			return
+		}
 	}
-	slog.Debug("Listing owner devices")
+}
 
+// deviceFilters returns the ListDevices filters given by the query parameters
+// of a device listing request
+func deviceFilters(r *http.Request) (map[string]interface{}, error) {
 	filters := make(map[string]interface{})
 	if guidHex := r.URL.Query().Get("old_guid"); guidHex != "" {
 		if !utils.IsValidGUID(guidHex) {
-			http.Error(w, "Invalid GUID", http.StatusBadRequest)
-			return
+			return nil, errors.New("Invalid GUID")
 		}
 		decoded, err := hex.DecodeString(guidHex)
 		if err != nil {
-			http.Error(w, "Invalid GUID format", http.StatusBadRequest)
-			return
+			return nil, errors.New("Invalid GUID format")
 		}
 		filters["old_guid"] = decoded
 	}
+	if serial := r.URL.Query().Get("serial_number"); serial != "" {
+		// Devices may report their serial as text or bytes; match either form
+		filters["serial_number"] = utils.SerialQueryCandidates(serial)
+	}
+	if label := r.URL.Query().Get("label"); label != "" {
+		filters["label"] = label
+	}
+	return filters, nil
+}
+
+// deviceExportHeader is the header row of the CSV device inventory
+var deviceExportHeader = []string{"guid", "device", "os", "arch", "serial", "onboarded", "last_onboarded"}
+
+// csvCell returns value as a CSV cell safe to open in a spreadsheet: device
+// reported values starting with a formula character are prefixed with a
+// quote so they are not evaluated.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
 
-	devices, err := db.ListDevices(filters)
+// ExportDevicesHandler returns the device listing as a CSV file, one row per
+// device, for import into asset management tools. The listing filters of
+// OwnerDevicesHandler apply. CSV is the only supported format. Rows are
+// written as they are read from the database.
+// Exposed as GET /api/v1/owner/devices/export.
+func ExportDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	if format := r.URL.Query().Get("format"); format != "" && format != "csv" {
+		writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf("Unsupported export format %q", format))
+		return
+	}
+	filters, err := deviceFilters(r)
 	if err != nil {
-		slog.Error("Error listing devices", "err", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(devices); err != nil {
-		slog.Error("Error encoding devices response", "err", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	// The response starts with the first row, so that a failing query is
+	// still answered with an error
+	out := csv.NewWriter(w)
+	started := false
+	start := func() error {
+		if started {
+			return nil
+		}
+		started = true
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="devices.csv"`)
+		return out.Write(deviceExportHeader)
+	}
+	// Expiry is not part of the export
+	warnings, err := db.ForEachDevice(r.Context(), filters, 0, func(device db.Device) error {
+		if err := start(); err != nil {
+			return err
+		}
+		var lastOnboarded string
+		if device.TO2CompletedAt != nil {
+			lastOnboarded = device.TO2CompletedAt.UTC().Format(time.RFC3339)
+		}
+		return out.Write([]string{
+			hex.EncodeToString(device.GUID),
+			csvCell(device.DeviceInfo),
+			csvCell(device.OS),
+			csvCell(device.Arch),
+			csvCell(device.SerialNumber),
+			strconv.FormatBool(device.TO2Completed),
+			lastOnboarded,
+		})
+	})
+	if err == nil {
+		err = start()
+	}
+	if err != nil {
+		if !started {
+			slog.Error("Error listing devices", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Internal server error")
+			return
+		}
+		// Headers are sent, the client sees a truncated file
+		slog.Error("Error writing device export", "err", err)
+		return
+	}
+	for _, warning := range warnings {
+		slog.Warn("Skipping undecodable device", "guid", hex.EncodeToString(warning.GUID), "err", warning.Error)
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		slog.Error("Error writing device export", "err", err)
+	}
+}
+
+// validateDeviceLabel checks a label is printable text of at most
+// MaxDeviceLabelLength characters
+func validateDeviceLabel(label string) error {
+	if !utf8.ValidString(label) {
+		return errors.New("label must be valid UTF-8")
+	}
+	if n := utf8.RuneCountInString(label); n > MaxDeviceLabelLength {
+		return fmt.Errorf("label must be at most %d characters, got %d", MaxDeviceLabelLength, n)
+	}
+	if strings.ContainsFunc(label, func(r rune) bool { return r < 0x20 || r == 0x7f }) {
+		return errors.New("label must not contain control characters")
+	}
+	return nil
+}
+
+// SetDeviceLabelHandler sets the label of a device, identified by the GUID of
+// its voucher. An empty label removes it.
+// Exposed as PUT /api/v1/owner/devices/{guid}/label.
+func SetDeviceLabelHandler(w http.ResponseWriter, r *http.Request) {
+	guidHex := r.PathValue("guid")
+	if !utils.IsValidGUID(guidHex) {
+		writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, "Invalid GUID")
 		return
 	}
+	guid, err := hex.DecodeString(guidHex)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, "Invalid GUID format")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error reading body")
+		return
+	}
+	var req DeviceLabel
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Invalid JSON body")
+		return
+	}
+	label := strings.TrimSpace(req.Label)
+	if err := validateDeviceLabel(label); err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	if err := db.SetDeviceLabel(r.Context(), guid, label); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeJSONError(w, http.StatusNotFound, ErrorCodeNotFound, "Device not found")
+			return
+		}
+		slog.Error("Error setting device label", "guid", guidHex, "err", err)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Internal server error")
+		return
+	}
+	slog.Debug("Device label set", "guid", guidHex, "label", label)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(DeviceLabel{GUID: guidHex, Label: label}); err != nil {
+		slog.Error("Error encoding device label response", "err", err)
+	}
 }
@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Machine readable codes of API error responses
+const (
+	ErrorCodeInvalidRequest   = "invalid_request"
+	ErrorCodeInvalidGUID      = "invalid_guid"
+	ErrorCodeNotFound         = "not_found"
+	ErrorCodeConflict         = "conflict"
+	ErrorCodeMethodNotAllowed = "method_not_allowed"
+	ErrorCodeNotAcceptable    = "not_acceptable"
+	ErrorCodeInternal         = "internal_error"
+	ErrorCodeUnauthorized     = "unauthorized"
+	ErrorCodeRateLimited      = "rate_limited"
+	ErrorCodeUnavailable      = "unavailable"
+	ErrorCodeTimeout          = "timeout"
+)
+
+// ErrorResponse is the body of API error responses
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes why an API request failed
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeJSONError replies to the request with the given status and an
+// ErrorResponse body. Like http.Error, it does not end the request; the
+// caller should ensure no further writes are done to w.
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ErrorResponse{Error: ErrorDetail{Code: code, Message: message}})
+}
@@ -4,8 +4,12 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"log/slog"
 	"net/http"
+
+	"gorm.io/gorm"
 )
 
 type HealthResponse struct {
@@ -28,3 +32,59 @@ func HealthHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
+
+// ReadinessCheck verifies that a resource the server needs to serve
+// requests, e.g. the source of an FSIM file, is available.
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// DatabaseCheck verifies that the database answers a trivial query
+func DatabaseCheck(state *gorm.DB) ReadinessCheck {
+	return ReadinessCheck{
+		Name: "database",
+		Check: func(ctx context.Context) error {
+			return state.WithContext(ctx).Exec("SELECT 1").Error
+		},
+	}
+}
+
+// Readiness statuses
+const (
+	ReadinessReady    = "ready"
+	ReadinessDegraded = "degraded"
+)
+
+type ReadinessResponse struct {
+	Status string `json:"status"`
+	// Result of each check by name: "ok" or the error
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// ReadinessHandler runs the checks, responding 200 when all pass and 503
+// with status degraded otherwise
+func ReadinessHandler(checks []ReadinessCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := ReadinessResponse{Status: ReadinessReady}
+		status := http.StatusOK
+		for _, check := range checks {
+			if response.Checks == nil {
+				response.Checks = make(map[string]string, len(checks))
+			}
+			if err := check.Check(r.Context()); err != nil {
+				slog.Warn("Readiness check failed", "check", check.Name, "err", err)
+				response.Checks[check.Name] = err.Error()
+				response.Status = ReadinessDegraded
+				status = http.StatusServiceUnavailable
+				continue
+			}
+			response.Checks[check.Name] = "ok"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			slog.Error("Error encoding readiness response", "err", err)
+		}
+	}
+}
@@ -24,7 +24,7 @@ func OwnerInfoHandler(w http.ResponseWriter, r *http.Request) {
 		updateOwnerInfo(w, r)
 	default:
 		slog.Error("Method not allowed", "method", r.Method, "path", r.URL.Path)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
 	}
 }
 
@@ -34,10 +34,10 @@ func getOwnerInfo(w http.ResponseWriter, _ *http.Request) {
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			slog.Error("No ownerInfo found")
-			http.Error(w, "No ownerInfo found", http.StatusNotFound)
+			writeJSONError(w, http.StatusNotFound, ErrorCodeNotFound, "No ownerInfo found")
 		} else {
 			slog.Error("Error fetching ownerInfo", "error", err)
-			http.Error(w, "Error fetching ownerInfo", http.StatusInternalServerError)
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error fetching ownerInfo")
 		}
 		return
 	}
@@ -50,23 +50,23 @@ func createOwnerInfo(w http.ResponseWriter, r *http.Request) {
 	ownerInfo, err := io.ReadAll(r.Body)
 	if err != nil {
 		slog.Error("Error reading body", "error", err)
-		http.Error(w, "Error reading body", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error reading body")
 		return
 	}
 
 	if err := db.InsertOwnerInfo(ownerInfo); err != nil {
 		if errors.Is(err, gorm.ErrDuplicatedKey) {
 			slog.Error("ownerInfo already exists (constraint)", "error", err)
-			http.Error(w, "ownerInfo already exists", http.StatusConflict)
+			writeJSONError(w, http.StatusConflict, ErrorCodeConflict, "ownerInfo already exists")
 			return
 		}
 		if errors.Is(err, db.ErrInvalidOwnerInfo) {
 			slog.Error("Invalid ownerInfo payload", "error", err)
-			http.Error(w, "Invalid ownerInfo", http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
 			return
 		}
 		slog.Error("Error inserting ownerInfo", "error", err)
-		http.Error(w, "Error inserting ownerInfo", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error inserting ownerInfo")
 		return
 	}
 
@@ -81,23 +81,23 @@ func updateOwnerInfo(w http.ResponseWriter, r *http.Request) {
 	ownerInfo, err := io.ReadAll(r.Body)
 	if err != nil {
 		slog.Error("Error reading body", "error", err)
-		http.Error(w, "Error reading body", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error reading body")
 		return
 	}
 
 	if err := db.UpdateOwnerInfo(ownerInfo); err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			slog.Error("ownerInfo does not exist, cannot update")
-			http.Error(w, "ownerInfo does not exist", http.StatusNotFound)
+			writeJSONError(w, http.StatusNotFound, ErrorCodeNotFound, "ownerInfo does not exist")
 			return
 		}
 		if errors.Is(err, db.ErrInvalidOwnerInfo) {
 			slog.Error("Invalid ownerInfo payload", "error", err)
-			http.Error(w, "Invalid ownerInfo", http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
 			return
 		}
 		slog.Error("Error updating ownerInfo", "error", err)
-		http.Error(w, "Error updating ownerInfo", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error updating ownerInfo")
 		return
 	}
 
@@ -0,0 +1,24 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import "context"
+
+// RequestIDHeader carries the ID of a request, kept from the client when
+// valid and generated otherwise, and echoed in the response
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx holding the request ID
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request being served, for use in log
+// records, or "" outside of a request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
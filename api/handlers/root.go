@@ -0,0 +1,42 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/version"
+)
+
+type RootResponse struct {
+	Version string `json:"version"`
+	// Paths of the server's informational endpoints by name
+	Links map[string]string `json:"links"`
+}
+
+// RootHandler responds at the server's root path with its version and the
+// paths of its informational endpoints, or redirects to redirect if set.
+func RootHandler(redirect string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if redirect != "" {
+			http.Redirect(w, r, redirect, http.StatusFound)
+			return
+		}
+		response := RootResponse{
+			Version: version.VERSION,
+			Links: map[string]string{
+				"health":       "/health",
+				"ready":        "/readyz",
+				"version":      "/api/v1/version",
+				"capabilities": "/api/v1/capabilities",
+			},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			slog.Error("Error encoding root response", "err", err)
+		}
+	}
+}
@@ -23,9 +23,13 @@ func RvInfoHandler() http.HandlerFunc {
 			createRvInfo(w, r)
 		case http.MethodPut:
 			updateRvInfo(w, r)
+		case http.MethodPatch:
+			patchRvInfo(w, r)
+		case http.MethodDelete:
+			deleteRvInfo(w, r)
 		default:
 			slog.Error("Method not allowed", "method", r.Method, "path", r.URL.Path)
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeJSONError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method not allowed")
 		}
 	}
 }
@@ -36,10 +40,10 @@ func getRvInfo(w http.ResponseWriter, _ *http.Request) {
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			slog.Error("No rvInfo found")
-			http.Error(w, "No rvInfo found", http.StatusNotFound)
+			writeJSONError(w, http.StatusNotFound, ErrorCodeNotFound, "No rvInfo found")
 		} else {
 			slog.Error("Error fetching rvInfo", "error", err)
-			http.Error(w, "Error fetching rvInfo", http.StatusInternalServerError)
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error fetching rvInfo")
 		}
 		return
 	}
@@ -52,23 +56,23 @@ func createRvInfo(w http.ResponseWriter, r *http.Request) {
 	rvInfo, err := io.ReadAll(r.Body)
 	if err != nil {
 		slog.Error("Error reading body", "error", err)
-		http.Error(w, "Error reading body", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error reading body")
 		return
 	}
 
 	if err := db.InsertRvInfo(rvInfo); err != nil {
 		if errors.Is(err, gorm.ErrDuplicatedKey) {
 			slog.Error("rvInfo already exists (constraint)", "error", err)
-			http.Error(w, "rvInfo already exists", http.StatusConflict)
+			writeJSONError(w, http.StatusConflict, ErrorCodeConflict, "rvInfo already exists")
 			return
 		}
 		if errors.Is(err, db.ErrInvalidRvInfo) {
 			slog.Error("Invalid rvInfo payload", "error", err)
-			http.Error(w, "Invalid rvInfo", http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
 			return
 		}
 		slog.Error("Error inserting rvInfo", "error", err)
-		http.Error(w, "Error inserting rvInfo", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error inserting rvInfo")
 		return
 	}
 
@@ -83,23 +87,23 @@ func updateRvInfo(w http.ResponseWriter, r *http.Request) {
 	rvInfo, err := io.ReadAll(r.Body)
 	if err != nil {
 		slog.Error("Error reading body", "error", err)
-		http.Error(w, "Error reading body", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error reading body")
 		return
 	}
 
 	if err := db.UpdateRvInfo(rvInfo); err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			slog.Error("rvInfo does not exist, cannot update")
-			http.Error(w, "rvInfo does not exist", http.StatusNotFound)
+			writeJSONError(w, http.StatusNotFound, ErrorCodeNotFound, "rvInfo does not exist")
 			return
 		}
 		if errors.Is(err, db.ErrInvalidRvInfo) {
 			slog.Error("Invalid rvInfo payload", "error", err)
-			http.Error(w, "Invalid rvInfo", http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
 			return
 		}
 		slog.Error("Error updating rvInfo", "error", err)
-		http.Error(w, "Error updating rvInfo", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error updating rvInfo")
 		return
 	}
 
@@ -108,3 +112,53 @@ func updateRvInfo(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(rvInfo)
 }
+
+// patchRvInfo merges the directives of the request into the stored rvinfo,
+// see db.PatchRvInfo, and returns the merged rvinfo
+func patchRvInfo(w http.ResponseWriter, r *http.Request) {
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		slog.Error("Error reading body", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error reading body")
+		return
+	}
+
+	rvInfo, err := db.PatchRvInfo(patch)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			slog.Error("rvInfo does not exist, cannot patch")
+			writeJSONError(w, http.StatusNotFound, ErrorCodeNotFound, "rvInfo does not exist")
+			return
+		}
+		if errors.Is(err, db.ErrInvalidRvInfo) {
+			slog.Error("Invalid rvInfo patch", "error", err)
+			writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+			return
+		}
+		slog.Error("Error patching rvInfo", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error patching rvInfo")
+		return
+	}
+
+	slog.Debug("rvInfo patched")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(rvInfo)
+}
+
+func deleteRvInfo(w http.ResponseWriter, _ *http.Request) {
+	if err := db.DeleteRvInfo(); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			slog.Error("rvInfo does not exist, cannot delete")
+			writeJSONError(w, http.StatusNotFound, ErrorCodeNotFound, "rvInfo does not exist")
+			return
+		}
+		slog.Error("Error deleting rvInfo", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error deleting rvInfo")
+		return
+	}
+
+	slog.Debug("rvInfo deleted")
+
+	w.WriteHeader(http.StatusNoContent)
+}
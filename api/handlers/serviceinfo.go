@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// FSIMCapability describes a service info module the owner performs during
+// TO2. Only high-level parameters are reported: message values and URL
+// credentials are never included.
+type FSIMCapability struct {
+	Module   string   `json:"module"`
+	Files    []string `json:"files,omitempty"`    // file names (download, upload, wget)
+	URLs     []string `json:"urls,omitempty"`     // wget URLs without credentials or query
+	Commands []string `json:"commands,omitempty"` // commands run by fdo.command
+	Messages []string `json:"messages,omitempty"` // message names of raw modules
+}
+
+// ServiceInfoOverrideCapability describes the modules performed for devices
+// matching a per-device override
+type ServiceInfoOverrideCapability struct {
+	Device string           `json:"device,omitempty"`
+	GUIDs  []string         `json:"guids,omitempty"`
+	FSIMs  []FSIMCapability `json:"fsims"`
+}
+
+// ServiceInfoCapabilities lists the service info modules the owner performs
+type ServiceInfoCapabilities struct {
+	FSIMs     []FSIMCapability                `json:"fsims"`
+	Overrides []ServiceInfoOverrideCapability `json:"overrides,omitempty"`
+}
+
+// ServiceInfoCapabilitiesHandler reports the service info modules derived
+// from the effective configuration. The capabilities function is called on
+// every request so reloaded configuration is reflected.
+// Exposed as GET /api/v1/owner/service-info/capabilities.
+func ServiceInfoCapabilitiesHandler(capabilities func() ServiceInfoCapabilities) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(capabilities()); err != nil {
+			slog.Error("Error encoding service info capabilities", "err", err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// StatsResponse reports the owner's vouchers and those needing attention
+type StatsResponse struct {
+	Vouchers int64 `json:"vouchers"`
+	// Vouchers not registered with a rendezvous server by TO0 within the
+	// configured delay of their insertion: devices relying on TO1 cannot
+	// find this owner
+	NeedsRegistration []db.Voucher `json:"needs_registration"`
+	Warnings          []string     `json:"warnings,omitempty"`
+}
+
+// StatsHandler reports voucher statistics, listing the vouchers which still
+// need TO0 registration registrationDelay after being inserted.
+// Exposed as GET /api/v1/stats.
+func StatsHandler(registrationDelay time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		total, err := db.CountVouchers(r.Context(), time.Time{})
+		if err != nil {
+			slog.Error("Error counting vouchers", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Internal server error")
+			return
+		}
+		unregistered, err := db.ListUnregisteredVouchers(r.Context(), time.Now().Add(-registrationDelay))
+		if err != nil {
+			slog.Error("Error listing unregistered vouchers", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Internal server error")
+			return
+		}
+
+		response := StatsResponse{Vouchers: total, NeedsRegistration: []db.Voucher{}}
+		for _, voucher := range unregistered {
+			var ov fdo.Voucher
+			if err := cbor.Unmarshal(voucher.CBOR, &ov); err != nil {
+				slog.Warn("Skipping undecodable voucher", "guid", hex.EncodeToString(voucher.GUID), "err", err)
+				continue
+			}
+			if !needsTO0(ov.Header.Val.RvInfo) {
+				continue
+			}
+			voucher.CBOR = nil
+			response.NeedsRegistration = append(response.NeedsRegistration, voucher)
+		}
+		if n := len(response.NeedsRegistration); n > 0 {
+			response.Warnings = append(response.Warnings, fmt.Sprintf("%d voucher(s) not registered with a rendezvous server: devices relying on TO1 cannot find this owner", n))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			slog.Error("Error encoding stats response", "err", err)
+		}
+	}
+}
+
+// needsTO0 reports whether a device with the given rendezvous info finds its
+// owner through a rendezvous server, rather than contacting it directly
+func needsTO0(rvInfo [][]protocol.RvInstruction) bool {
+	for _, directive := range protocol.ParseOwnerRvInfo(rvInfo) {
+		if !directive.Bypass && len(directive.URLs) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DirectoryUsage reports the disk usage of a directory tree
+type DirectoryUsage struct {
+	Directory  string    `json:"directory"`
+	TotalBytes int64     `json:"total_bytes"`
+	FileCount  int       `json:"file_count"`
+	Truncated  bool      `json:"truncated"` // the walk stopped at the file limit
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+const (
+	// Stop counting after this many files so a huge directory cannot stall the API
+	uploadStatsMaxFiles = 100000
+	// Reuse a computed result for this long
+	uploadStatsCacheTTL = 30 * time.Second
+)
+
+var errWalkLimit = errors.New("file limit reached")
+
+// directoryUsage sums the size of the regular files below dir, stopping
+// after maxFiles files.
+func directoryUsage(dir string, maxFiles int) (DirectoryUsage, error) {
+	usage := DirectoryUsage{Directory: dir}
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if usage.FileCount >= maxFiles {
+			usage.Truncated = true
+			return errWalkLimit
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		usage.FileCount++
+		usage.TotalBytes += info.Size()
+		return nil
+	})
+	if err != nil && !errors.Is(err, errWalkLimit) {
+		return DirectoryUsage{}, err
+	}
+	usage.ComputedAt = time.Now()
+	return usage, nil
+}
+
+// UploadStatsHandler reports the total size and file count of the FSIM
+// upload directory. Results are cached briefly to keep repeated polling cheap.
+// Exposed as GET /api/v1/owner/uploads/stats.
+func UploadStatsHandler(dir string) http.HandlerFunc {
+	var (
+		mu     sync.Mutex
+		cached *DirectoryUsage
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if cached == nil || time.Since(cached.ComputedAt) > uploadStatsCacheTTL {
+			usage, err := directoryUsage(dir, uploadStatsMaxFiles)
+			if err != nil {
+				mu.Unlock()
+				slog.Error("Error computing upload directory usage", "dir", dir, "err", err)
+				writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Internal server error")
+				return
+			}
+			cached = &usage
+		}
+		usage := *cached
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(usage); err != nil {
+			slog.Error("Error encoding upload stats response", "err", err)
+		}
+	}
+}
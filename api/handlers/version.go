@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/version"
+)
+
+// FDO protocol versions implemented by the server
+var supportedProtocolVersions = []int{101}
+
+type VersionResponse struct {
+	Version          string `json:"version"`
+	Commit           string `json:"commit"`
+	BuildDate        string `json:"build_date"`
+	GoVersion        string `json:"go_version"`
+	ProtocolVersions []int  `json:"fdo_protocol_versions"`
+}
+
+// VersionHandler responds with the server build information
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	response := VersionResponse{
+		Version:          version.VERSION,
+		Commit:           version.COMMIT,
+		BuildDate:        version.BUILD_DATE,
+		GoVersion:        runtime.Version(),
+		ProtocolVersions: supportedProtocolVersions,
+	}
+	// Fall back on the VCS information recorded by the Go toolchain
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && response.Commit == "":
+				response.Commit = setting.Value
+			case setting.Key == "vcs.time" && response.BuildDate == "":
+				response.BuildDate = setting.Value
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Error encoding version response", "err", err)
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"bytes"
 	"context"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
@@ -17,6 +20,8 @@ import (
 	"log/slog"
 	"net/http"
 	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fido-device-onboard/go-fdo"
@@ -27,6 +32,10 @@ import (
 	"gorm.io/gorm"
 )
 
+// GetVoucherHandler lists vouchers, optionally filtered by GUID or device
+// info. JSON summaries are returned unless the Accept header prefers
+// application/cbor, in which case the full vouchers are returned as a CBOR
+// array.
 func GetVoucherHandler(w http.ResponseWriter, r *http.Request) {
 	guidHex := r.URL.Query().Get("guid")
 	deviceInfo := r.URL.Query().Get("device_info")
@@ -35,13 +44,13 @@ func GetVoucherHandler(w http.ResponseWriter, r *http.Request) {
 
 	if guidHex != "" {
 		if !utils.IsValidGUID(guidHex) {
-			http.Error(w, fmt.Sprintf("Invalid GUID: %s", guidHex), http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, fmt.Sprintf("Invalid GUID: %s", guidHex))
 			return
 		}
 
 		guid, err := hex.DecodeString(guidHex)
 		if err != nil {
-			http.Error(w, "Invalid GUID format", http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, "Invalid GUID format")
 			return
 		}
 		filters["guid"] = guid
@@ -51,45 +60,252 @@ func GetVoucherHandler(w http.ResponseWriter, r *http.Request) {
 		filters["device_info"] = deviceInfo
 	}
 
-	vouchers, err := db.QueryVouchers(filters, false)
+	mediaType := preferredMediaType(r, "application/json", "application/cbor")
+	if mediaType == "" {
+		writeJSONError(w, http.StatusNotAcceptable, ErrorCodeNotAcceptable, "Supported media types are application/json and application/cbor")
+		return
+	}
+
+	vouchers, err := db.QueryVouchers(r.Context(), filters, mediaType == "application/cbor")
 	if err != nil {
 		slog.Debug("Error querying vouchers", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Vary", "Accept")
+	if mediaType == "application/cbor" {
+		// A CBOR array of the vouchers, each as stored
+		ovs := make([]cbor.RawBytes, len(vouchers))
+		for i, voucher := range vouchers {
+			ovs[i] = voucher.CBOR
+		}
+		body, err := cbor.Marshal(ovs)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/cbor")
+		_, _ = w.Write(body)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(vouchers); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
 	}
 }
 
+// preferredMediaType returns the offer the request's Accept header gives the
+// highest quality, preferring earlier offers on ties, or "" if none of the
+// offers is acceptable. A request without Accept header accepts the first
+// offer.
+func preferredMediaType(r *http.Request, offers ...string) string {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return offers[0]
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		// The most specific matching media range sets the quality
+		q, specificity := 0.0, -1
+		for _, header := range accept {
+			for _, mediaRange := range strings.Split(header, ",") {
+				typ, params, _ := strings.Cut(mediaRange, ";")
+				typ = strings.ToLower(strings.TrimSpace(typ))
+				var s int
+				switch {
+				case typ == offer:
+					s = 2
+				case typ == "*/*":
+					s = 0
+				case strings.HasSuffix(typ, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(typ, "*")):
+					s = 1
+				default:
+					continue
+				}
+				if s <= specificity {
+					continue
+				}
+				specificity, q = s, 1.0
+				for _, param := range strings.Split(params, ";") {
+					name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+					if strings.EqualFold(name, "q") {
+						if v, err := strconv.ParseFloat(value, 64); err == nil {
+							q = v
+						}
+					}
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
 // GetVoucherByGUIDHandler returns a PEM-encoded voucher by path GUID.
 func GetVoucherByGUIDHandler(w http.ResponseWriter, r *http.Request) {
 	guidHex := r.PathValue("guid")
 	if !utils.IsValidGUID(guidHex) {
-		http.Error(w, "Invalid GUID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, "Invalid GUID")
 		return
 	}
 	guid, err := hex.DecodeString(guidHex)
 	if err != nil {
-		http.Error(w, "Invalid GUID format", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, "Invalid GUID format")
 		return
 	}
-	voucher, err := db.FetchVoucher(map[string]interface{}{"guid": guid})
+	voucher, err := db.FetchVoucher(r.Context(), map[string]interface{}{"guid": guid})
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			http.Error(w, "Voucher not found", http.StatusNotFound)
+			writeJSONError(w, http.StatusNotFound, ErrorCodeNotFound, "Voucher not found")
 			return
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
 		return
 	}
 	w.Header().Set("Content-Type", "application/x-pem-file")
 	if err := pem.Encode(w, &pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: voucher.CBOR}); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		return
+	}
+}
+
+// GetDeviceMfgInfoHandler returns the manufacturing info the device of the
+// path GUID sent in DI, saved when manufacturing.store_mfg_info is set.
+// Exposed as GET /api/v1/vouchers/{guid}/mfginfo.
+func GetDeviceMfgInfoHandler(w http.ResponseWriter, r *http.Request) {
+	guidHex := r.PathValue("guid")
+	if !utils.IsValidGUID(guidHex) {
+		writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, "Invalid GUID")
+		return
+	}
+	guid, err := hex.DecodeString(guidHex)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, "Invalid GUID format")
+		return
+	}
+	info, err := db.FetchDeviceMfgInfo(r.Context(), guid)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeJSONError(w, http.StatusNotFound, ErrorCodeNotFound, "Device manufacturing info not found")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		slog.Error("Error encoding device manufacturing info", "err", err)
+	}
+}
+
+// DeleteVoucherHandler removes a voucher by path GUID, responding 204 on
+// success and 404 if no such voucher exists.
+// Exposed as DELETE /api/v1/owner/vouchers/{guid}.
+func DeleteVoucherHandler(vouchers fdo.VoucherReseller) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		guidHex := r.PathValue("guid")
+		if !utils.IsValidGUID(guidHex) {
+			writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, "Invalid GUID")
+			return
+		}
+		guidBytes, err := hex.DecodeString(guidHex)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, "Invalid GUID format")
+			return
+		}
+		var guid protocol.GUID
+		copy(guid[:], guidBytes)
+
+		if _, err := vouchers.RemoveVoucher(r.Context(), guid); err != nil {
+			if errors.Is(err, fdo.ErrNotFound) {
+				writeJSONError(w, http.StatusNotFound, ErrorCodeNotFound, "Voucher not found")
+				return
+			}
+			slog.Error("Error removing voucher", "guid", guidHex, "err", err)
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Internal server error")
+			return
+		}
+		slog.Debug("Voucher removed", "guid", guidHex)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetArchivedVouchersHandler lists the vouchers archived on deletion, when
+// owner.archive_deleted_vouchers is set, most recently archived first.
+// Exposed as GET /api/v1/owner/vouchers/archive.
+func GetArchivedVouchersHandler(w http.ResponseWriter, r *http.Request) {
+	vouchers, err := db.QueryArchivedVouchers(r.Context(), false)
+	if err != nil {
+		slog.Error("Error querying archived vouchers", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Internal server error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(vouchers); err != nil {
+		slog.Error("Error encoding archived vouchers", "err", err)
+	}
+}
+
+// GetArchivedVoucherHandler returns a PEM-encoded archived voucher by path
+// GUID.
+// Exposed as GET /api/v1/owner/vouchers/archive/{guid}.
+func GetArchivedVoucherHandler(w http.ResponseWriter, r *http.Request) {
+	guidHex := r.PathValue("guid")
+	if !utils.IsValidGUID(guidHex) {
+		writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, "Invalid GUID")
+		return
+	}
+	guid, err := hex.DecodeString(guidHex)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, "Invalid GUID format")
+		return
+	}
+	voucher, err := db.FetchArchivedVoucher(r.Context(), guid)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeJSONError(w, http.StatusNotFound, ErrorCodeNotFound, "Archived voucher not found")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	if err := pem.Encode(w, &pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: voucher.CBOR}); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		return
+	}
+}
+
+// ApproveVoucherHandler releases a quarantined voucher by path GUID so that
+// its device may onboard, responding 204 on success and 404 if no such
+// voucher exists.
+// Exposed as POST /api/v1/owner/vouchers/{guid}/approve.
+func ApproveVoucherHandler(w http.ResponseWriter, r *http.Request) {
+	guidHex := r.PathValue("guid")
+	if !utils.IsValidGUID(guidHex) {
+		writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, "Invalid GUID")
+		return
+	}
+	guid, err := hex.DecodeString(guidHex)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, "Invalid GUID format")
 		return
 	}
+	if err := db.ApproveVoucher(r.Context(), guid); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeJSONError(w, http.StatusNotFound, ErrorCodeNotFound, "Voucher not found")
+			return
+		}
+		slog.Error("Error approving voucher", "guid", guidHex, "err", err)
+		writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Internal server error")
+		return
+	}
+	slog.Info("Voucher approved for onboarding", "guid", guidHex)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // VerifyVoucherOwnership verifies the ownership voucher belongs to this owner.
@@ -119,7 +335,10 @@ func VerifyVoucherOwnership(ov *fdo.Voucher, ownerPKeys []crypto.PublicKey) erro
 //   - HMAC verification (ov.VerifyHeader): Owner server does not have the device HMAC secret
 //   - Manufacturer key hash verification (ov.VerifyManufacturerKey): Requires trusted manufacturer
 //     key hashes to be configured (owner server has no source for these hashes)
-func VerifyOwnershipVoucher(ov *fdo.Voucher) error {
+//
+// Certificate validity windows are checked allowing for a clock skew of up to
+// clockSkew between this server and the issuer of the certificates.
+func VerifyOwnershipVoucher(ov *fdo.Voucher, clockSkew time.Duration) error {
 	// TODO: Investigate whether protocol version should be verified for all messages received by the server
 	// and whether FDOProtocolVersion const should be moved to a common package (e.g., api package)
 	const FDOProtocolVersion uint16 = 101 // FDO spec v1.1
@@ -154,81 +373,166 @@ func VerifyOwnershipVoucher(ov *fdo.Voucher) error {
 	if err := ov.VerifyCertChainHash(); err != nil {
 		return fmt.Errorf("device certificate chain hash verification failed: %w", err)
 	}
-	if err := ov.VerifyDeviceCertChain(nil); err != nil {
-		return fmt.Errorf("device certificate chain verification failed: %w", err)
+	if ov.CertChain != nil {
+		chain := make([]*x509.Certificate, len(*ov.CertChain))
+		for i, cert := range *ov.CertChain {
+			chain[i] = (*x509.Certificate)(cert)
+		}
+		if err := verifyCertChain(chain, clockSkew); err != nil {
+			return fmt.Errorf("device certificate chain verification failed: %w", err)
+		}
+	}
+	mfgChain, err := ov.Header.Val.ManufacturerKey.Chain()
+	if err != nil {
+		return fmt.Errorf("manufacturer certificate chain verification failed: error parsing manufacturer public key: %w", err)
 	}
-	if err := ov.VerifyManufacturerCertChain(nil); err != nil {
-		return fmt.Errorf("manufacturer certificate chain verification failed: %w", err)
+	if mfgChain != nil {
+		if err := verifyCertChain(mfgChain, clockSkew); err != nil {
+			return fmt.Errorf("manufacturer certificate chain verification failed: %w", err)
+		}
 	}
 
 	return nil
 }
 
+// verifyCertChain verifies a certificate chain, implicitly trusting its last
+// certificate as go-fdo does. Unlike go-fdo, the certificates are also
+// accepted if valid at a time up to clockSkew before or after now.
+func verifyCertChain(chain []*x509.Certificate, clockSkew time.Duration) error {
+	if len(chain) == 0 {
+		return errors.New("empty cert chain")
+	}
+	intermediates := x509.NewCertPool()
+	if len(chain) > 2 {
+		for _, cert := range chain[1 : len(chain)-1] {
+			intermediates.AddCert(cert)
+		}
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(chain[len(chain)-1])
+
+	now := time.Now()
+	times := []time.Time{now}
+	if clockSkew > 0 {
+		times = append(times, now.Add(clockSkew), now.Add(-clockSkew))
+	}
+	var verifyErr error
+	for _, t := range times {
+		_, err := chain[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   t,
+		})
+		if err == nil {
+			return nil
+		}
+		if verifyErr == nil {
+			verifyErr = err
+		}
+	}
+	return fmt.Errorf("%w: %w", fdo.ErrCryptoVerifyFailed, verifyErr)
+}
+
 // VerifyVoucher performs comprehensive verification of an ownership voucher
 // as per FDO spec section 3.4.6. This combines both ownership and integrity checks.
-func VerifyVoucher(ov *fdo.Voucher, ownerPKeys []crypto.PublicKey) error {
+func VerifyVoucher(ov *fdo.Voucher, ownerPKeys []crypto.PublicKey, clockSkew time.Duration) error {
 	if err := VerifyVoucherOwnership(ov, ownerPKeys); err != nil {
 		return err
 	}
 
-	if err := VerifyOwnershipVoucher(ov); err != nil {
+	if err := VerifyOwnershipVoucher(ov, clockSkew); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// InsertVoucherHandler verifies and inserts vouchers. Background TO0 is handled by the owner server.
-func InsertVoucherHandler(ownerPKeys []crypto.PublicKey) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			http.Error(w, "Failure to read the request body", http.StatusInternalServerError)
-			return
+// Errors returned by InsertVouchers for invalid content
+var (
+	ErrVoucherPEM     = errors.New("unable to decode PEM content")
+	ErrVoucherCBOR    = errors.New("unable to decode cbor")
+	ErrVoucherInvalid = errors.New("invalid ownership voucher")
+)
+
+// InsertVouchers verifies and inserts the vouchers of PEM encoded data,
+// returning the number of vouchers inserted. Vouchers whose GUID is already
+// known are skipped. With quarantine set, new vouchers are quarantined:
+// their device may not onboard until approved with ApproveVoucherHandler.
+// Certificates are verified allowing for up to clockSkew between clocks.
+//
+// Vouchers are inserted one at a time, those preceding an invalid one stay
+// inserted.
+func InsertVouchers(ctx context.Context, data []byte, ownerPKeys []crypto.PublicKey, quarantine bool, clockSkew time.Duration) (int, error) {
+	inserted := 0
+	block, rest := pem.Decode(data)
+	for ; block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "OWNERSHIP VOUCHER" {
+			slog.Debug("Got unknown label type", "type", block.Type)
+			continue
+		}
+		var ov fdo.Voucher
+		if err := cbor.Unmarshal(block.Bytes, &ov); err != nil {
+			slog.Debug("Unable to decode cbor", "block", block.Bytes)
+			return inserted, fmt.Errorf("%w: %v", ErrVoucherCBOR, err)
 		}
 
-		block, rest := pem.Decode(body)
-		for ; block != nil; block, rest = pem.Decode(rest) {
-			if block.Type != "OWNERSHIP VOUCHER" {
-				slog.Debug("Got unknown label type", "type", block.Type)
+		// Ov Verification
+		if err := VerifyVoucher(&ov, ownerPKeys, clockSkew); err != nil {
+			slog.Error("Ownership voucher verification failed", "guid", ov.Header.Val.GUID[:], "err", err)
+			return inserted, fmt.Errorf("%w %x: %v", ErrVoucherInvalid, ov.Header.Val.GUID[:], err)
+		}
+
+		// Check for duplicate vouchers in database
+		if dbOv, err := db.FetchVoucher(ctx, map[string]interface{}{"guid": ov.Header.Val.GUID[:]}); err == nil {
+			if bytes.Equal(block.Bytes, dbOv.CBOR) {
+				slog.Debug("Voucher already exists", "guid", ov.Header.Val.GUID[:])
 				continue
 			}
-			var ov fdo.Voucher
-			if err := cbor.Unmarshal(block.Bytes, &ov); err != nil {
-				slog.Debug("Unable to decode cbor", "block", block.Bytes)
-				http.Error(w, "Unable to decode cbor", http.StatusBadRequest)
-				return
-			}
+			slog.Debug("Voucher guid already exists. not overwriting it", "guid", ov.Header.Val.GUID[:])
+			continue
+		}
 
-			// Ov Verification
-			if err := VerifyVoucher(&ov, ownerPKeys); err != nil {
-				slog.Error("Ownership voucher verification failed", "guid", ov.Header.Val.GUID[:], "err", err)
-				http.Error(w, "Invalid ownership voucher", http.StatusBadRequest)
-				return
-			}
+		// Insert voucher into database
+		slog.Debug("Inserting voucher", "GUID", ov.Header.Val.GUID)
 
-			// Check for duplicate vouchers in database
-			if dbOv, err := db.FetchVoucher(map[string]interface{}{"guid": ov.Header.Val.GUID[:]}); err == nil {
-				if bytes.Equal(block.Bytes, dbOv.CBOR) {
-					slog.Debug("Voucher already exists", "guid", ov.Header.Val.GUID[:])
-					continue
-				}
-				slog.Debug("Voucher guid already exists. not overwriting it", "guid", ov.Header.Val.GUID[:])
-				continue
-			}
+		insert := db.InsertVoucher
+		if quarantine {
+			insert = db.InsertQuarantinedVoucher
+		}
+		if err := insert(db.Voucher{GUID: ov.Header.Val.GUID[:], CBOR: block.Bytes, DeviceInfo: ov.Header.Val.DeviceInfo, CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
+			slog.Debug("Error inserting into database", "error", err.Error())
+			return inserted, err
+		}
+		inserted++
+	}
 
-			// Insert voucher into database
-			slog.Debug("Inserting voucher", "GUID", ov.Header.Val.GUID)
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return inserted, ErrVoucherPEM
+	}
+	return inserted, nil
+}
 
-			if err := db.InsertVoucher(db.Voucher{GUID: ov.Header.Val.GUID[:], CBOR: block.Bytes, DeviceInfo: ov.Header.Val.DeviceInfo, CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
-				slog.Debug("Error inserting into database", "error", err.Error())
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-				return
-			}
+// InsertVoucherHandler verifies and inserts vouchers with InsertVouchers.
+// Background TO0 is handled by the owner server.
+func InsertVoucherHandler(ownerPKeys []crypto.PublicKey, quarantine bool, clockSkew time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Failure to read the request body")
+			return
 		}
 
-		if len(bytes.TrimSpace(rest)) > 0 {
-			http.Error(w, "Unable to decode PEM content", http.StatusBadRequest)
+		if _, err := InsertVouchers(r.Context(), body, ownerPKeys, quarantine, clockSkew); err != nil {
+			switch {
+			case errors.Is(err, ErrVoucherCBOR):
+				writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Unable to decode cbor")
+			case errors.Is(err, ErrVoucherInvalid):
+				writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Invalid ownership voucher")
+			case errors.Is(err, ErrVoucherPEM):
+				writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Unable to decode PEM content")
+			default:
+				writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Internal server error")
+			}
 			return
 		}
 
@@ -237,18 +541,88 @@ func InsertVoucherHandler(ownerPKeys []crypto.PublicKey) http.HandlerFunc {
 	}
 }
 
+// parseNextOwner parses the PEM encoded next owner of a voucher: either a
+// PKIX public key, or a certificate chain starting with the certificate of
+// the owner key. The chain is returned as a []*x509.Certificate.
+func parseNextOwner(body []byte) (crypto.PublicKey, error) {
+	block, rest := pem.Decode(body)
+	if block == nil {
+		return nil, errors.New("request body must be the PEM encoded public key or certificate chain of the next owner")
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing x.509 public key: %w", err)
+		}
+		return pub, nil
+	case "CERTIFICATE":
+		var chain []*x509.Certificate
+		for ; block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				return nil, fmt.Errorf("unexpected PEM block %q in the certificate chain", block.Type)
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing x.509 certificate: %w", err)
+			}
+			chain = append(chain, cert)
+		}
+		return chain, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q: must be PUBLIC KEY or CERTIFICATE", block.Type)
+	}
+}
+
+// checkNextOwnerKey returns an error unless the next owner key, or that of
+// the first certificate of its chain, can be placed in a voucher whose owner
+// keys are of keyType
+func checkNextOwnerKey(keyType protocol.KeyType, nextOwner crypto.PublicKey) error {
+	key := nextOwner
+	if chain, ok := nextOwner.([]*x509.Certificate); ok {
+		key = chain[0].PublicKey
+	}
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		curves := map[protocol.KeyType]elliptic.Curve{
+			protocol.Secp256r1KeyType: elliptic.P256(),
+			protocol.Secp384r1KeyType: elliptic.P384(),
+		}
+		if curves[keyType] != key.Curve {
+			return fmt.Errorf("EC key on curve %s cannot replace a %s key", key.Curve.Params().Name, keyType)
+		}
+	case *rsa.PublicKey:
+		switch keyType {
+		case protocol.Rsa2048RestrKeyType:
+			if key.N.BitLen() != 2048 {
+				return fmt.Errorf("%d bit RSA key cannot replace a %s key", key.N.BitLen(), keyType)
+			}
+		case protocol.RsaPkcsKeyType, protocol.RsaPssKeyType:
+		default:
+			return fmt.Errorf("RSA key cannot replace a %s key", keyType)
+		}
+	default:
+		return fmt.Errorf("key type %T: must be ECDSA or RSA", key)
+	}
+	return nil
+}
+
+// ResellHandler extends the voucher of a device to the next owner, whose
+// PEM encoded public key or certificate chain is the request body, and
+// removes it from this owner. The extended voucher is returned PEM encoded, to be sent to the
+// next owner.
 func ResellHandler(to2Server *fdo.TO2Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		guidHex := r.PathValue("guid")
 
 		if !utils.IsValidGUID(guidHex) {
-			http.Error(w, "GUID is not a valid GUID", http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, "GUID is not a valid GUID")
 			return
 		}
 
 		guidBytes, err := hex.DecodeString(guidHex)
 		if err != nil {
-			http.Error(w, "Invalid GUID format", http.StatusBadRequest)
+			writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidGUID, "Invalid GUID format")
 			slog.Debug(err.Error())
 			return
 		}
@@ -258,30 +632,41 @@ func ResellHandler(to2Server *fdo.TO2Server) http.HandlerFunc {
 
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, "Failure to read the request body", http.StatusInternalServerError)
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Failure to read the request body")
 			slog.Debug(err.Error())
 			return
 		}
-		blk, _ := pem.Decode(body)
-		if blk == nil {
-			http.Error(w, "Invalid PEM content", http.StatusInternalServerError)
-			return
-		}
-		nextOwner, err := x509.ParsePKIXPublicKey(blk.Bytes)
+		nextOwner, err := parseNextOwner(body)
 		if err != nil {
-			http.Error(w, "Error parsing x.509 public key", http.StatusInternalServerError)
-			slog.Debug(err.Error())
+			writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
 			return
 		}
 
 		// Get the underlying *db.State to access the *gorm.DB for transactions
 		state, ok := to2Server.VouchersForExtension.(*db.State)
 		if !ok {
-			http.Error(w, "Internal server error: invalid state type", http.StatusInternalServerError)
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Internal server error: invalid state type")
 			slog.Error("VouchersForExtension is not *db.State", "type", fmt.Sprintf("%T", to2Server.VouchersForExtension))
 			return
 		}
 
+		// The next owner key must be of the type of the voucher's owner keys,
+		// which is only known once the voucher is found
+		ov, err := state.Voucher(r.Context(), guid)
+		if errors.Is(err, fdo.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, ErrorCodeNotFound, "Voucher not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error fetching voucher")
+			slog.Debug(err.Error())
+			return
+		}
+		if err := checkNextOwnerKey(ov.Header.Val.ManufacturerKey.Type, nextOwner); err != nil {
+			writeJSONError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "Unsupported next owner key: "+err.Error())
+			return
+		}
+
 		// Wrap Resell in a transaction to ensure atomicity
 		// If Resell fails after RemoveVoucher, the transaction will rollback
 		// and restore the original voucher
@@ -296,12 +681,17 @@ func ResellHandler(to2Server *fdo.TO2Server) http.HandlerFunc {
 
 			// Call Resell on the copy - it will use the transactional wrapper
 			var resellErr error
-			extended, resellErr = txTO2Server.Resell(context.TODO(), guid, nextOwner, nil)
+			extended, resellErr = txTO2Server.Resell(r.Context(), guid, nextOwner, nil)
 			return resellErr
 		})
 
+		if errors.Is(err, fdo.ErrNotFound) {
+			// Resold by a concurrent request
+			writeJSONError(w, http.StatusNotFound, ErrorCodeNotFound, "Voucher not found")
+			return
+		}
 		if err != nil {
-			http.Error(w, "Error reselling voucher", http.StatusInternalServerError)
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error reselling voucher")
 			slog.Debug("Resell failed", "error", err)
 			// Transaction already rolled back, restoring the original voucher
 			// No need to manually add it back
@@ -310,7 +700,7 @@ func ResellHandler(to2Server *fdo.TO2Server) http.HandlerFunc {
 
 		ovBytes, err := cbor.Marshal(extended)
 		if err != nil {
-			http.Error(w, "Error marshaling voucher", http.StatusInternalServerError)
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, "Error marshaling voucher")
 			slog.Debug(err.Error())
 			return
 		}
@@ -321,7 +711,7 @@ func ResellHandler(to2Server *fdo.TO2Server) http.HandlerFunc {
 			Bytes: ovBytes,
 		}); err != nil {
 			slog.Debug("Error encoding voucher", "error", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeJSONError(w, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
 			return
 		}
 	}
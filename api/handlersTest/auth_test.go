@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+)
+
+func TestRegisterRoutes_APIKeys(t *testing.T) {
+	apiRouter := http.NewServeMux()
+	apiRouter.HandleFunc("GET /owner/devices", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	keys := [][sha256.Size]byte{sha256.Sum256([]byte("operator-key")), sha256.Sum256([]byte("dashboard-key"))}
+	handler := api.NewHTTPHandler(nil, nil).WithAPIKeys(keys).WithClientRateLimit(1000, 1000).RegisterRoutes(apiRouter)
+
+	tests := []struct {
+		name       string
+		path       string
+		header     string
+		value      string
+		wantStatus int
+	}{
+		{name: "bearer token", path: "/api/v1/owner/devices", header: "Authorization", value: "Bearer dashboard-key", wantStatus: http.StatusOK},
+		{name: "bearer scheme case", path: "/api/v1/owner/devices", header: "Authorization", value: "bearer operator-key", wantStatus: http.StatusOK},
+		{name: "X-API-Key header", path: "/api/v1/owner/devices", header: "X-API-Key", value: "operator-key", wantStatus: http.StatusOK},
+		{name: "invalid key", path: "/api/v1/owner/devices", header: "Authorization", value: "Bearer guessed-key", wantStatus: http.StatusUnauthorized},
+		{name: "other scheme", path: "/api/v1/owner/devices", header: "Authorization", value: "Basic operator-key", wantStatus: http.StatusUnauthorized},
+		{name: "missing key", path: "/api/v1/owner/devices", wantStatus: http.StatusUnauthorized},
+		{name: "unknown route", path: "/api/v1/owner/unknown", wantStatus: http.StatusUnauthorized},
+		// Informational endpoints stay open
+		{name: "version", path: "/api/v1/version", wantStatus: http.StatusOK},
+		{name: "health", path: "/health", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus != http.StatusUnauthorized {
+				return
+			}
+			if rec.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("expected a bearer challenge, got %q", rec.Header().Get("WWW-Authenticate"))
+			}
+			var body handlers.ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Code != handlers.ErrorCodeUnauthorized {
+				t.Errorf("expected an unauthorized error response, got %q", rec.Body.String())
+			}
+		})
+	}
+
+	// FDO protocol endpoints do not require a key
+	req := httptest.NewRequest(http.MethodPost, "/fdo/101/msg/10", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code == http.StatusUnauthorized {
+		t.Fatal("expected the FDO protocol endpoint to be served without a key")
+	}
+
+	// Without keys the management API is open
+	handler = api.NewHTTPHandler(nil, nil).RegisterRoutes(apiRouter)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/owner/devices", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 without API keys configured, got %d", rec.Code)
+	}
+}
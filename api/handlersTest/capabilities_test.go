@@ -0,0 +1,42 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestRegisterRoutes_Capabilities(t *testing.T) {
+	handler := api.NewHTTPHandler(nil, nil).RegisterRoutes(nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/capabilities", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	var body handlers.CapabilitiesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected JSON capabilities response: %v", err)
+	}
+	if len(body.KeyTypes) == 0 {
+		t.Fatal("expected key types")
+	}
+	for _, name := range body.KeyTypes {
+		if _, err := protocol.ParseKeyType(name); err != nil {
+			t.Errorf("key type %q is not a protocol key type name: %v", name, err)
+		}
+	}
+	if !slices.Contains(body.CipherSuites, tls.CipherSuiteName(tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384)) {
+		t.Errorf("expected the default cipher suites to be listed, got %v", body.CipherSuites)
+	}
+}
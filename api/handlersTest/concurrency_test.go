@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+)
+
+func TestRegisterRoutes_LimitsConcurrentManagementRequests(t *testing.T) {
+	setupTestDB(t)
+
+	const limit = 2
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	apiRouter := http.NewServeMux()
+	apiRouter.HandleFunc("GET /slow", func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})
+	srv := httptest.NewServer(api.NewHTTPHandler(nil, nil).LimitConcurrency(limit).RegisterRoutes(apiRouter))
+	defer srv.Close()
+
+	// Saturate the limit with blocked requests
+	var wg sync.WaitGroup
+	for range limit {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(srv.URL + "/api/v1/slow")
+			if err != nil {
+				t.Errorf("request failed: %v", err)
+				return
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("expected status 200, got %d", resp.StatusCode)
+			}
+		}()
+	}
+	for range limit {
+		<-entered
+	}
+
+	// One request too many is rejected
+	resp, err := http.Get(srv.URL + "/api/v1/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body handlers.ErrorResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected Content-Type application/json, got %q", ct)
+	}
+	if decodeErr != nil || body.Error.Code != handlers.ErrorCodeUnavailable {
+		t.Fatalf("expected an unavailable JSON error, got %q (%v)", body.Error.Code, decodeErr)
+	}
+
+	// Routes outside the management API are not limited
+	resp, err = http.Get(srv.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected health status 200, got %d", resp.StatusCode)
+	}
+
+	close(release)
+	wg.Wait()
+}
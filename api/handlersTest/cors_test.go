@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+)
+
+func TestRegisterRoutes_CORS(t *testing.T) {
+	apiRouter := http.NewServeMux()
+	apiRouter.HandleFunc("GET /owner/devices", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	const dashboard = "https://dashboard.example.com"
+
+	tests := []struct {
+		name        string
+		origins     []string
+		method      string
+		path        string
+		origin      string
+		preflight   string // Access-Control-Request-Method
+		wantStatus  int
+		wantOrigin  string
+		wantMethods string
+	}{
+		{name: "allowed origin", origins: []string{dashboard}, method: http.MethodGet, path: "/api/v1/owner/devices", origin: dashboard, wantStatus: http.StatusOK, wantOrigin: dashboard},
+		{name: "origin case", origins: []string{dashboard}, method: http.MethodGet, path: "/api/v1/owner/devices", origin: "https://Dashboard.example.com", wantStatus: http.StatusOK, wantOrigin: "https://Dashboard.example.com"},
+		{name: "disallowed origin", origins: []string{dashboard}, method: http.MethodGet, path: "/api/v1/owner/devices", origin: "https://evil.example.com", wantStatus: http.StatusOK},
+		{name: "any origin", origins: []string{"*"}, method: http.MethodGet, path: "/api/v1/owner/devices", origin: "https://evil.example.com", wantStatus: http.StatusOK, wantOrigin: "*"},
+		{name: "version endpoint", origins: []string{dashboard}, method: http.MethodGet, path: "/api/v1/version", origin: dashboard, wantStatus: http.StatusOK, wantOrigin: dashboard},
+		{name: "disabled", method: http.MethodGet, path: "/api/v1/owner/devices", origin: dashboard, wantStatus: http.StatusOK},
+		{
+			name: "preflight", origins: []string{dashboard}, method: http.MethodOptions, path: "/api/v1/owner/devices", origin: dashboard, preflight: http.MethodDelete,
+			wantStatus: http.StatusNoContent, wantOrigin: dashboard, wantMethods: "GET, POST, PUT, PATCH, DELETE",
+		},
+		{name: "preflight of disallowed origin", origins: []string{dashboard}, method: http.MethodOptions, path: "/api/v1/owner/devices", origin: "https://evil.example.com", preflight: http.MethodGet, wantStatus: http.StatusNoContent},
+		{name: "preflight of disallowed method", origins: []string{dashboard}, method: http.MethodOptions, path: "/api/v1/owner/devices", origin: dashboard, preflight: http.MethodTrace, wantStatus: http.StatusNoContent},
+		{name: "FDO protocol endpoint", origins: []string{"*"}, method: http.MethodOptions, path: "/fdo/101/msg/60", origin: dashboard, preflight: http.MethodPost, wantStatus: http.StatusMethodNotAllowed},
+		{name: "health endpoint", origins: []string{"*"}, method: http.MethodGet, path: "/health", origin: dashboard, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := api.NewHTTPHandler(nil, nil).WithCORS(tt.origins, nil, nil).RegisterRoutes(apiRouter)
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.preflight != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.preflight)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Fatalf("expected Access-Control-Allow-Origin %q, got %q", tt.wantOrigin, got)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Fatalf("expected Access-Control-Allow-Methods %q, got %q", tt.wantMethods, got)
+			}
+			if tt.wantMethods != "" && rec.Header().Get("Access-Control-Allow-Headers") != "Authorization, Content-Type, X-API-Key" {
+				t.Fatalf("expected the default allowed headers, got %q", rec.Header().Get("Access-Control-Allow-Headers"))
+			}
+			if tt.wantOrigin != "" && tt.preflight == "" && rec.Header().Get("Access-Control-Expose-Headers") != "X-Request-ID" {
+				t.Fatalf("expected the request ID header to be exposed, got %q", rec.Header().Get("Access-Control-Expose-Headers"))
+			}
+		})
+	}
+}
+
+func TestRegisterRoutes_CORSConfiguredMethodsAndHeaders(t *testing.T) {
+	handler := api.NewHTTPHandler(nil, nil).
+		WithCORS([]string{"https://dashboard.example.com"}, []string{"get", "patch"}, []string{"X-Api-Key"}).
+		RegisterRoutes(http.NewServeMux())
+	req := httptest.NewRequest(http.MethodOptions, "/api/v1/owner/devices", nil)
+	req.Header.Set("Origin", "https://dashboard.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, PATCH" {
+		t.Fatalf("expected the configured methods, got %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Headers"); got != "X-Api-Key" {
+		t.Fatalf("expected the configured headers, got %q", got)
+	}
+}
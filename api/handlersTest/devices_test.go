@@ -0,0 +1,413 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// onboardDeviceWithSerial records a voucher and a completed TO2 session for
+// a device whose devmod "sn" message has the given CBOR encoding.
+func onboardDeviceWithSerial(t *testing.T, state *db.State, guid protocol.GUID, snMessage []byte) {
+	t.Helper()
+
+	// Decode the serial the same way devmod messages are decoded
+	var serial []byte
+	if err := cbor.Unmarshal(snMessage, &serial); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.InsertVoucher(db.Voucher{GUID: guid[:], DeviceInfo: "test", CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := state.NewToken(context.Background(), protocol.TO2Protocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := state.TokenContext(context.Background(), token)
+	if err := state.SetGUID(ctx, guid); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.SetDevmod(ctx, serviceinfo.Devmod{Os: "Linux", Arch: "x86_64", Serial: serial}, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	// Complete TO2 with credential reuse (GUID unchanged)
+	if err := state.DB.Model(&db.DeviceOnboarding{}).Where("guid = ?", guid[:]).
+		Updates(map[string]any{"new_guid": guid[:], "to2_completed": true}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOwnerDevicesHandler_SerialNumberMatchesTstrAndBstr(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+
+	tstr, _ := cbor.Marshal("SN-0001")
+	bstr, _ := cbor.Marshal([]byte("SN-0001"))
+	binary, _ := cbor.Marshal([]byte{0xde, 0xad, 0xbe, 0xef})
+	onboardDeviceWithSerial(t, state, protocol.GUID{1}, tstr)
+	onboardDeviceWithSerial(t, state, protocol.GUID{2}, bstr)
+	onboardDeviceWithSerial(t, state, protocol.GUID{3}, binary)
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{query: "SN-0001", want: 2},
+		{query: "534e2d30303031", want: 2}, // hex of "SN-0001"
+		{query: "deadbeef", want: 1},
+		{query: "DEADBEEF", want: 1},
+		{query: "SN-0002", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/owner/devices?serial_number="+url.QueryEscape(tt.query), nil)
+			rec := httptest.NewRecorder()
+			handlers.OwnerDevicesHandler(0)(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+			var response handlers.DevicesResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			devices := response.Devices
+			if len(devices) != tt.want {
+				t.Fatalf("expected %d devices, got %d: %+v", tt.want, len(devices), devices)
+			}
+		})
+	}
+}
+
+func TestOwnerDevicesHandler_SkipsCorruptRows(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+
+	for _, guid := range []protocol.GUID{{1}, {2}, {3}, {4}} {
+		if err := db.InsertVoucher(db.Voucher{GUID: guid[:], DeviceInfo: "test", CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A voucher whose CBOR does not decode
+	corruptVoucher := protocol.GUID{2}
+	if err := state.DB.Model(&db.Voucher{}).Where("guid = ?", corruptVoucher[:]).Update("cbor", []byte{0xff, 0x00}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// A row with a column that cannot be scanned
+	corruptRow := protocol.GUID{3}
+	if err := state.DB.Exec("UPDATE device_onboarding SET new_guid = guid, to2_completed = 'maybe' WHERE guid = ?", corruptRow[:]).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handlers.OwnerDevicesHandler(0)(rec, httptest.NewRequest(http.MethodGet, "/api/v1/owner/devices", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response handlers.DevicesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Devices) != 2 {
+		t.Fatalf("expected the 2 good devices, got %+v", response.Devices)
+	}
+	for _, device := range response.Devices {
+		if device.GUID[0] == 2 || device.GUID[0] == 3 {
+			t.Errorf("corrupt device %x listed", device.GUID)
+		}
+	}
+	if len(response.Warnings) != 2 {
+		t.Fatalf("expected 2 warnings, got %+v", response.Warnings)
+	}
+	warned := map[byte]bool{}
+	for _, warning := range response.Warnings {
+		if warning.Error == "" {
+			t.Errorf("warning for %x has no error", warning.GUID)
+		}
+		warned[warning.GUID[0]] = true
+	}
+	if !warned[2] || !warned[3] {
+		t.Fatalf("expected warnings for the corrupt devices, got %+v", response.Warnings)
+	}
+}
+
+func TestOwnerDevicesHandler_Labels(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	tstr, _ := cbor.Marshal("SN-0001")
+	onboardDeviceWithSerial(t, state, protocol.GUID{1}, tstr)
+	onboardDeviceWithSerial(t, state, protocol.GUID{2}, tstr)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /owner/devices", handlers.OwnerDevicesHandler(0))
+	mux.HandleFunc("PUT /owner/devices/{guid}/label", handlers.SetDeviceLabelHandler)
+	setLabel := func(guid protocol.GUID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/owner/devices/"+hex.EncodeToString(guid[:])+"/label", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	listByLabel := func(label string) []db.Device {
+		req := httptest.NewRequest(http.MethodGet, "/owner/devices?label="+url.QueryEscape(label), nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", rec.Code)
+		}
+		var resp handlers.DevicesResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Devices
+	}
+
+	if rec := setLabel(protocol.GUID{1}, `{"label":"lobby gateway"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	devices := listByLabel("lobby gateway")
+	if len(devices) != 1 || !bytes.Equal(devices[0].GUID, []byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}) || devices[0].Label != "lobby gateway" {
+		t.Fatalf("unexpected devices for label: %+v", devices)
+	}
+
+	// Relabeling replaces the label
+	if rec := setLabel(protocol.GUID{1}, `{"label":"roof gateway"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if devices := listByLabel("lobby gateway"); len(devices) != 0 {
+		t.Fatalf("expected no device with the old label, got %+v", devices)
+	}
+	if devices := listByLabel("roof gateway"); len(devices) != 1 {
+		t.Fatalf("expected one device with the new label, got %+v", devices)
+	}
+
+	// Unfiltered listings include the label of labeled devices only
+	for _, device := range listByLabel("") {
+		want := ""
+		if device.GUID[0] == 1 {
+			want = "roof gateway"
+		}
+		if device.Label != want {
+			t.Fatalf("device %x label=%q, want %q", []byte(device.GUID), device.Label, want)
+		}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		guid     protocol.GUID
+		body     string
+		want     int
+		wantCode string
+	}{
+		{name: "too long", guid: protocol.GUID{2}, body: `{"label":"` + strings.Repeat("x", handlers.MaxDeviceLabelLength+1) + `"}`, want: http.StatusBadRequest, wantCode: handlers.ErrorCodeInvalidRequest},
+		{name: "control characters", guid: protocol.GUID{2}, body: `{"label":"a\nb"}`, want: http.StatusBadRequest, wantCode: handlers.ErrorCodeInvalidRequest},
+		{name: "invalid JSON", guid: protocol.GUID{2}, body: `lobby`, want: http.StatusBadRequest, wantCode: handlers.ErrorCodeInvalidRequest},
+		{name: "unknown device", guid: protocol.GUID{9}, body: `{"label":"lobby"}`, want: http.StatusNotFound, wantCode: handlers.ErrorCodeNotFound},
+		{name: "max length", guid: protocol.GUID{2}, body: `{"label":"` + strings.Repeat("é", handlers.MaxDeviceLabelLength) + `"}`, want: http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := setLabel(tt.guid, tt.body)
+			if rec.Code != tt.want {
+				t.Fatalf("expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+			if tt.wantCode != "" {
+				errorMessage(t, rec, tt.wantCode)
+			}
+		})
+	}
+
+	// An empty label removes it
+	if rec := setLabel(protocol.GUID{1}, `{"label":""}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if devices := listByLabel("roof gateway"); len(devices) != 0 {
+		t.Fatalf("expected label to be removed, got %+v", devices)
+	}
+}
+
+func TestExportDevicesHandler_CSV(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	sn, _ := cbor.Marshal("SN-0001")
+	guid := protocol.GUID{1}
+	onboardDeviceWithSerial(t, state, guid, sn)
+
+	rec := httptest.NewRecorder()
+	handlers.ExportDevicesHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/owner/devices/export?format=csv", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("Expected Content-Type text/csv, got %q", ct)
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV: %v", err)
+	}
+	want := [][]string{
+		{"guid", "device", "os", "arch", "serial", "onboarded", "last_onboarded"},
+		{hex.EncodeToString(guid[:]), "test", "Linux", "x86_64", "SN-0001", "true", ""},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("Expected %d records, got %d: %v", len(want), len(records), records)
+	}
+	for i := range want {
+		if strings.Join(records[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("Record %d: expected %v, got %v", i, want[i], records[i])
+		}
+	}
+
+	// Device reported values are not evaluated as formulas by spreadsheets
+	formula, _ := cbor.Marshal("=HYPERLINK(\"http://example.com\")")
+	onboardDeviceWithSerial(t, state, protocol.GUID{2}, formula)
+	rec = httptest.NewRecorder()
+	handlers.ExportDevicesHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/owner/devices/export?serial_number=%3DHYPERLINK(%22http://example.com%22)", nil))
+	records, err = csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV: %v", err)
+	}
+	if len(records) != 2 || records[1][4] != `'=HYPERLINK("http://example.com")` {
+		t.Errorf("Expected the formula serial to be quoted, got %v", records)
+	}
+
+	rec = httptest.NewRecorder()
+	handlers.ExportDevicesHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/owner/devices/export?format=xlsx", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unsupported format, got %d", rec.Code)
+	}
+}
+
+func TestOwnerDevicesHandler_Expired(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	stale, fresh, onboarded := protocol.GUID{1}, protocol.GUID{2}, protocol.GUID{3}
+	if err := db.InsertVoucher(db.Voucher{GUID: stale[:], DeviceInfo: "test", CreatedAt: old, UpdatedAt: old}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertVoucher(db.Voucher{GUID: fresh[:], DeviceInfo: "test", CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	tstr, _ := cbor.Marshal("SN-0001")
+	onboardDeviceWithSerial(t, state, onboarded, tstr)
+	if err := state.DB.Model(&db.Voucher{}).Where("guid = ?", onboarded[:]).Update("created_at", old).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handlers.OwnerDevicesHandler(24*time.Hour)(rec, httptest.NewRequest(http.MethodGet, "/api/v1/owner/devices", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response handlers.DevicesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Devices) != 3 {
+		t.Fatalf("expected 3 devices, got %+v", response.Devices)
+	}
+	for _, device := range response.Devices {
+		if want := device.GUID[0] == stale[0]; device.Expired != want {
+			t.Errorf("device %x: expected expired=%t, got %t", device.GUID, want, device.Expired)
+		}
+	}
+
+	for guid, want := range map[protocol.GUID]bool{stale: true, fresh: false, onboarded: false} {
+		expired, err := db.IsVoucherExpired(context.Background(), guid[:], 24*time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expired != want {
+			t.Errorf("IsVoucherExpired(%x) = %t, want %t", guid, expired, want)
+		}
+	}
+}
+
+func TestOwnerDevicesHandler_ReportsNegotiatedMTU(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+
+	// A device whose TO2 session negotiated an MTU before completing
+	withMTU := protocol.GUID{1}
+	if err := db.InsertVoucher(db.Voucher{GUID: withMTU[:], DeviceInfo: "test", CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := state.NewToken(context.Background(), protocol.TO2Protocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := state.TokenContext(context.Background(), token)
+	if err := state.SetGUID(ctx, withMTU); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.SetMTU(ctx, 1300); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.DB.Model(&db.DeviceOnboarding{}).Where("guid = ?", withMTU[:]).
+		Updates(map[string]any{"new_guid": withMTU[:], "to2_completed": true}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// A device that never reached TO2
+	withoutMTU := protocol.GUID{2}
+	if err := db.InsertVoucher(db.Voucher{GUID: withoutMTU[:], DeviceInfo: "test", CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handlers.OwnerDevicesHandler(0)(rec, httptest.NewRequest(http.MethodGet, "/api/v1/owner/devices", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response handlers.DevicesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Devices) != 2 {
+		t.Fatalf("expected 2 devices, got %+v", response.Devices)
+	}
+	for _, device := range response.Devices {
+		switch device.GUID[0] {
+		case withMTU[0]:
+			if device.MTU == nil || *device.MTU != 1300 {
+				t.Errorf("expected MTU 1300, got %v", device.MTU)
+			}
+		case withoutMTU[0]:
+			if device.MTU != nil {
+				t.Errorf("expected no MTU, got %d", *device.MTU)
+			}
+		}
+	}
+	if strings.Count(rec.Body.String(), `"mtu":`) != 1 {
+		t.Fatalf("expected mtu to be omitted for the device without one: %s", rec.Body.String())
+	}
+}
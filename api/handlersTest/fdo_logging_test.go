@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// rejectingResponder answers every message with an FDO error
+type rejectingResponder struct{}
+
+func (rejectingResponder) Respond(_ context.Context, msgType uint8, body io.Reader) (uint8, any) {
+	_, _ = io.Copy(io.Discard, body)
+	return protocol.ErrorMsgType, protocol.ErrorMessage{Code: protocol.MessageBodyErrCode, PrevMsgType: msgType, ErrString: "rejected"}
+}
+
+func (rejectingResponder) HandleError(context.Context, protocol.ErrorMessage) {}
+
+func TestRegisterRoutes_FDOMessageRequestID(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	handler := api.NewHTTPHandler(&transport.Handler{Tokens: state, TO2Responder: rejectingResponder{}}, nil).
+		RegisterRoutes(nil)
+
+	guid := protocol.GUID{0xde, 0xad, 0xbe, 0xef}
+	helloDevice, err := cbor.Marshal([]any{uint16(1300), guid, protocol.Nonce{}, "ECDH256", uint16(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/fdo/101/msg/60", bytes.NewReader(helloDevice))
+		if requestID != "" {
+			req.Header.Set(handlers.RequestIDHeader, requestID)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A generated ID is returned, and no other ID header
+	rec := send("")
+	generated := rec.Header().Get(handlers.RequestIDHeader)
+	if generated == "" {
+		t.Fatal("expected a request ID header on the response")
+	}
+	if got := rec.Header().Get("X-Correlation-ID"); got != "" {
+		t.Fatalf("expected a single ID header, got X-Correlation-ID %q", got)
+	}
+
+	// The message is logged with the same ID, its name, GUID and outcome
+	var record map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var r map[string]any
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		if r["msg"] == "FDO message" && r["request_id"] == generated {
+			record = r
+		}
+	}
+	if record == nil {
+		t.Fatalf("no FDO message record with request ID %s in logs:\n%s", generated, logs.String())
+	}
+	if record["message"] != "TO2.HelloDevice" {
+		t.Errorf("expected message TO2.HelloDevice, got %v", record["message"])
+	}
+	if record["guid"] != hex.EncodeToString(guid[:]) {
+		t.Errorf("expected guid %x, got %v", guid, record["guid"])
+	}
+	if record["response"] != "Error" || record["outcome"] != "error" {
+		t.Errorf("expected an error outcome, got response %v outcome %v", record["response"], record["outcome"])
+	}
+}
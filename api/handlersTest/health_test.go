@@ -2,10 +2,13 @@ package handlersTest
 
 import (
 	"encoding/json"
-	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
 )
 
 func TestHealthHandler(t *testing.T) {
@@ -35,3 +38,47 @@ func TestHealthHandler(t *testing.T) {
 	}
 
 }
+
+func TestReadinessHandler_Database(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	handler := api.NewHTTPHandler(nil, state.DB).RegisterRoutes(nil)
+
+	get := func(path string) (int, handlers.ReadinessResponse) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var response handlers.ReadinessResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &response)
+		return rec.Code, response
+	}
+
+	for _, path := range []string{"/readyz", "/api/v1/ready"} {
+		code, response := get(path)
+		if code != http.StatusOK || response.Status != handlers.ReadinessReady || response.Checks["database"] != "ok" {
+			t.Fatalf("%s: expected ready, got %d %+v", path, code, response)
+		}
+	}
+
+	sqlDB, err := state.DB.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlDB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	code, response := get("/api/v1/ready")
+	if code != http.StatusServiceUnavailable || response.Status != handlers.ReadinessDegraded {
+		t.Fatalf("expected 503 degraded with the database closed, got %d %+v", code, response)
+	}
+	if reason := response.Checks["database"]; reason == "" || reason == "ok" {
+		t.Fatalf("expected the database error as reason, got %q", reason)
+	}
+	// The liveness probe does not depend on the database
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected /health to stay 200, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterRoutes_Metrics(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	extra := prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: "fdo_test_extra", Help: "Extra collector."}, func() float64 { return 3 })
+	handler := api.NewHTTPHandler(&transport.Handler{Tokens: state, TO2Responder: rejectingResponder{}}, nil).
+		WithMetrics(true, extra).
+		RegisterRoutes(nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/fdo/101/msg/60", bytes.NewReader([]byte{0x80})))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`fdo_messages_total{outcome="error",protocol="TO2"} 1`,
+		"fdo_test_extra 3",
+		"go_goroutines",
+		"process_cpu_seconds_total",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestRegisterRoutes_MetricsDisabled(t *testing.T) {
+	handler := api.NewHTTPHandler(nil, nil).WithMetrics(false).RegisterRoutes(nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 with metrics disabled, got %d", rec.Code)
+	}
+}
+
+func TestRegisterRoutes_MetricsAPIKey(t *testing.T) {
+	digest := sha256.Sum256([]byte("scraper-key"))
+	handler := api.NewHTTPHandler(nil, nil).WithAPIKeys([][sha256.Size]byte{digest}).WithMetrics(true).RegisterRoutes(nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without an API key, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer scraper-key")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with an API key, got %d", rec.Code)
+	}
+}
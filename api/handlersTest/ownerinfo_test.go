@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
@@ -58,9 +59,11 @@ func TestOwnerInfo_Put404ThenCreateThenUpdateAndGet(t *testing.T) {
 
 	// PUT before create -> 404
 	putBody := []byte(`[{"dns":"owner.example","port":"8082","protocol":"http"}]`)
-	if rec := put(putBody); rec.Code != http.StatusNotFound {
-		t.Fatalf("expected 404 on PUT before create, got %d", rec.Code)
+	notFound := put(putBody)
+	if notFound.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 on PUT before create, got %d", notFound.Code)
 	}
+	errorMessage(t, notFound, handlers.ErrorCodeNotFound)
 
 	// POST create -> 201
 	postBody := []byte(`[{"dns":"owner.example","port":"8082","protocol":"http"}]`)
@@ -84,3 +87,36 @@ func TestOwnerInfo_Put404ThenCreateThenUpdateAndGet(t *testing.T) {
 	}
 
 }
+
+func TestOwnerInfo_ValidatesEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+		msg  string
+	}{
+		{name: "valid", body: `[{"ip":"127.0.0.1","port":"8043","protocol":"http"},{"dns":"owner.example","port":"8443","protocol":"https"}]`, want: http.StatusCreated},
+		{name: "no ip or dns", body: `[{"dns":"owner.example","port":"8043"},{"ip":"","dns":"","port":"8043","protocol":"http"}]`, want: http.StatusBadRequest, msg: "to2[1]: at least one of dns or ip"},
+		{name: "tuple entry", body: `[{"dns":"owner.example"},["127.0.0.1",null,8043,3]]`, want: http.StatusBadRequest, msg: "to2[1]: entry must be an object"},
+		{name: "unknown key", body: `[{"dns":"owner.example","prot":"http"}]`, want: http.StatusBadRequest, msg: "to2[0]"},
+		{name: "empty", body: `[]`, want: http.StatusBadRequest, msg: "at least one owner address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/owner/redirect", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			handlers.OwnerInfoHandler(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+			if tt.msg == "" {
+				return
+			}
+			if msg := errorMessage(t, rec, handlers.ErrorCodeInvalidRequest); !strings.Contains(msg, tt.msg) {
+				t.Fatalf("expected error containing %q, got %q", tt.msg, msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+)
+
+func TestRegisterRoutes_ClientRateLimit(t *testing.T) {
+	apiRouter := http.NewServeMux()
+	apiRouter.HandleFunc("POST /owner/vouchers", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	// Up to 3 requests at once, then one every 100ms
+	handler := api.NewHTTPHandler(nil, nil).WithClientRateLimit(10, 3).RegisterRoutes(apiRouter)
+	insert := func(client string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/owner/vouchers", nil)
+		req.RemoteAddr = client + ":40000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := range 3 {
+		if rec := insert("192.0.2.1"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d of the burst: expected status 200, got %d", i, rec.Code)
+		}
+	}
+	for range 5 {
+		rec := insert("192.0.2.1")
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("expected status 429 past the burst, got %d", rec.Code)
+		}
+		if seconds, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || seconds < 1 {
+			t.Fatalf("expected Retry-After in seconds, got %q", rec.Header().Get("Retry-After"))
+		}
+		var body handlers.ErrorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error.Code != handlers.ErrorCodeRateLimited {
+			t.Fatalf("expected a rate_limited JSON error, got %q (%v)", body.Error.Code, err)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("expected Content-Type application/json, got %q", ct)
+		}
+	}
+
+	// Other clients have their own bucket
+	if rec := insert("192.0.2.2"); rec.Code != http.StatusOK {
+		t.Fatalf("expected another client to be served, got %d", rec.Code)
+	}
+
+	// Rejected requests do not use tokens, so the client recovers at the
+	// configured rate
+	time.Sleep(150 * time.Millisecond)
+	if rec := insert("192.0.2.1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the client to recover, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+)
+
+func TestRegisterRoutes_RecoversFromPanic(t *testing.T) {
+	setupTestDB(t)
+
+	apiRouter := http.NewServeMux()
+	apiRouter.HandleFunc("GET /panic", func(http.ResponseWriter, *http.Request) {
+		panic("deliberate test panic")
+	})
+	srv := httptest.NewServer(api.NewHTTPHandler(nil, nil).RegisterRoutes(apiRouter))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/v1/panic")
+	if err != nil {
+		t.Fatalf("request to panicking handler failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected JSON error, got Content-Type %q", ct)
+	}
+	var body handlers.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error.Code != handlers.ErrorCodeInternal {
+		t.Fatalf("expected JSON error body, got %+v (err=%v)", body, err)
+	}
+
+	// The server keeps serving requests
+	resp, err = http.Get(srv.URL + "/health")
+	if err != nil {
+		t.Fatalf("server did not survive panic: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected health status 200, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestListHandlers_UseReadReplica(t *testing.T) {
+	// Populate the replica with a device the primary does not have
+	replicaDSN := "file:" + filepath.Join(t.TempDir(), "replica.db")
+	replicaState, err := db.InitDb("sqlite", replicaDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicaGUID := protocol.GUID{0xaa}
+	if err := db.InsertVoucher(db.Voucher{GUID: replicaGUID[:], DeviceInfo: "replica", CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := replicaState.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	primary, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = primary.Close() })
+	primaryGUID := protocol.GUID{0xbb}
+	if err := db.InsertVoucher(db.Voucher{GUID: primaryGUID[:], DeviceInfo: "primary", CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InitReadReplica("sqlite", replicaDSN); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handlers.OwnerDevicesHandler(0)(rec, httptest.NewRequest(http.MethodGet, "/api/v1/owner/devices", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response handlers.DevicesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	devices := response.Devices
+	if len(devices) != 1 || devices[0].DeviceInfo != "replica" {
+		t.Fatalf("expected the replica's device only, got %+v", devices)
+	}
+
+	vouchers, err := db.QueryVouchers(context.Background(), map[string]interface{}{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vouchers) != 1 || vouchers[0].DeviceInfo != "replica" {
+		t.Fatalf("expected voucher list from the replica, got %+v", vouchers)
+	}
+
+	// Single voucher lookups and writes stay on the primary
+	if _, err := db.FetchVoucher(context.Background(), map[string]interface{}{"guid": primaryGUID[:]}); err != nil {
+		t.Fatalf("expected voucher lookup on the primary: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+)
+
+func TestRegisterRoutes_RequestID(t *testing.T) {
+	var seen string
+	apiRouter := http.NewServeMux()
+	apiRouter.HandleFunc("GET /echo", func(w http.ResponseWriter, r *http.Request) {
+		seen = handlers.RequestID(r.Context())
+	})
+	handler := api.NewHTTPHandler(nil, nil).RegisterRoutes(apiRouter)
+
+	get := func(path, requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if requestID != "" {
+			req.Header.Set(handlers.RequestIDHeader, requestID)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A UUID is generated when the client sends none, and handed to handlers
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	rec := get("/api/v1/echo", "")
+	generated := rec.Header().Get(handlers.RequestIDHeader)
+	if !uuid.MatchString(generated) {
+		t.Fatalf("expected a generated UUID request ID, got %q", generated)
+	}
+	if seen != generated {
+		t.Fatalf("handler saw request ID %q, response has %q", seen, generated)
+	}
+
+	// A supplied ID is preserved
+	rec = get("/api/v1/echo", "client-req-7")
+	if got := rec.Header().Get(handlers.RequestIDHeader); got != "client-req-7" || seen != "client-req-7" {
+		t.Fatalf("expected the supplied request ID to be kept, got %q on the response and %q in the handler", got, seen)
+	}
+
+	// A malformed ID is replaced
+	rec = get("/api/v1/echo", "no spaces allowed")
+	if got := rec.Header().Get(handlers.RequestIDHeader); !uuid.MatchString(got) {
+		t.Fatalf("expected a malformed request ID to be replaced, got %q", got)
+	}
+
+	// Responses outside of the management API carry it too
+	if rec := get("/health", "health-1"); rec.Header().Get(handlers.RequestIDHeader) != "health-1" {
+		t.Fatalf("expected the request ID on the health response, got %q", rec.Header().Get(handlers.RequestIDHeader))
+	}
+}
@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+)
+
+func TestRegisterRoutes_Root(t *testing.T) {
+	handler := api.NewHTTPHandler(nil, nil).RegisterRoutes(http.NewServeMux())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	var body handlers.RootResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected JSON root response: %v", err)
+	}
+	if body.Links["health"] != "/health" || body.Links["version"] != "/api/v1/version" {
+		t.Errorf("unexpected links: %v", body.Links)
+	}
+
+	// Only the root itself is handled
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown path, got %d", rec.Code)
+	}
+
+	handler = api.NewHTTPHandler(nil, nil).WithRootRedirect("/health").RegisterRoutes(nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/health" {
+		t.Fatalf("expected redirect to /health, got %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
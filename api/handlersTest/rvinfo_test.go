@@ -6,9 +6,12 @@ import (
 	"bytes"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
 )
 
 func TestRvInfo_PostConflictOnDuplicate(t *testing.T) {
@@ -32,6 +35,9 @@ func TestRvInfo_PostConflictOnDuplicate(t *testing.T) {
 	if rec2.Code != http.StatusConflict {
 		t.Fatalf("expected 409 on second POST, got %d", rec2.Code)
 	}
+	if msg := errorMessage(t, rec2, handlers.ErrorCodeConflict); msg != "rvInfo already exists" {
+		t.Fatalf("unexpected error message %q", msg)
+	}
 }
 
 func TestRvInfo_Put404ThenCreateThenUpdateAndGet(t *testing.T) {
@@ -58,9 +64,11 @@ func TestRvInfo_Put404ThenCreateThenUpdateAndGet(t *testing.T) {
 
 	// PUT before create -> 404
 	putBody := []byte(`[{"dns":"rv.example","device_port":"8082","owner_port":"8082","protocol":"http"}]`)
-	if rec := put(putBody); rec.Code != http.StatusNotFound {
-		t.Fatalf("expected 404 on PUT before create, got %d", rec.Code)
+	notFound := put(putBody)
+	if notFound.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 on PUT before create, got %d", notFound.Code)
 	}
+	errorMessage(t, notFound, handlers.ErrorCodeNotFound)
 
 	// POST create -> 201
 	postBody := []byte(`[{"dns":"rv.example","device_port":"8082","owner_port":"8082","protocol":"http"}]`)
@@ -83,3 +91,197 @@ func TestRvInfo_Put404ThenCreateThenUpdateAndGet(t *testing.T) {
 		t.Fatalf("expected body %q, got %q", string(updateBody), got)
 	}
 }
+
+func TestRvInfo_PostAllDirectiveTypes(t *testing.T) {
+	setupTestDB(t)
+
+	body := []byte(`[{"dns":"rv.example","device_port":"8082","protocol":"https","medium":"wifi_all","delay_seconds":30,"sv_cert_hash":"a1b2"},{"ip":"127.0.0.1","owner_port":"8443","rv_bypass":true}]`)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/rv", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	handlers.RvInfoHandler()(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 on POST, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rvInfo, err := db.FetchRvInfo()
+	if err != nil {
+		t.Fatalf("FetchRvInfo failed: %v", err)
+	}
+	want := [][]protocol.RvVar{
+		{protocol.RVDns, protocol.RVProtocol, protocol.RVMedium, protocol.RVDevPort, protocol.RVDelaysec, protocol.RVSvCertHash},
+		{protocol.RVIPAddress, protocol.RVOwnerPort, protocol.RVBypass},
+	}
+	if len(rvInfo) != len(want) {
+		t.Fatalf("expected %d directives, got %d", len(want), len(rvInfo))
+	}
+	for i, directive := range rvInfo {
+		if len(directive) != len(want[i]) {
+			t.Fatalf("directive %d: expected %d instructions, got %d", i, len(want[i]), len(directive))
+		}
+		for j, instruction := range directive {
+			if instruction.Variable != want[i][j] {
+				t.Errorf("directive %d instruction %d: expected variable %d, got %d", i, j, want[i][j], instruction.Variable)
+			}
+		}
+	}
+}
+
+func TestRvInfo_PostInvalidDirectiveNamed(t *testing.T) {
+	setupTestDB(t)
+
+	body := []byte(`[{"dns":"rv.example","protocol":"http"},{"dns":"rv2.example","medium":"carrier_pigeon"}]`)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/rv", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	handlers.RvInfoHandler()(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 on POST, got %d", rec.Code)
+	}
+	if msg := errorMessage(t, rec, handlers.ErrorCodeInvalidRequest); !strings.Contains(msg, "rvinfo[1]: medium") {
+		t.Fatalf("expected error naming rvinfo[1] medium, got %q", msg)
+	}
+}
+
+func TestRvInfo_RejectsSemanticallyInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "empty array", body: `[]`, want: "at least one rv directive"},
+		{name: "null", body: `null`, want: "at least one rv directive"},
+		{name: "no address", body: `[{"protocol":"http"}]`, want: "at least one of dns or ip"},
+		{name: "invalid ip", body: `[{"ip":"999.1.1.1"}]`, want: "invalid ip"},
+		{name: "port out of range", body: `[{"dns":"rv.example","device_port":"70000"}]`, want: "port out of range"},
+		{name: "unknown protocol", body: `[{"dns":"rv.example","protocol":"gopher"}]`, want: "unsupported protocol"},
+		{name: "invalid cert hash", body: `[{"dns":"rv.example","sv_cert_hash":"xyz"}]`, want: "sv_cert_hash"},
+		{name: "unknown key", body: `[{"dns":"rv.example","devce_port":"8082"}]`, want: "unknown field"},
+		{name: "dev and owner only", body: `[{"dns":"rv.example","dev_only":true,"owner_only":true}]`, want: "mutually exclusive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+
+			post := httptest.NewRecorder()
+			handlers.RvInfoHandler()(post, httptest.NewRequest(http.MethodPost, "/api/v1/rv", strings.NewReader(tt.body)))
+			if post.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400 on POST, got %d", post.Code)
+			}
+			if msg := errorMessage(t, post, handlers.ErrorCodeInvalidRequest); !strings.Contains(msg, tt.want) {
+				t.Fatalf("expected error containing %q, got %q", tt.want, msg)
+			}
+			if _, err := db.FetchRvInfoJSON(); err == nil {
+				t.Fatal("invalid rvinfo was stored")
+			}
+
+			// PUT over valid rvinfo must leave it unchanged
+			valid := []byte(`[{"dns":"rv.example","protocol":"http"}]`)
+			if err := db.InsertRvInfo(valid); err != nil {
+				t.Fatal(err)
+			}
+			put := httptest.NewRecorder()
+			handlers.RvInfoHandler()(put, httptest.NewRequest(http.MethodPut, "/api/v1/rv", strings.NewReader(tt.body)))
+			if put.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400 on PUT, got %d", put.Code)
+			}
+			stored, err := db.FetchRvInfoJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(stored, valid) {
+				t.Fatalf("rvinfo changed by invalid PUT: %s", stored)
+			}
+		})
+	}
+}
+
+func TestRvInfo_Patch(t *testing.T) {
+	stored := `[{"dns":"rv.example","device_port":"8082","owner_port":"8082","protocol":"http"}]`
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{
+			name:  "replace",
+			patch: `[{"owner_port":"8443","protocol":"https"}]`,
+			want:  `[{"device_port":"8082","dns":"rv.example","owner_port":"8443","protocol":"https"}]`,
+		},
+		{
+			name:  "add key and directive",
+			patch: `[{"ip":"127.0.0.1"},{"dns":"rv2.example","rv_bypass":true}]`,
+			want:  `[{"device_port":"8082","dns":"rv.example","ip":"127.0.0.1","owner_port":"8082","protocol":"http"},{"dns":"rv2.example","rv_bypass":true}]`,
+		},
+		{
+			name:  "remove key",
+			patch: `[{"owner_port":null}]`,
+			want:  `[{"device_port":"8082","dns":"rv.example","protocol":"http"}]`,
+		},
+		{
+			name:  "no-op",
+			patch: `[{"dns":"rv.example","owner_port":"8082"}]`,
+			want:  stored,
+		},
+		{
+			name:  "empty patch",
+			patch: `[]`,
+			want:  stored,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			if err := db.InsertRvInfo([]byte(stored)); err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			handlers.RvInfoHandler()(rec, httptest.NewRequest(http.MethodPatch, "/api/v1/rv", strings.NewReader(tt.patch)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 on PATCH, got %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Body.String(); got != tt.want {
+				t.Fatalf("expected merged rvinfo %s, got %s", tt.want, got)
+			}
+			current, err := db.FetchRvInfoJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(current) != tt.want {
+				t.Fatalf("expected stored rvinfo %s, got %s", tt.want, current)
+			}
+		})
+	}
+}
+
+func TestRvInfo_PatchErrors(t *testing.T) {
+	setupTestDB(t)
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handlers.RvInfoHandler()(rec, httptest.NewRequest(http.MethodPatch, "/api/v1/rv", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := patch(`[{"owner_port":"8443"}]`); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 on PATCH before create, got %d", rec.Code)
+	}
+
+	stored := []byte(`[{"dns":"rv.example","protocol":"http"}]`)
+	if err := db.InsertRvInfo(stored); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{`{"dns":"rv2.example"}`, `[{"dns":null}]`, `[{"protocol":"gopher"}]`} {
+		rec := patch(body)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 on PATCH %s, got %d", body, rec.Code)
+		}
+		errorMessage(t, rec, handlers.ErrorCodeInvalidRequest)
+	}
+	current, err := db.FetchRvInfoJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(current, stored) {
+		t.Fatalf("rvinfo changed by invalid PATCH: %s", current)
+	}
+}
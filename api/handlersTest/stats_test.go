@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/testdata"
+)
+
+// insertVoucherWithRvInfo stores a copy of the test voucher with the given
+// GUID and rendezvous info
+func insertVoucherWithRvInfo(t *testing.T, guid protocol.GUID, rvInfo [][]protocol.RvInstruction) {
+	t.Helper()
+	voucherPEM, err := testdata.Files.ReadFile("ov.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(voucherPEM)
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(block.Bytes, &ov); err != nil {
+		t.Fatal(err)
+	}
+	ov.Header.Val.GUID = guid
+	ov.Header.Val.RvInfo = rvInfo
+	ovBytes, err := cbor.Marshal(&ov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: ovBytes, DeviceInfo: "test", CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatsHandler_NeedsRegistration(t *testing.T) {
+	setupTestDB(t)
+
+	mustMarshal := func(v any) []byte {
+		b, err := cbor.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	viaRendezvous := [][]protocol.RvInstruction{{
+		{Variable: protocol.RVDns, Value: mustMarshal("rv.example.com")},
+		{Variable: protocol.RVOwnerPort, Value: mustMarshal(uint16(8041))},
+		{Variable: protocol.RVProtocol, Value: mustMarshal(uint8(protocol.RVProtHTTP))},
+	}}
+	bypass := [][]protocol.RvInstruction{{{Variable: protocol.RVBypass}}}
+
+	unregistered, registered, bypassed := protocol.GUID{1}, protocol.GUID{2}, protocol.GUID{3}
+	insertVoucherWithRvInfo(t, unregistered, viaRendezvous)
+	insertVoucherWithRvInfo(t, registered, viaRendezvous)
+	insertVoucherWithRvInfo(t, bypassed, bypass)
+	if err := db.SetTO0Registered(context.Background(), registered[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := func(delay time.Duration) handlers.StatsResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handlers.StatsHandler(delay)(rec, httptest.NewRequest(http.MethodGet, "/api/v1/stats", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp handlers.StatsResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := stats(0)
+	if resp.Vouchers != 3 {
+		t.Errorf("expected 3 vouchers, got %d", resp.Vouchers)
+	}
+	if len(resp.NeedsRegistration) != 1 || protocol.GUID(resp.NeedsRegistration[0].GUID) != unregistered {
+		t.Fatalf("expected only the unregistered voucher to need registration, got %+v", resp.NeedsRegistration)
+	}
+	if len(resp.NeedsRegistration[0].CBOR) != 0 {
+		t.Errorf("expected voucher summaries without CBOR")
+	}
+	if len(resp.Warnings) != 1 {
+		t.Errorf("expected a warning, got %v", resp.Warnings)
+	}
+
+	// Vouchers are given time to be registered before being reported
+	if resp := stats(time.Hour); len(resp.NeedsRegistration) != 0 || len(resp.Warnings) != 0 {
+		t.Fatalf("expected recently inserted vouchers not to be reported, got %+v", resp)
+	}
+}
@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestRegisterRoutes_TimesOutSlowManagementRequests(t *testing.T) {
+	setupTestDB(t)
+
+	cancelled := make(chan error, 1)
+	apiRouter := http.NewServeMux()
+	apiRouter.HandleFunc("GET /owner/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		// Queries made with the request context are aborted too
+		_, _, err := db.ListDevices(r.Context(), nil, 0)
+		cancelled <- err
+	})
+	apiRouter.HandleFunc("GET /fast", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	})
+	handler := api.NewHTTPHandler(nil, nil).
+		WithTimeouts(time.Second, map[string]time.Duration{"/owner": 50 * time.Millisecond}).
+		RegisterRoutes(apiRouter)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/owner/slow", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected Content-Type application/json, got %q", ct)
+	}
+	var body handlers.ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error.Code != handlers.ErrorCodeTimeout {
+		t.Fatalf("expected a timeout JSON error, got %q (%v)", body.Error.Code, err)
+	}
+	select {
+	case err := <-cancelled:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the database query to be cancelled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler context was not cancelled")
+	}
+
+	// Other route groups use the default timeout
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/fast", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+}
+
+// slowResponder stands in for a TO2 responder whose module operation runs
+// until its context is cancelled
+type slowResponder struct {
+	cancelled chan error
+}
+
+func (r slowResponder) Respond(ctx context.Context, msgType uint8, _ io.Reader) (uint8, any) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+	}
+	r.cancelled <- ctx.Err()
+	return protocol.ErrorMsgType, protocol.ErrorMessage{Code: protocol.InternalServerErrCode, PrevMsgType: msgType, ErrString: "timed out"}
+}
+
+func (slowResponder) HandleError(context.Context, protocol.ErrorMessage) {}
+
+func TestRegisterRoutes_MessageDeadline(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+
+	responder := slowResponder{cancelled: make(chan error, 1)}
+	handler := api.NewHTTPHandler(&transport.Handler{Tokens: state, TO2Responder: responder}, nil).
+		WithMessageTimeout(50 * time.Millisecond).
+		RegisterRoutes(nil)
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/fdo/101/msg/60", bytes.NewReader([]byte{0x80})))
+	select {
+	case err := <-responder.cancelled:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the message context to exceed its deadline, got %v", err)
+		}
+	default:
+		t.Fatal("responder was not called")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("message was not cancelled by the deadline, took %s", elapsed)
+	}
+}
@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+)
+
+func TestRegisterRoutes_ClientAddrFromTrustedProxies(t *testing.T) {
+	apiRouter := http.NewServeMux()
+	apiRouter.HandleFunc("GET /remote", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.RemoteAddr)
+	})
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.1/32")}
+	handler := api.NewHTTPHandler(nil, nil).WithTrustedProxies(trusted).RegisterRoutes(apiRouter)
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers []string
+		want    string
+	}{
+		{name: "trusted peer", peer: "10.1.2.3:4000", headers: []string{"203.0.113.7"}, want: "203.0.113.7:0"},
+		{name: "untrusted peer", peer: "198.51.100.9:4000", headers: []string{"203.0.113.7"}, want: "198.51.100.9:4000"},
+		{name: "trusted peer without header", peer: "10.1.2.3:4000", want: "10.1.2.3:4000"},
+		{name: "chain of trusted proxies", peer: "10.1.2.3:4000", headers: []string{"203.0.113.7, 192.0.2.1"}, want: "203.0.113.7:0"},
+		{name: "spoofed entry ignored", peer: "10.1.2.3:4000", headers: []string{"1.1.1.1, 203.0.113.7"}, want: "203.0.113.7:0"},
+		{name: "multiple headers", peer: "10.1.2.3:4000", headers: []string{"1.1.1.1", "203.0.113.7"}, want: "203.0.113.7:0"},
+		{name: "malformed entry", peer: "10.1.2.3:4000", headers: []string{"not-an-ip"}, want: "10.1.2.3:4000"},
+		{name: "ipv6 client", peer: "10.1.2.3:4000", headers: []string{"2001:db8::1"}, want: "[2001:db8::1]:0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/remote", nil)
+			req.RemoteAddr = tt.peer
+			for _, h := range tt.headers {
+				req.Header.Add("X-Forwarded-For", h)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", rec.Code)
+			}
+			if got := rec.Body.String(); got != tt.want {
+				t.Fatalf("RemoteAddr=%q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+)
+
+func TestUploadStatsHandler(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "device1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]int{
+		"a.log":             10,
+		"b.bin":             1024,
+		"device1/c.tar.gz":  500,
+		"device1/empty.txt": 0,
+	}
+	for name, size := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handlers.UploadStatsHandler(dir)(rec, httptest.NewRequest(http.MethodGet, "/api/v1/owner/uploads/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var usage handlers.DirectoryUsage
+	if err := json.Unmarshal(rec.Body.Bytes(), &usage); err != nil {
+		t.Fatal(err)
+	}
+	if usage.FileCount != 4 || usage.TotalBytes != 1534 || usage.Truncated {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+}
+
+func TestUploadStatsHandler_MissingDirectory(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handlers.UploadStatsHandler(filepath.Join(t.TempDir(), "missing"))(rec, httptest.NewRequest(http.MethodGet, "/api/v1/owner/uploads/stats", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package handlersTest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/version"
+)
+
+func TestVersionHandler(t *testing.T) {
+	origVersion, origCommit, origDate := version.VERSION, version.COMMIT, version.BUILD_DATE
+	t.Cleanup(func() { version.VERSION, version.COMMIT, version.BUILD_DATE = origVersion, origCommit, origDate })
+	version.VERSION = "1.2.3"
+	version.COMMIT = "0123456789abcdef"
+	version.BUILD_DATE = "2025-01-02T03:04:05Z"
+
+	handler := api.NewHTTPHandler(nil, nil).RegisterRoutes(nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/version", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var response handlers.VersionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	want := handlers.VersionResponse{
+		Version:          "1.2.3",
+		Commit:           "0123456789abcdef",
+		BuildDate:        "2025-01-02T03:04:05Z",
+		GoVersion:        runtime.Version(),
+		ProtocolVersions: []int{101},
+	}
+	if response.Version != want.Version || response.Commit != want.Commit || response.BuildDate != want.BuildDate ||
+		response.GoVersion != want.GoVersion || !slices.Equal(response.ProtocolVersions, want.ProtocolVersions) {
+		t.Fatalf("expected %+v, got %+v", want, response)
+	}
+}
@@ -5,20 +5,29 @@ package handlersTest
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/fido-device-onboard/go-fdo"
 	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
 	"github.com/fido-device-onboard/go-fdo-server/internal/db"
 	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
 	"github.com/fido-device-onboard/go-fdo/testdata"
 )
 
@@ -29,6 +38,23 @@ func setupTestDB(t *testing.T) {
 	}
 }
 
+// errorMessage checks the response is a JSON error with the expected code
+// and returns its message
+func errorMessage(t *testing.T, rec *httptest.ResponseRecorder, code string) string {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON error, got Content-Type %q", ct)
+	}
+	var resp handlers.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid JSON error body %q: %v", rec.Body.String(), err)
+	}
+	if resp.Error.Code != code {
+		t.Errorf("Expected error code %q, got %q", code, resp.Error.Code)
+	}
+	return resp.Error.Message
+}
+
 type testData struct {
 	validVoucherPEM        []byte
 	corruptedPEM           []byte
@@ -140,7 +166,7 @@ func TestInsertVoucherHandler(t *testing.T) {
 			ownerKey:           testData.extendedOwnerPublicKey, // Next owner key
 			expectedStatusCode: http.StatusBadRequest,
 			expectedBodyOneOf: []string{
-				"Invalid ownership voucher\n",
+				"Invalid ownership voucher",
 			},
 		},
 		{
@@ -150,7 +176,7 @@ func TestInsertVoucherHandler(t *testing.T) {
 			ownerKey:           testData.ownerPublicKey, // Doesn't matter, fails before key check
 			expectedStatusCode: http.StatusBadRequest,
 			expectedBodyOneOf: []string{
-				"Unable to decode cbor\n",
+				"Unable to decode cbor",
 			},
 		},
 		{
@@ -160,7 +186,7 @@ func TestInsertVoucherHandler(t *testing.T) {
 			ownerKey:           testData.ownerPublicKey, // Doesn't matter, fails before key check
 			expectedStatusCode: http.StatusBadRequest,
 			expectedBodyOneOf: []string{
-				"Unable to decode PEM content\n",
+				"Unable to decode PEM content",
 			},
 		},
 	}
@@ -172,7 +198,7 @@ func TestInsertVoucherHandler(t *testing.T) {
 			rec := httptest.NewRecorder()
 
 			// Create handler with appropriate owner key for this test case
-			handler := handlers.InsertVoucherHandler([]crypto.PublicKey{tc.ownerKey})
+			handler := handlers.InsertVoucherHandler([]crypto.PublicKey{tc.ownerKey}, false, 0)
 
 			// Call handler
 			handler(rec, req)
@@ -183,7 +209,7 @@ func TestInsertVoucherHandler(t *testing.T) {
 			}
 
 			if len(tc.expectedBodyOneOf) > 0 {
-				body := rec.Body.String()
+				body := errorMessage(t, rec, handlers.ErrorCodeInvalidRequest)
 				found := false
 				for _, expected := range tc.expectedBodyOneOf {
 					if body == expected {
@@ -213,13 +239,13 @@ func TestInsertVoucherHandler_WrongOwnerKey(t *testing.T) {
 	}
 	req := httptest.NewRequest(http.MethodPost, "/api/v1/owner/vouchers", bytes.NewReader(voucherPEM))
 	rec := httptest.NewRecorder()
-	handler := handlers.InsertVoucherHandler([]crypto.PublicKey{wrongOwnerKey.Public()})
+	handler := handlers.InsertVoucherHandler([]crypto.PublicKey{wrongOwnerKey.Public()}, false, 0)
 	handler(rec, req)
 	if rec.Code != http.StatusBadRequest {
 		t.Errorf("Expected status 400 Bad Request for wrong owner key, got %d", rec.Code)
 	}
-	body := rec.Body.String()
-	expectedError := "Invalid ownership voucher\n"
+	body := errorMessage(t, rec, handlers.ErrorCodeInvalidRequest)
+	expectedError := "Invalid ownership voucher"
 	if body != expectedError {
 		t.Errorf("Expected error '%s', got: '%s'", expectedError, body)
 	}
@@ -262,7 +288,7 @@ func TestInsertVoucherHandler_InvalidHeaderFields(t *testing.T) {
 				v.Version = 999 // Invalid version
 				v.Header.Val.Version = 999
 			},
-			expectedError: "Invalid ownership voucher\n",
+			expectedError: "Invalid ownership voucher",
 		},
 		{
 			name: "Protocol version mismatch",
@@ -270,7 +296,7 @@ func TestInsertVoucherHandler_InvalidHeaderFields(t *testing.T) {
 				v.Version = fdoProtocolVersion
 				v.Header.Val.Version = 100 // Mismatch
 			},
-			expectedError: "Invalid ownership voucher\n",
+			expectedError: "Invalid ownership voucher",
 		},
 		{
 			name: "Zero GUID",
@@ -280,28 +306,28 @@ func TestInsertVoucherHandler_InvalidHeaderFields(t *testing.T) {
 					v.Header.Val.GUID[i] = 0
 				}
 			},
-			expectedError: "Invalid ownership voucher\n",
+			expectedError: "Invalid ownership voucher",
 		},
 		{
 			name: "Empty DeviceInfo",
 			modifyVoucher: func(v *fdo.Voucher) {
 				v.Header.Val.DeviceInfo = ""
 			},
-			expectedError: "Invalid ownership voucher\n",
+			expectedError: "Invalid ownership voucher",
 		},
 		{
 			name: "Invalid ManufacturerKey",
 			modifyVoucher: func(v *fdo.Voucher) {
 				v.Header.Val.ManufacturerKey.Type = 0 // Invalid type
 			},
-			expectedError: "Invalid ownership voucher\n",
+			expectedError: "Invalid ownership voucher",
 		},
 		{
 			name: "Empty RvInfo",
 			modifyVoucher: func(v *fdo.Voucher) {
 				v.Header.Val.RvInfo = nil
 			},
-			expectedError: "Invalid ownership voucher\n",
+			expectedError: "Invalid ownership voucher",
 		},
 	}
 
@@ -325,16 +351,555 @@ func TestInsertVoucherHandler_InvalidHeaderFields(t *testing.T) {
 			rec := httptest.NewRecorder()
 
 			// Create handler with correct owner key and verify response for each invalid voucher
-			handler := handlers.InsertVoucherHandler([]crypto.PublicKey{ownerPubKey})
+			handler := handlers.InsertVoucherHandler([]crypto.PublicKey{ownerPubKey}, false, 0)
 			handler(rec, req)
 
 			if rec.Code != http.StatusBadRequest {
 				t.Errorf("Expected status 400 Bad Request, got %d: %s", rec.Code, rec.Body.String())
 			}
-			body := rec.Body.String()
+			body := errorMessage(t, rec, handlers.ErrorCodeInvalidRequest)
 			if body != tc.expectedError {
 				t.Errorf("Expected error '%s', got: '%s'", tc.expectedError, body)
 			}
 		})
 	}
 }
+
+func TestDeleteVoucherHandler(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	testData := setupTestData(t)
+
+	block, _ := pem.Decode(testData.validVoucherPEM)
+	var voucher fdo.Voucher
+	if err := cbor.Unmarshal(block.Bytes, &voucher); err != nil {
+		t.Fatalf("Failed to unmarshal voucher: %v", err)
+	}
+	guid := hex.EncodeToString(voucher.Header.Val.GUID[:])
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /owner/vouchers", handlers.InsertVoucherHandler([]crypto.PublicKey{testData.ownerPublicKey}, false, 0))
+	mux.HandleFunc("GET /owner/vouchers/{guid}", handlers.GetVoucherByGUIDHandler)
+	mux.Handle("DELETE /owner/vouchers/{guid}", handlers.DeleteVoucherHandler(state))
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(body)))
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/owner/vouchers", testData.validVoucherPEM); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 inserting voucher, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodGet, "/owner/vouchers/"+guid, nil); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 fetching voucher, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := db.SetDeviceLabel(t.Context(), voucher.Header.Val.GUID[:], "rack 4"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := do(http.MethodDelete, "/owner/vouchers/"+guid, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204 deleting voucher, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var labels int64
+	if err := state.DB.Model(&db.DeviceLabel{}).Count(&labels).Error; err != nil || labels != 0 {
+		t.Errorf("Expected the label to be deleted with the voucher, found %d: %v", labels, err)
+	}
+	if rec := do(http.MethodGet, "/owner/vouchers/"+guid, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 fetching deleted voucher, got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/owner/vouchers/"+guid, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 deleting missing voucher, got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/owner/vouchers/not-a-guid", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid GUID, got %d", rec.Code)
+	}
+
+	// A deleted voucher can be inserted again: owner keys are per server,
+	// not per voucher, so nothing is left behind to conflict with it
+	if rec := do(http.MethodPost, "/owner/vouchers", testData.validVoucherPEM); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 re-inserting deleted voucher, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodGet, "/owner/vouchers/"+guid, nil); rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200 fetching re-inserted voucher, got %d", rec.Code)
+	}
+}
+
+func TestDeleteVoucherHandler_Archive(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	testData := setupTestData(t)
+
+	block, _ := pem.Decode(testData.validVoucherPEM)
+	var voucher fdo.Voucher
+	if err := cbor.Unmarshal(block.Bytes, &voucher); err != nil {
+		t.Fatalf("Failed to unmarshal voucher: %v", err)
+	}
+	guid := hex.EncodeToString(voucher.Header.Val.GUID[:])
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /owner/vouchers", handlers.InsertVoucherHandler([]crypto.PublicKey{testData.ownerPublicKey}, false, 0))
+	mux.HandleFunc("GET /owner/vouchers", handlers.GetVoucherHandler)
+	mux.HandleFunc("GET /owner/vouchers/{guid}", handlers.GetVoucherByGUIDHandler)
+	mux.Handle("DELETE /owner/vouchers/{guid}", handlers.DeleteVoucherHandler(db.VoucherArchiver{State: state}))
+	mux.HandleFunc("GET /owner/vouchers/archive", handlers.GetArchivedVouchersHandler)
+	mux.HandleFunc("GET /owner/vouchers/archive/{guid}", handlers.GetArchivedVoucherHandler)
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(body)))
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/owner/vouchers", testData.validVoucherPEM); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 inserting voucher, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodGet, "/owner/vouchers/archive/"+guid, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404 fetching voucher not archived, got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/owner/vouchers/"+guid, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204 deleting voucher, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Gone from the listings
+	if rec := do(http.MethodGet, "/owner/vouchers/"+guid, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 fetching deleted voucher, got %d", rec.Code)
+	}
+	rec := do(http.MethodGet, "/owner/vouchers", nil)
+	var listed []db.Voucher
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("Failed to decode voucher list: %v", err)
+	}
+	if len(listed) != 0 {
+		t.Errorf("Expected no listed voucher after deletion, got %d", len(listed))
+	}
+
+	// Present in the archive
+	rec = do(http.MethodGet, "/owner/vouchers/archive", nil)
+	var archived []db.ArchivedVoucher
+	if err := json.Unmarshal(rec.Body.Bytes(), &archived); err != nil {
+		t.Fatalf("Failed to decode archived voucher list: %v", err)
+	}
+	if len(archived) != 1 || hex.EncodeToString(archived[0].GUID) != guid || archived[0].ArchivedAt.IsZero() {
+		t.Fatalf("Expected the deleted voucher in the archive, got %+v", archived)
+	}
+	rec = do(http.MethodGet, "/owner/vouchers/archive/"+guid, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 fetching archived voucher, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if archivedBlock, _ := pem.Decode(rec.Body.Bytes()); archivedBlock == nil || !bytes.Equal(archivedBlock.Bytes, block.Bytes) {
+		t.Errorf("Expected the archived voucher to match the deleted one")
+	}
+
+	// Deleting the voucher again after re-inserting it replaces the archived copy
+	if rec := do(http.MethodPost, "/owner/vouchers", testData.validVoucherPEM); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 re-inserting deleted voucher, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodDelete, "/owner/vouchers/"+guid, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204 deleting re-inserted voucher, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if list, err := db.QueryArchivedVouchers(t.Context(), false); err != nil || len(list) != 1 {
+		t.Errorf("Expected a single archived voucher, got %d, %v", len(list), err)
+	}
+}
+
+func TestGetVoucherHandler_ContentNegotiation(t *testing.T) {
+	setupTestDB(t)
+	testData := setupTestData(t)
+
+	block, _ := pem.Decode(testData.validVoucherPEM)
+	var voucher fdo.Voucher
+	if err := cbor.Unmarshal(block.Bytes, &voucher); err != nil {
+		t.Fatalf("Failed to unmarshal voucher: %v", err)
+	}
+	guid := voucher.Header.Val.GUID
+	if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: block.Bytes, DeviceInfo: voucher.Header.Val.DeviceInfo}); err != nil {
+		t.Fatalf("Failed to insert voucher: %v", err)
+	}
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/vouchers", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		handlers.GetVoucherHandler(rec, req)
+		return rec
+	}
+
+	for _, accept := range []string{"", "application/json", "*/*", "application/cbor;q=0.5, application/json"} {
+		rec := get(accept)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Accept %q: expected status 200, got %d: %s", accept, rec.Code, rec.Body.String())
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("Accept %q: expected JSON, got %q", accept, ct)
+		}
+		var summaries []map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &summaries); err != nil {
+			t.Fatalf("Accept %q: invalid JSON: %v", accept, err)
+		}
+		if len(summaries) != 1 || summaries[0]["guid"] != hex.EncodeToString(guid[:]) {
+			t.Fatalf("Accept %q: unexpected summaries %v", accept, summaries)
+		}
+		if _, ok := summaries[0]["cbor"]; ok {
+			t.Errorf("Accept %q: expected summary without voucher CBOR", accept)
+		}
+	}
+
+	for _, accept := range []string{"application/cbor", "application/json;q=0.5, application/cbor"} {
+		rec := get(accept)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Accept %q: expected status 200, got %d: %s", accept, rec.Code, rec.Body.String())
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/cbor" {
+			t.Fatalf("Accept %q: expected CBOR, got %q", accept, ct)
+		}
+		var vouchers []fdo.Voucher
+		if err := cbor.Unmarshal(rec.Body.Bytes(), &vouchers); err != nil {
+			t.Fatalf("Accept %q: invalid CBOR: %v", accept, err)
+		}
+		if len(vouchers) != 1 || vouchers[0].Header.Val.GUID != guid {
+			t.Fatalf("Accept %q: unexpected vouchers %+v", accept, vouchers)
+		}
+	}
+
+	if rec := get("text/html"); rec.Code != http.StatusNotAcceptable {
+		t.Errorf("Expected status 406 for unsupported media type, got %d", rec.Code)
+	}
+}
+
+func TestVerifyOwnershipVoucher_ClockSkew(t *testing.T) {
+	voucherPEM, err := testdata.Files.ReadFile("ov.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(voucherPEM)
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(block.Bytes, &ov); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the device certificate with one only valid from a minute from
+	// now, as issued by a manufacturer whose clock is ahead. Dropping the
+	// entries avoids re-signing them over the modified header.
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "device"},
+		NotBefore:             time.Now().Add(time.Minute),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, deviceKey.Public(), deviceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ov.CertChain = &[]*cbor.X509Certificate{(*cbor.X509Certificate)(deviceCert)}
+	digest := ov.Header.Val.CertChainHash.Algorithm.HashFunc().New()
+	digest.Write(deviceCert.Raw)
+	ov.Header.Val.CertChainHash.Value = digest.Sum(nil)
+	ov.Entries = nil
+
+	tests := []struct {
+		name      string
+		clockSkew time.Duration
+		wantErr   bool
+	}{
+		{"no skew", 0, true},
+		{"skew too small", 30 * time.Second, true},
+		{"within skew", 5 * time.Minute, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := handlers.VerifyOwnershipVoucher(&ov, tc.clockSkew)
+			if tc.wantErr && err == nil {
+				t.Fatal("expected the not yet valid device certificate to be rejected")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("expected the device certificate to be accepted, got %v", err)
+			}
+		})
+	}
+}
+
+// testOwnerKeys signs voucher extensions with a single key
+type testOwnerKeys struct {
+	key crypto.Signer
+}
+
+func (k testOwnerKeys) OwnerKey(context.Context, protocol.KeyType, int) (crypto.Signer, []*x509.Certificate, error) {
+	return k.key, nil, nil
+}
+
+func TestResellHandler(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	testData := setupTestData(t)
+
+	block, _ := pem.Decode(testData.validVoucherPEM)
+	var voucher fdo.Voucher
+	if err := cbor.Unmarshal(block.Bytes, &voucher); err != nil {
+		t.Fatalf("Failed to unmarshal voucher: %v", err)
+	}
+	guid := hex.EncodeToString(voucher.Header.Val.GUID[:])
+
+	// The test voucher is not extended, its owner key is the manufacturer's
+	mfgKeyPEM, err := testdata.Files.ReadFile("mfg_key.pem")
+	if err != nil {
+		t.Fatalf("Failed to read manufacturer key: %v", err)
+	}
+	mfgKeyBlock, _ := pem.Decode(mfgKeyPEM)
+	mfgKey, err := x509.ParseECPrivateKey(mfgKeyBlock.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse manufacturer key: %v", err)
+	}
+	to2Server := &fdo.TO2Server{OwnerKeys: testOwnerKeys{mfgKey}, VouchersForExtension: state}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /owner/vouchers", handlers.InsertVoucherHandler([]crypto.PublicKey{testData.ownerPublicKey}, false, 0))
+	mux.HandleFunc("GET /owner/vouchers/{guid}", handlers.GetVoucherByGUIDHandler)
+	mux.HandleFunc("POST /owner/resell/{guid}", handlers.ResellHandler(to2Server))
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(body)))
+		return rec
+	}
+	publicKeyPEM := func(key crypto.PublicKey) []byte {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatalf("Failed to marshal public key: %v", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}
+
+	if rec := do(http.MethodPost, "/owner/vouchers", testData.validVoucherPEM); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 inserting voucher, got %d: %s", rec.Code, rec.Body.String())
+	}
+	nextOwnerKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate next owner key: %v", err)
+	}
+	otherCurveKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	rejected := []struct {
+		name string
+		guid string
+		body []byte
+		code int
+	}{
+		{"missing key", guid, nil, http.StatusBadRequest},
+		{"invalid PEM", guid, testData.invalidPEM, http.StatusBadRequest},
+		{"not a public key", guid, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("not a key")}), http.StatusBadRequest},
+		{"key type of another voucher", guid, publicKeyPEM(otherCurveKey.Public()), http.StatusBadRequest},
+		{"unknown GUID", "00112233445566778899aabbccddeeff", publicKeyPEM(nextOwnerKey.Public()), http.StatusNotFound},
+	}
+	for _, tc := range rejected {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := do(http.MethodPost, "/owner/resell/"+tc.guid, tc.body)
+			if rec.Code != tc.code {
+				t.Fatalf("Expected status %d, got %d: %s", tc.code, rec.Code, rec.Body.String())
+			}
+			code := handlers.ErrorCodeInvalidRequest
+			if tc.code == http.StatusNotFound {
+				code = handlers.ErrorCodeNotFound
+			}
+			errorMessage(t, rec, code)
+		})
+	}
+	// Rejected requests leave the voucher with this owner
+	if rec := do(http.MethodGet, "/owner/vouchers/"+guid, nil); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 fetching voucher, got %d", rec.Code)
+	}
+
+	rec := do(http.MethodPost, "/owner/resell/"+guid, publicKeyPEM(nextOwnerKey.Public()))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 reselling voucher, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-pem-file" {
+		t.Errorf("Expected Content-Type application/x-pem-file, got %q", ct)
+	}
+	block, _ = pem.Decode(rec.Body.Bytes())
+	if block == nil || block.Type != "OWNERSHIP VOUCHER" {
+		t.Fatalf("Expected an OWNERSHIP VOUCHER PEM block, got %q", rec.Body.String())
+	}
+	var extended fdo.Voucher
+	if err := cbor.Unmarshal(block.Bytes, &extended); err != nil {
+		t.Fatalf("Failed to unmarshal resold voucher: %v", err)
+	}
+	if extended.Header.Val.GUID != voucher.Header.Val.GUID {
+		t.Errorf("Expected the resold voucher to keep GUID %s, got %x", guid, extended.Header.Val.GUID[:])
+	}
+	ownerKey, err := extended.OwnerPublicKey()
+	if err != nil {
+		t.Fatalf("Failed to get owner key of resold voucher: %v", err)
+	}
+	if !nextOwnerKey.PublicKey.Equal(ownerKey) {
+		t.Error("Expected the resold voucher to be owned by the next owner key")
+	}
+	if err := extended.VerifyEntries(); err != nil {
+		t.Errorf("Expected the resold voucher entries to verify: %v", err)
+	}
+
+	// The voucher now belongs to the next owner
+	if rec := do(http.MethodGet, "/owner/vouchers/"+guid, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 fetching resold voucher, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/owner/resell/"+guid, publicKeyPEM(nextOwnerKey.Public())); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 reselling voucher again, got %d", rec.Code)
+	}
+}
+
+// resellableVoucher returns the test voucher, unextended and with key as its
+// manufacturer key, stored in a new database
+func resellableVoucher(t *testing.T, key crypto.Signer, keyType protocol.KeyType) (*db.State, fdo.Voucher) {
+	t.Helper()
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	voucherPEM, err := testdata.Files.ReadFile("ov.pem")
+	if err != nil {
+		t.Fatalf("Failed to read test voucher: %v", err)
+	}
+	block, _ := pem.Decode(voucherPEM)
+	var voucher fdo.Voucher
+	if err := cbor.Unmarshal(block.Bytes, &voucher); err != nil {
+		t.Fatalf("Failed to unmarshal voucher: %v", err)
+	}
+	var mfgKey *protocol.PublicKey
+	switch pub := key.Public().(type) {
+	case *ecdsa.PublicKey:
+		mfgKey, err = protocol.NewPublicKey(keyType, pub, false)
+	case *rsa.PublicKey:
+		mfgKey, err = protocol.NewPublicKey(keyType, pub, false)
+	}
+	if err != nil {
+		t.Fatalf("Failed to encode manufacturer key: %v", err)
+	}
+	voucher.Header.Val.ManufacturerKey = *mfgKey
+	voucher.Entries = nil
+	if err := state.AddVoucher(context.Background(), &voucher); err != nil {
+		t.Fatalf("Failed to add voucher: %v", err)
+	}
+	return state, voucher
+}
+
+func TestResellHandler_NextOwnerKeys(t *testing.T) {
+	ecOwner, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaOwner, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecNext, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaNext, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edNext, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeyPEM := func(key crypto.PublicKey) []byte {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatalf("Failed to marshal public key: %v", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "next owner"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, ecNext.Public(), ecNext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	ecPrivateDER, err := x509.MarshalECPrivateKey(ecNext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		owner     crypto.Signer
+		keyType   protocol.KeyType
+		body      []byte
+		nextOwner crypto.PublicKey // nil if rejected
+	}{
+		{"EC public key", ecOwner, protocol.Secp384r1KeyType, publicKeyPEM(ecNext.Public()), ecNext.Public()},
+		{"EC certificate", ecOwner, protocol.Secp384r1KeyType, certPEM, ecNext.Public()},
+		{"RSA public key", rsaOwner, protocol.RsaPkcsKeyType, publicKeyPEM(rsaNext.Public()), rsaNext.Public()},
+		{"RSA public key for an EC voucher", ecOwner, protocol.Secp384r1KeyType, publicKeyPEM(rsaNext.Public()), nil},
+		{"EC public key for an RSA voucher", rsaOwner, protocol.RsaPkcsKeyType, publicKeyPEM(ecNext.Public()), nil},
+		{"Ed25519 public key", ecOwner, protocol.Secp384r1KeyType, publicKeyPEM(edNext), nil},
+		{"private key", ecOwner, protocol.Secp384r1KeyType, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecPrivateDER}), nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			state, voucher := resellableVoucher(t, tc.owner, tc.keyType)
+			to2Server := &fdo.TO2Server{OwnerKeys: testOwnerKeys{tc.owner}, VouchersForExtension: state}
+			guid := hex.EncodeToString(voucher.Header.Val.GUID[:])
+			req := httptest.NewRequest(http.MethodPost, "/owner/resell/"+guid, bytes.NewReader(tc.body))
+			req.SetPathValue("guid", guid)
+			rec := httptest.NewRecorder()
+			handlers.ResellHandler(to2Server)(rec, req)
+
+			if tc.nextOwner == nil {
+				if rec.Code != http.StatusBadRequest {
+					t.Fatalf("Expected status 400, got %d: %s", rec.Code, rec.Body.String())
+				}
+				errorMessage(t, rec, handlers.ErrorCodeInvalidRequest)
+				if _, err := state.Voucher(context.Background(), voucher.Header.Val.GUID); err != nil {
+					t.Fatalf("Expected the voucher to be kept, got %v", err)
+				}
+				return
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+			block, _ := pem.Decode(rec.Body.Bytes())
+			if block == nil || block.Type != "OWNERSHIP VOUCHER" {
+				t.Fatalf("Expected an OWNERSHIP VOUCHER PEM block, got %q", rec.Body.String())
+			}
+			var extended fdo.Voucher
+			if err := cbor.Unmarshal(block.Bytes, &extended); err != nil {
+				t.Fatalf("Failed to unmarshal resold voucher: %v", err)
+			}
+			ownerKey, err := extended.OwnerPublicKey()
+			if err != nil {
+				t.Fatalf("Failed to get owner key of resold voucher: %v", err)
+			}
+			if !tc.nextOwner.(interface{ Equal(crypto.PublicKey) bool }).Equal(ownerKey) {
+				t.Error("Expected the resold voucher to be owned by the next owner key")
+			}
+			if err := extended.VerifyEntries(); err != nil {
+				t.Errorf("Expected the resold voucher entries to verify: %v", err)
+			}
+		})
+	}
+}
@@ -4,33 +4,152 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/time/rate"
 	"gorm.io/gorm"
 
+	"github.com/fido-device-onboard/go-fdo/cbor"
 	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/protocol"
 
 	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
 )
 
 // HTTPHandler handles HTTP requests
 type HTTPHandler struct {
-	handler *transport.Handler
-	state   *gorm.DB
+	handler       *transport.Handler
+	state         *gorm.DB
+	maxConcurrent int
+	timeout       time.Duration
+	timeouts      map[string]time.Duration
+	proxies       []netip.Prefix
+	readiness     []handlers.ReadinessCheck
+	rootRedirect  string
+	msgTimeout    time.Duration
+	metrics       *prometheus.Registry
+	fdoMessages   *prometheus.CounterVec
+	cors          *corsPolicy
+	clientRate    rate.Limit
+	clientBurst   int
+	apiKeys       [][sha256.Size]byte
 }
 
-func rateLimitMiddleware(limiter *rate.Limiter, next http.Handler) http.HandlerFunc {
+// rateLimitMiddleware rejects requests with 429 while the limiter returned
+// for them has no token left, telling clients when to retry
+func rateLimitMiddleware(limiter func(*http.Request) *rate.Limiter, next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !limiter.Allow() {
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		reservation := limiter(r).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeAPIError(w, http.StatusTooManyRequests, handlers.ErrorCodeRateLimited, "too many requests")
 			return
 		}
 		next.ServeHTTP(w, r)
 	}
 }
 
+// apiKeyMiddleware rejects with 401 the requests without one of the API
+// keys whose SHA-256 digests are given, sent as a bearer token or in the
+// X-API-Key header. The digest of the key sent is compared to all of them
+// in constant time.
+func apiKeyMiddleware(digests [][sha256.Size]byte, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); key == "" && ok && strings.EqualFold(scheme, "Bearer") {
+			key = strings.TrimSpace(token)
+		}
+		if key != "" {
+			digest := sha256.Sum256([]byte(key))
+			var match int
+			for _, allowed := range digests {
+				match |= subtle.ConstantTimeCompare(digest[:], allowed[:])
+			}
+			if match == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIError(w, http.StatusUnauthorized, handlers.ErrorCodeUnauthorized, "missing or invalid API key")
+	}
+}
+
+// writeAPIError replies to the request with the given status and an
+// ErrorResponse body, as the management API handlers do
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(handlers.ErrorResponse{Error: handlers.ErrorDetail{Code: code, Message: message}})
+}
+
+// clientRateLimiters holds a token bucket per client address
+type clientRateLimiters struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	clients   map[string]*rate.Limiter
+	lastSweep time.Time
+}
+
+func newClientRateLimiters(limit rate.Limit, burst int) *clientRateLimiters {
+	return &clientRateLimiters{limit: limit, burst: burst, clients: make(map[string]*rate.Limiter), lastSweep: time.Now()}
+}
+
+// limiter returns the token bucket of the client of r, identified by its
+// IP address
+func (c *clientRateLimiters) limiter(r *http.Request) *rate.Limiter {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.lastSweep) > time.Minute {
+		// Buckets refilled are those of clients gone quiet, and no
+		// different from new ones
+		for client, limiter := range c.clients {
+			if limiter.TokensAt(now) >= float64(c.burst) {
+				delete(c.clients, client)
+			}
+		}
+		c.lastSweep = now
+	}
+	limiter, ok := c.clients[client]
+	if !ok {
+		limiter = rate.NewLimiter(c.limit, c.burst)
+		c.clients[client] = limiter
+	}
+	return limiter
+}
+
 func bodySizeMiddleware(limitBytes int64, next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		r.Body = struct {
@@ -44,24 +163,571 @@ func bodySizeMiddleware(limitBytes int64, next http.Handler) http.HandlerFunc {
 	}
 }
 
+// concurrencyLimitMiddleware rejects requests with 503 while limit requests
+// are already being served.
+func concurrencyLimitMiddleware(limit int, next http.Handler) http.HandlerFunc {
+	inFlight := make(chan struct{}, limit)
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case inFlight <- struct{}{}:
+			defer func() { <-inFlight }()
+			next.ServeHTTP(w, r)
+		default:
+			writeAPIError(w, http.StatusServiceUnavailable, handlers.ErrorCodeUnavailable, "too many requests in progress")
+		}
+	}
+}
+
+// timeoutMiddleware bounds the time spent serving a request. The deadline of
+// the longest path prefix in groups matching the request applies, otherwise
+// defaultTimeout. Requests exceeding it receive 503 and their context is
+// cancelled, aborting the database queries made with it.
+func timeoutMiddleware(defaultTimeout time.Duration, groups map[string]time.Duration, next http.Handler) http.HandlerFunc {
+	type routeGroup struct {
+		prefix  string
+		handler http.Handler
+	}
+	timeoutBody, _ := json.Marshal(handlers.ErrorResponse{Error: handlers.ErrorDetail{Code: handlers.ErrorCodeTimeout, Message: "request timed out"}})
+	withTimeout := func(timeout time.Duration) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		timeoutHandler := http.TimeoutHandler(next, timeout, string(timeoutBody))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeoutHandler.ServeHTTP(timeoutResponseWriter{w}, r)
+		})
+	}
+	routeGroups := make([]routeGroup, 0, len(groups))
+	for prefix, timeout := range groups {
+		routeGroups = append(routeGroups, routeGroup{prefix: prefix, handler: withTimeout(timeout)})
+	}
+	slices.SortFunc(routeGroups, func(a, b routeGroup) int { return len(b.prefix) - len(a.prefix) })
+	defaultHandler := withTimeout(defaultTimeout)
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, group := range routeGroups {
+			if strings.HasPrefix(r.URL.Path, group.prefix) {
+				group.handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		defaultHandler.ServeHTTP(w, r)
+	}
+}
+
+// timeoutResponseWriter labels as JSON the 503 responses written without a
+// content type, which http.TimeoutHandler sends when a request times out
+type timeoutResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w timeoutResponseWriter) WriteHeader(status int) {
+	if status == http.StatusServiceUnavailable && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// messageDeadlineMiddleware bounds the context of each FDO protocol message
+// with the timeout, so that the database and FSIM operations made while
+// handling it are cancelled once it expires.
+func messageDeadlineMiddleware(timeout time.Duration, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// fdoMessageNames names the FDO protocol messages for logging
+var fdoMessageNames = map[uint8]string{
+	protocol.DIAppStartMsgType:                "DI.AppStart",
+	protocol.DISetCredentialsMsgType:          "DI.SetCredentials",
+	protocol.DISetHmacMsgType:                 "DI.SetHMAC",
+	protocol.DIDoneMsgType:                    "DI.Done",
+	protocol.TO0HelloMsgType:                  "TO0.Hello",
+	protocol.TO0HelloAckMsgType:               "TO0.HelloAck",
+	protocol.TO0OwnerSignMsgType:              "TO0.OwnerSign",
+	protocol.TO0AcceptOwnerMsgType:            "TO0.AcceptOwner",
+	protocol.TO1HelloRVMsgType:                "TO1.HelloRV",
+	protocol.TO1HelloRVAckMsgType:             "TO1.HelloRVAck",
+	protocol.TO1ProveToRVMsgType:              "TO1.ProveToRV",
+	protocol.TO1RVRedirectMsgType:             "TO1.RVRedirect",
+	protocol.TO2HelloDeviceMsgType:            "TO2.HelloDevice",
+	protocol.TO2ProveOVHdrMsgType:             "TO2.ProveOVHdr",
+	protocol.TO2GetOVNextEntryMsgType:         "TO2.GetOVNextEntry",
+	protocol.TO2OVNextEntryMsgType:            "TO2.OVNextEntry",
+	protocol.TO2ProveDeviceMsgType:            "TO2.ProveDevice",
+	protocol.TO2SetupDeviceMsgType:            "TO2.SetupDevice",
+	protocol.TO2DeviceServiceInfoReadyMsgType: "TO2.DeviceServiceInfoReady",
+	protocol.TO2OwnerServiceInfoReadyMsgType:  "TO2.OwnerServiceInfoReady",
+	protocol.TO2DeviceServiceInfoMsgType:      "TO2.DeviceServiceInfo",
+	protocol.TO2OwnerServiceInfoMsgType:       "TO2.OwnerServiceInfo",
+	protocol.TO2DoneMsgType:                   "TO2.Done",
+	protocol.TO2Done2MsgType:                  "TO2.Done2",
+	protocol.ErrorMsgType:                     "Error",
+}
+
+func fdoMessageName(msgType string) string {
+	n, err := strconv.ParseUint(msgType, 10, 8)
+	if err != nil {
+		return "unknown"
+	}
+	if name, ok := fdoMessageNames[uint8(n)]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// requestIDRegexp matches the request IDs accepted from clients
+var requestIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// newRequestID returns a random (version 4) UUID
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// requestIDMiddleware keeps the ID of the request sent by the client in the
+// RequestIDHeader, or generates one, stores it in the request context for
+// handlers.RequestID and sets it on the response.
+func requestIDMiddleware(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(handlers.RequestIDHeader)
+		if !requestIDRegexp.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(handlers.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(handlers.ContextWithRequestID(r.Context(), id)))
+	}
+}
+
+// maxLoggedMessageSize bounds the part of a request body kept to find the
+// device GUID
+const maxLoggedMessageSize = 1024
+
+// fdoStatusRecorder records the status and FDO message type of a response
+type fdoStatusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *fdoStatusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *fdoStatusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// outcome is "error" if the message failed, with an HTTP error or an FDO
+// error message, and "ok" otherwise
+func (r *fdoStatusRecorder) outcome() string {
+	if r.status >= http.StatusBadRequest || r.Header().Get("Message-Type") == strconv.Itoa(int(protocol.ErrorMsgType)) {
+		return "error"
+	}
+	return "ok"
+}
+
+// fdoLoggingMiddleware logs each FDO protocol message at debug level: its
+// name, the device GUID when the message carries it in the clear, the
+// response message and status, all tagged with the request ID set by
+// requestIDMiddleware. Message bodies and the Authorization header are never
+// logged, as they may hold keys and secrets.
+func fdoLoggingMiddleware(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !slog.Default().Enabled(r.Context(), slog.LevelDebug) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		msgType := r.PathValue("msg")
+		var head bytes.Buffer
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{
+			Reader: io.TeeReader(r.Body, &limitedWriter{w: &head, n: maxLoggedMessageSize}),
+			Closer: r.Body,
+		}
+		rec := &fdoStatusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		attrs := []any{
+			"request_id", handlers.RequestID(r.Context()),
+			"message", fdoMessageName(msgType),
+			"msg_type", msgType,
+		}
+		if guid, ok := fdoMessageGUID(msgType, head.Bytes()); ok {
+			attrs = append(attrs, "guid", guid)
+		}
+		attrs = append(attrs,
+			"response", fdoMessageName(rec.Header().Get("Message-Type")),
+			"status", rec.status,
+			"outcome", rec.outcome(),
+			"duration", time.Since(start),
+		)
+		slog.Debug("FDO message", attrs...)
+	}
+}
+
+// limitedWriter keeps the first n bytes written to it and discards the rest
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(b []byte) (int, error) {
+	if l.n > 0 {
+		keep := min(len(b), l.n)
+		_, _ = l.w.Write(b[:keep])
+		l.n -= keep
+	}
+	return len(b), nil
+}
+
+// fdoMessageGUID returns the device GUID of the messages sending it in the
+// clear: TO1.HelloRV and TO2.HelloDevice
+func fdoMessageGUID(msgType string, body []byte) (string, bool) {
+	var index int
+	switch msgType {
+	case strconv.Itoa(int(protocol.TO1HelloRVMsgType)):
+		index = 0
+	case strconv.Itoa(int(protocol.TO2HelloDeviceMsgType)):
+		index = 1
+	default:
+		return "", false
+	}
+	var fields []cbor.RawBytes
+	if err := cbor.Unmarshal(body, &fields); err != nil || len(fields) <= index {
+		return "", false
+	}
+	var guid protocol.GUID
+	if err := cbor.Unmarshal(fields[index], &guid); err != nil {
+		return "", false
+	}
+	return hex.EncodeToString(guid[:]), true
+}
+
+// fdoMetricsMiddleware counts the FDO protocol messages by protocol and
+// outcome
+func fdoMetricsMiddleware(messages *prometheus.CounterVec, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &fdoStatusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		proto := "unknown"
+		if n, err := strconv.ParseUint(r.PathValue("msg"), 10, 8); err == nil {
+			proto = protocol.Of(uint8(n)).String()
+		}
+		messages.WithLabelValues(proto, rec.outcome()).Inc()
+	}
+}
+
+// clientAddrMiddleware replaces the request's RemoteAddr with the client
+// address found in X-Forwarded-For when the peer is one of the trusted
+// proxies. The header is read right to left, skipping trusted proxies, so
+// that the first untrusted address is used: entries to its left may have been
+// forged by the client.
+func clientAddrMiddleware(trusted []netip.Prefix, next http.Handler) http.HandlerFunc {
+	isTrusted := func(addr netip.Addr) bool {
+		addr = addr.Unmap()
+		return slices.ContainsFunc(trusted, func(p netip.Prefix) bool { return p.Contains(addr) })
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		peer, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err != nil || !isTrusted(peer.Addr()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var entries []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			entries = append(entries, strings.Split(header, ",")...)
+		}
+		for i := len(entries) - 1; i >= 0; i-- {
+			client, err := netip.ParseAddr(strings.TrimSpace(entries[i]))
+			if err != nil {
+				slog.Debug("Ignoring malformed X-Forwarded-For", "peer", r.RemoteAddr, "entry", entries[i])
+				break
+			}
+			if !isTrusted(client) || i == 0 {
+				// The client's port is not forwarded, keep the host:port form
+				r2 := r.Clone(r.Context())
+				r2.RemoteAddr = net.JoinHostPort(client.Unmap().String(), "0")
+				r = r2
+				break
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// recoveryMiddleware turns a panic in a handler into a 500 response, logging
+// the panic and stack trace, so that the server keeps running.
+func recoveryMiddleware(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if err, ok := rec.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				// Deliberate abort of the response, let net/http handle it
+				panic(rec)
+			}
+			slog.Error("Panic serving request", "method", r.Method, "path", r.URL.Path, "request_id", handlers.RequestID(r.Context()), "panic", rec, "stack", string(debug.Stack()))
+			writeAPIError(w, http.StatusInternalServerError, handlers.ErrorCodeInternal, "internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	}
+}
+
+// corsPolicy lists what the browsers of other origins may request from the
+// management API
+type corsPolicy struct {
+	origins []string // "*" allows any origin
+	methods []string
+	headers []string
+}
+
+// Methods and headers allowed by WithCORS when none are given
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "X-API-Key"}
+)
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// false if origin is not allowed
+func (p *corsPolicy) allowOrigin(origin string) (string, bool) {
+	for _, allowed := range p.origins {
+		if allowed == "*" {
+			return "*", true
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// corsMiddleware adds the CORS headers letting browsers on the allowed
+// origins read the responses, and answers the preflight requests they send
+// before other than simple requests. Requests of other origins are served
+// without the headers, so that browsers block them.
+func corsMiddleware(policy *corsPolicy, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowOrigin, allowed := policy.allowOrigin(origin)
+
+		requestMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == http.MethodOptions && requestMethod != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if allowed && slices.Contains(policy.methods, requestMethod) {
+				w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.methods, ", "))
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.headers, ", "))
+				w.Header().Set("Access-Control-Max-Age", "600")
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+			w.Header().Set("Access-Control-Expose-Headers", handlers.RequestIDHeader)
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
 // NewHTTPHandler creates a new HTTPHandler
 func NewHTTPHandler(handler *transport.Handler, state *gorm.DB) *HTTPHandler {
 	return &HTTPHandler{handler: handler, state: state}
 }
 
+// LimitConcurrency limits the number of management API requests served at
+// once. FDO protocol endpoints are not limited. A limit of 0 disables it.
+func (h *HTTPHandler) LimitConcurrency(limit int) *HTTPHandler {
+	h.maxConcurrent = limit
+	return h
+}
+
+// WithClientRateLimit limits the management API requests of each client
+// address to requestsPerSecond, with bursts of up to burst requests, in
+// place of the default limit shared by all clients. Requests over the limit
+// receive 429 with Retry-After. A rate of 0 keeps the default limit.
+func (h *HTTPHandler) WithClientRateLimit(requestsPerSecond float64, burst int) *HTTPHandler {
+	h.clientRate = rate.Limit(requestsPerSecond)
+	h.clientBurst = burst
+	return h
+}
+
+// WithAPIKeys requires management API requests to carry one of the API keys
+// whose SHA-256 digests are given. FDO protocol and informational endpoints
+// are not affected. No digests disables authentication.
+func (h *HTTPHandler) WithAPIKeys(digests [][sha256.Size]byte) *HTTPHandler {
+	h.apiKeys = digests
+	return h
+}
+
+// WithTimeouts sets the deadline for serving management API requests, with
+// per route group overrides keyed by path prefix below /api/v1. A timeout of
+// 0 disables it.
+func (h *HTTPHandler) WithTimeouts(timeout time.Duration, groups map[string]time.Duration) *HTTPHandler {
+	h.timeout = timeout
+	h.timeouts = groups
+	return h
+}
+
+// WithTrustedProxies sets the addresses of the reverse proxies whose
+// X-Forwarded-For header is trusted to carry the client address.
+func (h *HTTPHandler) WithTrustedProxies(proxies []netip.Prefix) *HTTPHandler {
+	h.proxies = proxies
+	return h
+}
+
+// WithReadinessChecks sets the checks run by the readiness endpoints, in
+// addition to the database check.
+func (h *HTTPHandler) WithReadinessChecks(checks ...handlers.ReadinessCheck) *HTTPHandler {
+	h.readiness = checks
+	return h
+}
+
+// WithRootRedirect redirects requests for the root path to target instead
+// of describing the server.
+func (h *HTTPHandler) WithRootRedirect(target string) *HTTPHandler {
+	h.rootRedirect = target
+	return h
+}
+
+// WithMessageTimeout sets the deadline for handling each FDO protocol
+// message. A timeout of 0 disables it.
+func (h *HTTPHandler) WithMessageTimeout(timeout time.Duration) *HTTPHandler {
+	h.msgTimeout = timeout
+	return h
+}
+
+// WithMetrics serves Prometheus metrics at /metrics when enabled: the FDO
+// protocol messages handled by protocol and outcome, the Go runtime and
+// process metrics, and the given server specific collectors. With API keys,
+// scrapers must authenticate like management API clients.
+func (h *HTTPHandler) WithMetrics(enabled bool, extra ...prometheus.Collector) *HTTPHandler {
+	if !enabled {
+		return h
+	}
+	h.metrics = prometheus.NewRegistry()
+	h.fdoMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fdo_messages_total",
+		Help: "FDO protocol messages handled, by protocol and outcome.",
+	}, []string{"protocol", "outcome"})
+	h.metrics.MustRegister(h.fdoMessages,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	h.metrics.MustRegister(extra...)
+	return h
+}
+
+// WithCORS lets browsers on the given origins, or any origin for "*", use
+// the management API below /api/v1 with the given methods and request
+// headers, defaulting to GET, POST, PUT, PATCH and DELETE and to the
+// Authorization, Content-Type and X-API-Key headers. FDO protocol endpoints
+// are not affected. No origins disables CORS.
+func (h *HTTPHandler) WithCORS(origins, methods, headers []string) *HTTPHandler {
+	if len(origins) == 0 {
+		h.cors = nil
+		return h
+	}
+	policy := &corsPolicy{origins: origins, methods: defaultCORSMethods, headers: defaultCORSHeaders}
+	if len(methods) > 0 {
+		policy.methods = make([]string, len(methods))
+		for i, method := range methods {
+			policy.methods[i] = strings.ToUpper(method)
+		}
+	}
+	if len(headers) > 0 {
+		policy.headers = headers
+	}
+	h.cors = policy
+	return h
+}
+
 // RegisterRoutes registers the routes for the HTTP server
-func (h *HTTPHandler) RegisterRoutes(apiRouter *http.ServeMux) *http.ServeMux {
+func (h *HTTPHandler) RegisterRoutes(apiRouter *http.ServeMux) http.Handler {
 	handler := http.NewServeMux()
-	handler.Handle("POST /fdo/101/msg/{msg}", h.handler)
+	var fdoHandler http.Handler = h.handler
+	if h.msgTimeout > 0 {
+		fdoHandler = messageDeadlineMiddleware(h.msgTimeout, fdoHandler)
+	}
+	if h.metrics != nil {
+		fdoHandler = fdoMetricsMiddleware(h.fdoMessages, fdoHandler)
+		var metricsHandler http.Handler = promhttp.HandlerFor(h.metrics, promhttp.HandlerOpts{})
+		if len(h.apiKeys) > 0 {
+			// Metrics disclose the activity of the server, like the API
+			metricsHandler = apiKeyMiddleware(h.apiKeys, metricsHandler)
+		}
+		handler.Handle("GET /metrics", metricsHandler)
+	}
+	fdoHandler = fdoLoggingMiddleware(fdoHandler)
+	handler.Handle("POST /fdo/101/msg/{msg}", fdoHandler)
 	if apiRouter != nil {
-		apiHandler := rateLimitMiddleware(rate.NewLimiter(2, 10),
-			bodySizeMiddleware(1<<20, /* 1MB */
-				apiRouter,
-			),
+		var limitedRouter http.Handler = apiRouter
+		if h.timeout > 0 || len(h.timeouts) > 0 {
+			limitedRouter = timeoutMiddleware(h.timeout, h.timeouts, limitedRouter)
+		}
+		if h.maxConcurrent > 0 {
+			limitedRouter = concurrencyLimitMiddleware(h.maxConcurrent, limitedRouter)
+		}
+		limiter := rate.NewLimiter(2, 10)
+		limiterOf := func(*http.Request) *rate.Limiter { return limiter }
+		if h.clientRate > 0 {
+			limiterOf = newClientRateLimiters(h.clientRate, h.clientBurst).limiter
+		}
+		var authRouter http.Handler = bodySizeMiddleware(1<<20, /* 1MB */
+			limitedRouter,
 		)
+		if len(h.apiKeys) > 0 {
+			authRouter = apiKeyMiddleware(h.apiKeys, authRouter)
+		}
+		var apiHandler http.Handler = rateLimitMiddleware(limiterOf, authRouter)
+		if h.cors != nil {
+			apiHandler = corsMiddleware(h.cors, apiHandler)
+		}
 		handler.Handle("/api/v1/", http.StripPrefix("/api/v1", apiHandler))
 
 	}
+	handler.Handle("GET /{$}", handlers.RootHandler(h.rootRedirect))
 	handler.HandleFunc("/health", handlers.HealthHandler)
-	return handler
+	readiness := h.readiness
+	if h.state != nil {
+		readiness = append([]handlers.ReadinessCheck{handlers.DatabaseCheck(h.state)}, readiness...)
+	}
+	readinessHandler := handlers.ReadinessHandler(readiness)
+	handler.Handle("GET /readyz", readinessHandler)
+	var apiReadinessHandler, versionHandler, capabilitiesHandler http.Handler = readinessHandler,
+		http.HandlerFunc(handlers.VersionHandler), http.HandlerFunc(handlers.CapabilitiesHandler)
+	if h.cors != nil {
+		apiReadinessHandler = corsMiddleware(h.cors, apiReadinessHandler)
+		versionHandler = corsMiddleware(h.cors, versionHandler)
+		capabilitiesHandler = corsMiddleware(h.cors, capabilitiesHandler)
+	}
+	handler.Handle("GET /api/v1/ready", apiReadinessHandler)
+	handler.Handle("GET /api/v1/version", versionHandler)
+	handler.Handle("GET /api/v1/capabilities", capabilitiesHandler)
+	if len(h.proxies) > 0 {
+		return requestIDMiddleware(recoveryMiddleware(clientAddrMiddleware(h.proxies, handler)))
+	}
+	return requestIDMiddleware(recoveryMiddleware(handler))
 }
@@ -4,30 +4,309 @@
 package cmd
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 // Log configuration
 type LogConfig struct {
-	Level string `mapstructure:"level"`
+	Level  string `mapstructure:"level"`
+	Format string `mapstructure:"format"` // "text" or "json"
 }
 
 // Configuration for the server's HTTP endpoint
 type HTTPConfig struct {
-	CertPath string `mapstructure:"cert"`
-	KeyPath  string `mapstructure:"key"`
-	IP       string `mapstructure:"ip"`
-	Port     string `mapstructure:"port"`
+	CertPath  string `mapstructure:"cert"`
+	KeyPath   string `mapstructure:"key"`
+	IP        string `mapstructure:"ip"`
+	Port      string `mapstructure:"port"`
+	ReadyFile string `mapstructure:"ready_file"` // written once the server is listening
+
+	// Require a PROXY protocol header on every connection, as sent by an
+	// L4 load balancer, and use the client address it carries
+	ProxyProtocol bool `mapstructure:"proxy_protocol"`
+	// Addresses or CIDR ranges of reverse proxies trusted to report the
+	// client address in X-Forwarded-For
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	// Time given to in-flight requests to complete when shutting down
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	// Deadline for handling each FDO protocol message, cancelling the
+	// database and FSIM operations made for it. 0 disables it.
+	MessageTimeout time.Duration `mapstructure:"message_timeout"`
+	TLS            TLSConfig     `mapstructure:"tls"`
+	// Path or http(s) URL the root path redirects to, instead of
+	// describing the server
+	RootRedirect string `mapstructure:"root_redirect"`
+	// Serve Prometheus metrics at /metrics
+	Metrics bool `mapstructure:"metrics"`
+	// Cross-origin access to the management API by browsers
+	CORS CORSConfig `mapstructure:"cors"`
+	// API keys required by the management API
+	Auth AuthConfig `mapstructure:"auth"`
+}
+
+// Authentication of the management API requests below /api/v1, disabled
+// unless API keys are configured. Only the SHA-256 digests of the keys are
+// configured, hex encoded.
+type AuthConfig struct {
+	APIKeys []string `mapstructure:"api_keys"`
+	// File holding further digests, one per line. Empty lines and lines
+	// starting with "#" are ignored.
+	KeyFile string `mapstructure:"key_file"`
+}
+
+// keyDigests returns the digests of the API keys accepted, none if
+// authentication is disabled
+func (a *AuthConfig) keyDigests() ([][sha256.Size]byte, error) {
+	entries := slices.Clone(a.APIKeys)
+	if a.KeyFile != "" {
+		b, err := os.ReadFile(a.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("http.auth.key_file: %w", err)
+		}
+		var fromFile int
+		for line := range strings.Lines(string(b)) {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			entries = append(entries, line)
+			fromFile++
+		}
+		if fromFile == 0 {
+			return nil, fmt.Errorf("http.auth.key_file %q holds no API key digest", a.KeyFile)
+		}
+	}
+	digests := make([][sha256.Size]byte, 0, len(entries))
+	for _, entry := range entries {
+		b, err := hex.DecodeString(entry)
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("http.auth: %q is not a hex encoded SHA-256 digest", entry)
+		}
+		digests = append(digests, [sha256.Size]byte(b))
+	}
+	return digests, nil
+}
+
+// CORS settings of the management API below /api/v1, disabled unless
+// origins are allowed
+type CORSConfig struct {
+	// Origins of the web pages allowed to use the API, such as
+	// "https://dashboard.example.com", or "*" for any origin
+	AllowedOrigins []string `mapstructure:"allowed_origins"`
+	// Methods and request headers allowed, defaulting to GET, POST, PUT and
+	// DELETE and to Authorization and Content-Type
+	AllowedMethods []string `mapstructure:"allowed_methods"`
+	AllowedHeaders []string `mapstructure:"allowed_headers"`
+}
+
+func (c *CORSConfig) validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("http.cors.allowed_origins: %q must be \"*\" or an origin such as \"https://dashboard.example.com\"", origin)
+		}
+	}
+	for _, method := range c.AllowedMethods {
+		if method == "" || strings.ContainsFunc(method, func(r rune) bool { return !unicode.IsLetter(r) }) {
+			return fmt.Errorf("http.cors.allowed_methods: invalid method %q", method)
+		}
+	}
+	return nil
+}
+
+// TLS settings used when the server's HTTP endpoint has a certificate
+type TLSConfig struct {
+	// Minimum protocol version, "1.2" (default) or "1.3"
+	MinVersion string `mapstructure:"min_version"`
+	// TLS 1.2 cipher suites in order of preference, by their Go names such
+	// as "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384". TLS 1.3 suites are
+	// accepted but not configurable in Go and so have no effect.
+	CipherSuites []string `mapstructure:"cipher_suites"`
+	// PKCS#12 (.p12/.pfx) bundle holding the server certificate chain and
+	// key, as an alternative to the PEM files of http.cert and http.key
+	PKCS12 PKCS12Config `mapstructure:"pkcs12"`
+}
+
+// A PKCS#12 bundle and the passphrase protecting it
+type PKCS12Config struct {
+	Path       string `mapstructure:"path"`
+	Passphrase string `mapstructure:"passphrase"`
+}
+
+// certificate decodes the bundle into the certificate served over TLS: the
+// leaf certificate, followed by the CA certificates of the bundle
+func (p *PKCS12Config) certificate() (tls.Certificate, error) {
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("http.tls.pkcs12: %w", err)
+	}
+	key, leaf, caCerts, err := pkcs12.DecodeChain(data, p.Passphrase)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("http.tls.pkcs12: decoding %s: %w", p.Path, err)
+	}
+	cert := tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	for _, ca := range caCerts {
+		cert.Certificate = append(cert.Certificate, ca.Raw)
+	}
+	return cert, nil
+}
+
+// Cipher suites used when none are configured
+var defaultCipherSuites = []uint16{
+	tls.TLS_AES_256_GCM_SHA384,                  // TLS v1.3
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,   // TLS v1.2
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, // TLS v1.2
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, // TLS v1.2
+}
+
+// buildTLSConfig returns the server TLS configuration for cfg
+func buildTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	conf := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		CipherSuites: defaultCipherSuites,
+	}
+	switch cfg.MinVersion {
+	case "", "1.2":
+	case "1.3":
+		conf.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("http.tls.min_version %q is invalid: must be \"1.2\" or \"1.3\"", cfg.MinVersion)
+	}
+	if len(cfg.CipherSuites) > 0 {
+		conf.CipherSuites = make([]uint16, 0, len(cfg.CipherSuites))
+		for _, name := range cfg.CipherSuites {
+			i := slices.IndexFunc(tls.CipherSuites(), func(suite *tls.CipherSuite) bool { return suite.Name == name })
+			if i < 0 {
+				return nil, fmt.Errorf("http.tls.cipher_suites: unknown or insecure cipher suite %q", name)
+			}
+			conf.CipherSuites = append(conf.CipherSuites, tls.CipherSuites()[i].ID)
+		}
+	}
+	return conf, nil
 }
 
 // Device Certificate Authority
 type DeviceCAConfig struct {
 	CertPath string `mapstructure:"cert"` // path to certificate file
 	KeyPath  string `mapstructure:"key"`  // path to key file
+	// Maximum number of PEM blocks read from a certificate chain file, 0
+	// for defaultMaxChainLength
+	MaxChainLength int `mapstructure:"max_chain_length"`
+	// Key held by a PKCS#11 token (HSM), used instead of KeyPath to sign
+	// device certificates
+	PKCS11 PKCS11KeyConfig `mapstructure:"pkcs11"`
+}
+
+// usePKCS11 returns true if the key is held by a PKCS#11 token
+func (d *DeviceCAConfig) usePKCS11() bool {
+	return d.PKCS11.Module != ""
+}
+
+// defaultMaxChainLength bounds the PEM blocks of certificate chain files
+// unless configured otherwise
+const defaultMaxChainLength = 16
+
+// maxChainLength returns the maximum number of PEM blocks of a certificate
+// chain file
+func (d *DeviceCAConfig) maxChainLength() int {
+	if d.MaxChainLength > 0 {
+		return d.MaxChainLength
+	}
+	return defaultMaxChainLength
+}
+
+// validate checks the chain length limit and the PKCS#11 key settings
+func (d *DeviceCAConfig) validate() error {
+	if d.MaxChainLength < 0 {
+		return fmt.Errorf("device_ca.max_chain_length must not be negative, got %d", d.MaxChainLength)
+	}
+	if d.usePKCS11() {
+		if d.KeyPath != "" {
+			return errors.New("device_ca.key cannot be used with device_ca.pkcs11")
+		}
+		return d.PKCS11.validate("device_ca.pkcs11")
+	}
+	return nil
+}
+
+// Management API configuration
+type APIConfig struct {
+	MaxConcurrent int `mapstructure:"max_concurrent"` // 0 means unlimited
+	// Deadline for serving a request, 0 means none
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Deadlines overriding Timeout for route groups, keyed by path prefix
+	// below /api/v1, e.g. "/owner/devices"
+	Timeouts map[string]time.Duration `mapstructure:"timeouts"`
+	// Per client limit on the rate of requests, replacing the default limit
+	// shared by all clients
+	RateLimit APIRateLimitConfig `mapstructure:"rate_limit"`
+}
+
+// Token bucket limiting the requests of each client address
+type APIRateLimitConfig struct {
+	// Sustained rate of requests, 0 disables the per client limit
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	// Requests a client may send at once, defaults to 1
+	Burst int `mapstructure:"burst"`
+}
+
+func (a *APIConfig) validate() error {
+	if a.MaxConcurrent < 0 {
+		return fmt.Errorf("api.max_concurrent must not be negative, got %d", a.MaxConcurrent)
+	}
+	if a.Timeout < 0 {
+		return fmt.Errorf("api.timeout must not be negative, got %s", a.Timeout)
+	}
+	for prefix, timeout := range a.Timeouts {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("api.timeouts: route prefix %q must start with \"/\"", prefix)
+		}
+		if timeout < 0 {
+			return fmt.Errorf("api.timeouts[%q] must not be negative, got %s", prefix, timeout)
+		}
+	}
+	if a.RateLimit.RequestsPerSecond < 0 {
+		return fmt.Errorf("api.rate_limit.requests_per_second must not be negative, got %v", a.RateLimit.RequestsPerSecond)
+	}
+	if a.RateLimit.Burst < 0 {
+		return fmt.Errorf("api.rate_limit.burst must not be negative, got %d", a.RateLimit.Burst)
+	}
+	return nil
+}
+
+// rateLimitBurst returns the burst of the per client rate limit
+func (a *APIConfig) rateLimitBurst() int {
+	return max(a.RateLimit.Burst, 1)
 }
 
 // Structure to hold the common contents of the configuration file
@@ -35,18 +314,59 @@ type FDOServerConfig struct {
 	Log  LogConfig      `mapstructure:"log"`
 	DB   DatabaseConfig `mapstructure:"db"`
 	HTTP HTTPConfig     `mapstructure:"http"`
+	API  APIConfig      `mapstructure:"api"`
 }
 
 // ListenAddress returns the concatenated IP:Port address for listening
 func (h *HTTPConfig) ListenAddress() string {
-	return h.IP + ":" + h.Port
+	return net.JoinHostPort(h.IP, h.Port)
 }
 
-// UseTLS returns true if TLS should be used (cert and key are both set)
+// UseTLS returns true if TLS should be used (cert and key are both set, or
+// a PKCS#12 bundle is)
 func (h *HTTPConfig) UseTLS() bool {
-	return h.CertPath != "" && h.KeyPath != ""
+	return (h.CertPath != "" && h.KeyPath != "") || h.TLS.PKCS12.Path != ""
+}
+
+// serverTLSConfig returns the TLS configuration of the server, holding the
+// certificate of the PKCS#12 bundle if one is set. Otherwise the
+// certificate is loaded from http.cert and http.key when serving.
+func (h *HTTPConfig) serverTLSConfig() (*tls.Config, error) {
+	conf, err := buildTLSConfig(h.TLS)
+	if err != nil {
+		return nil, err
+	}
+	if h.TLS.PKCS12.Path != "" {
+		cert, err := h.TLS.PKCS12.certificate()
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// trustedProxies parses the trusted proxy addresses and CIDR ranges
+func (h *HTTPConfig) trustedProxies() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(h.TrustedProxies))
+	for _, entry := range h.TrustedProxies {
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("http.trusted_proxies: %q is neither an IP address nor a CIDR range", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
 }
 
+// hostnameRegexp matches a DNS hostname made of RFC 1123 labels. The last
+// label must not be all digits so that a mistyped IPv4 address is rejected.
+var hostnameRegexp = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9-]*[a-zA-Z-][a-zA-Z0-9-]*\.?$`)
+
 func (h *HTTPConfig) validate() error {
 	if h.IP == "" {
 		return errors.New("the server's HTTP IP address is required")
@@ -54,10 +374,43 @@ func (h *HTTPConfig) validate() error {
 	if h.Port == "" {
 		return errors.New("the server's HTTP port is required")
 	}
+	if net.ParseIP(h.IP) == nil && (len(h.IP) > 253 || !hostnameRegexp.MatchString(h.IP)) {
+		return fmt.Errorf("the server's HTTP address %q is neither an IP address nor a hostname", h.IP)
+	}
+	if port, err := strconv.Atoi(h.Port); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("the server's HTTP port %q must be a number between 1 and 65535", h.Port)
+	}
+	if _, err := h.trustedProxies(); err != nil {
+		return err
+	}
+	if h.ShutdownTimeout <= 0 {
+		return fmt.Errorf("the server's shutdown timeout must be positive, got %s", h.ShutdownTimeout)
+	}
+	if h.MessageTimeout < 0 {
+		return fmt.Errorf("the server's message timeout must not be negative, got %s", h.MessageTimeout)
+	}
 	// Both cert and key must be set together or both must be unset
 	if (h.CertPath == "" && h.KeyPath != "") || (h.CertPath != "" && h.KeyPath == "") {
 		return errors.New("both certificate and key must be provided together, or neither")
 	}
+	if h.TLS.PKCS12.Path != "" && h.CertPath != "" {
+		return errors.New("http.tls.pkcs12 cannot be used with http.cert and http.key")
+	}
+	if _, err := h.serverTLSConfig(); err != nil {
+		return err
+	}
+	if err := h.CORS.validate(); err != nil {
+		return err
+	}
+	if _, err := h.Auth.keyDigests(); err != nil {
+		return err
+	}
+	if h.RootRedirect != "" {
+		u, err := url.Parse(h.RootRedirect)
+		if err != nil || (!strings.HasPrefix(h.RootRedirect, "/") && ((u.Scheme != "http" && u.Scheme != "https") || u.Host == "")) {
+			return fmt.Errorf("http.root_redirect %q must be a path starting with \"/\" or an http(s) URL", h.RootRedirect)
+		}
+	}
 	return nil
 }
 
@@ -65,18 +418,492 @@ func (h *HTTPConfig) validate() error {
 type DatabaseConfig struct {
 	Type string `mapstructure:"type"`
 	DSN  string `mapstructure:"dsn"`
+	// Optional read-only replica serving the management API list queries
+	ReadDSN string `mapstructure:"read_dsn"`
+	// Attempts made for a write failing with a transient error such as a
+	// locked database. Zero selects the default.
+	RetryAttempts int `mapstructure:"retry_attempts"`
+	// Password added to the PostgreSQL dsn, so that it can be kept out of
+	// the configuration file: see resolveDBPassword for its sources
+	Password     string `mapstructure:"password"`
+	PasswordFile string `mapstructure:"password_file"`
+	// Complexity required of Password: dbPassPolicyStrict or dbPassPolicyNone
+	PasswordPolicy string `mapstructure:"password_policy"`
 }
 
-func (dc *DatabaseConfig) getState() (*db.State, error) {
+// Database password policies
+const (
+	dbPassPolicyStrict = "strict" // at least 8 characters with a digit, an uppercase and a special character
+	dbPassPolicyNone   = "none"   // any non-empty password
+)
+
+// validateDBPassword checks a supplied database password against the policy
+func validateDBPassword(password string, supplied bool, policy string) error {
+	if policy != dbPassPolicyStrict && policy != dbPassPolicyNone {
+		return fmt.Errorf("database configuration error: unknown password policy %q (must be %q or %q)", policy, dbPassPolicyStrict, dbPassPolicyNone)
+	}
+	if !supplied {
+		return nil
+	}
+	if password == "" {
+		return errors.New("database configuration error: password must not be empty")
+	}
+	if policy == dbPassPolicyNone {
+		return nil
+	}
+	var digit, upper, special bool
+	for _, r := range password {
+		switch {
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsUpper(r):
+			upper = true
+		case !unicode.IsLetter(r):
+			special = true
+		}
+	}
+	if len(password) < 8 || !digit || !upper || !special {
+		return errors.New("database configuration error: password must be at least 8 characters long with a digit, an uppercase and a special character (see --db-pass-policy)")
+	}
+	return nil
+}
+
+// dbPasswordEnv names the environment variable holding the database password
+const dbPasswordEnv = "FDO_DB_PASS"
+
+// dsn returns the connection string with the password, if any, added
+func (dc *DatabaseConfig) dsn() (string, error) {
+	if dc.Password == "" {
+		return dc.DSN, nil
+	}
+	if dc.Type != "postgres" {
+		return "", errors.New("database configuration error: password is only supported with postgres")
+	}
+	if u, err := url.Parse(dc.DSN); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		var user string
+		if u.User != nil {
+			user = u.User.Username()
+		}
+		u.User = url.UserPassword(user, dc.Password)
+		return u.String(), nil
+	}
+	// Keyword/value form: a later setting overrides an earlier one
+	quoted := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(dc.Password)
+	return dc.DSN + " password='" + quoted + "'", nil
+}
+
+func (dc *DatabaseConfig) validate() error {
 	if dc.DSN == "" {
-		return nil, errors.New("database configuration error: dsn is required")
+		return errors.New("database configuration error: dsn is required")
+	}
+	if dbType := strings.ToLower(dc.Type); dbType != "sqlite" && dbType != "postgres" {
+		return fmt.Errorf("unsupported database type: %s (must be 'sqlite' or 'postgres')", dbType)
 	}
+	if dc.RetryAttempts < 0 {
+		return errors.New("database configuration error: retry_attempts cannot be negative")
+	}
+	return nil
+}
 
-	// Validate database type
+func (dc *DatabaseConfig) getState() (*db.State, error) {
+	if err := dc.validate(); err != nil {
+		return nil, err
+	}
 	dc.Type = strings.ToLower(dc.Type)
-	if dc.Type != "sqlite" && dc.Type != "postgres" {
-		return nil, fmt.Errorf("unsupported database type: %s (must be 'sqlite' or 'postgres')", dc.Type)
+	if dc.RetryAttempts > 0 {
+		db.SetRetryAttempts(dc.RetryAttempts)
+	}
+
+	dsn, err := dc.dsn()
+	if err != nil {
+		return nil, err
+	}
+	state, err := db.InitDb(dc.Type, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dc.ReadDSN != "" {
+		if err := db.InitReadReplica(dc.Type, dc.ReadDSN); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// ServiceInfo (FSIM) configuration for the owner server
+type ServiceInfoConfig struct {
+	// File separator used for device-side paths when the device does not
+	// report one in devmod. Defaults to "/".
+	DefaultFileSep string                 `mapstructure:"default_file_sep"`
+	Fsims          []ServiceInfoOperation `mapstructure:"fsims"`
+	// Per-device FSIM operations, replacing Fsims for matching devices
+	Overrides []ServiceInfoOverride `mapstructure:"overrides"`
+	// How the owner prepares the FSIM operations: "sequential" (default)
+	// or "parallel", which reads all download sources concurrently when
+	// TO2 service info starts. Modules still run one after another.
+	Strategy string `mapstructure:"strategy"`
+}
+
+// FSIM operations for a class of devices. A device matches if its GUID is
+// listed or its devmod device (model) matches the Device glob pattern.
+type ServiceInfoOverride struct {
+	GUIDs  []string               `mapstructure:"guids"`
+	Device string                 `mapstructure:"device"`
+	Fsims  []ServiceInfoOperation `mapstructure:"fsims"`
+}
+
+// A single FSIM operation performed by the owner during TO2. The FSIM
+// field selects which of the parameter sections is used.
+type ServiceInfoOperation struct {
+	FSIM     string             `mapstructure:"fsim"`
+	Wget     FSIMWgetParams     `mapstructure:"wget"`
+	Raw      FSIMRawParams      `mapstructure:"raw"`
+	TimeSync FSIMTimeSyncParams `mapstructure:"time_sync"`
+	Command  FSIMCommandParams  `mapstructure:"command"`
+	Download FSIMDownloadParams `mapstructure:"download"`
+	Upload   FSIMUploadParams   `mapstructure:"upload"`
+}
+
+// Parameters for the fdo.wget FSIM
+type FSIMWgetParams struct {
+	Files []FSIMWgetFileSpec `mapstructure:"files"`
+}
+
+// A single file the device retrieves using fdo.wget
+type FSIMWgetFileSpec struct {
+	URL  string `mapstructure:"url"`
+	Name string `mapstructure:"name"` // defaults to the last element of the URL path
+	// Optional hex encoded SHA-384 digest of the file, verified by the device
+	Checksum string `mapstructure:"checksum"`
+	// Optional size of the file in bytes, verified by the device
+	Length int64 `mapstructure:"length"`
+}
+
+// Digest lengths of fdo.wget checksums, in bytes. The fdo.wget module only
+// carries SHA-384 digests, so SHA-256 ones are recognized to be refused
+// with a clear error rather than failing verification on the device.
+const (
+	sha256ChecksumLength = 32
+	sha384ChecksumLength = 48
+)
+
+// checksum decodes the SHA-384 digest sent to the device, nil when unset
+func (f *FSIMWgetFileSpec) checksum() ([]byte, error) {
+	if f.Checksum == "" {
+		return nil, nil
+	}
+	digest, err := hex.DecodeString(f.Checksum)
+	if err != nil {
+		return nil, fmt.Errorf("fdo.wget checksum of %q is not hex encoded: %w", f.URL, err)
+	}
+	switch len(digest) {
+	case sha384ChecksumLength:
+		return digest, nil
+	case sha256ChecksumLength:
+		return nil, fmt.Errorf("fdo.wget checksum of %q is a SHA-256 digest: the fdo.wget module only verifies SHA-384 checksums", f.URL)
+	default:
+		return nil, fmt.Errorf("fdo.wget checksum of %q must be a SHA-384 digest of %d hex digits, got %d", f.URL, 2*sha384ChecksumLength, len(f.Checksum))
+	}
+}
+
+// Parameters for setting the device clock to the owner's time using the
+// fdo.command FSIM
+type FSIMTimeSyncParams struct {
+	// Continue onboarding if the device fails to set its clock
+	MayFail bool `mapstructure:"may_fail"`
+}
+
+// Parameters for the fdo.download FSIM
+type FSIMDownloadParams struct {
+	// Device-side directory relative file names are resolved against
+	Dir   string                 `mapstructure:"dir"`
+	Files []FSIMDownloadFileSpec `mapstructure:"files"`
+}
+
+// A single owner file sent to the device using fdo.download
+type FSIMDownloadFileSpec struct {
+	Path string `mapstructure:"path"` // file on the owner server
+	Name string `mapstructure:"name"` // defaults to the last element of Path
+	// Continue onboarding if the device fails to store the file
+	MayFail bool `mapstructure:"may_fail"`
+	// Render the file for each device before sending it, substituting the
+	// device attributes it references, e.g. ${GUID}
+	Template bool `mapstructure:"template"`
+}
+
+// Parameters for the fdo.upload FSIM
+type FSIMUploadParams struct {
+	// Owner directory receiving the files, in a subdirectory per device
+	Dir   string   `mapstructure:"dir"`
+	Files []string `mapstructure:"files"` // device-side file names
+}
+
+// Parameters for a command the device runs using the fdo.command FSIM
+type FSIMCommandParams struct {
+	Cmd  string   `mapstructure:"cmd"`
+	Args []string `mapstructure:"args"`
+	// Continue onboarding if the command fails on the device
+	MayFail bool `mapstructure:"may_fail"`
+	// Request the command output from the device, written to the owner
+	// server's stdout and stderr
+	ReturnStdout bool `mapstructure:"return_stdout"`
+	ReturnStderr bool `mapstructure:"return_stderr"`
+}
+
+// Parameters for a vendor-defined FSIM whose messages are sent verbatim
+type FSIMRawParams struct {
+	Module   string           `mapstructure:"module"`
+	Messages []FSIMRawMessage `mapstructure:"messages"`
+}
+
+// A single raw FSIM message. Value is the base64 encoding of the CBOR
+// message body.
+type FSIMRawMessage struct {
+	Name  string `mapstructure:"name"`
+	Value string `mapstructure:"value"`
+}
+
+// FSIM types accepted in the service_info configuration
+const (
+	wgetFSIMType     = "fdo.wget"
+	rawFSIMType      = "raw"
+	timeSyncFSIMType = "time_sync"
+	commandFSIMType  = "fdo.command"
+	downloadFSIMType = "fdo.download"
+	uploadFSIMType   = "fdo.upload"
+)
+
+// Execution strategies accepted in the service_info configuration
+const (
+	sequentialStrategy = "sequential"
+	parallelStrategy   = "parallel"
+)
+
+// Module names are dot-separated identifiers, e.g. "com.example.config"
+var fsimModuleNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)+$`)
+
+func (s *ServiceInfoConfig) validate() error {
+	if problems := s.problems(); len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+// problems returns every problem of the configuration, where validate stops
+// at the first one
+func (s *ServiceInfoConfig) problems() []error {
+	var problems []error
+	if s.DefaultFileSep != "" && len(s.DefaultFileSep) != 1 {
+		problems = append(problems, fmt.Errorf("service_info.default_file_sep must be a single character, got %q", s.DefaultFileSep))
+	}
+	switch s.Strategy {
+	case "", sequentialStrategy, parallelStrategy:
+	default:
+		problems = append(problems, fmt.Errorf("service_info.strategy must be %q or %q, got %q", sequentialStrategy, parallelStrategy, s.Strategy))
 	}
+	problems = append(problems, fsimProblems("service_info.fsims", s.Fsims)...)
+	for i, override := range s.Overrides {
+		prefix := fmt.Sprintf("service_info.overrides[%d]", i)
+		if len(override.GUIDs) == 0 && override.Device == "" {
+			problems = append(problems, fmt.Errorf("%s: guids or device is required", prefix))
+		}
+		for _, guid := range override.GUIDs {
+			if !utils.IsValidGUID(guid) {
+				problems = append(problems, fmt.Errorf("%s: invalid GUID %q", prefix, guid))
+			}
+		}
+		if _, err := path.Match(override.Device, ""); err != nil {
+			problems = append(problems, fmt.Errorf("%s: invalid device pattern %q: %w", prefix, override.Device, err))
+		}
+		problems = append(problems, fsimProblems(prefix+".fsims", override.Fsims)...)
+	}
+	return problems
+}
 
-	return db.InitDb(dc.Type, dc.DSN)
+// fsimProblems returns the problem of each invalid FSIM operation
+func fsimProblems(prefix string, fsims []ServiceInfoOperation) []error {
+	var problems []error
+	for i, op := range fsims {
+		if err := validateFsim(op); err != nil {
+			problems = append(problems, fmt.Errorf("%s[%d]: %w", prefix, i, err))
+		}
+	}
+	return problems
+}
+
+func validateFsim(op ServiceInfoOperation) error {
+	switch op.FSIM {
+	case wgetFSIMType:
+		return op.Wget.validate()
+	case rawFSIMType:
+		return op.Raw.validate()
+	case commandFSIMType:
+		return op.Command.validate()
+	case downloadFSIMType:
+		return op.Download.validate()
+	case uploadFSIMType:
+		return op.Upload.validate()
+	case timeSyncFSIMType:
+		return nil
+	case "":
+		return errors.New("fsim type is required")
+	default:
+		return fmt.Errorf("unsupported fsim type %q", op.FSIM)
+	}
+}
+
+// ForDevice returns the configuration to use for the given device: the
+// FSIM operations of the first matching override, or the default ones.
+func (s *ServiceInfoConfig) ForDevice(guid protocol.GUID, devmod *serviceinfo.Devmod) *ServiceInfoConfig {
+	guidHex := hex.EncodeToString(guid[:])
+	for _, override := range s.Overrides {
+		if !override.matches(guidHex, devmod) {
+			continue
+		}
+		return &ServiceInfoConfig{
+			DefaultFileSep: s.DefaultFileSep,
+			Fsims:          override.Fsims,
+			Strategy:       s.Strategy,
+		}
+	}
+	return s
+}
+
+func (o *ServiceInfoOverride) matches(guidHex string, devmod *serviceinfo.Devmod) bool {
+	for _, guid := range o.GUIDs {
+		if strings.EqualFold(guid, guidHex) {
+			return true
+		}
+	}
+	if o.Device != "" && devmod != nil {
+		matched, _ := path.Match(o.Device, devmod.Device)
+		return matched
+	}
+	return false
+}
+
+// DownloadPaths returns the owner files sent with fdo.download, including
+// those of per-device overrides
+func (s *ServiceInfoConfig) DownloadPaths() []string {
+	var paths []string
+	fsims := slices.Clone(s.Fsims)
+	for _, override := range s.Overrides {
+		fsims = append(fsims, override.Fsims...)
+	}
+	for _, op := range fsims {
+		if op.FSIM != downloadFSIMType {
+			continue
+		}
+		for _, file := range op.Download.Files {
+			paths = append(paths, file.Path)
+		}
+	}
+	return paths
+}
+
+// WgetURLs returns the URLs of all configured fdo.wget files, including
+// those of per-device overrides
+func (s *ServiceInfoConfig) WgetURLs() []string {
+	var urls []string
+	fsims := slices.Clone(s.Fsims)
+	for _, override := range s.Overrides {
+		fsims = append(fsims, override.Fsims...)
+	}
+	for _, op := range fsims {
+		if op.FSIM != wgetFSIMType {
+			continue
+		}
+		for _, file := range op.Wget.Files {
+			urls = append(urls, file.URL)
+		}
+	}
+	return urls
+}
+
+func (w *FSIMWgetParams) validate() error {
+	if len(w.Files) == 0 {
+		return errors.New("fdo.wget requires at least one file")
+	}
+	for _, file := range w.Files {
+		if _, err := parseWgetURL(file.URL); err != nil {
+			return err
+		}
+		if _, err := file.checksum(); err != nil {
+			return err
+		}
+		if file.Length < 0 {
+			return fmt.Errorf("fdo.wget length of %q must not be negative, got %d", file.URL, file.Length)
+		}
+	}
+	return nil
+}
+
+func (d *FSIMDownloadParams) validate() error {
+	if len(d.Files) == 0 {
+		return errors.New("fdo.download requires at least one file")
+	}
+	for _, file := range d.Files {
+		if file.Path == "" {
+			return errors.New("fdo.download file path is required")
+		}
+		if _, err := os.Stat(file.Path); err != nil {
+			return fmt.Errorf("cannot access download file %q: %w", file.Path, err)
+		}
+	}
+	return nil
+}
+
+func (u *FSIMUploadParams) validate() error {
+	if len(u.Files) == 0 {
+		return errors.New("fdo.upload requires at least one file")
+	}
+	if u.Dir == "" {
+		return errors.New("fdo.upload requires a directory")
+	}
+	info, err := os.Stat(u.Dir)
+	if err != nil {
+		return fmt.Errorf("cannot access upload directory %q: %w", u.Dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("upload path %q is not a directory", u.Dir)
+	}
+	return nil
+}
+
+func (c *FSIMCommandParams) validate() error {
+	if c.Cmd == "" {
+		return errors.New("fdo.command requires a command")
+	}
+	return nil
+}
+
+func (r *FSIMRawParams) validate() error {
+	if !fsimModuleNameRegexp.MatchString(r.Module) {
+		return fmt.Errorf("invalid raw module name %q", r.Module)
+	}
+	if len(r.Messages) == 0 {
+		return fmt.Errorf("raw module %q has no messages", r.Module)
+	}
+	for _, msg := range r.Messages {
+		if msg.Name == "" || strings.Contains(msg.Name, ":") {
+			return fmt.Errorf("raw module %q: invalid message name %q", r.Module, msg.Name)
+		}
+		if _, err := msg.body(); err != nil {
+			return fmt.Errorf("raw module %q: message %q: %w", r.Module, msg.Name, err)
+		}
+	}
+	return nil
+}
+
+// body returns the decoded CBOR message body
+func (m *FSIMRawMessage) body() ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(m.Value)
+	if err != nil {
+		return nil, fmt.Errorf("value is not valid base64: %w", err)
+	}
+	var v any
+	if err := cbor.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("value is not valid CBOR: %w", err)
+	}
+	return b, nil
 }
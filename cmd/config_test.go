@@ -4,12 +4,29 @@
 package cmd
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 // Configuration capture for testing
@@ -28,6 +45,7 @@ func resetState(t *testing.T) {
 
 	// reinitialize the CLI/Config logic
 	viper.Reset()
+	configFiles = nil
 	rootCmd.ResetFlags()
 	rootCmd.ResetCommands()
 	rootCmd.SetArgs(nil)
@@ -44,10 +62,32 @@ func resetState(t *testing.T) {
 	rendezvousCmd.ResetCommands()
 	rendezvousCmd.SetArgs(nil)
 
+	seedVoucherCmd.ResetFlags()
+	seedVoucherCmd.SetArgs(nil)
+
+	importVouchersCmd.ResetFlags()
+	importVouchersCmd.SetArgs(nil)
+
+	exportVoucherCmd.ResetFlags()
+	exportVoucherCmd.SetArgs(nil)
+
+	validateConfigCmd.ResetFlags()
+	validateConfigCmd.ResetCommands()
+	validateConfigCmd.SetArgs(nil)
+
+	devCmd.ResetCommands()
+	rvInfoCmd.ResetCommands()
+	rvInfoDiffCmd.SetArgs(nil)
+	decryptServiceInfoCmd.ResetFlags()
+	decryptServiceInfoCmd.SetArgs(nil)
+
 	rootCmdInit()
 	ownerCmdInit()
 	manufacturingCmdInit()
 	rendezvousCmdInit()
+	validateConfigCmdInit()
+	devCmdInit()
+	rvInfoCmdInit()
 
 	// Zero globals populated by load functions
 	date = false
@@ -55,6 +95,13 @@ func resetState(t *testing.T) {
 	uploads = nil
 	uploadDir = ""
 	downloads = nil
+	wgetURLs = nil
+	downloadPaths = nil
+
+	// Restore the default logger
+	if err := setLogFormat(logFormatText); err != nil {
+		t.Fatal(err)
+	}
 
 	// Reset captured config
 	capturedConfig = nil
@@ -893,3 +940,748 @@ dsn = "file:/tmp/database.db"
 		t.Fatalf("HTTP.KeyPath=%q, want %q (CLI flag should override config)", capturedConfig.HTTP.KeyPath, "/cli/server.key")
 	}
 }
+
+func TestRendezvous_LoadsYAMLConfigFromStdin(t *testing.T) {
+	resetState(t)
+	stubRunE(t, rendezvousCmd)
+
+	cfg := `
+http:
+  ip: "127.0.0.1"
+  port: "8084"
+db:
+  type: "sqlite"
+  dsn: "file:test-rendezvous-stdin.db"
+`
+	rootCmd.SetIn(strings.NewReader(cfg))
+	t.Cleanup(func() { rootCmd.SetIn(nil) })
+	rootCmd.SetArgs([]string{"rendezvous", "--config", "-", "--config-type", "yaml"})
+
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+	if capturedConfig == nil {
+		t.Fatalf("rendezvous config not captured")
+	}
+	if capturedConfig.HTTP.Port != "8084" {
+		t.Fatalf("HTTP.Port=%q", capturedConfig.HTTP.Port)
+	}
+	if capturedConfig.DB.DSN != "file:test-rendezvous-stdin.db" {
+		t.Fatalf("DB.DSN=%q", capturedConfig.DB.DSN)
+	}
+}
+
+func TestRendezvous_ConfigTypeOverridesExtension(t *testing.T) {
+	resetState(t)
+	stubRunE(t, rendezvousCmd)
+
+	p := filepath.Join(t.TempDir(), "rendezvous.conf")
+	cfg := `
+[http]
+ip = "127.0.0.1"
+port = "8085"
+`
+	if err := os.WriteFile(p, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rootCmd.SetArgs([]string{"rendezvous", "--config", p, "--config-type", "toml"})
+
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+	if capturedConfig == nil || capturedConfig.HTTP.Port != "8085" {
+		t.Fatalf("unexpected config: %+v", capturedConfig)
+	}
+}
+
+func TestRendezvous_MergesConfigFilesInOrder(t *testing.T) {
+	resetState(t)
+	stubRunE(t, rendezvousCmd)
+
+	base := writeTOMLConfig(t, `
+[http]
+ip = "127.0.0.1"
+port = "8086"
+[db]
+type = "sqlite"
+dsn = "file:base.db"
+`)
+	overlay := writeYAMLConfig(t, "db:\n  dsn: \"file:overlay.db\"\n")
+	rootCmd.SetArgs([]string{"rendezvous", "--config", base, "--config", overlay})
+
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+	if capturedConfig == nil {
+		t.Fatalf("rendezvous config not captured")
+	}
+	if capturedConfig.DB.DSN != "file:overlay.db" {
+		t.Fatalf("DB.DSN=%q, want %q (second file should override)", capturedConfig.DB.DSN, "file:overlay.db")
+	}
+	if capturedConfig.DB.Type != "sqlite" || capturedConfig.HTTP.Port != "8086" {
+		t.Fatalf("expected keys of the first file to be kept, got %+v", capturedConfig)
+	}
+}
+
+func TestRendezvous_ErrorForStdinConfigWithoutType(t *testing.T) {
+	resetState(t)
+	stubRunE(t, rendezvousCmd)
+
+	rootCmd.SetIn(strings.NewReader("http:\n  ip: \"127.0.0.1\"\n"))
+	t.Cleanup(func() { rootCmd.SetIn(nil) })
+	rootCmd.SetArgs([]string{"rendezvous", "--config", "-"})
+
+	if err := rootCmd.Execute(); err == nil {
+		t.Fatal("expected error when --config-type is missing for stdin")
+	}
+}
+
+func TestRendezvous_DiscoversConfigFile(t *testing.T) {
+	config := func(port string) string {
+		return "http:\n  ip: \"127.0.0.1\"\n  port: \"" + port + "\"\ndb:\n  type: \"sqlite\"\n  dsn: \"file:discovered.db\"\n"
+	}
+	run := func(t *testing.T, args ...string) *TestFullConfig {
+		t.Helper()
+		resetState(t)
+		stubRunE(t, rendezvousCmd)
+		rootCmd.SetArgs(append([]string{"rendezvous"}, args...))
+		if err := rootCmd.Execute(); err != nil {
+			t.Fatalf("execute failed: %v", err)
+		}
+		if capturedConfig == nil {
+			t.Fatal("rendezvous config not captured")
+		}
+		return capturedConfig
+	}
+
+	t.Run("config-dir", func(t *testing.T) {
+		dir := filepath.Dir(writeYAMLConfig(t, config("8101")))
+		if got := run(t, "--config-dir", dir); got.HTTP.Port != "8101" || got.DB.DSN != "file:discovered.db" {
+			t.Fatalf("expected the config.yaml of --config-dir to be loaded, got %+v", got)
+		}
+		// A file named after the command is preferred
+		if err := os.WriteFile(filepath.Join(dir, "rendezvous.json"), []byte(`{"http": {"ip": "127.0.0.1", "port": "8102"}}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if got := run(t, "--config-dir", dir); got.HTTP.Port != "8102" {
+			t.Fatalf("expected rendezvous.json to be preferred to config.yaml, got port %q", got.HTTP.Port)
+		}
+	})
+
+	t.Run("working directory", func(t *testing.T) {
+		t.Chdir(filepath.Dir(writeYAMLConfig(t, config("8103"))))
+		if got := run(t); got.HTTP.Port != "8103" {
+			t.Fatalf("expected ./config.yaml to be loaded, got port %q", got.HTTP.Port)
+		}
+	})
+
+	t.Run("home directory", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		if err := os.Mkdir(filepath.Join(home, ".go-fdo-server"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(home, ".go-fdo-server", "config.yaml"), []byte(config("8104")), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if got := run(t); got.HTTP.Port != "8104" {
+			t.Fatalf("expected $HOME/.go-fdo-server/config.yaml to be loaded, got port %q", got.HTTP.Port)
+		}
+	})
+
+	t.Run("explicit config overrides discovery", func(t *testing.T) {
+		dir := filepath.Dir(writeYAMLConfig(t, config("8105")))
+		explicit := writeTOMLConfig(t, "[http]\nip = \"127.0.0.1\"\nport = \"8106\"\n")
+		got := run(t, "--config-dir", dir, "--config", explicit)
+		if got.HTTP.Port != "8106" {
+			t.Fatalf("expected --config to be used, got port %q", got.HTTP.Port)
+		}
+		if got.DB.DSN == "file:discovered.db" {
+			t.Fatal("expected the discovered file not to be merged with --config")
+		}
+	})
+
+	t.Run("nothing found", func(t *testing.T) {
+		if got := run(t, "--config-dir", t.TempDir(), "127.0.0.1:8107"); got.HTTP.Port != "8107" {
+			t.Fatalf("expected the flags to be used, got port %q", got.HTTP.Port)
+		}
+	})
+
+	t.Run("unreadable file", func(t *testing.T) {
+		// An unreadable candidate does not end the search for its name in
+		// the following directories, nor fall back to config.yaml
+		unreadable := t.TempDir()
+		if err := os.Mkdir(filepath.Join(unreadable, "rendezvous.yaml"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(unreadable, "config.yaml"), []byte(config("8108")), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "rendezvous.yaml"), []byte(config("8109")), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if got := run(t, "--config-dir", unreadable, "--config-dir", dir); got.HTTP.Port != "8109" {
+			t.Fatalf("expected the rendezvous.yaml of the second directory to be loaded, got port %q", got.HTTP.Port)
+		}
+		if got := run(t, "--config-dir", unreadable); got.HTTP.Port != "8108" {
+			t.Fatalf("expected config.yaml to be loaded when no rendezvous file is readable, got port %q", got.HTTP.Port)
+		}
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		dir := filepath.Dir(writeYAMLConfig(t, "http: [not: valid"))
+		resetState(t)
+		stubRunE(t, rendezvousCmd)
+		rootCmd.SetArgs([]string{"rendezvous", "--config-dir", dir, "127.0.0.1:8110"})
+		if err := rootCmd.Execute(); err == nil {
+			t.Fatal("expected an invalid discovered file to be an error")
+		}
+	})
+}
+
+func TestHTTPConfig_ValidateListenAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		ip      string
+		port    string
+		wantErr string
+	}{
+		{name: "ipv4", ip: "127.0.0.1", port: "8080"},
+		{name: "ipv6", ip: "::1", port: "8080"},
+		{name: "hostname", ip: "fdo.example.com", port: "65535"},
+		{name: "localhost", ip: "localhost", port: "1"},
+		{name: "invalid ipv4", ip: "192.168.1.300", port: "8080", wantErr: "neither an IP address nor a hostname"},
+		{name: "invalid hostname", ip: "fdo_example.com", port: "8080", wantErr: "neither an IP address nor a hostname"},
+		{name: "port out of range", ip: "127.0.0.1", port: "70000", wantErr: "between 1 and 65535"},
+		{name: "port zero", ip: "127.0.0.1", port: "0", wantErr: "between 1 and 65535"},
+		{name: "port not numeric", ip: "127.0.0.1", port: "http", wantErr: "between 1 and 65535"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := HTTPConfig{IP: tt.ip, Port: tt.port, ShutdownTimeout: 5 * time.Second}
+			err := h.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRendezvous_ErrorForInvalidListenAddress(t *testing.T) {
+	resetState(t)
+	stubRunE(t, rendezvousCmd)
+
+	cfg := `
+[http]
+ip = "10.0.0.256"
+port = "8041"
+`
+	path := writeTOMLConfig(t, cfg)
+	rootCmd.SetArgs([]string{"rendezvous", "--config", path})
+
+	err := rootCmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), `"10.0.0.256"`) {
+		t.Fatalf("expected error naming the invalid address, got %v", err)
+	}
+}
+
+func TestHTTPConfig_ListenAddressIPv6(t *testing.T) {
+	h := HTTPConfig{IP: "::1", Port: "8080"}
+	if got := h.ListenAddress(); got != "[::1]:8080" {
+		t.Fatalf("ListenAddress()=%q, want %q", got, "[::1]:8080")
+	}
+}
+
+func TestHTTPConfig_TrustedProxies(t *testing.T) {
+	h := HTTPConfig{IP: "127.0.0.1", Port: "8080", TrustedProxies: []string{"10.0.0.1", "192.168.0.0/16", "fd00::/8"}, ShutdownTimeout: 5 * time.Second}
+	if err := h.validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	prefixes, err := h.trustedProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.1/32", "192.168.0.0/16", "fd00::/8"}
+	if len(prefixes) != len(want) {
+		t.Fatalf("got %v, want %v", prefixes, want)
+	}
+	for i := range want {
+		if prefixes[i].String() != want[i] {
+			t.Fatalf("prefix %d=%s, want %s", i, prefixes[i], want[i])
+		}
+	}
+
+	h.TrustedProxies = []string{"10.0.0.0/33"}
+	if err := h.validate(); err == nil || !strings.Contains(err.Error(), "trusted_proxies") {
+		t.Fatalf("expected trusted_proxies error, got %v", err)
+	}
+}
+
+func TestManufacturingConfig_ValidateVoucherLimit(t *testing.T) {
+	for _, tt := range []struct {
+		config  ManufacturingConfig
+		wantErr bool
+	}{
+		{config: ManufacturingConfig{}},
+		{config: ManufacturingConfig{MaxVouchers: 100, MaxVouchersWindow: time.Hour}},
+		{config: ManufacturingConfig{MaxVouchers: -1}, wantErr: true},
+		{config: ManufacturingConfig{MaxVouchers: 100, MaxVouchersWindow: -time.Hour}, wantErr: true},
+	} {
+		if err := tt.config.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("validate(%+v) = %v, want error %t", tt.config, err, tt.wantErr)
+		}
+	}
+}
+
+func TestShutdownTimeoutFlag(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want time.Duration
+	}{
+		{args: nil, want: 5 * time.Second},
+		{args: []string{"--shutdown-timeout", "90s"}, want: 90 * time.Second},
+	} {
+		resetState(t)
+		stubRunE(t, ownerCmd)
+		rootCmd.SetArgs(append([]string{"owner", "127.0.0.1:8043"}, tt.args...))
+		if err := rootCmd.Execute(); err != nil {
+			t.Fatalf("args %v: %v", tt.args, err)
+		}
+		if got := NewOwnerServer(capturedConfig.HTTP, nil).config.ShutdownTimeout; got != tt.want {
+			t.Errorf("args %v: owner server shutdown timeout = %s, want %s", tt.args, got, tt.want)
+		}
+		if got := NewManufacturingServer(capturedConfig.HTTP, nil).config.ShutdownTimeout; got != tt.want {
+			t.Errorf("args %v: manufacturing server shutdown timeout = %s, want %s", tt.args, got, tt.want)
+		}
+		if got := NewRendezvousServer(capturedConfig.HTTP, nil).config.ShutdownTimeout; got != tt.want {
+			t.Errorf("args %v: rendezvous server shutdown timeout = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+
+	for _, timeout := range []string{"0s", "-1s"} {
+		resetState(t)
+		stubRunE(t, ownerCmd)
+		rootCmd.SetArgs([]string{"owner", "127.0.0.1:8043", "--shutdown-timeout", timeout})
+		if err := rootCmd.Execute(); err == nil {
+			t.Errorf("expected error for shutdown timeout %s", timeout)
+		}
+	}
+}
+
+func TestBuildTLSConfig(t *testing.T) {
+	resetState(t)
+	stubRunE(t, rendezvousCmd)
+
+	cfg := `
+[http]
+ip = "127.0.0.1"
+port = "8083"
+[http.tls]
+min_version = "1.3"
+cipher_suites = ["TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_AES_256_GCM_SHA384"]
+`
+	rootCmd.SetArgs([]string{"rendezvous", "--config", writeTOMLConfig(t, cfg)})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+	conf, err := buildTLSConfig(capturedConfig.HTTP.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.MinVersion != tls.VersionTLS13 {
+		t.Errorf("MinVersion=%x, want TLS 1.3", conf.MinVersion)
+	}
+	if want := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384}; !slices.Equal(conf.CipherSuites, want) {
+		t.Errorf("CipherSuites=%v, want %v", conf.CipherSuites, want)
+	}
+
+	conf, err = buildTLSConfig(TLSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.MinVersion != tls.VersionTLS12 || !slices.Equal(conf.CipherSuites, defaultCipherSuites) {
+		t.Errorf("unexpected default TLS config: min version %x, cipher suites %v", conf.MinVersion, conf.CipherSuites)
+	}
+
+	for _, tt := range []struct {
+		cfg  TLSConfig
+		want string
+	}{
+		{TLSConfig{MinVersion: "1.1"}, "min_version"},
+		{TLSConfig{CipherSuites: []string{"TLS_NOT_A_SUITE"}}, "TLS_NOT_A_SUITE"},
+		{TLSConfig{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, "TLS_RSA_WITH_RC4_128_SHA"},
+	} {
+		h := HTTPConfig{IP: "127.0.0.1", Port: "8080", ShutdownTimeout: 5 * time.Second, TLS: tt.cfg}
+		if err := h.validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: expected error mentioning %q, got %v", tt.cfg, tt.want, err)
+		}
+	}
+}
+
+func TestHTTPConfig_RootRedirect(t *testing.T) {
+	for _, tt := range []struct {
+		redirect string
+		valid    bool
+	}{
+		{"", true},
+		{"/health", true},
+		{"https://docs.example.com/fdo", true},
+		{"health", false},
+		{"ftp://example.com/", false},
+	} {
+		h := HTTPConfig{IP: "127.0.0.1", Port: "8080", ShutdownTimeout: 5 * time.Second, RootRedirect: tt.redirect}
+		if err := h.validate(); (err == nil) != tt.valid {
+			t.Errorf("root_redirect %q: valid=%v, got error %v", tt.redirect, tt.valid, err)
+		}
+	}
+}
+
+func TestCORSConfig_Validate(t *testing.T) {
+	for _, tt := range []struct {
+		config CORSConfig
+		valid  bool
+	}{
+		{CORSConfig{}, true},
+		{CORSConfig{AllowedOrigins: []string{"*"}}, true},
+		{CORSConfig{AllowedOrigins: []string{"https://dashboard.example.com", "http://localhost:3000"}, AllowedMethods: []string{"GET", "patch"}}, true},
+		{CORSConfig{AllowedOrigins: []string{"dashboard.example.com"}}, false},
+		{CORSConfig{AllowedOrigins: []string{"https://dashboard.example.com/"}}, false},
+		{CORSConfig{AllowedOrigins: []string{"ftp://dashboard.example.com"}}, false},
+		{CORSConfig{AllowedMethods: []string{"GET,POST"}}, false},
+	} {
+		if err := tt.config.validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v: valid=%v, got error %v", tt.config, tt.valid, err)
+		}
+	}
+}
+
+func TestAPIConfig_RateLimit(t *testing.T) {
+	for _, tt := range []struct {
+		rateLimit APIRateLimitConfig
+		valid     bool
+		burst     int
+	}{
+		{APIRateLimitConfig{}, true, 1},
+		{APIRateLimitConfig{RequestsPerSecond: 0.5}, true, 1},
+		{APIRateLimitConfig{RequestsPerSecond: 5, Burst: 20}, true, 20},
+		{APIRateLimitConfig{RequestsPerSecond: -1}, false, 1},
+		{APIRateLimitConfig{RequestsPerSecond: 5, Burst: -1}, false, 1},
+	} {
+		a := APIConfig{RateLimit: tt.rateLimit}
+		if err := a.validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v: valid=%v, got error %v", tt.rateLimit, tt.valid, err)
+		}
+		if tt.valid && a.rateLimitBurst() != tt.burst {
+			t.Errorf("%+v: expected burst %d, got %d", tt.rateLimit, tt.burst, a.rateLimitBurst())
+		}
+	}
+}
+
+func TestAuthConfig_KeyDigests(t *testing.T) {
+	operator := sha256.Sum256([]byte("operator-key"))
+	dashboard := sha256.Sum256([]byte("dashboard-key"))
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "api-keys")
+	if err := os.WriteFile(keyFile, []byte("# dashboard\n"+hex.EncodeToString(dashboard[:])+"\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(dir, "empty")
+	if err := os.WriteFile(emptyFile, []byte("# no keys yet\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		auth    AuthConfig
+		want    [][sha256.Size]byte
+		wantErr string
+	}{
+		{name: "disabled", want: [][sha256.Size]byte{}},
+		{name: "keys and file", auth: AuthConfig{APIKeys: []string{hex.EncodeToString(operator[:])}, KeyFile: keyFile}, want: [][sha256.Size]byte{operator, dashboard}},
+		{name: "not a digest", auth: AuthConfig{APIKeys: []string{"operator-key"}}, wantErr: "is not a hex encoded SHA-256 digest"},
+		{name: "short digest", auth: AuthConfig{APIKeys: []string{hex.EncodeToString(operator[:16])}}, wantErr: "is not a hex encoded SHA-256 digest"},
+		{name: "missing file", auth: AuthConfig{KeyFile: filepath.Join(dir, "missing")}, wantErr: "http.auth.key_file"},
+		{name: "empty file", auth: AuthConfig{KeyFile: emptyFile}, wantErr: "holds no API key digest"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.auth.keyDigests()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("expected digests %x, got %x", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDBPasswordPrecedence(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "db-pass")
+	if err := os.WriteFile(passwordFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := `
+[db]
+type = "postgres"
+dsn = "host=localhost user=fdo dbname=fdo"
+password = "from-config"
+password_policy = "none"
+`
+	tests := []struct {
+		name string
+		env  string
+		args []string
+		want string
+	}{
+		{name: "config", want: "from-config"},
+		{name: "env", env: "from-env", want: "from-env"},
+		{name: "file", env: "from-env", args: []string{"--db-pass-file", passwordFile}, want: "from-file"},
+		{name: "flag", env: "from-env", args: []string{"--db-pass-file", passwordFile, "--db-pass", "from-flag"}, want: "from-flag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetState(t)
+			stubRunE(t, rendezvousCmd)
+			if tt.env != "" {
+				t.Setenv(dbPasswordEnv, tt.env)
+			}
+			rootCmd.SetArgs(append([]string{"rendezvous", "127.0.0.1:8041", "--config", writeTOMLConfig(t, cfg)}, tt.args...))
+			if err := rootCmd.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			if capturedConfig.DB.Password != tt.want {
+				t.Fatalf("DB.Password=%q, want %q", capturedConfig.DB.Password, tt.want)
+			}
+		})
+	}
+
+	t.Run("password_file setting", func(t *testing.T) {
+		resetState(t)
+		stubRunE(t, rendezvousCmd)
+		rootCmd.SetArgs([]string{"rendezvous", "127.0.0.1:8041", "--config", writeTOMLConfig(t, cfg+"password_file = \""+passwordFile+"\"\n")})
+		if err := rootCmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		if capturedConfig.DB.Password != "from-file" {
+			t.Fatalf("DB.Password=%q, want %q", capturedConfig.DB.Password, "from-file")
+		}
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		resetState(t)
+		stubRunE(t, rendezvousCmd)
+		empty := filepath.Join(t.TempDir(), "empty")
+		if err := os.WriteFile(empty, []byte("\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		rootCmd.SetArgs([]string{"rendezvous", "127.0.0.1:8041", "--db-pass-file", empty})
+		if err := rootCmd.Execute(); err == nil || !strings.Contains(err.Error(), "is empty") {
+			t.Fatalf("expected an empty password file error, got %v", err)
+		}
+	})
+}
+
+func TestDatabaseConfig_DSNWithPassword(t *testing.T) {
+	tests := []struct {
+		config  DatabaseConfig
+		want    string
+		wantErr bool
+	}{
+		{config: DatabaseConfig{Type: "sqlite", DSN: "file:fdo.db"}, want: "file:fdo.db"},
+		{config: DatabaseConfig{Type: "sqlite", DSN: "file:fdo.db", Password: "secret"}, wantErr: true},
+		{config: DatabaseConfig{Type: "postgres", DSN: "host=db user=fdo", Password: `it's\secret`}, want: `host=db user=fdo password='it\'s\\secret'`},
+		{config: DatabaseConfig{Type: "postgres", DSN: "postgres://fdo@db:5432/fdo?sslmode=disable", Password: "p@ss"}, want: "postgres://fdo:p%40ss@db:5432/fdo?sslmode=disable"},
+	}
+	for _, tt := range tests {
+		got, err := tt.config.dsn()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%+v: expected an error", tt.config)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("dsn() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestDBPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "no password", args: nil},
+		{name: "strict complex", args: []string{"--db-pass", "Secr3t-pass"}},
+		{name: "strict short", args: []string{"--db-pass", "S3c-"}, wantErr: "at least 8 characters"},
+		{name: "strict no special", args: []string{"--db-pass", "Secr3tpass"}, wantErr: "special character"},
+		{name: "strict empty", args: []string{"--db-pass", ""}, wantErr: "must not be empty"},
+		{name: "none simple", args: []string{"--db-pass-policy", "none", "--db-pass", "dev"}},
+		{name: "none empty", args: []string{"--db-pass-policy", "none", "--db-pass", ""}, wantErr: "must not be empty"},
+		{name: "unknown policy", args: []string{"--db-pass-policy", "lax"}, wantErr: "unknown password policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetState(t)
+			stubRunE(t, rendezvousCmd)
+			rootCmd.SetArgs(append([]string{"rendezvous", "127.0.0.1:8041"}, tt.args...))
+			err := rootCmd.Execute()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// writePKCS12 writes a PKCS#12 bundle of a server certificate for 127.0.0.1
+// issued by ca, returning its path
+func writePKCS12(t *testing.T, ca *x509.Certificate, caKey crypto.Signer, passphrase string) (string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := pkcs12.Modern.Encode(key, leaf, []*x509.Certificate{ca}, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "server.p12")
+	if err := os.WriteFile(path, bundle, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path, leaf
+}
+
+func TestHTTPConfig_PKCS12Validation(t *testing.T) {
+	ca, caKey := newTestCA(t, "server CA", nil, nil)
+	bundle, _ := writePKCS12(t, ca, caKey, "secret")
+
+	tests := []struct {
+		name    string
+		config  HTTPConfig
+		wantErr string
+	}{
+		{name: "bundle", config: HTTPConfig{TLS: TLSConfig{PKCS12: PKCS12Config{Path: bundle, Passphrase: "secret"}}}},
+		{name: "wrong passphrase", config: HTTPConfig{TLS: TLSConfig{PKCS12: PKCS12Config{Path: bundle, Passphrase: "wrong"}}}, wantErr: "decoding"},
+		{name: "missing file", config: HTTPConfig{TLS: TLSConfig{PKCS12: PKCS12Config{Path: "/no/such/server.p12"}}}, wantErr: "no such file"},
+		{
+			name:    "with PEM files",
+			config:  HTTPConfig{CertPath: "/path/server.crt", KeyPath: "/path/server.key", TLS: TLSConfig{PKCS12: PKCS12Config{Path: bundle}}},
+			wantErr: "cannot be used with http.cert and http.key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.IP, tt.config.Port, tt.config.ShutdownTimeout = "127.0.0.1", "8443", time.Second
+			if !tt.config.UseTLS() {
+				t.Fatal("expected TLS to be enabled by a PKCS#12 bundle")
+			}
+			err := tt.config.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestServer_ServesTLSFromPKCS12(t *testing.T) {
+	ca, caKey := newTestCA(t, "server CA", nil, nil)
+	bundle, leaf := writePKCS12(t, ca, caKey, "secret")
+	readyFile := filepath.Join(t.TempDir(), "ready")
+	server := NewRendezvousServer(HTTPConfig{
+		IP:              "127.0.0.1",
+		Port:            "0",
+		ReadyFile:       readyFile,
+		ShutdownTimeout: time.Second,
+		TLS:             TLSConfig{PKCS12: PKCS12Config{Path: bundle, Passphrase: "secret"}},
+	}, http.NotFoundHandler())
+
+	errc := make(chan error, 1)
+	go func() { errc <- server.Start() }()
+
+	var addr string
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b, err := os.ReadFile(readyFile)
+		if err == nil {
+			addr = strings.TrimSpace(string(b))
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("ready file was not written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The server presents the bundle's certificate, chained to its CA
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get("https://" + addr + "/")
+	if err != nil {
+		t.Fatalf("TLS request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if peer := resp.TLS.PeerCertificates; len(peer) != 2 || !peer[0].Equal(leaf) || !peer[1].Equal(ca) {
+		t.Fatalf("expected the bundle's certificate chain, got %d certificate(s)", len(peer))
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("server returned error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
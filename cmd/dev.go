@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/spf13/cobra"
+)
+
+// devCmd groups tools for debugging interoperability with devices
+var devCmd = &cobra.Command{
+	Use:    "dev",
+	Short:  "Developer tools for debugging interoperability",
+	Hidden: true,
+}
+
+// decryptServiceInfoCmd decrypts a captured TO2 message, such as
+// TO2.OwnerServiceInfo, with the session keys of its key exchange
+var decryptServiceInfoCmd = &cobra.Command{
+	Use:   "decrypt-serviceinfo",
+	Short: "Decrypt a captured COSE-encrypted ServiceInfo message given its session keys",
+	Long: `Decrypt a captured COSE-encrypted TO2 message, such as TO2.DeviceServiceInfo
+or TO2.OwnerServiceInfo, with the session keys of its key exchange. The
+message is read as raw CBOR from --in and its decrypted CBOR payload is
+printed hex encoded, or written as is with --raw.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		cipherName, _ := flags.GetString("cipher")
+		sekHex, _ := flags.GetString("sek")
+		svkHex, _ := flags.GetString("svk")
+		inPath, _ := flags.GetString("in")
+		raw, _ := flags.GetBool("raw")
+
+		sek, err := hex.DecodeString(sekHex)
+		if err != nil || len(sek) == 0 {
+			return fmt.Errorf("--sek must be a hex encoded session encryption key")
+		}
+		svk, err := hex.DecodeString(svkHex)
+		if err != nil {
+			return fmt.Errorf("--svk must be a hex encoded session verification key")
+		}
+
+		var in io.Reader = cmd.InOrStdin()
+		if inPath != "-" {
+			f, err := os.Open(inPath)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = f.Close() }()
+			in = f
+		}
+		ciphertext, err := io.ReadAll(in)
+		if err != nil {
+			return fmt.Errorf("failed to read message: %w", err)
+		}
+
+		payload, err := decryptMessage(cipherName, sek, svk, ciphertext)
+		if err != nil {
+			return err
+		}
+		if raw {
+			_, err = cmd.OutOrStdout().Write(payload)
+			return err
+		}
+		cmd.Println(hex.EncodeToString(payload))
+		return nil
+	},
+}
+
+// decryptMessage decrypts a tagged COSE_Encrypt0 or COSE_Mac0 message with
+// the named cipher suite. svk is only used by encrypt-then-MAC suites.
+func decryptMessage(cipherName string, sek, svk, ciphertext []byte) ([]byte, error) {
+	suite, err := cipherSuiteByName(cipherName)
+	if err != nil {
+		return nil, err
+	}
+	if suite.MacAlg != 0 && len(svk) == 0 {
+		return nil, fmt.Errorf("cipher suite %s requires --svk", strings.ToUpper(cipherName))
+	}
+	crypter := kex.SessionCrypter{Cipher: suite, SEK: sek, SVK: svk}
+	payload, err := crypter.Decrypt(nil, bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt message: %w", err)
+	}
+	return payload, nil
+}
+
+// cipherSuiteByName returns the cipher suite registered for a name such as
+// A128GCM or COSEAES256CTR
+func cipherSuiteByName(name string) (suite kex.CipherSuite, err error) {
+	id, ok := kex.CipherSuiteByName(name)
+	if !ok {
+		return suite, fmt.Errorf("unknown cipher suite %q", name)
+	}
+	// Suite panics for named suites without an implementation
+	defer func() {
+		if recover() != nil {
+			err = fmt.Errorf("cipher suite %s is not supported", id)
+		}
+	}()
+	return id.Suite(), nil
+}
+
+// Set up the dev command line. Used by the unit tests to reset state between tests.
+func devCmdInit() {
+	rootCmd.AddCommand(devCmd)
+	devCmd.AddCommand(decryptServiceInfoCmd)
+
+	decryptServiceInfoCmd.Flags().String("cipher", "A128GCM", "Cipher suite name, e.g. A128GCM, A256GCM, COSEAES128CTR")
+	decryptServiceInfoCmd.Flags().String("sek", "", "Hex encoded session encryption key (SEK)")
+	decryptServiceInfoCmd.Flags().String("svk", "", "Hex encoded session verification key (SVK), for encrypt-then-MAC suites")
+	decryptServiceInfoCmd.Flags().String("in", "-", "File holding the CBOR encoded message, or \"-\" for stdin")
+	decryptServiceInfoCmd.Flags().Bool("raw", false, "Write the decrypted payload as binary instead of hex")
+	_ = decryptServiceInfoCmd.MarkFlagRequired("sek")
+}
+
+func init() {
+	devCmdInit()
+}
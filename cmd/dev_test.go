@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+func TestDecryptServiceInfo_RoundTrip(t *testing.T) {
+	payload, err := cbor.Marshal([]any{true, false, []*serviceinfo.KV{{Key: "fdo.command:active", Val: []byte{0xf5}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		cipher string
+		id     kex.CipherSuiteID
+		keyLen int
+	}{
+		{cipher: "A128GCM", id: kex.A128GcmCipher, keyLen: 16},
+		{cipher: "A256GCM", id: kex.A256GcmCipher, keyLen: 32},
+		{cipher: "COSEAES128CTR", id: kex.CoseAes128CtrCipher, keyLen: 16},
+		{cipher: "COSEAES256CBC", id: kex.CoseAes256CbcCipher, keyLen: 32},
+	} {
+		t.Run(tt.cipher, func(t *testing.T) {
+			resetState(t)
+
+			sek := make([]byte, tt.keyLen)
+			svk := make([]byte, tt.keyLen)
+			_, _ = rand.Read(sek)
+			_, _ = rand.Read(svk)
+			crypter := kex.SessionCrypter{ID: tt.id, Cipher: tt.id.Suite(), SEK: sek, SVK: svk}
+			msg, err := crypter.Encrypt(rand.Reader, cbor.RawBytes(payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ciphertext, err := cbor.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inPath := filepath.Join(t.TempDir(), "msg.cbor")
+			if err := os.WriteFile(inPath, ciphertext, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			rootCmd.SetOut(&out)
+			t.Cleanup(func() { rootCmd.SetOut(nil) })
+			rootCmd.SetArgs([]string{"dev", "decrypt-serviceinfo", "--cipher", tt.cipher,
+				"--sek", hex.EncodeToString(sek), "--svk", hex.EncodeToString(svk), "--in", inPath})
+			if err := rootCmd.Execute(); err != nil {
+				t.Fatalf("decrypt-serviceinfo failed: %v", err)
+			}
+			if got := strings.TrimSpace(out.String()); got != hex.EncodeToString(payload) {
+				t.Fatalf("payload=%s, want %x", got, payload)
+			}
+		})
+	}
+}
+
+func TestDecryptServiceInfo_Errors(t *testing.T) {
+	sek := make([]byte, 16)
+	crypter := kex.SessionCrypter{Cipher: kex.A128GcmCipher.Suite(), SEK: sek}
+	msg, err := crypter.Encrypt(rand.Reader, cbor.RawBytes{0xf6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := cbor.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := decryptMessage("A128GCM", make([]byte, 16), nil, ciphertext); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wrongKey := bytes.Repeat([]byte{1}, 16)
+	if _, err := decryptMessage("A128GCM", wrongKey, nil, ciphertext); err == nil {
+		t.Fatal("expected error decrypting with the wrong key")
+	}
+	if _, err := decryptMessage("ROT13", sek, nil, ciphertext); err == nil || !strings.Contains(err.Error(), "unknown cipher suite") {
+		t.Fatalf("expected unknown cipher suite error, got %v", err)
+	}
+	if _, err := decryptMessage("AES-CCM-64-128-128", sek, nil, ciphertext); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("expected unsupported cipher suite error, got %v", err)
+	}
+	if _, err := decryptMessage("COSEAES128CTR", sek, nil, ciphertext); err == nil || !strings.Contains(err.Error(), "--svk") {
+		t.Fatalf("expected missing SVK error, got %v", err)
+	}
+}
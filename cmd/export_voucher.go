@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// exportVoucherCmd writes a voucher of the owner database as PEM, as
+// GET /api/v1/owner/vouchers/{guid} does, without a running owner server
+var exportVoucherCmd = &cobra.Command{
+	Use:   "export-voucher guid",
+	Short: "Write the PEM encoded voucher of a device from the owner database",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !utils.IsValidGUID(args[0]) {
+			return fmt.Errorf("invalid GUID %q: must be 32 hexadecimal characters", args[0])
+		}
+		guid, err := hex.DecodeString(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid GUID %q: %w", args[0], err)
+		}
+		var config FDOServerConfig
+		if err := viper.Unmarshal(&config); err != nil {
+			return fmt.Errorf("failed to unmarshal export-voucher config: %w", err)
+		}
+		if _, err := config.DB.getState(); err != nil {
+			return err
+		}
+		voucher, err := db.FetchVoucher(cmd.Context(), map[string]interface{}{"guid": guid})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("no voucher with GUID %s", args[0])
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch voucher %s: %w", args[0], err)
+		}
+
+		block := pem.EncodeToMemory(&pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: voucher.CBOR})
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			_, err = cmd.OutOrStdout().Write(block)
+			return err
+		}
+		return os.WriteFile(out, block, 0o600)
+	},
+}
+
+// Set up the export-voucher command line. Used by the unit tests to reset state between tests.
+func exportVoucherCmdInit() {
+	ownerCmd.AddCommand(exportVoucherCmd)
+
+	exportVoucherCmd.Flags().String("out", "", "File to write the voucher to instead of stdout")
+}
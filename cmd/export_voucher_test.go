@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+func TestOwnerExportVoucher_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, b []byte) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	ownerKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := writeFile("owner.key", der)
+	deviceCA, _ := newTestCA(t, "device CA", nil, nil)
+	caPath := writeFile("device-ca.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: deviceCA.Raw}))
+	ownerConfig := func(dbName string) string {
+		return writeTOMLConfig(t, fmt.Sprintf(`
+[db]
+type = "sqlite"
+dsn = "file:%s"
+
+[device_ca]
+cert = "%s"
+
+[owner]
+key = "%s"
+`, filepath.Join(dir, dbName), caPath, keyPath))
+	}
+	run := func(args ...string) (string, error) {
+		t.Helper()
+		resetState(t)
+		var out bytes.Buffer
+		rootCmd.SetOut(&out)
+		t.Cleanup(func() { rootCmd.SetOut(nil) })
+		rootCmd.SetArgs(args)
+		err := rootCmd.Execute()
+		return out.String(), err
+	}
+
+	// Export a voucher of one owner instance...
+	source := ownerConfig("source.db")
+	out, err := run("owner", "seed-voucher", "--config", source)
+	if err != nil {
+		t.Fatalf("seed-voucher failed: %v", err)
+	}
+	guidHex := strings.TrimSpace(out)
+	guid, err := hex.DecodeString(guidHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeded, err := db.FetchVoucher(context.Background(), map[string]interface{}{"guid": guid})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err = run("owner", "export-voucher", guidHex, "--config", source)
+	if err != nil {
+		t.Fatalf("export-voucher failed: %v", err)
+	}
+	block, rest := pem.Decode([]byte(out))
+	if block == nil || block.Type != "OWNERSHIP VOUCHER" || len(bytes.TrimSpace(rest)) > 0 {
+		t.Fatalf("expected a single OWNERSHIP VOUCHER PEM block, got %q", out)
+	}
+	if !bytes.Equal(block.Bytes, seeded.CBOR) {
+		t.Fatal("exported voucher differs from the stored one")
+	}
+
+	exported := filepath.Join(dir, "voucher.pem")
+	if _, err := run("owner", "export-voucher", guidHex, "--out", exported, "--config", source); err != nil {
+		t.Fatalf("export-voucher --out failed: %v", err)
+	}
+
+	// ...and import it into another one
+	if _, err := run("owner", "import-vouchers", exported, "--config", ownerConfig("target.db")); err != nil {
+		t.Fatalf("import-vouchers failed: %v", err)
+	}
+	imported, err := db.FetchVoucher(context.Background(), map[string]interface{}{"guid": guid})
+	if err != nil {
+		t.Fatalf("exported voucher not imported: %v", err)
+	}
+	if !bytes.Equal(imported.CBOR, seeded.CBOR) {
+		t.Fatal("imported voucher differs from the exported one")
+	}
+
+	// Malformed and unknown GUIDs are rejected
+	if _, err := run("owner", "export-voucher", "not-a-guid", "--config", source); err == nil || !strings.Contains(err.Error(), "invalid GUID") {
+		t.Fatalf("expected an invalid GUID error, got %v", err)
+	}
+	if _, err := run("owner", "export-voucher", strings.Repeat("0", 32), "--config", source); err == nil || !strings.Contains(err.Error(), "no voucher with GUID") {
+		t.Fatalf("expected a missing voucher error, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// importVouchersCmd inserts PEM encoded vouchers into the owner database
+// without going through the owner API, for bulk provisioning
+var importVouchersCmd = &cobra.Command{
+	Use:   "import-vouchers path...",
+	Short: "Verify and insert ownership vouchers from PEM files or directories",
+	Long: `Verify and insert ownership vouchers into the owner database, as done by
+POST /api/v1/owner/vouchers. Each path is either a PEM file holding one or
+more vouchers or a directory, of which all *.pem files are imported. The
+outcome of each file is printed and the command fails if any file could not
+be imported.`,
+	Args: cobra.MinimumNArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlag("owner.key", cmd.Flags().Lookup("owner-key")); err != nil {
+			return err
+		}
+		if err := viper.BindPFlag("device_ca.cert", cmd.Flags().Lookup("device-ca-cert")); err != nil {
+			return err
+		}
+		return viper.BindPFlag("owner.quarantine", cmd.Flags().Lookup("quarantine"))
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var config OwnerServerConfig
+		if err := viper.Unmarshal(&config); err != nil {
+			return fmt.Errorf("failed to unmarshal import-vouchers config: %w", err)
+		}
+		if err := config.Owner.validateKey(); err != nil {
+			return err
+		}
+		if config.DeviceCA.CertPath == "" {
+			return errors.New("a device CA certificate file is required")
+		}
+		files, err := voucherFiles(args)
+		if err != nil {
+			return err
+		}
+		state, err := getOwnerServerState(&config)
+		if err != nil {
+			return err
+		}
+		defer state.close()
+
+		failed, total := importVoucherFiles(cmd, files, state.importPublicKeys(), config.Owner)
+		cmd.Printf("Imported %d voucher(s) from %d file(s), %d file(s) failed\n", total, len(files)-failed, failed)
+		if failed > 0 {
+			return fmt.Errorf("%d of %d voucher file(s) failed to import", failed, len(files))
+		}
+		return nil
+	},
+}
+
+// voucherFiles returns the files named by paths, replacing each directory by
+// the *.pem files it holds
+func voucherFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no *.pem file in directory %s", path)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// importVoucherFiles inserts the vouchers of each file, printing the outcome
+// per file, and returns the number of files that failed and of vouchers
+// inserted
+func importVoucherFiles(cmd *cobra.Command, files []string, ownerPKeys []crypto.PublicKey, config OwnerConfig) (failed, total int) {
+	for _, file := range files {
+		n, err := importVoucherFile(cmd, file, ownerPKeys, config)
+		total += n
+		if err != nil {
+			failed++
+			cmd.Printf("FAILED %s: %v\n", file, err)
+			continue
+		}
+		cmd.Printf("OK     %s: %d voucher(s) imported\n", file, n)
+	}
+	return failed, total
+}
+
+func importVoucherFile(cmd *cobra.Command, file string, ownerPKeys []crypto.PublicKey, config OwnerConfig) (int, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	return handlers.InsertVouchers(cmd.Context(), data, ownerPKeys, config.Quarantine, config.ClockSkew)
+}
+
+// Set up the import-vouchers command line. Used by the unit tests to reset state between tests.
+func importVouchersCmdInit() {
+	ownerCmd.AddCommand(importVouchersCmd)
+
+	importVouchersCmd.Flags().String("owner-key", "", "Owner private key path")
+	importVouchersCmd.Flags().String("device-ca-cert", "", "Device CA certificate path")
+	importVouchersCmd.Flags().Bool("quarantine", false, "Quarantine the imported vouchers until approved")
+}
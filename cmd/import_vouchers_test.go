@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestOwnerImportVouchers_Directory(t *testing.T) {
+	resetState(t)
+
+	dir := t.TempDir()
+	writeFile := func(name string, b []byte) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	ownerKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := writeFile("owner.key", der)
+	deviceCA, _ := newTestCA(t, "device CA", nil, nil)
+	caPath := writeFile("device-ca.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: deviceCA.Raw}))
+
+	vouchersDir := filepath.Join(dir, "vouchers")
+	if err := os.Mkdir(vouchersDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	ov, err := newSeedVoucher(ownerKey, "good", [][]protocol.RvInstruction{{{Variable: protocol.RVBypass}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ovBytes, err := cbor.Marshal(ov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := filepath.Join(vouchersDir, "good.pem")
+	if err := os.WriteFile(good, pem.EncodeToMemory(&pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: ovBytes}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(vouchersDir, "bad.pem")
+	if err := os.WriteFile(bad, pem.EncodeToMemory(&pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: []byte("not a voucher")}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Files without the .pem suffix are ignored
+	if err := os.WriteFile(filepath.Join(vouchersDir, "README"), []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeTOMLConfig(t, fmt.Sprintf(`
+[db]
+type = "sqlite"
+dsn = "file:%s"
+
+[device_ca]
+cert = "%s"
+
+[owner]
+key = "%s"
+`, filepath.Join(dir, "owner.db"), caPath, keyPath))
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	t.Cleanup(func() { rootCmd.SetOut(nil) })
+	rootCmd.SetArgs([]string{"owner", "import-vouchers", vouchersDir, "--config", path})
+	err = rootCmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 voucher file(s) failed") {
+		t.Fatalf("expected the import to fail for one file, got %v", err)
+	}
+
+	output := out.String()
+	for _, want := range []string{
+		"OK     " + good + ": 1 voucher(s) imported",
+		"FAILED " + bad + ": unable to decode cbor",
+		"Imported 1 voucher(s) from 1 file(s), 1 file(s) failed",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "README") {
+		t.Errorf("expected files without the .pem suffix to be skipped, got:\n%s", output)
+	}
+
+	guid := ov.Header.Val.GUID
+	if _, err := db.FetchVoucher(context.Background(), map[string]interface{}{"guid": guid[:]}); err != nil {
+		t.Fatalf("imported voucher not found: %v", err)
+	}
+}
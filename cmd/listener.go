@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"net"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// proxyHeaderTimeout bounds the wait for the PROXY protocol header of a new
+// connection, matching the server's ReadHeaderTimeout
+const proxyHeaderTimeout = 3 * time.Second
+
+// listen opens the server's TCP listener. If PROXY protocol is enabled every
+// connection must start with a v1 or v2 PROXY header, and the client address
+// it carries is reported as the connection's RemoteAddr.
+func listen(config HTTPConfig) (net.Listener, error) {
+	lis, err := net.Listen("tcp", config.ListenAddress())
+	if err != nil {
+		return nil, err
+	}
+	if !config.ProxyProtocol {
+		return lis, nil
+	}
+	return &proxyproto.Listener{
+		Listener: lis,
+		ConnPolicy: func(proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+			return proxyproto.REQUIRE, nil
+		},
+		ReadHeaderTimeout: proxyHeaderTimeout,
+	}, nil
+}
@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// serveRemoteAddr serves the request's RemoteAddr on a listener opened with
+// the given configuration and returns the listener's address
+func serveRemoteAddr(t *testing.T, config HTTPConfig) string {
+	t.Helper()
+	lis, err := listen(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.RemoteAddr)
+	})}
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return lis.Addr().String()
+}
+
+// requestRemoteAddr sends header (if any) followed by a GET request and
+// returns the response body, failing unless the request was served
+func requestRemoteAddr(t *testing.T, addr string, header *proxyproto.Header) (string, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	if header != nil {
+		if _, err := header.WriteTo(conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: fdo\r\nConnection: close\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestListen_ProxyProtocol(t *testing.T) {
+	addr := serveRemoteAddr(t, HTTPConfig{IP: "127.0.0.1", Port: "0", ProxyProtocol: true})
+
+	for _, version := range []byte{1, 2} {
+		header := &proxyproto.Header{
+			Version:           version,
+			Command:           proxyproto.PROXY,
+			TransportProtocol: proxyproto.TCPv4,
+			SourceAddr:        &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234},
+			DestinationAddr:   &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8080},
+		}
+		got, err := requestRemoteAddr(t, addr, header)
+		if err != nil {
+			t.Fatalf("v%d: request failed: %v", version, err)
+		}
+		if got != "203.0.113.7:51234" {
+			t.Fatalf("v%d: RemoteAddr=%q, want %q", version, got, "203.0.113.7:51234")
+		}
+	}
+
+	// Connections without a PROXY header are rejected
+	if got, err := requestRemoteAddr(t, addr, nil); err == nil {
+		t.Fatalf("expected connection without PROXY header to fail, got RemoteAddr %q", got)
+	}
+}
+
+func TestListen_WithoutProxyProtocol(t *testing.T) {
+	addr := serveRemoteAddr(t, HTTPConfig{IP: "127.0.0.1", Port: "0"})
+
+	got, err := requestRemoteAddr(t, addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host, _, _ := net.SplitHostPort(got); host != "127.0.0.1" {
+		t.Fatalf("RemoteAddr=%q, want the peer address", got)
+	}
+}
@@ -4,20 +4,24 @@
 package cmd
 
 import (
+	"cmp"
 	"context"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/rand"
 	"crypto/rsa"
-	"crypto/tls"
+	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"log/slog"
-	"net"
+	"math/big"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 
@@ -35,6 +39,42 @@ import (
 // The manufacturer server configuration
 type ManufacturingConfig struct {
 	ManufacturerKeyPath string `mapstructure:"key"`
+	// Manufacturer private keys of other key types than the main one,
+	// selected by the key type requested by each device
+	AdditionalKeys []ManufacturerKeyConfig `mapstructure:"additional_keys"`
+	// Maximum number of vouchers created during device initialization, 0
+	// for no limit
+	MaxVouchers int `mapstructure:"max_vouchers"`
+	// Window over which MaxVouchers applies, counting vouchers created in
+	// the last MaxVouchersWindow, 0 to count all vouchers
+	MaxVouchersWindow time.Duration `mapstructure:"max_vouchers_window"`
+	// Keep the manufacturing info sent by each device in DI, served by
+	// GET /api/v1/vouchers/{guid}/mfginfo
+	StoreMfgInfo bool `mapstructure:"store_mfg_info"`
+}
+
+// A manufacturer key of another key type than the main one
+type ManufacturerKeyConfig struct {
+	Key string `mapstructure:"key"`
+	// Owner certificate or PKIX public key the vouchers of this key are
+	// extended to, which must have the same key type. Defaults to owner.cert.
+	OwnerCert string `mapstructure:"owner_cert"`
+}
+
+// validate checks the voucher limit and key settings
+func (m *ManufacturingConfig) validate() error {
+	if m.MaxVouchers < 0 {
+		return fmt.Errorf("manufacturing.max_vouchers must not be negative, got %d", m.MaxVouchers)
+	}
+	if m.MaxVouchersWindow < 0 {
+		return fmt.Errorf("manufacturing.max_vouchers_window must not be negative, got %s", m.MaxVouchersWindow)
+	}
+	for i, key := range m.AdditionalKeys {
+		if key.Key == "" {
+			return fmt.Errorf("manufacturing.additional_keys[%d]: a private key file is required", i)
+		}
+	}
+	return nil
 }
 
 // Manufacturer server configuration file structure
@@ -50,17 +90,26 @@ func (m *ManufacturingServerConfig) validate() error {
 	if err := m.HTTP.validate(); err != nil {
 		return err
 	}
+	if err := m.API.validate(); err != nil {
+		return err
+	}
 	if m.Manufacturer.ManufacturerKeyPath == "" {
 		return errors.New("a manufacturing key file is required")
 	}
-	if m.DeviceCA.KeyPath == "" {
-		return errors.New("a device CA key file is required")
+	if err := m.Manufacturer.validate(); err != nil {
+		return err
+	}
+	if m.DeviceCA.KeyPath == "" && !m.DeviceCA.usePKCS11() {
+		return errors.New("a device CA key file or PKCS#11 key is required")
 	}
 	if m.DeviceCA.CertPath == "" {
 		return errors.New("a device CA certificate file is required")
 	}
-	if m.Owner.OwnerCertificate == "" {
-		return errors.New("an owner certificate file is required")
+	if err := m.DeviceCA.validate(); err != nil {
+		return err
+	}
+	if m.Owner.OwnerCertificate == "" && m.Owner.OwnerPrivateKey == "" {
+		return errors.New("an owner certificate or private key file is required")
 	}
 	return nil
 }
@@ -80,13 +129,16 @@ var manufacturingCmd = &cobra.Command{
 		if err := viper.BindPFlag("owner.cert", cmd.Flags().Lookup("owner-cert")); err != nil {
 			return err
 		}
+		if err := viper.BindPFlag("owner.key", cmd.Flags().Lookup("owner-key")); err != nil {
+			return err
+		}
 		if err := viper.BindPFlag("device_ca.cert", cmd.Flags().Lookup("device-ca-cert")); err != nil {
 			return err
 		}
 		if err := viper.BindPFlag("device_ca.key", cmd.Flags().Lookup("device-ca-key")); err != nil {
 			return err
 		}
-		return nil
+		return setHTTPAddress(args)
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var mfgConfig ManufacturingServerConfig
@@ -127,7 +179,7 @@ func (s *ManufacturingServer) Start() error {
 		<-stop
 		slog.Debug("Shutting down server...")
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
 		defer cancel()
 
 		if err := srv.Shutdown(ctx); err != nil {
@@ -136,25 +188,28 @@ func (s *ManufacturingServer) Start() error {
 	}()
 
 	// Listen and serve
-	lis, err := net.Listen("tcp", s.config.ListenAddress())
+	lis, err := listen(s.config)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = lis.Close() }()
 	slog.Info("Listening", "local", lis.Addr().String())
 
-	if s.config.UseTLS() {
-		preferredCipherSuites := []uint16{
-			tls.TLS_AES_256_GCM_SHA384,                  // TLS v1.3
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,   // TLS v1.2
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, // TLS v1.2
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, // TLS v1.2
+	if s.config.ReadyFile != "" {
+		removeReadyFile, err := writeReadyFile(s.config.ReadyFile, lis.Addr().String())
+		if err != nil {
+			return err
 		}
-		srv.TLSConfig = &tls.Config{
-			MinVersion:   tls.VersionTLS12,
-			CipherSuites: preferredCipherSuites,
+		defer removeReadyFile()
+	}
+
+	if s.config.UseTLS() {
+		tlsConfig, err := s.config.serverTLSConfig()
+		if err != nil {
+			return err
 		}
-		err := srv.ServeTLS(lis, s.config.CertPath, s.config.KeyPath)
+		srv.TLSConfig = tlsConfig
+		err = srv.ServeTLS(lis, s.config.CertPath, s.config.KeyPath)
 		if err != nil && err != http.ErrServerClosed {
 			return err
 		}
@@ -175,85 +230,342 @@ func serveManufacturing(config *ManufacturingServerConfig) error {
 	}
 
 	// Load Certs
-	mfgKey, err := parsePrivateKey(config.Manufacturer.ManufacturerKeyPath)
+	mfgKeys, err := loadManufacturerKeys(config)
 	if err != nil {
 		return err
 	}
-	deviceKey, err := parsePrivateKey(config.DeviceCA.KeyPath)
+	deviceKey, deviceCAChain, err := loadDeviceCA(config.DeviceCA)
 	if err != nil {
 		return err
 	}
-	deviceCA, err := os.ReadFile(config.DeviceCA.CertPath)
+	defer closeSigner(deviceKey)
+
+	// Create FDO responder
+	handler := &transport.Handler{
+		Tokens:      dbState,
+		DIResponder: errorLoggingResponder{newDIServer(config.Manufacturer, dbState, mfgKeys, deviceKey, deviceCAChain)},
+	}
+
+	// Handle messages
+	trustedProxies, err := config.HTTP.trustedProxies()
 	if err != nil {
 		return err
 	}
-	blk, _ := pem.Decode(deviceCA)
-	parsedDeviceCACert, err := x509.ParseCertificate(blk.Bytes)
+	apiKeys, err := config.HTTP.Auth.keyDigests()
 	if err != nil {
 		return err
 	}
-	// TODO: chain length >1 should be supported too
-	deviceCAChain := []*x509.Certificate{parsedDeviceCACert}
+	httpHandler := api.NewHTTPHandler(handler, dbState.DB).LimitConcurrency(config.API.MaxConcurrent).
+		WithTimeouts(config.API.Timeout, config.API.Timeouts).WithTrustedProxies(trustedProxies).WithAPIKeys(apiKeys).
+		WithClientRateLimit(config.API.RateLimit.RequestsPerSecond, config.API.rateLimitBurst()).
+		WithRootRedirect(config.HTTP.RootRedirect).WithMessageTimeout(config.HTTP.MessageTimeout).
+		WithMetrics(config.HTTP.Metrics, vouchersGauge()).
+		WithCORS(config.HTTP.CORS.AllowedOrigins, config.HTTP.CORS.AllowedMethods, config.HTTP.CORS.AllowedHeaders).
+		RegisterRoutes(manufacturingAPIRouter())
 
-	// Parse
-	ownerPublicKey, err := os.ReadFile(config.Owner.OwnerCertificate)
+	// Listen and serve
+	server := NewManufacturingServer(config.HTTP, httpHandler)
+
+	stopReload := handleReloadSignal(checkListenAddress(config.HTTP))
+	defer stopReload()
+
+	slog.Debug("Starting server on:", "addr", config.HTTP.ListenAddress())
+	return server.Start()
+}
+
+// newDIServer returns the DI responder creating the vouchers of devices
+// signed by the manufacturer keys, with device certificates signed by the
+// device CA
+func newDIServer(config ManufacturingConfig, dbState *db.State, mfgKeys []manufacturerKey, deviceKey crypto.Signer, deviceCAChain []*x509.Certificate) *fdo.DIServer[custom.DeviceMfgInfo] {
+	var vouchers fdo.VoucherPersistentState = dbState
+	if config.StoreMfgInfo {
+		vouchers = db.MfgInfoRecorder{State: dbState}
+	}
+	server := &fdo.DIServer[custom.DeviceMfgInfo]{
+		Session:               dbState,
+		Vouchers:              vouchers,
+		SignDeviceCertificate: custom.SignDeviceCertificate(deviceKey, deviceCAChain),
+		DeviceInfo:            manufacturerDeviceInfo(mfgKeys),
+		BeforeVoucherPersist: func(ctx context.Context, ov *fdo.Voucher) error {
+			if err := checkVoucherLimit(ctx, config); err != nil {
+				return err
+			}
+			extended, err := extendVoucherToOwner(ov, mfgKeys)
+			if err != nil {
+				return err
+			}
+			*ov = *extended
+			return nil
+		},
+		RvInfo: func(context.Context, *fdo.Voucher) ([][]protocol.RvInstruction, error) {
+			return db.FetchRvInfo()
+		},
+	}
+	return server
+}
+
+// errVoucherLimit is returned by device initialization once the configured
+// maximum number of vouchers has been created.
+var errVoucherLimit = errors.New("voucher limit reached")
+
+// checkVoucherLimit fails with errVoucherLimit if creating another voucher
+// would exceed manufacturing.max_vouchers. Concurrent device initializations
+// are not serialized, so the limit may be overshot by the number of requests
+// in flight.
+func checkVoucherLimit(ctx context.Context, config ManufacturingConfig) error {
+	if config.MaxVouchers == 0 {
+		return nil
+	}
+	var since time.Time
+	if config.MaxVouchersWindow > 0 {
+		since = time.Now().Add(-config.MaxVouchersWindow)
+	}
+	count, err := db.CountVouchers(ctx, since)
 	if err != nil {
-		return err
+		return fmt.Errorf("counting vouchers: %w", err)
+	}
+	if count >= int64(config.MaxVouchers) {
+		slog.Warn("Rejecting device initialization, voucher limit reached", "max_vouchers", config.MaxVouchers, "window", config.MaxVouchersWindow)
+		return errVoucherLimit
+	}
+	return nil
+}
+
+// A manufacturer key with the extension of its vouchers to the owner
+type manufacturerKey struct {
+	signingKey
+	extendToOwner func(*fdo.Voucher, crypto.Signer) (*fdo.Voucher, error)
+}
+
+// loadManufacturerKeys loads the manufacturer key followed by the additional
+// manufacturer keys, each of which must have a distinct key type, with the
+// owner certificate their vouchers are extended to. Without owner.cert, a
+// self-signed certificate is generated from owner.key in its place.
+func loadManufacturerKeys(config *ManufacturingServerConfig) ([]manufacturerKey, error) {
+	keyConfigs := append([]ManufacturerKeyConfig{{Key: config.Manufacturer.ManufacturerKeyPath}}, config.Manufacturer.AdditionalKeys...)
+	var generatedOwnerPEM []byte
+	if config.Owner.OwnerCertificate == "" {
+		ownerKey, err := parsePrivateKey(config.Owner.OwnerPrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("owner.key: %w", err)
+		}
+		ownerCert, err := newSelfSignedOwnerCertificate(ownerKey)
+		if err != nil {
+			return nil, fmt.Errorf("owner.key: %w", err)
+		}
+		slog.Info("Generated a self-signed owner certificate from the owner key", "path", config.Owner.OwnerPrivateKey)
+		generatedOwnerPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ownerCert.Raw})
+	}
+	var keys []manufacturerKey
+	for i, keyConfig := range keyConfigs {
+		name := "manufacturing.key"
+		if i > 0 {
+			name = fmt.Sprintf("manufacturing.additional_keys[%d]", i-1)
+		}
+		key, err := parsePrivateKey(keyConfig.Key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		keyType, err := getPrivateKeyType(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if slices.ContainsFunc(keys, func(k manufacturerKey) bool { return k.keyType == keyType }) {
+			return nil, fmt.Errorf("%s: another manufacturer key has key type %s", name, keyType)
+		}
+		ownerCert := cmp.Or(keyConfig.OwnerCert, config.Owner.OwnerCertificate)
+		ownerPEM := generatedOwnerPEM
+		if ownerCert != "" {
+			if ownerPEM, err = os.ReadFile(ownerCert); err != nil {
+				return nil, err
+			}
+		} else {
+			ownerCert = config.Owner.OwnerPrivateKey
+		}
+		extendToOwner, err := ownerVoucherExtension(ownerPEM)
+		if err != nil {
+			return nil, fmt.Errorf("owner certificate %q: %w", ownerCert, err)
+		}
+		keys = append(keys, manufacturerKey{signingKey: signingKey{key: key, keyType: keyType}, extendToOwner: extendToOwner})
+	}
+	return keys, nil
+}
+
+// manufacturerDeviceInfo returns the DI callback placing in the voucher the
+// public key of the first manufacturer key matching the key type requested
+// by the device.
+func manufacturerDeviceInfo(keys []manufacturerKey) func(context.Context, *custom.DeviceMfgInfo, []*x509.Certificate) (string, protocol.PublicKey, error) {
+	return func(ctx context.Context, info *custom.DeviceMfgInfo, _ []*x509.Certificate) (string, protocol.PublicKey, error) {
+		i := slices.IndexFunc(keys, func(k manufacturerKey) bool { return k.matches(info.KeyType, 0) })
+		if i < 0 {
+			return "", protocol.PublicKey{}, fmt.Errorf("no manufacturer key of key type %s", info.KeyType)
+		}
+		// TODO: Parse manufacturer key chain (different than device CA chain)
+		mfgPubKey, err := encodePublicKey(info.KeyType, info.KeyEncoding, keys[i].key.Public(), nil)
+		if err != nil {
+			return "", protocol.PublicKey{}, err
+		}
+		return info.DeviceInfo, *mfgPubKey, nil
+	}
+}
+
+// extendVoucherToOwner extends the voucher to the owner with the manufacturer
+// key whose public key is in the voucher header
+func extendVoucherToOwner(ov *fdo.Voucher, keys []manufacturerKey) (*fdo.Voucher, error) {
+	pub, err := ov.Header.Val.ManufacturerKey.Public()
+	if err != nil {
+		return nil, fmt.Errorf("voucher manufacturer key: %w", err)
+	}
+	for _, key := range keys {
+		if key.key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(pub) {
+			return key.extendToOwner(ov, key.key)
+		}
 	}
-	block, _ := pem.Decode([]byte(ownerPublicKey))
+	return nil, errors.New("voucher manufacturer key is not one of the manufacturer keys")
+}
+
+// ownerVoucherExtension returns a function extending vouchers, signed with
+// the given manufacturer key, to the owner identified by a PEM encoded
+// certificate or PKIX public key.
+func ownerVoucherExtension(ownerPEM []byte) (func(*fdo.Voucher, crypto.Signer) (*fdo.Voucher, error), error) {
+	block, _ := pem.Decode(ownerPEM)
 	if block == nil {
-		return fmt.Errorf("unable to decode owner public key")
+		return nil, fmt.Errorf("unable to decode owner public key")
 	}
 
-	// TODO: Support PKIX public keys
-	// TODO: Support certificate chains > 1
-	var ownerCert *x509.Certificate
-	ownerCert, err = x509.ParseCertificate(block.Bytes)
+	switch block.Type {
+	case "CERTIFICATE":
+		// TODO: Support certificate chains > 1
+		ownerCert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkOwnerPublicKey(ownerCert.PublicKey); err != nil {
+			return nil, err
+		}
+		chain := []*x509.Certificate{ownerCert}
+		return func(ov *fdo.Voucher, mfgKey crypto.Signer) (*fdo.Voucher, error) {
+			return fdo.ExtendVoucher(ov, mfgKey, chain, nil)
+		}, nil
+
+	case "PUBLIC KEY":
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch pub := pub.(type) {
+		case *ecdsa.PublicKey:
+			return func(ov *fdo.Voucher, mfgKey crypto.Signer) (*fdo.Voucher, error) {
+				return fdo.ExtendVoucher(ov, mfgKey, pub, nil)
+			}, nil
+		case *rsa.PublicKey:
+			return func(ov *fdo.Voucher, mfgKey crypto.Signer) (*fdo.Voucher, error) {
+				return fdo.ExtendVoucher(ov, mfgKey, pub, nil)
+			}, nil
+		default:
+			return nil, checkOwnerPublicKey(pub)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q: must be CERTIFICATE or PUBLIC KEY", block.Type)
+	}
+}
+
+// newSelfSignedOwnerCertificate generates a self-signed certificate for the
+// owner key, for vouchers to be extended to when no owner certificate is
+// configured.
+func newSelfSignedOwnerCertificate(key crypto.Signer) (*x509.Certificate, error) {
+	if err := checkOwnerPublicKey(key.Public()); err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "go-fdo-server owner"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create owner certificate: %w", err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+// loadDeviceCA loads the device CA key and certificate chain, checking that
+// the key is that of the first certificate: device certificates signed with
+// another key would only fail to verify when the device onboards. A key held
+// by a PKCS#11 token is checked by verifying a test signature.
+func loadDeviceCA(config DeviceCAConfig) (crypto.Signer, []*x509.Certificate, error) {
+	chain, err := parseCertificateChain(config.CertPath, config.maxChainLength())
+	if err != nil {
+		return nil, nil, err
+	}
+	if config.usePKCS11() {
+		key, err := openPKCS11Signer(config.PKCS11, chain[0].PublicKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := checkSignerCertificate(key, chain[0]); err != nil {
+			_ = key.Close()
+			return nil, nil, fmt.Errorf("device CA PKCS#11 key does not match certificate %s: %w", config.CertPath, err)
+		}
+		return key, chain, nil
+	}
+	key, err := parsePrivateKey(config.KeyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(chain[0].PublicKey) {
+		return nil, nil, fmt.Errorf("device CA key %s does not match the public key of certificate %s", config.KeyPath, config.CertPath)
+	}
+	return key, chain, nil
+}
+
+// checkSignerCertificate signs a test message with key and verifies the
+// signature with the public key of cert
+func checkSignerCertificate(key crypto.Signer, cert *x509.Certificate) error {
+	msg := []byte("go-fdo-server device CA key check")
+	digest := sha256.Sum256(msg)
+	sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
 	if err != nil {
 		return err
 	}
+	algorithm := x509.ECDSAWithSHA256
+	if _, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+		algorithm = x509.SHA256WithRSA
+	}
+	return cert.CheckSignature(algorithm, msg, sig)
+}
 
-	// Create FDO responder
-	handler := &transport.Handler{
-		Tokens: dbState,
-		DIResponder: &fdo.DIServer[custom.DeviceMfgInfo]{
-			Session:               dbState,
-			Vouchers:              dbState,
-			SignDeviceCertificate: custom.SignDeviceCertificate(deviceKey, deviceCAChain),
-			DeviceInfo: func(ctx context.Context, info *custom.DeviceMfgInfo, _ []*x509.Certificate) (string, protocol.PublicKey, error) {
-				// TODO: Parse manufacturer key chain (different than device CA chain)
-				mfgPubKey, err := encodePublicKey(info.KeyType, info.KeyEncoding, mfgKey.Public(), nil)
-				if err != nil {
-					return "", protocol.PublicKey{}, err
-				}
-				return info.DeviceInfo, *mfgPubKey, nil
-			},
-			BeforeVoucherPersist: func(ctx context.Context, ov *fdo.Voucher) error {
-				extended, err := fdo.ExtendVoucher(ov, mfgKey, []*x509.Certificate{ownerCert}, nil)
-				if err != nil {
-					return err
-				}
-				*ov = *extended
-				return nil
-			},
-			RvInfo: func(context.Context, *fdo.Voucher) ([][]protocol.RvInstruction, error) {
-				return db.FetchRvInfo()
-			},
-		},
+// checkOwnerPublicKey returns an error unless the owner key is of a type
+// encodePublicKey can place in a voucher
+func checkOwnerPublicKey(pub crypto.PublicKey) error {
+	switch pub.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		return nil
+	default:
+		return fmt.Errorf("unsupported owner public key type %T: must be ECDSA or RSA", pub)
 	}
+}
 
-	// Handle messages
+// manufacturingAPIRouter returns the management API routes served by the
+// manufacturing server. The rvInfo is read from the database each time a
+// voucher is created, so changes made through /rvinfo (including its removal)
+// apply to the next device initialized without further refresh.
+func manufacturingAPIRouter() *http.ServeMux {
 	apiRouter := http.NewServeMux()
 	apiRouter.HandleFunc("GET /vouchers", handlers.GetVoucherHandler)
 	apiRouter.HandleFunc("GET /vouchers/{guid}", handlers.GetVoucherByGUIDHandler)
+	apiRouter.HandleFunc("GET /vouchers/{guid}/mfginfo", handlers.GetDeviceMfgInfoHandler)
 	apiRouter.Handle("/rvinfo", handlers.RvInfoHandler())
-	httpHandler := api.NewHTTPHandler(handler, dbState.DB).RegisterRoutes(apiRouter)
-
-	// Listen and serve
-	server := NewManufacturingServer(config.HTTP, httpHandler)
-
-	slog.Debug("Starting server on:", "addr", config.HTTP.ListenAddress())
-	return server.Start()
+	return apiRouter
 }
 
 func encodePublicKey(keyType protocol.KeyType, keyEncoding protocol.KeyEncoding, pub crypto.PublicKey, chain []*x509.Certificate) (*protocol.PublicKey, error) {
@@ -271,7 +583,11 @@ func encodePublicKey(keyType protocol.KeyType, keyEncoding protocol.KeyEncoding,
 		case protocol.Secp256r1KeyType, protocol.Secp384r1KeyType:
 			return protocol.NewPublicKey(keyType, pub.(*ecdsa.PublicKey), keyEncoding == protocol.CoseKeyEnc)
 		case protocol.Rsa2048RestrKeyType, protocol.RsaPkcsKeyType, protocol.RsaPssKeyType:
-			return protocol.NewPublicKey(keyType, pub.(*rsa.PublicKey), keyEncoding == protocol.CoseKeyEnc)
+			rsaPub := pub.(*rsa.PublicKey)
+			if keyType == protocol.Rsa2048RestrKeyType && rsaPub.N.BitLen() != 2048 {
+				return nil, fmt.Errorf("key type %s requires a 2048 bit RSA key, got %d bits", keyType, rsaPub.N.BitLen())
+			}
+			return protocol.NewPublicKey(keyType, rsaPub, keyEncoding == protocol.CoseKeyEnc)
 		default:
 			return nil, fmt.Errorf("unsupported key type: %s", keyType)
 		}
@@ -289,7 +605,8 @@ func manufacturingCmdInit() {
 	// Declare any CLI flags for overriding configuration file settings.
 	// These flags are bound to Viper in the manufacturingCmd PreRun handler.
 	manufacturingCmd.Flags().String("manufacturing-key", "", "Manufacturing private key path")
-	manufacturingCmd.Flags().String("owner-cert", "", "Owner certificate path")
+	manufacturingCmd.Flags().String("owner-cert", "", "Owner certificate or PKIX public key path")
+	manufacturingCmd.Flags().String("owner-key", "", "Owner private key path, to generate a self-signed owner certificate when --owner-cert is not set")
 	manufacturingCmd.Flags().String("device-ca-cert", "", "Device CA certificate path")
 	manufacturingCmd.Flags().String("device-ca-key", "", "Device CA private key path")
 }
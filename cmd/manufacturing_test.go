@@ -0,0 +1,540 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/custom"
+	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"gorm.io/gorm"
+)
+
+func TestManufacturingRvInfoDelete(t *testing.T) {
+	if _, err := db.InitDb("sqlite", ":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	router := manufacturingAPIRouter()
+
+	do := func(method string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/rvinfo", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	body := []byte(`[{"dns":"rv.example","device_port":"8082","owner_port":"8082","protocol":"http"}]`)
+	if rec := do(http.MethodPost, body); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 on POST, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rvInfo, err := db.FetchRvInfo(); err != nil || len(rvInfo) != 1 {
+		t.Fatalf("expected rvInfo used for DI after POST, got %v (err=%v)", rvInfo, err)
+	}
+
+	if rec := do(http.MethodDelete, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 on DELETE, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodGet, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 on GET after DELETE, got %d", rec.Code)
+	}
+	// DI reads the rvInfo from the database, so it sees the removal at once
+	if _, err := db.FetchRvInfo(); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected no rvInfo for DI after DELETE, got err=%v", err)
+	}
+
+	if rec := do(http.MethodDelete, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 on second DELETE, got %d", rec.Code)
+	}
+	// The rvInfo can be created again after removal
+	if rec := do(http.MethodPost, body); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 on POST after DELETE, got %d", rec.Code)
+	}
+}
+
+func TestOwnerVoucherExtension(t *testing.T) {
+	mfgKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerCert, _ := newTestCA(t, "owner", nil, nil)
+	pkix := func(pub crypto.PublicKey) []byte {
+		t.Helper()
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}
+
+	for _, tt := range []struct {
+		name  string
+		pem   []byte
+		owner crypto.PublicKey
+	}{
+		{name: "certificate", pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ownerCert.Raw}), owner: ownerCert.PublicKey},
+		{name: "PKIX public key", pem: pkix(ownerKey.Public()), owner: ownerKey.Public()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			extend, err := ownerVoucherExtension(tt.pem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A voucher owned by the manufacturer key, as created by DI
+			ov, err := newSeedVoucher(mfgKey, "test", [][]protocol.RvInstruction{{{Variable: protocol.RVBypass}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			extended, err := extend(ov, mfgKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := extended.VerifyEntries(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := extended.OwnerPublicKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pub, ok := got.(*ecdsa.PublicKey); !ok || !pub.Equal(tt.owner) {
+				t.Fatal("voucher not extended to the owner key")
+			}
+		})
+	}
+
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		pem     []byte
+		wantErr string
+	}{
+		{name: "unsupported key type", pem: pkix(edPub), wantErr: "unsupported owner public key type ed25519.PublicKey"},
+		{name: "unsupported block", pem: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte{0}}), wantErr: `unsupported PEM block "EC PRIVATE KEY"`},
+		{name: "not PEM", pem: []byte("owner"), wantErr: "unable to decode"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ownerVoucherExtension(tt.pem); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCheckVoucherLimit(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfgKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rvInfo := [][]protocol.RvInstruction{{{Variable: protocol.RVBypass}}}
+
+	const maxVouchers = 3
+	config := ManufacturingConfig{MaxVouchers: maxVouchers}
+	for i := range maxVouchers + 1 {
+		err := checkVoucherLimit(t.Context(), config)
+		if i == maxVouchers {
+			if !errors.Is(err, errVoucherLimit) {
+				t.Fatalf("voucher %d: expected %v, got %v", i+1, errVoucherLimit, err)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("voucher %d: %v", i+1, err)
+		}
+		ov, err := newSeedVoucher(mfgKey, "test", rvInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := state.AddVoucher(t.Context(), ov); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := checkVoucherLimit(t.Context(), ManufacturingConfig{}); err != nil {
+		t.Fatalf("expected no limit when max_vouchers is 0, got %v", err)
+	}
+
+	// Vouchers created before the window do not count towards the limit
+	if err := state.DB.Model(&db.Voucher{}).Where("1 = 1").Update("created_at", time.Now().Add(-2*time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+	config.MaxVouchersWindow = time.Hour
+	if err := checkVoucherLimit(t.Context(), config); err != nil {
+		t.Fatalf("expected vouchers outside the window to be ignored, got %v", err)
+	}
+}
+
+func TestLoadDeviceCA(t *testing.T) {
+	caCert, caKey := newTestCA(t, "device CA", nil, nil)
+	_, otherKey := newTestCA(t, "other", nil, nil)
+
+	dir := t.TempDir()
+	writeKey := func(name string, key crypto.Signer) string {
+		t.Helper()
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, der, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	certPath := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	key, chain, err := loadDeviceCA(DeviceCAConfig{CertPath: certPath, KeyPath: writeKey("ca.key", caKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 1 || !key.Public().(*ecdsa.PublicKey).Equal(chain[0].PublicKey) {
+		t.Fatal("unexpected device CA loaded")
+	}
+
+	_, _, err = loadDeviceCA(DeviceCAConfig{CertPath: certPath, KeyPath: writeKey("other.key", otherKey)})
+	if err == nil || !strings.Contains(err.Error(), "does not match the public key of certificate") {
+		t.Fatalf("expected a key mismatch error, got %v", err)
+	}
+}
+
+func TestManufacturerKeys_ByKeyType(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, b []byte) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	writeKey := func(name string, key crypto.Signer) string {
+		t.Helper()
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return writeFile(name, der)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecOwner, _ := newTestCA(t, "owner", nil, nil)
+	deviceCert, _ := newTestCA(t, "device", nil, nil)
+	rsaOwner, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaOwnerDER, err := x509.MarshalPKIXPublicKey(rsaOwner.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ManufacturingServerConfig{
+		Manufacturer: ManufacturingConfig{
+			ManufacturerKeyPath: writeKey("mfg-ec.key", ecKey),
+			AdditionalKeys: []ManufacturerKeyConfig{{
+				Key:       writeKey("mfg-rsa.key", rsaKey),
+				OwnerCert: writeFile("owner-rsa.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaOwnerDER})),
+			}},
+		},
+		Owner: OwnerConfig{OwnerCertificate: writeFile("owner-ec.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ecOwner.Raw}))},
+	}
+	keys, err := loadManufacturerKeys(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceInfo := manufacturerDeviceInfo(keys)
+
+	for _, tt := range []struct {
+		keyType protocol.KeyType
+		mfgKey  crypto.PublicKey
+		owner   crypto.PublicKey
+	}{
+		{keyType: protocol.Secp256r1KeyType, mfgKey: ecKey.Public(), owner: ecOwner.PublicKey},
+		{keyType: protocol.RsaPkcsKeyType, mfgKey: rsaKey.Public(), owner: rsaOwner.Public()},
+	} {
+		t.Run(tt.keyType.String(), func(t *testing.T) {
+			info := &custom.DeviceMfgInfo{KeyType: tt.keyType, KeyEncoding: protocol.X509KeyEnc, DeviceInfo: "device"}
+			_, mfgPubKey, err := deviceInfo(context.Background(), info, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pub, err := mfgPubKey.Public()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(tt.mfgKey) {
+				t.Fatal("voucher manufacturer key does not match the requested key type")
+			}
+
+			// The voucher DI creates with it is extended by that key
+			ov := &fdo.Voucher{
+				Version: 101,
+				Header: *cbor.NewBstr(fdo.VoucherHeader{
+					Version:         101,
+					DeviceInfo:      info.DeviceInfo,
+					ManufacturerKey: mfgPubKey,
+				}),
+				Hmac:      protocol.Hmac{Algorithm: protocol.HmacSha384Hash, Value: make([]byte, 48)},
+				CertChain: &[]*cbor.X509Certificate{(*cbor.X509Certificate)(deviceCert)},
+			}
+			extended, err := extendVoucherToOwner(ov, keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := extended.VerifyEntries(); err != nil {
+				t.Fatal(err)
+			}
+			owner, err := extended.OwnerPublicKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !owner.(interface{ Equal(crypto.PublicKey) bool }).Equal(tt.owner) {
+				t.Fatal("voucher not extended to the owner key of its key type")
+			}
+		})
+	}
+
+	info := &custom.DeviceMfgInfo{KeyType: protocol.Secp384r1KeyType, KeyEncoding: protocol.X509KeyEnc}
+	if _, _, err := deviceInfo(context.Background(), info, nil); err == nil || !strings.Contains(err.Error(), "no manufacturer key") {
+		t.Fatalf("expected no manufacturer key for secp384r1, got %v", err)
+	}
+
+	config.Manufacturer.AdditionalKeys = append(config.Manufacturer.AdditionalKeys, ManufacturerKeyConfig{Key: config.Manufacturer.ManufacturerKeyPath})
+	if _, err := loadManufacturerKeys(config); err == nil || !strings.Contains(err.Error(), "another manufacturer key has key type") {
+		t.Fatalf("expected duplicate key type to be rejected, got %v", err)
+	}
+}
+
+func TestManufacturerKeys_GeneratedOwnerCert(t *testing.T) {
+	dir := t.TempDir()
+	writeKey := func(name string, key crypto.Signer) string {
+		t.Helper()
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, der, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	mfgKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceCA, _ := newTestCA(t, "device", nil, nil)
+	deviceCAPath := filepath.Join(dir, "device-ca.pem")
+	if err := os.WriteFile(deviceCAPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: deviceCA.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ownerKeyPath := writeKey("owner.key", ownerKey)
+
+	// Only the owner key is given to the manufacturing server
+	config := &ManufacturingServerConfig{
+		Manufacturer: ManufacturingConfig{ManufacturerKeyPath: writeKey("mfg.key", mfgKey)},
+		DeviceCA:     DeviceCAConfig{KeyPath: "device-ca.key", CertPath: deviceCAPath},
+		Owner:        OwnerConfig{OwnerPrivateKey: ownerKeyPath},
+	}
+	keys, err := loadManufacturerKeys(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := &custom.DeviceMfgInfo{KeyType: protocol.Secp256r1KeyType, KeyEncoding: protocol.X509KeyEnc, DeviceInfo: "device"}
+	_, mfgPubKey, err := manufacturerDeviceInfo(keys)(context.Background(), info, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ov := &fdo.Voucher{
+		Version: 101,
+		Header: *cbor.NewBstr(fdo.VoucherHeader{
+			Version:         101,
+			DeviceInfo:      info.DeviceInfo,
+			ManufacturerKey: mfgPubKey,
+		}),
+		Hmac:      protocol.Hmac{Algorithm: protocol.HmacSha384Hash, Value: make([]byte, 48)},
+		CertChain: &[]*cbor.X509Certificate{(*cbor.X509Certificate)(deviceCA)},
+	}
+	extended, err := extendVoucherToOwner(ov, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := extended.VerifyEntries(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The owner server loaded with the same key proves ownership of the
+	// voucher in TO2 and accepts it on import
+	state, err := getOwnerServerState(&OwnerServerConfig{
+		FDOServerConfig: FDOServerConfig{DB: DatabaseConfig{Type: "sqlite", DSN: ":memory:"}},
+		DeviceCA:        DeviceCAConfig{CertPath: deviceCAPath},
+		Owner:           OwnerConfig{OwnerPrivateKey: ownerKeyPath},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	voucherOwner, err := extended.OwnerPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, err := state.OwnerKey(context.Background(), protocol.Secp256r1KeyType, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(voucherOwner) {
+		t.Fatal("voucher not extended to the owner key")
+	}
+	if !slices.ContainsFunc(state.importPublicKeys(), func(pub crypto.PublicKey) bool {
+		return pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(voucherOwner)
+	}) {
+		t.Fatal("voucher extended to the generated owner certificate is not accepted by the owner")
+	}
+}
+
+func TestDIServer_StoresMfgInfo(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertRvInfo([]byte(`[{"dns":"rv.example","protocol":"http"}]`)); err != nil {
+		t.Fatal(err)
+	}
+	mfgKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, _ := newTestCA(t, "owner", nil, nil)
+	extendToOwner, err := ownerVoucherExtension(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: owner.Raw}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfgKeys := []manufacturerKey{{signingKey: signingKey{key: mfgKey, keyType: protocol.Secp256r1KeyType}, extendToOwner: extendToOwner}}
+	deviceCA, deviceCAKey := newTestCA(t, "device CA", nil, nil)
+
+	srv := httptest.NewServer(&transport.Handler{
+		Tokens:      state,
+		DIResponder: newDIServer(ManufacturingConfig{StoreMfgInfo: true}, state, mfgKeys, deviceCAKey, []*x509.Certificate{deviceCA}),
+	})
+	defer srv.Close()
+
+	// Run DI as a device would
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "device"}}, deviceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	cred, err := fdo.DI(context.Background(), &transport.Transport{BaseURL: srv.URL}, custom.DeviceMfgInfo{
+		KeyType:      protocol.Secp256r1KeyType,
+		KeyEncoding:  protocol.X509KeyEnc,
+		SerialNumber: "SN-1234",
+		DeviceInfo:   "test-device",
+		CertInfo:     cbor.X509CertificateRequest(*csr),
+	}, fdo.DIConfig{
+		HmacSha256: hmac.New(sha256.New, secret),
+		HmacSha384: hmac.New(sha512.New384, secret),
+		Key:        deviceKey,
+	})
+	if err != nil {
+		t.Fatalf("DI failed: %v", err)
+	}
+	guid := cred.GUID
+
+	rec := httptest.NewRecorder()
+	manufacturingAPIRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/vouchers/"+hex.EncodeToString(guid[:])+"/mfginfo", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var info struct {
+		GUID         string `json:"guid"`
+		KeyType      int    `json:"key_type"`
+		KeyEncoding  int    `json:"key_encoding"`
+		SerialNumber string `json:"serial_number"`
+		DeviceInfo   string `json:"device_info"`
+		CSR          []byte `json:"csr"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.GUID != hex.EncodeToString(guid[:]) {
+		t.Errorf("guid = %q, want %x", info.GUID, guid[:])
+	}
+	if protocol.KeyType(info.KeyType) != protocol.Secp256r1KeyType || protocol.KeyEncoding(info.KeyEncoding) != protocol.X509KeyEnc {
+		t.Errorf("key type/encoding = %d/%d, want %d/%d", info.KeyType, info.KeyEncoding, protocol.Secp256r1KeyType, protocol.X509KeyEnc)
+	}
+	if info.SerialNumber != "SN-1234" || info.DeviceInfo != "test-device" {
+		t.Errorf("serial/device info = %q/%q", info.SerialNumber, info.DeviceInfo)
+	}
+	if !bytes.Equal(info.CSR, csrDER) {
+		t.Errorf("stored certificate request differs from the device's: %d vs %d bytes", len(info.CSR), len(csrDER))
+	}
+
+	// Nothing is kept for unknown devices
+	rec = httptest.NewRecorder()
+	manufacturingAPIRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/vouchers/"+strings.Repeat("0", 32)+"/mfginfo", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown device, got %d", rec.Code)
+	}
+}
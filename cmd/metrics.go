@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// vouchersGaugeTTL is how long the voucher count of vouchersGauge is reused
+// for the following scrapes, not to count the vouchers table on each of them
+const vouchersGaugeTTL = time.Minute
+
+// vouchersGauge reports the number of vouchers in the database, counted at
+// most once every vouchersGaugeTTL
+func vouchersGauge() prometheus.Collector {
+	var (
+		mu        sync.Mutex
+		count     float64
+		countedAt time.Time
+	)
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "fdo_vouchers",
+		Help: "Ownership vouchers stored in the database.",
+	}, func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		if !countedAt.IsZero() && time.Since(countedAt) < vouchersGaugeTTL {
+			return count
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		n, err := db.CountVouchers(ctx, time.Time{})
+		if err != nil {
+			slog.Error("Failed to count vouchers for metrics", "err", err)
+			return math.NaN()
+		}
+		count, countedAt = float64(n), time.Now()
+		return count
+	})
+}
+
+// moduleSessionsGauge reports the number of TO2 sessions running owner
+// service info modules
+func moduleSessionsGauge(sessions *moduleSessions) prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "fdo_active_module_sessions",
+		Help: "TO2 sessions currently running owner service info modules.",
+	}, func() float64 {
+		return float64(sessions.len())
+	})
+}
@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsGauges(t *testing.T) {
+	if _, err := db.InitDb("sqlite", ":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	for _, guid := range [][]byte{{1}, {2}} {
+		if err := db.InsertVoucher(db.Voucher{GUID: guid, DeviceInfo: "test", CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sessions := newModuleSessions()
+	sessions.set("token", &moduleStateMachineState{Stop: func() {}})
+
+	vouchers := vouchersGauge()
+	active := moduleSessionsGauge(sessions)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(vouchers, active)
+
+	if got := testutil.ToFloat64(vouchers); got != 2 {
+		t.Errorf("expected 2 vouchers, got %v", got)
+	}
+	// The count is reused by the following scrapes
+	if err := db.InsertVoucher(db.Voucher{GUID: []byte{3}, DeviceInfo: "test", CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(vouchers); got != 2 {
+		t.Errorf("expected the cached count of 2 vouchers, got %v", got)
+	}
+	if got := testutil.ToFloat64(active); got != 1 {
+		t.Errorf("expected 1 active module session, got %v", got)
+	}
+	sessions.reap("token", 0)
+	if got := testutil.ToFloat64(active); got != 0 {
+		t.Errorf("expected no active module session, got %v", got)
+	}
+	if n, err := testutil.GatherAndCount(registry, "fdo_vouchers", "fdo_active_module_sessions"); err != nil || n != 2 {
+		t.Fatalf("expected both metrics to be gathered, got %d, %v", n, err)
+	}
+}
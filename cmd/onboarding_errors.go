@@ -0,0 +1,164 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// onboardingFailure is a common cause of onboarding failures, recognized by
+// the errors it produces, with a hint at how to remedy it
+type onboardingFailure struct {
+	cause string
+	hint  string
+	// Lower case fragments of the error messages of the cause
+	matches []string
+}
+
+var (
+	expiredCertificate = onboardingFailure{
+		cause:   "expired_certificate",
+		hint:    "a certificate of the chain is expired or not yet valid: check the clocks of the device and servers, renew the certificate or allow for clock skew with owner.clock_skew",
+		matches: []string{"certificate has expired or is not yet valid"},
+	}
+	untrustedDeviceCA = onboardingFailure{
+		cause:   "untrusted_device_ca",
+		hint:    "the device certificate chain does not verify: check that the device was initialized with the intended device CA (device_ca.cert and device_ca.key of the manufacturing server) and that its voucher was not altered",
+		matches: []string{"certificate signed by unknown authority", "device certificate chain verification failed"},
+	}
+	keyTypeMismatch = onboardingFailure{
+		cause: "key_type_mismatch",
+		hint:  "the voucher or device uses a key type the owner has no key for: configure an owner key of that type with owner.key or owner.additional_keys, or extend the voucher to an owner key of a configured type",
+		matches: []string{
+			"unsupported key type",
+			"owner key type",
+			"to match manufacturer key",
+			"voucher owner key does not match",
+		},
+	}
+)
+
+// onboardingFailures are matched in order, the first matching one being the
+// cause of an error
+var onboardingFailures = []onboardingFailure{expiredCertificate, untrustedDeviceCA, keyTypeMismatch}
+
+// rvUnreachable is the cause of the TO0 registrations failing on network
+// errors. It only applies to TO0, the other network errors being those of
+// the connection of the device.
+var rvUnreachable = onboardingFailure{
+	cause: "rv_unreachable",
+	hint:  "the rendezvous server could not be reached: check that it is running and that the addresses of the voucher RV info resolve and are reachable from this server",
+}
+
+// classifyOnboardingError returns the cause of an onboarding error message,
+// if known
+func classifyOnboardingError(msg string) (onboardingFailure, bool) {
+	msg = strings.ToLower(msg)
+	for _, failure := range onboardingFailures {
+		for _, match := range failure.matches {
+			if strings.Contains(msg, match) {
+				return failure, true
+			}
+		}
+	}
+	return onboardingFailure{}, false
+}
+
+// classifyError returns the cause of an onboarding error, if known. The
+// certificate errors it wraps are recognized by type, others by their
+// message.
+func classifyError(err error) (onboardingFailure, bool) {
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &invalid) && invalid.Reason == x509.Expired {
+		return expiredCertificate, true
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthority) {
+		return untrustedDeviceCA, true
+	}
+	return classifyOnboardingError(err.Error())
+}
+
+// classifyTO0Error returns the cause of a TO0 registration error, if known:
+// rvUnreachable for the network errors it wraps, otherwise that of
+// classifyError
+func classifyTO0Error(err error) (onboardingFailure, bool) {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return rvUnreachable, true
+	}
+	return classifyError(err)
+}
+
+// onboardingErrorAttrs returns the log attributes of an onboarding error
+// message: the error and, if its cause is known, the cause and its
+// remediation hint
+func onboardingErrorAttrs(msg string) []any {
+	failure, ok := classifyOnboardingError(msg)
+	return failureAttrs(msg, failure, ok)
+}
+
+// to0ErrorAttrs returns the log attributes of a TO0 registration error,
+// classified by classifyTO0Error
+func to0ErrorAttrs(err error) []any {
+	failure, ok := classifyTO0Error(err)
+	return failureAttrs(err.Error(), failure, ok)
+}
+
+func failureAttrs(msg string, failure onboardingFailure, ok bool) []any {
+	attrs := []any{"err", msg}
+	if ok {
+		attrs = append(attrs, "cause", failure.cause, "hint", failure.hint)
+	}
+	return attrs
+}
+
+// errorLoggingResponder logs the failures of the protocol messages of a
+// responder, whether they are sent to the device as an error message or
+// reported by the device, classified by onboardingErrorAttrs
+type errorLoggingResponder struct {
+	protocol.Responder
+}
+
+func (r errorLoggingResponder) Respond(ctx context.Context, msgType uint8, msg io.Reader) (uint8, any) {
+	respType, resp := r.Responder.Respond(ctx, msgType, msg)
+	if respType == protocol.ErrorMsgType {
+		var errMsg protocol.ErrorMessage
+		switch resp := resp.(type) {
+		case protocol.ErrorMessage:
+			errMsg = resp
+		case *protocol.ErrorMessage:
+			errMsg = *resp
+		}
+		slog.Warn("Onboarding failed", append([]any{"request_id", handlers.RequestID(ctx), "msg_type", msgType, "code", errMsg.Code}, onboardingErrorAttrs(errMsg.ErrString)...)...)
+	}
+	return respType, resp
+}
+
+func (r errorLoggingResponder) HandleError(ctx context.Context, errMsg protocol.ErrorMessage) {
+	slog.Warn("Device reported an onboarding error", append([]any{"request_id", handlers.RequestID(ctx), "msg_type", errMsg.PrevMsgType, "code", errMsg.Code}, onboardingErrorAttrs(errMsg.ErrString)...)...)
+	r.Responder.HandleError(ctx, errMsg)
+}
+
+// CryptSession returns the encryption session of the TO2 responder, which
+// the transport needs for the encrypted TO2 messages
+func (r errorLoggingResponder) CryptSession(ctx context.Context) (kex.Session, error) {
+	crypt, ok := r.Responder.(interface {
+		CryptSession(context.Context) (kex.Session, error)
+	})
+	if !ok {
+		return nil, errors.New("responder has no encryption session")
+	}
+	return crypt.CryptSession(ctx)
+}
@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/testdata"
+)
+
+func TestErrorLoggingResponder_KeyTypeMismatch(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ovPEM, err := testdata.Files.ReadFile("ov.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(ovPEM)
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(block.Bytes, &ov); err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := testdata.Files.ReadFile("mfg_key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ = pem.Decode(keyPEM)
+	mfgKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extended, err := fdo.ExtendVoucher(&ov, mfgKey, &ownerKey.PublicKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.AddVoucher(context.Background(), extended); err != nil {
+		t.Fatal(err)
+	}
+
+	// The owner has no key of the type the device signs with
+	responder := errorLoggingResponder{&fdo.TO2Server{Session: state, Vouchers: state, OwnerKeys: state}}
+	token, err := state.NewToken(context.Background(), protocol.TO2Protocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello, err := cbor.Marshal([]any{
+		uint16(1300), ov.Header.Val.GUID, protocol.Nonce{}, "ECDH256", uint16(1),
+		[]any{cose.ES256Alg, []byte{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	respType, _ := responder.Respond(state.TokenContext(context.Background(), token), protocol.TO2HelloDeviceMsgType, bytes.NewReader(hello))
+	if respType != protocol.ErrorMsgType {
+		t.Fatalf("expected an error message, got message type %d", respType)
+	}
+
+	var record map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var r map[string]any
+		if err := json.Unmarshal([]byte(line), &r); err == nil && r["msg"] == "Onboarding failed" {
+			record = r
+		}
+	}
+	if record == nil {
+		t.Fatalf("expected the failure to be logged, got %s", logs.String())
+	}
+	failure, _ := classifyOnboardingError("unsupported key type")
+	if record["cause"] != "key_type_mismatch" || record["hint"] != failure.hint {
+		t.Fatalf("expected a key type mismatch with its hint, got %v", record)
+	}
+	if record["level"] != "WARN" || record["msg_type"] != float64(protocol.TO2HelloDeviceMsgType) || record["err"] == "" {
+		t.Fatalf("expected the message type and error to be logged, got %v", record)
+	}
+}
+
+func TestClassifyOnboardingError(t *testing.T) {
+	tests := []struct {
+		msg   string
+		cause string
+	}{
+		{"VerifyVoucher: device certificate chain verification failed: cryptographic verification failed: x509: certificate has expired or is not yet valid: current time is after", "expired_certificate"},
+		{"VerifyVoucher: device certificate chain verification failed: cryptographic verification failed: x509: certificate signed by unknown authority", "untrusted_device_ca"},
+		{"owner key type SECP256R1 not supported", "key_type_mismatch"},
+		{"device sig info has key type \"SECP256R1\", must be \"SECP384R1\" to match manufacturer key", "key_type_mismatch"},
+		// Network errors are only classified for TO0 registrations
+		{"unable to register any 'RVTO2Addr' URL for guid='00': http://rv:8041: dial tcp 192.0.2.1:8041: connect: connection refused", ""},
+		{"error retrieving voucher for device 00: not found", ""},
+	}
+	for _, tt := range tests {
+		failure, ok := classifyOnboardingError(tt.msg)
+		if ok != (tt.cause != "") || failure.cause != tt.cause {
+			t.Errorf("%q: expected cause %q, got %q", tt.msg, tt.cause, failure.cause)
+		}
+		attrs := onboardingErrorAttrs(tt.msg)
+		if ok && len(attrs) != 6 || !ok && len(attrs) != 2 {
+			t.Errorf("%q: expected the cause and hint only for known causes, got %v", tt.msg, attrs)
+		}
+	}
+}
+
+func TestClassifyTO0Error(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	expired := x509.CertificateInvalidError{Reason: x509.Expired}
+	tests := []struct {
+		err   error
+		cause string
+	}{
+		{fmt.Errorf("unable to register any 'RVTO2Addr' URL for guid='00': %w", errors.Join(fmt.Errorf("http://rv:8041: TO0.Hello: %w", refused))), "rv_unreachable"},
+		{fmt.Errorf("TO0.Hello: %w", &net.DNSError{Err: "no such host", Name: "rv", IsNotFound: true}), "rv_unreachable"},
+		{fmt.Errorf("error making HTTP request: %w", expired), "expired_certificate"},
+		{fmt.Errorf("error making HTTP request: %w", x509.UnknownAuthorityError{}), "untrusted_device_ca"},
+		{errors.New("owner key type SECP256R1 not supported"), "key_type_mismatch"},
+		{errors.New("no RV info found that is usable for the owner"), ""},
+	}
+	for _, tt := range tests {
+		failure, ok := classifyTO0Error(tt.err)
+		if ok != (tt.cause != "") || failure.cause != tt.cause {
+			t.Errorf("%q: expected cause %q, got %q", tt.err, tt.cause, failure.cause)
+		}
+	}
+}
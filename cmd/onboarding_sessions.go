@@ -0,0 +1,219 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// Default for OwnerOnboardingConfig.IdleTimeout
+const defaultOnboardingIdleTimeout = 10 * time.Minute
+
+// Owner limits on the TO2 sessions served at once
+type OwnerOnboardingConfig struct {
+	// Maximum number of TO2 sessions served at once, 0 means unlimited
+	MaxSessions int `mapstructure:"max_sessions"`
+	// Relative share of the sessions of each device class, the device info
+	// of the voucher (typically its model), compared case insensitively.
+	// Classes not listed have a weight of 1.
+	Weights map[string]int `mapstructure:"weights"`
+	// Time without a message after which a session no longer holds its
+	// place, and after which places are no longer kept for a class refused
+	// a session
+	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+}
+
+func (o *OwnerOnboardingConfig) validate() error {
+	if o.MaxSessions < 0 {
+		return fmt.Errorf("owner.onboarding.max_sessions must not be negative, got %d", o.MaxSessions)
+	}
+	for class, weight := range o.Weights {
+		if weight < 1 {
+			return fmt.Errorf("owner.onboarding.weights: weight of %q must be positive, got %d", class, weight)
+		}
+	}
+	if o.IdleTimeout < 0 {
+		return fmt.Errorf("owner.onboarding.idle_timeout must not be negative, got %s", o.IdleTimeout)
+	}
+	return nil
+}
+
+// errOnboardingBusy refuses a TO2 session the pool has no place for. The
+// device retries TO2 later.
+var errOnboardingBusy = errors.New("too many devices onboarding, retry later")
+
+// onboardingPool bounds the TO2 sessions served at once, sharing them
+// between device classes in proportion to their weights. A class may use
+// the places left free by others, but once a class below its share has been
+// refused a session, the places freed are kept for it.
+type onboardingPool struct {
+	max         int
+	weights     map[string]int
+	idleTimeout time.Duration
+	now         func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*onboardingSession // by token
+	active   map[string]int                // sessions by class
+	waiting  map[string]time.Time          // last refusal of the classes refused since their last session
+}
+
+type onboardingSession struct {
+	class    string
+	lastSeen time.Time
+}
+
+// newOnboardingPool returns the pool of the configuration, nil if sessions
+// are not limited
+func newOnboardingPool(config OwnerOnboardingConfig) *onboardingPool {
+	if config.MaxSessions == 0 {
+		return nil
+	}
+	weights := make(map[string]int, len(config.Weights))
+	for class, weight := range config.Weights {
+		weights[strings.ToLower(class)] = weight
+	}
+	idleTimeout := config.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = defaultOnboardingIdleTimeout
+	}
+	return &onboardingPool{
+		max:         config.MaxSessions,
+		weights:     weights,
+		idleTimeout: idleTimeout,
+		now:         time.Now,
+		sessions:    make(map[string]*onboardingSession),
+		active:      make(map[string]int),
+		waiting:     make(map[string]time.Time),
+	}
+}
+
+func (p *onboardingPool) weight(class string) int {
+	if weight, ok := p.weights[class]; ok {
+		return weight
+	}
+	return 1
+}
+
+// acquire gives the session of token a place in the pool for a device of
+// class, or returns errOnboardingBusy
+func (p *onboardingPool) acquire(token, class string) error {
+	class = strings.ToLower(class)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	p.expire(now)
+	if session, ok := p.sessions[token]; ok {
+		session.lastSeen = now
+		return nil
+	}
+
+	if len(p.sessions) >= p.max || !p.withinShare(class) {
+		p.waiting[class] = now
+		slog.Debug("Onboarding session refused", "class", class, "active", p.active[class], "sessions", len(p.sessions))
+		return errOnboardingBusy
+	}
+	delete(p.waiting, class)
+	p.sessions[token] = &onboardingSession{class: class, lastSeen: now}
+	p.active[class]++
+	return nil
+}
+
+// withinShare reports whether class may take one more session: either it
+// holds less than its share of the pool, or no other waiting class does.
+// Shares are those of the classes holding or waiting for sessions.
+func (p *onboardingPool) withinShare(class string) bool {
+	competing := map[string]bool{class: true}
+	for c := range p.active {
+		competing[c] = true
+	}
+	for c := range p.waiting {
+		competing[c] = true
+	}
+	var total int
+	for c := range competing {
+		total += p.weight(c)
+	}
+	share := func(c string) float64 {
+		return float64(p.max) * float64(p.weight(c)) / float64(total)
+	}
+
+	if float64(p.active[class]) < share(class) {
+		return true
+	}
+	for c := range p.waiting {
+		if c != class && float64(p.active[c]) < share(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// expire frees the places of idle sessions and stops waiting for the
+// classes that no longer ask for sessions
+func (p *onboardingPool) expire(now time.Time) {
+	for token, session := range p.sessions {
+		if now.Sub(session.lastSeen) > p.idleTimeout {
+			p.remove(token, session)
+		}
+	}
+	for class, last := range p.waiting {
+		if now.Sub(last) > p.idleTimeout {
+			delete(p.waiting, class)
+		}
+	}
+}
+
+// touch records a message of the session of token
+func (p *onboardingPool) touch(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if session, ok := p.sessions[token]; ok {
+		session.lastSeen = p.now()
+	}
+}
+
+// release frees the place of the session of token, if it holds one
+func (p *onboardingPool) release(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if session, ok := p.sessions[token]; ok {
+		p.remove(token, session)
+	}
+}
+
+func (p *onboardingPool) remove(token string, session *onboardingSession) {
+	delete(p.sessions, token)
+	if p.active[session.class]--; p.active[session.class] == 0 {
+		delete(p.active, session.class)
+	}
+}
+
+// onboardingTokens releases the place of TO2 sessions in the pool when
+// their token is invalidated, as happens when TO2 completes or fails, and
+// keeps the sessions receiving messages from expiring
+type onboardingTokens struct {
+	protocol.TokenService
+	pool *onboardingPool
+}
+
+func (t onboardingTokens) TokenContext(ctx context.Context, token string) context.Context {
+	t.pool.touch(token)
+	return t.TokenService.TokenContext(ctx, token)
+}
+
+func (t onboardingTokens) InvalidateToken(ctx context.Context) error {
+	if token, ok := t.TokenFromContext(ctx); ok {
+		t.pool.release(token)
+	}
+	return t.TokenService.InvalidateToken(ctx)
+}
@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// onboardRounds simulates devices of each class starting TO2 in rounds,
+// the classes in order, every session admitted in a round completing at
+// its end. It returns the sessions admitted per class in each round.
+func onboardRounds(t *testing.T, pool *onboardingPool, classes []string, devices map[string]int, rounds int) []map[string]int {
+	t.Helper()
+	var admitted []map[string]int
+	var n int
+	for range rounds {
+		round := make(map[string]int)
+		var tokens []string
+		for _, class := range classes {
+			for range devices[class] {
+				n++
+				token := fmt.Sprintf("token-%d", n)
+				err := pool.acquire(token, class)
+				if errors.Is(err, errOnboardingBusy) {
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				round[class]++
+				tokens = append(tokens, token)
+			}
+		}
+		if len(tokens) > pool.max {
+			t.Fatalf("%d sessions admitted in a pool of %d", len(tokens), pool.max)
+		}
+		for _, token := range tokens {
+			pool.release(token)
+		}
+		for class, count := range round {
+			devices[class] -= count
+		}
+		admitted = append(admitted, round)
+	}
+	return admitted
+}
+
+func TestOnboardingPool_ClassesShareSessions(t *testing.T) {
+	pool := newOnboardingPool(OwnerOnboardingConfig{MaxSessions: 4})
+	devices := map[string]int{"model-a": 40, "model-b": 6}
+	admitted := onboardRounds(t, pool, []string{"model-a", "model-b"}, devices, 5)
+
+	// The first class takes the whole pool until the other asks for a
+	// session, then both get their share
+	if admitted[0]["model-a"] != 4 || admitted[0]["model-b"] != 0 {
+		t.Fatalf("round 0: expected the idle pool to be used by model-a, got %v", admitted[0])
+	}
+	for i, round := range admitted[1:4] {
+		if round["model-a"] != 2 || round["model-b"] != 2 {
+			t.Fatalf("round %d: expected both classes to get 2 sessions, got %v", i+1, round)
+		}
+	}
+	// Once model-b is done, model-a takes the whole pool again
+	if devices["model-b"] != 0 {
+		t.Fatalf("expected all model-b devices to onboard, %d left", devices["model-b"])
+	}
+	if admitted[4]["model-a"] != 4 {
+		t.Fatalf("round 4: expected model-a to use the whole pool, got %v", admitted[4])
+	}
+}
+
+func TestOnboardingPool_Weights(t *testing.T) {
+	// Weights are matched case insensitively
+	pool := newOnboardingPool(OwnerOnboardingConfig{MaxSessions: 4, Weights: map[string]int{"Model-A": 3}})
+	devices := map[string]int{"model-a": 40, "MODEL-B": 40}
+	admitted := onboardRounds(t, pool, []string{"MODEL-B", "model-a"}, devices, 4)
+	for i, round := range admitted[1:] {
+		if round["model-a"] != 3 || round["MODEL-B"] != 1 {
+			t.Fatalf("round %d: expected 3 model-a and 1 model-b sessions, got %v", i+1, round)
+		}
+	}
+}
+
+func TestOnboardingPool_IdleSessionsExpire(t *testing.T) {
+	now := time.Now()
+	pool := newOnboardingPool(OwnerOnboardingConfig{MaxSessions: 1, IdleTimeout: time.Minute})
+	pool.now = func() time.Time { return now }
+
+	if err := pool.acquire("first", "model"); err != nil {
+		t.Fatal(err)
+	}
+	// A session receiving messages keeps its place
+	now = now.Add(50 * time.Second)
+	pool.touch("first")
+	now = now.Add(50 * time.Second)
+	if err := pool.acquire("second", "model"); !errors.Is(err, errOnboardingBusy) {
+		t.Fatalf("expected the pool to be full, got %v", err)
+	}
+	// An abandoned one loses it
+	now = now.Add(2 * time.Minute)
+	if err := pool.acquire("second", "model"); err != nil {
+		t.Fatalf("expected the idle session to free its place, got %v", err)
+	}
+}
+
+func TestOnboardingTokens_InvalidateReleases(t *testing.T) {
+	dbState, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := newOnboardingPool(OwnerOnboardingConfig{MaxSessions: 1})
+	tokens := onboardingTokens{TokenService: dbState, pool: pool}
+
+	token, err := tokens.NewToken(context.Background(), protocol.TO2Protocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.acquire(token, "model"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.acquire("other", "model"); !errors.Is(err, errOnboardingBusy) {
+		t.Fatalf("expected the pool to be full, got %v", err)
+	}
+	// TO2 completing or failing invalidates the token
+	if err := tokens.InvalidateToken(tokens.TokenContext(context.Background(), token)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.acquire("other", "model"); err != nil {
+		t.Fatalf("expected the ended session to free its place, got %v", err)
+	}
+}
+
+func TestOwnerOnboardingConfig_Validate(t *testing.T) {
+	tests := []struct {
+		config  OwnerOnboardingConfig
+		wantErr string
+	}{
+		{config: OwnerOnboardingConfig{}},
+		{config: OwnerOnboardingConfig{MaxSessions: 8, Weights: map[string]int{"model": 2}, IdleTimeout: time.Minute}},
+		{config: OwnerOnboardingConfig{MaxSessions: -1}, wantErr: "max_sessions must not be negative"},
+		{config: OwnerOnboardingConfig{Weights: map[string]int{"model": 0}}, wantErr: `weight of "model" must be positive`},
+		{config: OwnerOnboardingConfig{IdleTimeout: -time.Second}, wantErr: "idle_timeout must not be negative"},
+	}
+	for _, tt := range tests {
+		err := tt.config.validate()
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%+v: unexpected error: %v", tt.config, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.config, tt.wantErr, err)
+		}
+	}
+	if newOnboardingPool(OwnerOnboardingConfig{}) != nil {
+		t.Error("expected no pool without max_sessions")
+	}
+}
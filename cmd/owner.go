@@ -6,10 +6,9 @@ package cmd
 import (
 	"context"
 	"crypto"
-	"crypto/tls"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/hex"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"iter"
@@ -22,6 +21,9 @@ import (
 	"path"
 	"path/filepath"
 	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -33,6 +35,7 @@ import (
 	"github.com/fido-device-onboard/go-fdo/cbor"
 	"github.com/fido-device-onboard/go-fdo/fsim"
 	transport "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/kex"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 	"github.com/fido-device-onboard/go-fdo/serviceinfo"
 	"github.com/spf13/cobra"
@@ -43,15 +46,169 @@ import (
 type OwnerConfig struct {
 	OwnerCertificate string `mapstructure:"cert"`
 	OwnerPrivateKey  string `mapstructure:"key"`
-	ReuseCred        bool   `mapstructure:"reuse_credentials"`
-	TO0InsecureTLS   bool   `mapstructure:"to0_insecure_tls"`
+	// Owner key held by a PKCS#11 token (HSM), used instead of
+	// OwnerPrivateKey
+	PKCS11         PKCS11KeyConfig        `mapstructure:"pkcs11"`
+	ReuseCred      bool                   `mapstructure:"reuse_credentials"`
+	TO0InsecureTLS bool                   `mapstructure:"to0_insecure_tls"`
+	ServiceInfo    OwnerServiceInfoConfig `mapstructure:"serviceinfo"`
+	Upload         OwnerUploadConfig      `mapstructure:"upload"`
+
+	// Address devices reach this owner at for TO2, registered by TO0 when
+	// no owner info was set through the API: "host:port", "host" or
+	// ":port", defaulting to the listen address
+	ExternalAddress string `mapstructure:"external_address"`
+
+	// Delay after which a voucher not yet registered with a rendezvous
+	// server by TO0 is reported by the stats API
+	TO0WarnAfter time.Duration `mapstructure:"to0_warn_after"`
+
+	// Quarantine newly imported vouchers: their device may not onboard
+	// until an operator approves the voucher
+	Quarantine bool `mapstructure:"quarantine"`
+
+	// Tolerated difference between this server's clock and that of the
+	// issuers of the voucher certificates when checking their validity
+	ClockSkew time.Duration `mapstructure:"clock_skew"`
+
+	// Age after which the voucher of a device that has not onboarded is
+	// reported expired. 0 disables expiry.
+	OnboardingExpiry time.Duration `mapstructure:"onboarding_expiry"`
+
+	// Refuse TO2 for devices whose voucher is expired
+	RefuseExpired bool `mapstructure:"refuse_expired"`
+
+	// Move the vouchers deleted through the API to an archive instead of
+	// deleting them
+	ArchiveDeletedVouchers bool `mapstructure:"archive_deleted_vouchers"`
+
+	// Action taken when a device that already completed TO2 starts it again
+	// without credential reuse: "allow" (default), "refuse" or "reset"
+	Reonboard string `mapstructure:"reonboard"`
+
+	// Optional key signing TO2.SetupDevice, which becomes the owner key of
+	// the replacement voucher. Defaults to the owner key.
+	TO2PrivateKey string `mapstructure:"to2_key"`
+
+	// Owner keys of other key types than the owner key, each returned with
+	// its own certificate chain
+	AdditionalKeys []OwnerKeyConfig `mapstructure:"additional_keys"`
+
+	// Maximum service info MTU sent to devices, capping the size the device
+	// requests in TO2.DeviceServiceInfoReady. 0 leaves it to the device.
+	MTU int `mapstructure:"mtu"`
+
+	// Limits on the TO2 sessions served at once, shared between device
+	// classes
+	Onboarding OwnerOnboardingConfig `mapstructure:"onboarding"`
+}
+
+// usePKCS11 returns true if the owner key is held by a PKCS#11 token
+func (o *OwnerConfig) usePKCS11() bool {
+	return o.PKCS11.Module != ""
+}
+
+// validateKey checks that exactly one of the owner key file and the PKCS#11
+// owner key is configured
+func (o *OwnerConfig) validateKey() error {
+	if !o.usePKCS11() {
+		if o.OwnerPrivateKey == "" {
+			return errors.New("an owner private key file is required")
+		}
+		return nil
+	}
+	if o.OwnerPrivateKey != "" {
+		return errors.New("owner.key cannot be used with owner.pkcs11")
+	}
+	return o.PKCS11.validate("owner.pkcs11")
+}
+
+// loadOwnerKey returns the owner key, parsed from its file or signing
+// through the PKCS#11 token holding it
+func (o *OwnerConfig) loadOwnerKey() (crypto.Signer, error) {
+	if !o.usePKCS11() {
+		return parsePrivateKey(o.OwnerPrivateKey)
+	}
+	key, err := openPKCS11Signer(o.PKCS11, nil)
+	if err != nil {
+		return nil, fmt.Errorf("owner.pkcs11: %w", err)
+	}
+	return key, nil
+}
+
+// Actions for OwnerConfig.Reonboard
+const (
+	reonboardAllow  = "allow"
+	reonboardRefuse = "refuse"
+	reonboardReset  = "reset"
+)
+
+// Bounds of OwnerConfig.MTU: the smallest service info size the FDO
+// specification requires devices to accept and the largest encodable one
+const (
+	minOwnerMTU = 256
+	maxOwnerMTU = 65535
+)
+
+// An additional owner key and its certificate chain
+type OwnerKeyConfig struct {
+	Key   string `mapstructure:"key"`   // private key path
+	Chain string `mapstructure:"chain"` // optional PEM certificate chain path
+}
+
+// Owner policy for the device's service info modules
+type OwnerServiceInfoConfig struct {
+	// Abort TO2 if the device advertises a module the owner does not handle
+	RejectUnknownModules bool `mapstructure:"reject_unknown_modules"`
+	// Action taken when no owner module applies to the device: "proceed"
+	// (default), "warn" or "fail"
+	NoModules string `mapstructure:"no_modules"`
+	// Devmod fields the device must report, e.g. "sn" or "mudurl", on top of
+	// those required by the FDO specification
+	RequireDevmod []string `mapstructure:"require_devmod"`
+	// Probe the fdo.wget URLs in the readiness check, on top of checking
+	// the fdo.download files
+	CheckWgetURLs bool `mapstructure:"check_wget_urls"`
+	// Log the FSIM operations planned for each device when its service
+	// info starts
+	LogPlan bool `mapstructure:"log_plan"`
+	// Time the module state of a TO2 session is kept after the session
+	// ends, 0 to remove it at once
+	CleanupGrace time.Duration `mapstructure:"cleanup_grace"`
+}
+
+// Actions for OwnerServiceInfoConfig.NoModules
+const (
+	noModulesProceed = "proceed"
+	noModulesWarn    = "warn"
+	noModulesFail    = "fail"
+)
+
+func (o *OwnerServiceInfoConfig) validate() error {
+	switch o.NoModules {
+	case "", noModulesProceed, noModulesWarn, noModulesFail:
+	default:
+		return fmt.Errorf("invalid owner.serviceinfo.no_modules %q: must be %q, %q or %q",
+			o.NoModules, noModulesProceed, noModulesWarn, noModulesFail)
+	}
+	for _, field := range o.RequireDevmod {
+		if !slices.Contains(devmodFieldNames(), field) {
+			return fmt.Errorf("invalid owner.serviceinfo.require_devmod field %q: must be one of %s",
+				field, strings.Join(devmodFieldNames(), ", "))
+		}
+	}
+	if o.CleanupGrace < 0 {
+		return fmt.Errorf("owner.serviceinfo.cleanup_grace must not be negative, got %s", o.CleanupGrace)
+	}
+	return nil
 }
 
 // Owner server configuration file structure
 type OwnerServerConfig struct {
 	FDOServerConfig `mapstructure:",squash"`
-	DeviceCA        DeviceCAConfig `mapstructure:"device_ca"`
-	Owner           OwnerConfig    `mapstructure:"owner"`
+	DeviceCA        DeviceCAConfig    `mapstructure:"device_ca"`
+	Owner           OwnerConfig       `mapstructure:"owner"`
+	ServiceInfo     ServiceInfoConfig `mapstructure:"service_info"`
 }
 
 // validate checks that required configuration is present
@@ -59,17 +216,69 @@ func (o *OwnerServerConfig) validate() error {
 	if err := o.HTTP.validate(); err != nil {
 		return err
 	}
-	if o.Owner.OwnerPrivateKey == "" {
-		return errors.New("an owner private key file is required")
+	if err := o.API.validate(); err != nil {
+		return err
+	}
+	if err := o.Owner.validateKey(); err != nil {
+		return err
+	}
+	if o.Owner.TO2PrivateKey != "" && o.Owner.ReuseCred {
+		return errors.New("owner.to2_key cannot be used with owner.reuse_credentials: devices only reuse credentials when the owner key is unchanged")
 	}
 	if o.DeviceCA.CertPath == "" {
 		return errors.New("a device CA certificate file is required")
 	}
+	if err := o.DeviceCA.validate(); err != nil {
+		return err
+	}
+	if o.Owner.ExternalAddress != "" {
+		if _, _, err := parseExternalAddress(o.Owner.ExternalAddress, o.HTTP.IP, o.HTTP.Port); err != nil {
+			return fmt.Errorf("owner.external_address: %w", err)
+		}
+	}
+	if o.Owner.TO0WarnAfter < 0 {
+		return fmt.Errorf("owner.to0_warn_after must not be negative, got %s", o.Owner.TO0WarnAfter)
+	}
+	for i, key := range o.Owner.AdditionalKeys {
+		if key.Key == "" {
+			return fmt.Errorf("owner.additional_keys[%d]: a private key file is required", i)
+		}
+	}
+	if o.Owner.ClockSkew < 0 {
+		return fmt.Errorf("owner.clock_skew must not be negative, got %s", o.Owner.ClockSkew)
+	}
+	if o.Owner.OnboardingExpiry < 0 {
+		return fmt.Errorf("owner.onboarding_expiry must not be negative, got %s", o.Owner.OnboardingExpiry)
+	}
+	if o.Owner.RefuseExpired && o.Owner.OnboardingExpiry == 0 {
+		return errors.New("owner.refuse_expired requires owner.onboarding_expiry")
+	}
+	switch o.Owner.Reonboard {
+	case "", reonboardAllow, reonboardRefuse, reonboardReset:
+	default:
+		return fmt.Errorf("invalid owner.reonboard %q: must be %q, %q or %q",
+			o.Owner.Reonboard, reonboardAllow, reonboardRefuse, reonboardReset)
+	}
+	if o.Owner.MTU != 0 && (o.Owner.MTU < minOwnerMTU || o.Owner.MTU > maxOwnerMTU) {
+		return fmt.Errorf("owner.mtu must be between %d and %d, got %d", minOwnerMTU, maxOwnerMTU, o.Owner.MTU)
+	}
 
 	// Validate FSIM parameters
 	if err := validateFSIMParameters(); err != nil {
 		return err
 	}
+	if err := o.ServiceInfo.validate(); err != nil {
+		return err
+	}
+	if err := o.Owner.ServiceInfo.validate(); err != nil {
+		return err
+	}
+	if err := o.Owner.Upload.validate(); err != nil {
+		return err
+	}
+	if err := o.Owner.Onboarding.validate(); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -103,10 +312,40 @@ var ownerCmd = &cobra.Command{
 		if err := viper.BindPFlag("owner.key", cmd.Flags().Lookup("owner-key")); err != nil {
 			return err
 		}
+		if err := viper.BindPFlag("owner.to2_key", cmd.Flags().Lookup("to2-key")); err != nil {
+			return err
+		}
 		if err := viper.BindPFlag("owner.to0_insecure_tls", cmd.Flags().Lookup("to0-insecure-tls")); err != nil {
 			return err
 		}
-		return nil
+		if err := viper.BindPFlag("owner.external_address", cmd.Flags().Lookup("external-address")); err != nil {
+			return err
+		}
+		if err := viper.BindPFlag("owner.quarantine", cmd.Flags().Lookup("quarantine")); err != nil {
+			return err
+		}
+		if err := viper.BindPFlag("owner.clock_skew", cmd.Flags().Lookup("clock-skew")); err != nil {
+			return err
+		}
+		if err := viper.BindPFlag("owner.onboarding_expiry", cmd.Flags().Lookup("onboarding-expiry")); err != nil {
+			return err
+		}
+		if err := viper.BindPFlag("owner.refuse_expired", cmd.Flags().Lookup("refuse-expired")); err != nil {
+			return err
+		}
+		if err := viper.BindPFlag("owner.mtu", cmd.Flags().Lookup("owner-mtu")); err != nil {
+			return err
+		}
+		if err := viper.BindPFlag("owner.archive_deleted_vouchers", cmd.Flags().Lookup("archive-deleted-vouchers")); err != nil {
+			return err
+		}
+		if err := viper.BindPFlag("owner.reonboard", cmd.Flags().Lookup("reonboard")); err != nil {
+			return err
+		}
+		if err := viper.BindPFlag("owner.onboarding.max_sessions", cmd.Flags().Lookup("max-onboarding-sessions")); err != nil {
+			return err
+		}
+		return setHTTPAddress(args)
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var ownerConfig OwnerServerConfig
@@ -147,7 +386,7 @@ func (s *OwnerServer) Start() error {
 		<-stop
 		slog.Debug("Shutting down server...")
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
 		defer cancel()
 
 		if err := srv.Shutdown(ctx); err != nil {
@@ -156,25 +395,28 @@ func (s *OwnerServer) Start() error {
 	}()
 
 	// Listen and serve
-	lis, err := net.Listen("tcp", s.config.ListenAddress())
+	lis, err := listen(s.config)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = lis.Close() }()
 	slog.Info("Listening", "local", lis.Addr().String())
 
-	if s.config.UseTLS() {
-		preferredCipherSuites := []uint16{
-			tls.TLS_AES_256_GCM_SHA384,                  // TLS v1.3
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,   // TLS v1.2
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, // TLS v1.2
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, // TLS v1.2
+	if s.config.ReadyFile != "" {
+		removeReadyFile, err := writeReadyFile(s.config.ReadyFile, lis.Addr().String())
+		if err != nil {
+			return err
 		}
-		srv.TLSConfig = &tls.Config{
-			MinVersion:   tls.VersionTLS12,
-			CipherSuites: preferredCipherSuites,
+		defer removeReadyFile()
+	}
+
+	if s.config.UseTLS() {
+		tlsConfig, err := s.config.serverTLSConfig()
+		if err != nil {
+			return err
 		}
-		err := srv.ServeTLS(lis, s.config.CertPath, s.config.KeyPath)
+		srv.TLSConfig = tlsConfig
+		err = srv.ServeTLS(lis, s.config.CertPath, s.config.KeyPath)
 		if err != nil && err != http.ErrServerClosed {
 			return err
 		}
@@ -188,43 +430,108 @@ func (s *OwnerServer) Start() error {
 }
 
 type OwnerServerState struct {
-	DB           *db.State
-	ownerKey     crypto.Signer
-	ownerKeyType protocol.KeyType
-	chain        []*x509.Certificate
+	DB             *db.State
+	ownerKey       crypto.Signer
+	ownerKeyType   protocol.KeyType
+	chain          []*x509.Certificate
+	to2Key         crypto.Signer // nil unless distinct from ownerKey
+	additionalKeys []signingKey
+}
+
+// A private key with its FDO key type, e.g. an owner key of another key type
+// than the main owner key
+type signingKey struct {
+	key     crypto.Signer
+	keyType protocol.KeyType
+	chain   []*x509.Certificate
+}
+
+// matches reports whether the key can sign for the requested key type, e.g.
+// an OwnerKey request
+func (k *signingKey) matches(keyType protocol.KeyType, rsaBits int) bool {
+	switch keyType {
+	case protocol.Rsa2048RestrKeyType, protocol.RsaPkcsKeyType, protocol.RsaPssKeyType:
+		pub, ok := k.key.Public().(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		if keyType == protocol.Rsa2048RestrKeyType {
+			return pub.N.BitLen() == 2048
+		}
+		return rsaBits == 0 || pub.N.BitLen() == rsaBits
+	default:
+		return k.keyType == keyType
+	}
 }
 
-func getOwnerServerState(config *OwnerServerConfig) (*OwnerServerState, error) {
+func getOwnerServerState(config *OwnerServerConfig) (_ *OwnerServerState, err error) {
 	dbState, err := config.DB.getState()
 	if err != nil {
 		return nil, err
 	}
-	ownerKey, err := parsePrivateKey(config.Owner.OwnerPrivateKey)
+	ownerKey, err := config.Owner.loadOwnerKey()
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err != nil {
+			closeSigner(ownerKey)
+		}
+	}()
 	ownerKeyType, err := getPrivateKeyType(ownerKey)
 	if err != nil {
 		return nil, err
 	}
-	deviceCA, err := os.ReadFile(config.DeviceCA.CertPath)
-	if err != nil {
-		return nil, err
-	}
-	blk, _ := pem.Decode(deviceCA)
-	if blk == nil {
-		return nil, fmt.Errorf("unable to decode device CA")
+	var to2Key crypto.Signer
+	if config.Owner.TO2PrivateKey != "" {
+		to2Key, err = parsePrivateKey(config.Owner.TO2PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		// The replacement voucher keeps the key type of the original one
+		to2KeyType, err := getPrivateKeyType(to2Key)
+		if err != nil {
+			return nil, err
+		}
+		if to2KeyType != ownerKeyType {
+			return nil, fmt.Errorf("TO2 key type %s does not match owner key type %s", to2KeyType, ownerKeyType)
+		}
 	}
-	parsedDeviceCACert, err := x509.ParseCertificate(blk.Bytes)
+	deviceCAChain, err := parseCertificateChain(config.DeviceCA.CertPath, config.DeviceCA.maxChainLength())
 	if err != nil {
 		return nil, err
 	}
+	keyTypes := []protocol.KeyType{ownerKeyType}
+	var additionalKeys []signingKey
+	for i, keyConfig := range config.Owner.AdditionalKeys {
+		key, err := parsePrivateKey(keyConfig.Key)
+		if err != nil {
+			return nil, fmt.Errorf("owner.additional_keys[%d]: %w", i, err)
+		}
+		keyType, err := getPrivateKeyType(key)
+		if err != nil {
+			return nil, fmt.Errorf("owner.additional_keys[%d]: %w", i, err)
+		}
+		if slices.Contains(keyTypes, keyType) {
+			return nil, fmt.Errorf("owner.additional_keys[%d]: another owner key has key type %s", i, keyType)
+		}
+		keyTypes = append(keyTypes, keyType)
+		var chain []*x509.Certificate
+		if keyConfig.Chain != "" {
+			if chain, err = parseCertificateChain(keyConfig.Chain, config.DeviceCA.maxChainLength()); err != nil {
+				return nil, fmt.Errorf("owner.additional_keys[%d]: %w", i, err)
+			}
+		}
+		additionalKeys = append(additionalKeys, signingKey{key: key, keyType: keyType, chain: chain})
+	}
 
 	return &OwnerServerState{
-		DB:           dbState,
-		chain:        []*x509.Certificate{parsedDeviceCACert},
-		ownerKey:     ownerKey,
-		ownerKeyType: ownerKeyType,
+		DB:             dbState,
+		chain:          deviceCAChain,
+		ownerKey:       ownerKey,
+		ownerKeyType:   ownerKeyType,
+		to2Key:         to2Key,
+		additionalKeys: additionalKeys,
 	}, nil
 }
 
@@ -237,15 +544,9 @@ func validateFSIMParameters() error {
 	// Parse and validate wget URLs
 	wgetURLs = make([]*url.URL, 0, len(wgets))
 	for _, urlString := range wgets {
-		parsedURL, err := url.Parse(urlString)
+		parsedURL, err := parseWgetURL(urlString)
 		if err != nil {
-			return fmt.Errorf("invalid wget URL %q: %w", urlString, err)
-		}
-		if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-			return fmt.Errorf("wget URL %q must use http or https scheme, got %q", urlString, parsedURL.Scheme)
-		}
-		if parsedURL.Host == "" {
-			return fmt.Errorf("wget URL %q missing host", urlString)
+			return err
 		}
 		wgetURLs = append(wgetURLs, parsedURL)
 	}
@@ -289,6 +590,21 @@ func validateFSIMParameters() error {
 	return nil
 }
 
+// parseWgetURL parses and validates a URL the device will retrieve using fdo.wget
+func parseWgetURL(urlString string) (*url.URL, error) {
+	parsedURL, err := url.Parse(urlString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wget URL %q: %w", urlString, err)
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return nil, fmt.Errorf("wget URL %q must use http or https scheme, got %q", urlString, parsedURL.Scheme)
+	}
+	if parsedURL.Host == "" {
+		return nil, fmt.Errorf("wget URL %q missing host", urlString)
+	}
+	return parsedURL, nil
+}
+
 func hasFSIMParameters() bool {
 	return len(wgets) > 0 || len(downloads) > 0 || len(uploads) > 0 || uploadDir != "" || date
 }
@@ -298,38 +614,140 @@ func serveOwner(config *OwnerServerConfig) error {
 	if err != nil {
 		return err
 	}
+	defer state.close()
 
+	serviceInfo := new(atomic.Pointer[ServiceInfoConfig])
+	serviceInfo.Store(&config.ServiceInfo)
+
+	moduleSessions := newModuleSessions()
+	// Sessions in their cleanup grace period are removed when the server
+	// stops, rather than left with their module iterators running
+	defer moduleSessions.close()
+	onboarding := newOnboardingPool(config.Owner.Onboarding)
+	var tokens protocol.TokenService = state.DB
+	if onboarding != nil {
+		tokens = onboardingTokens{TokenService: state.DB, pool: onboarding}
+	}
+	var to2Session fdo.TO2SessionState = state.DB
+	if config.Owner.MTU != 0 {
+		to2Session = mtuCappedSession{TO2SessionState: state.DB, max: uint16(config.Owner.MTU)}
+	}
+	var to2Vouchers fdo.OwnerVoucherPersistentState = state.DB
+	if config.Owner.Reonboard == reonboardReset && !config.Owner.ReuseCred {
+		to2Vouchers = db.OnboardingResetter{State: state.DB}
+	}
 	to2Server := &fdo.TO2Server{
-		Session:              state.DB,
-		Vouchers:             state.DB,
+		Session:              to2Session,
+		Vouchers:             to2Vouchers,
 		VouchersForExtension: state.DB,
 		OwnerKeys:            state,
 		RvInfo: func(_ context.Context, voucher fdo.Voucher) ([][]protocol.RvInstruction, error) {
 			return voucher.Header.Val.RvInfo, nil
 		},
-		Modules:         moduleStateMachines{DB: state.DB, states: make(map[string]*moduleStateMachineState)},
+		Modules: moduleStateMachines{
+			DB:                   state.DB,
+			ServiceInfo:          serviceInfo,
+			RejectUnknownModules: config.Owner.ServiceInfo.RejectUnknownModules,
+			NoModules:            config.Owner.ServiceInfo.NoModules,
+			RequireDevmod:        config.Owner.ServiceInfo.RequireDevmod,
+			LogPlan:              config.Owner.ServiceInfo.LogPlan,
+			CleanupGrace:         config.Owner.ServiceInfo.CleanupGrace,
+			states:               moduleSessions,
+		},
 		ReuseCredential: func(context.Context, fdo.Voucher) (bool, error) { return config.Owner.ReuseCred, nil },
-		VerifyVoucher: func(_ context.Context, voucher fdo.Voucher) error {
-			return handlers.VerifyVoucher(&voucher, []crypto.PublicKey{state.ownerKey.Public()})
+		VerifyVoucher: func(ctx context.Context, voucher fdo.Voucher) error {
+			if err := handlers.VerifyVoucher(&voucher, state.ownerPublicKeys(), config.Owner.ClockSkew); err != nil {
+				return err
+			}
+			if err := checkQuarantine(ctx, voucher.Header.Val.GUID); err != nil {
+				return err
+			}
+			if config.Owner.RefuseExpired {
+				if err := checkExpiry(ctx, voucher.Header.Val.GUID, config.Owner.OnboardingExpiry); err != nil {
+					return err
+				}
+			}
+			if !config.Owner.ReuseCred {
+				if err := checkReonboard(ctx, voucher.Header.Val.GUID, config.Owner.Reonboard); err != nil {
+					return err
+				}
+			}
+			if onboarding != nil {
+				// Only sessions of vouchers passing the checks take a place
+				token, _ := state.DB.TokenFromContext(ctx)
+				return onboarding.acquire(token, voucher.Header.Val.DeviceInfo)
+			}
+			return nil
 		},
 	}
 
 	handler := &transport.Handler{
-		Tokens:       state.DB,
-		TO2Responder: to2Server,
+		Tokens:       tokens,
+		TO2Responder: errorLoggingResponder{to2Server},
 	}
 
 	// Handle messages
 	apiRouter := http.NewServeMux()
-	apiRouter.Handle("POST /owner/vouchers", handlers.InsertVoucherHandler([]crypto.PublicKey{state.ownerKey.Public()}))
+	apiRouter.Handle("POST /owner/vouchers", handlers.InsertVoucherHandler(state.importPublicKeys(), config.Owner.Quarantine, config.Owner.ClockSkew))
+	apiRouter.HandleFunc("GET /owner/vouchers/{guid}", handlers.GetVoucherByGUIDHandler)
+	var deletedVouchers fdo.VoucherReseller = state.DB
+	if config.Owner.ArchiveDeletedVouchers {
+		deletedVouchers = db.VoucherArchiver{State: state.DB}
+	}
+	apiRouter.Handle("DELETE /owner/vouchers/{guid}", handlers.DeleteVoucherHandler(deletedVouchers))
+	apiRouter.HandleFunc("GET /owner/vouchers/archive", handlers.GetArchivedVouchersHandler)
+	apiRouter.HandleFunc("GET /owner/vouchers/archive/{guid}", handlers.GetArchivedVoucherHandler)
+	apiRouter.HandleFunc("POST /owner/vouchers/{guid}/approve", handlers.ApproveVoucherHandler)
 	apiRouter.HandleFunc("/owner/redirect", handlers.OwnerInfoHandler)
 	apiRouter.Handle("POST /owner/resell/{guid}", handlers.ResellHandler(to2Server))
-	apiRouter.Handle("GET /owner/devices", http.HandlerFunc(handlers.OwnerDevicesHandler))
-	httpHandler := api.NewHTTPHandler(handler, state.DB.DB).RegisterRoutes(apiRouter)
+	apiRouter.Handle("GET /owner/devices", handlers.OwnerDevicesHandler(config.Owner.OnboardingExpiry))
+	apiRouter.HandleFunc("GET /owner/devices/export", handlers.ExportDevicesHandler)
+	apiRouter.HandleFunc("PUT /owner/devices/{guid}/label", handlers.SetDeviceLabelHandler)
+	apiRouter.Handle("GET /owner/service-info/capabilities", handlers.ServiceInfoCapabilitiesHandler(func() handlers.ServiceInfoCapabilities {
+		return serviceInfoCapabilities(serviceInfo.Load())
+	}))
+	apiRouter.Handle("GET /stats", handlers.StatsHandler(config.Owner.TO0WarnAfter))
+	if uploadDir != "" {
+		apiRouter.Handle("GET /owner/uploads/stats", handlers.UploadStatsHandler(uploadDir))
+	}
+	trustedProxies, err := config.HTTP.trustedProxies()
+	if err != nil {
+		return err
+	}
+	apiKeys, err := config.HTTP.Auth.keyDigests()
+	if err != nil {
+		return err
+	}
+	fsimSources, stopFSIMSourcesCheck := fsimSourcesCheck(serviceInfo, config.Owner.ServiceInfo.CheckWgetURLs, fsimSourcesCheckInterval)
+	defer stopFSIMSourcesCheck()
+	httpHandler := api.NewHTTPHandler(handler, state.DB.DB).LimitConcurrency(config.API.MaxConcurrent).
+		WithTimeouts(config.API.Timeout, config.API.Timeouts).WithTrustedProxies(trustedProxies).WithAPIKeys(apiKeys).
+		WithClientRateLimit(config.API.RateLimit.RequestsPerSecond, config.API.rateLimitBurst()).
+		WithRootRedirect(config.HTTP.RootRedirect).WithMessageTimeout(config.HTTP.MessageTimeout).
+		WithReadinessChecks(fsimSources).
+		WithMetrics(config.HTTP.Metrics, vouchersGauge(), moduleSessionsGauge(moduleSessions)).
+		WithCORS(config.HTTP.CORS.AllowedOrigins, config.HTTP.CORS.AllowedMethods, config.HTTP.CORS.AllowedHeaders).
+		RegisterRoutes(apiRouter)
 
 	// Listen and serve
 	server := NewOwnerServer(config.HTTP, httpHandler)
 
+	// Vouchers without owner info are only registered by TO0 at an address
+	// configured explicitly: one guessed from the interfaces may not be the
+	// one devices reach the server at
+	var externalTO2Addrs []protocol.RvTO2Addr
+	if config.Owner.ExternalAddress != "" {
+		addr, err := externalTO2Addr(config.Owner.ExternalAddress, config.HTTP)
+		if err != nil {
+			return err
+		}
+		externalTO2Addrs = []protocol.RvTO2Addr{addr}
+	} else if externalAddress, err := defaultExternalAddress(config.HTTP.IP, net.InterfaceAddrs); err != nil {
+		slog.Warn("owner.external_address is not set, vouchers without owner info are not registered by TO0", "err", err)
+	} else {
+		slog.Warn("owner.external_address is not set, vouchers without owner info are not registered by TO0", "suggested_address", externalAddress)
+	}
+
 	// Background TO0 scheduler: after restarts, continue attempting TO0 for any
 	// devices without completed TO2 as recorded in the database.
 	go func() {
@@ -368,42 +786,335 @@ func serveOwner(config *OwnerServerConfig) error {
 					continue
 				}
 				// Attempt TO0 once for this GUID
-				refresh, err := to0.RegisterRvBlob(ov.Header.Val.RvInfo, guidHex, state.DB, state, config.Owner.TO0InsecureTLS, defaultTo0TTL)
+				refresh, err := to0.RegisterRvBlob(ov.Header.Val.RvInfo, guidHex, state.DB, state, config.Owner.TO0InsecureTLS, defaultTo0TTL, externalTO2Addrs)
 				if err != nil {
 					// On failure, retry after 60s
 					nextTry[guidHex] = now.Add(10 * time.Second)
-					slog.Warn("to0 scheduler: register 'RV2TO0Addr' failed", "guid", guidHex, "err", err)
+					slog.Warn("to0 scheduler: register 'RV2TO0Addr' failed", append([]any{"guid", guidHex}, to0ErrorAttrs(err)...)...)
 					continue
 				}
 				if refresh == 0 {
 					refresh = defaultTo0TTL
 				}
 				slog.Debug("to0 scheduler: register 'RV2TO0Addr' completed", "guid", guidHex, "refresh", refresh)
+				if err := db.SetTO0Registered(context.Background(), ov.Header.Val.GUID[:]); err != nil {
+					slog.Warn("to0 scheduler: recording registration failed", "guid", guidHex, "err", err)
+				}
 				nextTry[guidHex] = now.Add(time.Duration(refresh) * time.Second)
 			}
 			<-ticker.C
 		}
 	}()
 
+	if config.Owner.Upload.Retention > 0 {
+		if uploadDir == "" {
+			slog.Warn("owner.upload.retention is set but no upload directory is configured")
+		} else {
+			stopCleanup := startUploadCleanup(uploadDir, config.Owner.Upload.Retention)
+			defer stopCleanup()
+		}
+	}
+
+	stopReload := handleReloadSignal(func() error {
+		return reloadOwnerConfig(config, serviceInfo)
+	})
+	defer stopReload()
+
 	slog.Debug("Starting server on:", "addr", config.HTTP.ListenAddress())
 	return server.Start()
 }
 
+// mtuCappedSession caps the service info MTU requested by the device, for
+// the owner to chunk its service info to at most max bytes
+type mtuCappedSession struct {
+	fdo.TO2SessionState
+	max uint16
+}
+
+func (s mtuCappedSession) SetMTU(ctx context.Context, mtu uint16) error {
+	if mtu > s.max {
+		slog.Debug("Capping service info MTU requested by device", "requested", mtu, "mtu", s.max)
+		mtu = s.max
+	}
+	return s.TO2SessionState.SetMTU(ctx, mtu)
+}
+
+// reloadOwnerConfig applies the reloadable owner settings from the re-read
+// configuration. The service_info configuration is replaced atomically;
+// sessions already in progress keep the configuration they started with.
+func reloadOwnerConfig(current *OwnerServerConfig, serviceInfo *atomic.Pointer[ServiceInfoConfig]) error {
+	var reloaded OwnerServerConfig
+	if err := viper.Unmarshal(&reloaded); err != nil {
+		return fmt.Errorf("failed to unmarshal owner config: %w", err)
+	}
+	if err := reloaded.ServiceInfo.validate(); err != nil {
+		return fmt.Errorf("invalid reloaded configuration: %w", err)
+	}
+	warnIfListenAddressChanged(current.HTTP, reloaded.HTTP)
+	serviceInfo.Store(&reloaded.ServiceInfo)
+	slog.Info("Reloaded service_info configuration", "fsims", len(reloaded.ServiceInfo.Fsims), "overrides", len(reloaded.ServiceInfo.Overrides))
+	return nil
+}
+
+// close releases the owner key, logging out of its PKCS#11 token
+func (state *OwnerServerState) close() {
+	closeSigner(state.ownerKey)
+}
+
+// OwnerKey returns the key signing on behalf of the owner. With a distinct
+// TO2 key, a TO2 session proves ownership (TO2.ProveOVHdr) with the key the
+// device's voucher is extended to, then signs TO2.SetupDevice with the TO2
+// key once the key exchange is established. ASYMKEX sessions keep the
+// voucher's key throughout: the device encrypts its key exchange parameter
+// to it, and TO2.SetupDevice is decrypted with the same key it is signed
+// with. The owner key is used outside of TO2, e.g. for TO0 and resale.
+// Requests for the key type of an additional owner key are served by that
+// key and its chain.
 func (state *OwnerServerState) OwnerKey(ctx context.Context, keyType protocol.KeyType, rsaBits int) (crypto.Signer, []*x509.Certificate, error) {
+	primary := signingKey{key: state.ownerKey, keyType: state.ownerKeyType}
+	if !primary.matches(keyType, rsaBits) {
+		for _, key := range state.additionalKeys {
+			if key.matches(keyType, rsaBits) {
+				return key.key, key.chain, nil
+			}
+		}
+	}
+	if state.to2Key == nil {
+		return state.ownerKey, state.chain, nil
+	}
+	if _, ok := state.DB.TokenFromContext(ctx); !ok {
+		return state.ownerKey, state.chain, nil
+	}
+	suite, sess, err := state.DB.XSession(ctx)
+	switch {
+	case err == nil:
+		sess.Destroy()
+		if suite != kex.ASYMKEX2048Suite && suite != kex.ASYMKEX3072Suite {
+			return state.to2Key, nil, nil
+		}
+		slog.Debug("Not using the TO2 key with an asymmetric key exchange", "suite", suite)
+	case !errors.Is(err, fdo.ErrNotFound):
+		return nil, nil, err
+	}
+	return state.voucherOwnerKey(ctx)
+}
+
+// voucherOwnerKey returns the owner key or the TO2 key, whichever the voucher
+// of the TO2 session is extended to: the TO2 key if the device already
+// onboarded with it
+func (state *OwnerServerState) voucherOwnerKey(ctx context.Context) (crypto.Signer, []*x509.Certificate, error) {
+	guid, err := state.DB.GUID(ctx)
+	if err != nil {
+		return state.ownerKey, state.chain, nil
+	}
+	ov, err := state.DB.Voucher(ctx, guid)
+	if err != nil {
+		return nil, nil, err
+	}
+	voucherKey, err := ov.OwnerPublicKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	if state.to2Key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(voucherKey) {
+		return state.to2Key, nil, nil
+	}
 	return state.ownerKey, state.chain, nil
 }
 
+// ownerPublicKeys returns the public keys vouchers may be extended to
+func (state *OwnerServerState) ownerPublicKeys() []crypto.PublicKey {
+	keys := state.importPublicKeys()
+	if state.to2Key != nil {
+		keys = append(keys, state.to2Key.Public())
+	}
+	return keys
+}
+
+// importPublicKeys returns the public keys of the owner keys, which vouchers
+// imported through the API must be extended to
+func (state *OwnerServerState) importPublicKeys() []crypto.PublicKey {
+	keys := []crypto.PublicKey{state.ownerKey.Public()}
+	for _, key := range state.additionalKeys {
+		keys = append(keys, key.key.Public())
+	}
+	return keys
+}
+
+// externalTO2Addr returns the TO2 address registered by TO0 for the owner's
+// external address
+func externalTO2Addr(externalAddress string, config HTTPConfig) (protocol.RvTO2Addr, error) {
+	host, port, err := parseExternalAddress(externalAddress, config.IP, config.Port)
+	if err != nil {
+		return protocol.RvTO2Addr{}, fmt.Errorf("owner.external_address: %w", err)
+	}
+	addr := protocol.RvTO2Addr{Port: port, TransportProtocol: protocol.HTTPTransport}
+	if config.UseTLS() {
+		addr.TransportProtocol = protocol.HTTPSTransport
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		addr.IPAddress = &ip
+	} else {
+		addr.DNSAddress = &host
+	}
+	return addr, nil
+}
+
+// errQuarantined rejects TO2 for a device whose voucher awaits approval
+var errQuarantined = errors.New("voucher is quarantined until approved")
+
+// checkQuarantine fails with errQuarantined if the voucher with the given
+// GUID has not been approved for onboarding. Vouchers stay quarantined when
+// owner.quarantine is later disabled.
+func checkQuarantine(ctx context.Context, guid protocol.GUID) error {
+	quarantined, err := db.IsVoucherQuarantined(ctx, guid[:])
+	if err != nil {
+		return fmt.Errorf("checking voucher quarantine: %w", err)
+	}
+	if quarantined {
+		slog.Warn("Refusing to onboard quarantined device", "guid", hex.EncodeToString(guid[:]))
+		return errQuarantined
+	}
+	return nil
+}
+
+// errVoucherExpired rejects TO2 for a device whose voucher is older than the
+// onboarding expiry
+var errVoucherExpired = errors.New("voucher is expired")
+
+// checkExpiry fails with errVoucherExpired if the voucher with the given GUID
+// is older than owner.onboarding_expiry.
+func checkExpiry(ctx context.Context, guid protocol.GUID, expiry time.Duration) error {
+	expired, err := db.IsVoucherExpired(ctx, guid[:], expiry)
+	if err != nil {
+		return fmt.Errorf("checking voucher expiry: %w", err)
+	}
+	if expired {
+		slog.Warn("Refusing to onboard device with expired voucher", "guid", hex.EncodeToString(guid[:]))
+		return errVoucherExpired
+	}
+	return nil
+}
+
+// errAlreadyOnboarded rejects TO2 for a device that already completed it,
+// when owner.reonboard is "refuse"
+var errAlreadyOnboarded = errors.New("device already onboarded")
+
+// checkReonboard applies the owner.reonboard policy to a device starting
+// TO2: when the device already completed TO2, it fails with
+// errAlreadyOnboarded for "refuse". The previous onboarding is forgotten for
+// "reset" once TO2 completes, by db.OnboardingResetter.
+func checkReonboard(ctx context.Context, guid protocol.GUID, policy string) error {
+	if policy != reonboardRefuse {
+		return nil
+	}
+	onboarded, err := db.IsDeviceOnboarded(ctx, guid[:])
+	if err != nil {
+		return fmt.Errorf("checking previous onboarding: %w", err)
+	}
+	if !onboarded {
+		return nil
+	}
+	slog.Warn("Refusing to onboard device again", "guid", hex.EncodeToString(guid[:]))
+	return errAlreadyOnboarded
+}
+
 type moduleStateMachines struct {
-	DB *db.State
+	DB                   *db.State
+	ServiceInfo          *atomic.Pointer[ServiceInfoConfig] // replaced on configuration reload
+	RejectUnknownModules bool
+	NoModules            string
+	RequireDevmod        []string
+	LogPlan              bool
+	CleanupGrace         time.Duration
 	// current module state machine state for all sessions (indexed by token)
+	states *moduleSessions
+}
+
+// moduleSessions holds the module state machine state of the TO2 sessions
+// being served concurrently, and of the sessions ended less than their
+// cleanup grace period ago
+type moduleSessions struct {
+	mu     sync.Mutex
 	states map[string]*moduleStateMachineState
+	reaps  map[string]*time.Timer // pending removals of ended sessions
+}
+
+func newModuleSessions() *moduleSessions {
+	return &moduleSessions{
+		states: make(map[string]*moduleStateMachineState),
+		reaps:  make(map[string]*time.Timer),
+	}
+}
+
+func (m *moduleSessions) get(token string) (*moduleStateMachineState, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.states[token]
+	return state, ok
+}
+
+func (m *moduleSessions) set(token string, state *moduleStateMachineState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.states[token] = state
+}
+
+// reap stops the modules of the session of token at once and removes its
+// state once grace has elapsed, or at once if grace is 0. Only the state
+// left for inspection is kept during the grace period. The state of a
+// session already being reaped is left to its pending removal.
+func (m *moduleSessions) reap(token string, grace time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.reaps[token]; ok {
+		return
+	}
+	state, ok := m.states[token]
+	if !ok {
+		return
+	}
+	state.Stop()
+	if grace <= 0 {
+		delete(m.states, token)
+		return
+	}
+	m.reaps[token] = time.AfterFunc(grace, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		// The removal may have been done by close already
+		if _, ok := m.reaps[token]; ok {
+			delete(m.reaps, token)
+			delete(m.states, token)
+		}
+	})
+}
+
+// close removes at once the state of the sessions in their grace period
+func (m *moduleSessions) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for token, timer := range m.reaps {
+		timer.Stop()
+		delete(m.reaps, token)
+		delete(m.states, token)
+	}
+}
+
+// len returns the number of sessions running owner modules, those in their
+// grace period excluded
+func (m *moduleSessions) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.states) - len(m.reaps)
 }
 
 type moduleStateMachineState struct {
-	Name string
-	Impl serviceinfo.OwnerModule
-	Next func() (string, serviceinfo.OwnerModule, bool)
-	Stop func()
+	GUID    protocol.GUID
+	Name    string
+	Impl    serviceinfo.OwnerModule
+	Next    func() (string, serviceinfo.OwnerModule, bool)
+	Stop    func()
+	Started bool // at least one module was run
 }
 
 func (s moduleStateMachines) Module(ctx context.Context) (string, serviceinfo.OwnerModule, error) {
@@ -411,7 +1122,7 @@ func (s moduleStateMachines) Module(ctx context.Context) (string, serviceinfo.Ow
 	if !ok {
 		return "", nil, fmt.Errorf("invalid context: no token")
 	}
-	module, ok := s.states[token]
+	module, ok := s.states.get(token)
 	if !ok {
 		return "", nil, fmt.Errorf("NextModule not called")
 	}
@@ -423,23 +1134,58 @@ func (s moduleStateMachines) NextModule(ctx context.Context) (bool, error) {
 	if !ok {
 		return false, fmt.Errorf("invalid context: no token")
 	}
-	module, ok := s.states[token]
+	module, ok := s.states.get(token)
 	if !ok {
 		// Create a new module state machine
-		_, modules, _, err := s.DB.Devmod(ctx)
+		devmod, modules, _, err := s.DB.Devmod(ctx)
 		if err != nil {
 			return false, fmt.Errorf("error getting devmod: %w", err)
 		}
-		next, stop := iter.Pull2(ownerModules(ctx, modules, s.DB))
+		guid, err := s.DB.GUID(ctx)
+		if err != nil {
+			return false, fmt.Errorf("error getting device GUID: %w", err)
+		}
+		var serviceInfo *ServiceInfoConfig
+		if s.ServiceInfo != nil {
+			serviceInfo = s.ServiceInfo.Load()
+		}
+		if serviceInfo != nil {
+			serviceInfo = serviceInfo.ForDevice(guid, &devmod)
+		}
+		if err := checkDevmodFields(&devmod, s.RequireDevmod); err != nil {
+			return false, err
+		}
+		if s.RejectUnknownModules {
+			if err := checkDeviceModules(modules, serviceInfo); err != nil {
+				return false, err
+			}
+		}
+		if s.LogPlan {
+			slog.Info("FSIM plan", "guid", hex.EncodeToString(guid[:]), "operations", fsimPlan(modules, serviceInfo))
+		}
+		next, stop := iter.Pull2(ownerModules(ctx, &devmod, modules, s.DB, serviceInfo))
 		module = &moduleStateMachineState{
+			GUID: guid,
 			Next: next,
 			Stop: stop,
 		}
-		s.states[token] = module
+		s.states.set(token, module)
 	}
 
 	var valid bool
 	module.Name, module.Impl, valid = module.Next()
+	if valid {
+		module.Impl = newLoggedOwnerModule(module.Name, module.GUID, module.Impl)
+	}
+	if !valid && !module.Started {
+		switch s.NoModules {
+		case noModulesWarn:
+			slog.Warn("no owner service info modules apply to device")
+		case noModulesFail:
+			return false, fmt.Errorf("no owner service info modules apply to device")
+		}
+	}
+	module.Started = module.Started || valid
 	return valid, nil
 }
 
@@ -448,12 +1194,14 @@ func (s moduleStateMachines) CleanupModules(ctx context.Context) {
 	if !ok {
 		return
 	}
-	module, ok := s.states[token]
+	module, ok := s.states.get(token)
 	if !ok {
 		return
 	}
-	module.Stop()
-	delete(s.states, token)
+	if logged, ok := module.Impl.(*loggedOwnerModule); ok {
+		logged.logResult(errors.New("TO2 ended before the operation completed"))
+	}
+	s.states.reap(token, s.CleanupGrace)
 }
 
 func getPerDeviceUploadDir(ctx context.Context, baseDir string, dbState *db.State) (string, error) {
@@ -468,67 +1216,85 @@ func getPerDeviceUploadDir(ctx context.Context, baseDir string, dbState *db.Stat
 	return deviceUploadDir, nil
 }
 
-func ownerModules(ctx context.Context, modules []string, dbState *db.State) iter.Seq2[string, serviceinfo.OwnerModule] { //nolint:gocyclo
+// ownerModules returns the owner modules for the device: those of the
+// service_info configuration when it has FSIM operations for the device,
+// otherwise those of the command line flags.
+func ownerModules(ctx context.Context, devmod *serviceinfo.Devmod, modules []string, dbState *db.State, serviceInfo *ServiceInfoConfig) iter.Seq2[string, serviceinfo.OwnerModule] {
+	if hasServiceInfoFsims(serviceInfo) {
+		return serviceInfoModules(ctx, devmod, modules, dbState, serviceInfo)
+	}
+	return flagModules(ctx, devmod, modules, dbState, serviceInfo)
+}
+
+// flagModules returns the owner modules configured by command line flags
+func flagModules(ctx context.Context, devmod *serviceinfo.Devmod, modules []string, dbState *db.State, serviceInfo *ServiceInfoConfig) iter.Seq2[string, serviceinfo.OwnerModule] {
 	return func(yield func(string, serviceinfo.OwnerModule) bool) {
-		if slices.Contains(modules, "fdo.download") {
-			for i, cleanPath := range downloadPaths {
-				f, err := os.Open(cleanPath)
-				if err != nil {
-					slog.Error("error opening %q for download FSIM: %v", cleanPath, err)
-					continue
+		sep := deviceFileSep(devmod, serviceInfo)
+
+		for _, module := range flagFSIMModules() {
+			if !slices.Contains(modules, module) {
+				continue
+			}
+			switch module {
+			case "fdo.download":
+				for i, cleanPath := range downloadPaths {
+					f, err := os.Open(cleanPath)
+					if err != nil {
+						slog.Error("error opening %q for download FSIM: %v", cleanPath, err)
+						continue
+					}
+					defer func() { _ = f.Close() }()
+
+					if !yield("fdo.download", &fsim.DownloadContents[*os.File]{
+						Name:         devicePath(sep, downloads[i]), // Use original name for display
+						Contents:     f,
+						MustDownload: true,
+					}) {
+						return
+					}
 				}
-				defer func() { _ = f.Close() }()
 
-				if !yield("fdo.download", &fsim.DownloadContents[*os.File]{
-					Name:         downloads[i], // Use original name for display
-					Contents:     f,
-					MustDownload: true,
-				}) {
+			case "fdo.upload":
+				deviceUploadDir, err := getPerDeviceUploadDir(ctx, uploadDir, dbState)
+				if err != nil {
+					slog.Error("fdo.upload: failed to get per device upload directory", "err", err)
 					return
 				}
-			}
-		}
+				for _, name := range uploads {
+					if !yield("fdo.upload", &fsim.UploadRequest{
+						Dir:  deviceUploadDir,
+						Name: devicePath(sep, name),
+						// Store under the base name regardless of the device's separator
+						Rename: path.Base(name),
+						CreateTemp: func() (*os.File, error) {
+							return os.CreateTemp(deviceUploadDir, ".fdo-upload_*")
+						},
+					}) {
+						return
+					}
+				}
 
-		if slices.Contains(modules, "fdo.upload") {
-			deviceUploadDir, err := getPerDeviceUploadDir(ctx, uploadDir, dbState)
-			if err != nil {
-				slog.Error("fdo.upload: failed to get per device upload directory", "err", err)
-				return
-			}
-			for _, name := range uploads {
-				if !yield("fdo.upload", &fsim.UploadRequest{
-					Dir:  deviceUploadDir,
-					Name: name,
-					CreateTemp: func() (*os.File, error) {
-						return os.CreateTemp(deviceUploadDir, ".fdo-upload_*")
-					},
-				}) {
-					return
+			case "fdo.wget":
+				for _, url := range wgetURLs {
+					if !yield("fdo.wget", &fsim.WgetCommand{
+						Name: path.Base(url.Path),
+						URL:  url,
+					}) {
+						return
+					}
 				}
-			}
-		}
 
-		if slices.Contains(modules, "fdo.wget") {
-			for _, url := range wgetURLs {
-				if !yield("fdo.wget", &fsim.WgetCommand{
-					Name: path.Base(url.Path),
-					URL:  url,
+			case "fdo.command":
+				if !yield("fdo.command", &fsim.RunCommand{
+					Command: "date",
+					Args:    []string{"--utc"},
+					Stdout:  os.Stdout,
+					Stderr:  os.Stderr,
 				}) {
 					return
 				}
 			}
 		}
-
-		if date && slices.Contains(modules, "fdo.command") {
-			if !yield("fdo.command", &fsim.RunCommand{
-				Command: "date",
-				Args:    []string{"--utc"},
-				Stdout:  os.Stdout,
-				Stderr:  os.Stderr,
-			}) {
-				return
-			}
-		}
 	}
 }
 
@@ -548,7 +1314,21 @@ func ownerCmdInit() {
 	ownerCmd.Flags().Bool("reuse-credentials", false, "Perform the Credential Reuse Protocol in TO2")
 	ownerCmd.Flags().String("device-ca-cert", "", "Device CA certificate path")
 	ownerCmd.Flags().String("owner-key", "", "Owner private key path")
+	ownerCmd.Flags().String("to2-key", "", "Private key path for signing TO2.SetupDevice, if different from the owner key")
 	ownerCmd.Flags().Bool("to0-insecure-tls", false, "Use insecure TLS (skip rendezvous certificate verification) for TO0")
+	ownerCmd.Flags().String("external-address", "", "Address devices reach the owner at, registered by TO0 when no owner info is set (host, host:port or :port)")
+	ownerCmd.Flags().Bool("quarantine", false, "Refuse to onboard devices of newly imported vouchers until approved")
+	ownerCmd.Flags().Duration("clock-skew", 0, "Tolerated clock skew when checking the validity of voucher certificates")
+	ownerCmd.Flags().Duration("onboarding-expiry", 0, "Age after which the voucher of a device not yet onboarded is expired (0 disables)")
+	ownerCmd.Flags().Bool("refuse-expired", false, "Refuse to onboard devices whose voucher is expired")
+	ownerCmd.Flags().Bool("archive-deleted-vouchers", false, "Move the vouchers deleted through the API to an archive instead of deleting them")
+	ownerCmd.Flags().String("reonboard", reonboardAllow, "Action when a device that already onboarded starts TO2 again without credential reuse: allow, refuse or reset")
+	ownerCmd.Flags().Int("owner-mtu", 0, "Maximum service info MTU sent to devices, between 256 and 65535 (0 leaves it to the device)")
+	ownerCmd.Flags().Int("max-onboarding-sessions", 0, "Maximum number of TO2 sessions served at once, shared fairly between device classes (0 means unlimited)")
+
+	seedVoucherCmdInit()
+	importVouchersCmdInit()
+	exportVoucherCmdInit()
 }
 
 func init() {
@@ -0,0 +1,257 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// insertSeedVoucher stores a voucher extended to ownerKey and returns its GUID
+func insertSeedVoucher(t *testing.T, ownerKey crypto.Signer) protocol.GUID {
+	t.Helper()
+	ov, err := newSeedVoucher(ownerKey, "test", [][]protocol.RvInstruction{{{Variable: protocol.RVBypass}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ovBytes, err := cbor.Marshal(ov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guid := ov.Header.Val.GUID
+	if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: ovBytes, DeviceInfo: "test", CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	return guid
+}
+
+func TestOwnerServerState_DistinctTO2Key(t *testing.T) {
+	dbState, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to2Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &OwnerServerState{DB: dbState, ownerKey: ownerKey, ownerKeyType: protocol.Secp384r1KeyType, to2Key: to2Key}
+
+	ownerKeyFor := func(ctx context.Context) crypto.Signer {
+		t.Helper()
+		key, _, err := state.OwnerKey(ctx, protocol.Secp384r1KeyType, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	newSession := func(guid protocol.GUID) context.Context {
+		t.Helper()
+		token, err := dbState.NewToken(context.Background(), protocol.TO2Protocol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := dbState.TokenContext(context.Background(), token)
+		if err := dbState.SetGUID(ctx, guid); err != nil {
+			t.Fatal(err)
+		}
+		return ctx
+	}
+
+	// Outside of TO2 the owner key is used
+	if ownerKeyFor(context.Background()) != ownerKey {
+		t.Fatal("expected the owner key outside of a TO2 session")
+	}
+
+	// TO2.ProveOVHdr is signed with the key the voucher is extended to
+	ctx := newSession(insertSeedVoucher(t, ownerKey))
+	if ownerKeyFor(ctx) != ownerKey {
+		t.Fatal("expected the owner key to prove ownership of a voucher extended to it")
+	}
+
+	// Once the key exchange is established, TO2.SetupDevice uses the TO2 key
+	sess := kex.ECDH384Suite.New(nil, kex.A128GcmCipher)
+	if _, err := sess.Parameter(rand.Reader, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbState.SetXSession(ctx, kex.ECDH384Suite, sess); err != nil {
+		t.Fatal(err)
+	}
+	if ownerKeyFor(ctx) != to2Key {
+		t.Fatal("expected the TO2 key after the key exchange")
+	}
+
+	// A device already onboarded with the TO2 key proves ownership with it
+	ctx = newSession(insertSeedVoucher(t, to2Key))
+	if ownerKeyFor(ctx) != to2Key {
+		t.Fatal("expected the TO2 key to prove ownership of a voucher extended to it")
+	}
+
+	// Vouchers extended to either key are accepted
+	for _, key := range []crypto.Signer{ownerKey, to2Key} {
+		ov, err := newSeedVoucher(key, "test", [][]protocol.RvInstruction{{{Variable: protocol.RVBypass}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := handlers.VerifyVoucher(ov, state.ownerPublicKeys(), 0); err != nil {
+			t.Fatalf("voucher extended to %T rejected: %v", key, err)
+		}
+	}
+}
+
+func TestOwnerServerState_PerKeyTypeChains(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, contents []byte) string {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, contents, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	writeKey := func(name string, key crypto.Signer) string {
+		t.Helper()
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return writeFile(name, der)
+	}
+	writeChain := func(name string, cert *x509.Certificate) string {
+		t.Helper()
+		return writeFile(name, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecCert, _ := newTestCA(t, "device CA", nil, nil)
+	rsaCert, _ := newTestCA(t, "RSA owner", nil, nil)
+
+	config := &OwnerServerConfig{
+		FDOServerConfig: FDOServerConfig{DB: DatabaseConfig{Type: "sqlite", DSN: ":memory:"}},
+		DeviceCA:        DeviceCAConfig{CertPath: writeChain("device-ca.pem", ecCert)},
+		Owner: OwnerConfig{
+			OwnerPrivateKey: writeKey("owner-ec.der", ecKey),
+			AdditionalKeys:  []OwnerKeyConfig{{Key: writeKey("owner-rsa.der", rsaKey), Chain: writeChain("owner-rsa.pem", rsaCert)}},
+		},
+	}
+	state, err := getOwnerServerState(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		keyType protocol.KeyType
+		rsaBits int
+		key     crypto.PublicKey
+		chain   *x509.Certificate
+	}{
+		{protocol.Secp384r1KeyType, 0, ecKey.Public(), ecCert},
+		{protocol.Rsa2048RestrKeyType, 2048, rsaKey.Public(), rsaCert},
+		{protocol.RsaPkcsKeyType, 2048, rsaKey.Public(), rsaCert},
+	} {
+		key, chain, err := state.OwnerKey(context.Background(), tt.keyType, tt.rsaBits)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.keyType, err)
+		}
+		if !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(tt.key) {
+			t.Errorf("%s: unexpected key %T", tt.keyType, key)
+		}
+		if len(chain) != 1 || !chain[0].Equal(tt.chain) {
+			t.Errorf("%s: expected the chain of %q, got %v", tt.keyType, tt.chain.Subject.CommonName, chain)
+		}
+	}
+	if keys := state.importPublicKeys(); len(keys) != 2 {
+		t.Errorf("expected vouchers for both owner keys to be accepted, got %d keys", len(keys))
+	}
+
+	// Each key type may only be loaded once
+	config.Owner.AdditionalKeys = append(config.Owner.AdditionalKeys, OwnerKeyConfig{Key: config.Owner.OwnerPrivateKey})
+	if _, err := getOwnerServerState(config); err == nil || !strings.Contains(err.Error(), "additional_keys[1]") {
+		t.Fatalf("expected duplicate key type error, got %v", err)
+	}
+}
+
+func TestOwnerServerState_TO2KeyWithASYMKEX(t *testing.T) {
+	dbState, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to2Key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &OwnerServerState{DB: dbState, ownerKey: ownerKey, ownerKeyType: protocol.Rsa2048RestrKeyType, to2Key: to2Key}
+
+	token, err := dbState.NewToken(context.Background(), protocol.TO2Protocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := dbState.TokenContext(context.Background(), token)
+	if err := dbState.SetGUID(ctx, insertSeedVoucher(t, ownerKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	// TO2.ProveOVHdr: the owner sends its parameter, encrypted to the
+	// voucher's owner key, as does the device in TO2.SetupDevice
+	sess := kex.ASYMKEX2048Suite.New(nil, kex.A128GcmCipher)
+	xA, err := sess.Parameter(rand.Reader, &ownerKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dbState.SetXSession(ctx, kex.ASYMKEX2048Suite, sess); err != nil {
+		t.Fatal(err)
+	}
+	xB, err := kex.ASYMKEX2048Suite.New(xA, kex.A128GcmCipher).Parameter(rand.Reader, &ownerKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TO2.SetupDevice decrypts the device's parameter with the key it is
+	// signed with
+	key, _, err := state.OwnerKey(ctx, protocol.Rsa2048RestrKeyType, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != ownerKey {
+		t.Fatal("expected the voucher's owner key with an asymmetric key exchange")
+	}
+	_, sess, err = dbState.XSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.SetParameter(xB, key.(*rsa.PrivateKey)); err != nil {
+		t.Fatalf("device parameter not decrypted: %v", err)
+	}
+}
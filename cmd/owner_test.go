@@ -0,0 +1,262 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestQuarantinedVoucherRefusedUntilApproved(t *testing.T) {
+	if _, err := db.InitDb("sqlite", ":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	ownerKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ov, err := newSeedVoucher(ownerKey, "test", [][]protocol.RvInstruction{{{Variable: protocol.RVBypass}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ovBytes, err := cbor.Marshal(ov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guid := ov.Header.Val.GUID
+	guidHex := hex.EncodeToString(guid[:])
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /owner/vouchers", handlers.InsertVoucherHandler([]crypto.PublicKey{ownerKey.Public()}, true, 0))
+	mux.HandleFunc("POST /owner/vouchers/{guid}/approve", handlers.ApproveVoucherHandler)
+	post := func(path string, body []byte) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+		return rec.Code
+	}
+
+	if code := post("/owner/vouchers", pem.EncodeToMemory(&pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: ovBytes})); code != http.StatusOK {
+		t.Fatalf("expected status 200 inserting voucher, got %d", code)
+	}
+	if err := checkQuarantine(t.Context(), guid); !errors.Is(err, errQuarantined) {
+		t.Fatalf("expected TO2 to be refused before approval, got %v", err)
+	}
+	devices, _, err := db.ListDevices(t.Context(), nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || !devices[0].Quarantined {
+		t.Fatalf("expected the device to be listed as quarantined, got %+v", devices)
+	}
+
+	if code := post("/owner/vouchers/"+guidHex+"/approve", nil); code != http.StatusNoContent {
+		t.Fatalf("expected status 204 approving voucher, got %d", code)
+	}
+	if err := checkQuarantine(t.Context(), guid); err != nil {
+		t.Fatalf("expected TO2 to proceed after approval, got %v", err)
+	}
+	// Approval is idempotent
+	if code := post("/owner/vouchers/"+guidHex+"/approve", nil); code != http.StatusNoContent {
+		t.Fatalf("expected status 204 approving voucher again, got %d", code)
+	}
+	if code := post("/owner/vouchers/"+hex.EncodeToString(make([]byte, 16))+"/approve", nil); code != http.StatusNotFound {
+		t.Fatalf("expected status 404 approving unknown voucher, got %d", code)
+	}
+}
+
+// recordingMTUSession records the MTU stored for the session
+type recordingMTUSession struct {
+	fdo.TO2SessionState
+	mtu uint16
+}
+
+func (s *recordingMTUSession) SetMTU(_ context.Context, mtu uint16) error {
+	s.mtu = mtu
+	return nil
+}
+
+func TestMTUCappedSession(t *testing.T) {
+	for _, tc := range []struct {
+		requested, want uint16
+	}{
+		{requested: 1300, want: 512},
+		{requested: 65535, want: 512},
+		{requested: 300, want: 300},
+	} {
+		recorder := &recordingMTUSession{}
+		session := mtuCappedSession{TO2SessionState: recorder, max: 512}
+		if err := session.SetMTU(context.Background(), tc.requested); err != nil {
+			t.Fatal(err)
+		}
+		if recorder.mtu != tc.want {
+			t.Errorf("requested MTU %d: expected %d, got %d", tc.requested, tc.want, recorder.mtu)
+		}
+	}
+}
+
+func TestOwnerConfig_MTUBounds(t *testing.T) {
+	for _, tc := range []struct {
+		mtu     int
+		wantErr bool
+	}{
+		{mtu: 0},
+		{mtu: minOwnerMTU},
+		{mtu: maxOwnerMTU},
+		{mtu: minOwnerMTU - 1, wantErr: true},
+		{mtu: maxOwnerMTU + 1, wantErr: true},
+		{mtu: -1, wantErr: true},
+	} {
+		config := OwnerServerConfig{
+			FDOServerConfig: FDOServerConfig{HTTP: HTTPConfig{IP: "127.0.0.1", Port: "8080", ShutdownTimeout: time.Second}},
+			DeviceCA:        DeviceCAConfig{CertPath: "/path/to/ca.crt"},
+			Owner:           OwnerConfig{OwnerPrivateKey: "/path/to/owner.key", MTU: tc.mtu},
+		}
+		err := config.validate()
+		if tc.wantErr && (err == nil || !strings.Contains(err.Error(), "owner.mtu")) {
+			t.Errorf("mtu %d: expected owner.mtu error, got %v", tc.mtu, err)
+		}
+		if !tc.wantErr && err != nil {
+			t.Errorf("mtu %d: unexpected error: %v", tc.mtu, err)
+		}
+	}
+}
+
+func TestCheckReonboard(t *testing.T) {
+	completedAt := time.Now()
+	for _, tc := range []struct {
+		policy         string
+		wantErr        error
+		stillOnboarded bool // whether the device still counts as onboarded afterwards
+	}{
+		{policy: "", stillOnboarded: true},
+		{policy: reonboardAllow, stillOnboarded: true},
+		{policy: reonboardRefuse, wantErr: errAlreadyOnboarded, stillOnboarded: true},
+		// Reset only applies once TO2 completes
+		{policy: reonboardReset, stillOnboarded: true},
+	} {
+		t.Run("policy="+tc.policy, func(t *testing.T) {
+			state, err := db.InitDb("sqlite", ":memory:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The device onboarded once, replacing GUID 1 by GUID 2, and a
+			// device of GUID 3 never onboarded
+			oldGUID, guid, newDevice := protocol.GUID{1}, protocol.GUID{2}, protocol.GUID{3}
+			for _, g := range []protocol.GUID{guid, newDevice} {
+				if err := db.InsertVoucher(db.Voucher{GUID: g[:], DeviceInfo: "test"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := state.DB.Create(&db.DeviceOnboarding{GUID: oldGUID[:], NewGUID: guid[:], TO2Completed: true, TO2CompletedAt: &completedAt}).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			if err := checkReonboard(t.Context(), newDevice, tc.policy); err != nil {
+				t.Fatalf("expected a device never onboarded to proceed, got %v", err)
+			}
+			if err := checkReonboard(t.Context(), guid, tc.policy); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected %v, got %v", tc.wantErr, err)
+			}
+			onboarded, err := db.IsDeviceOnboarded(t.Context(), guid[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if onboarded != tc.stillOnboarded {
+				t.Fatalf("expected onboarded=%v after the check, got %v", tc.stillOnboarded, onboarded)
+			}
+		})
+	}
+}
+
+func TestCheckReonboard_CredentialReuseGUID(t *testing.T) {
+	state, err := db.InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A device that onboarded keeping its GUID
+	guid := protocol.GUID{4}
+	completedAt := time.Now()
+	if err := db.InsertVoucher(db.Voucher{GUID: guid[:], DeviceInfo: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.DB.Model(&db.DeviceOnboarding{}).Where("guid = ?", guid[:]).
+		Updates(db.DeviceOnboarding{NewGUID: guid[:], SerialNumber: "sn", TO2Completed: true, TO2CompletedAt: &completedAt}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkReonboard(t.Context(), guid, reonboardRefuse); !errors.Is(err, errAlreadyOnboarded) {
+		t.Fatalf("expected refusal, got %v", err)
+	}
+}
+
+func TestOnboardingResetter(t *testing.T) {
+	ownerKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, reset := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reset=%v", reset), func(t *testing.T) {
+			state, err := db.InitDb("sqlite", ":memory:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The device onboarded once, replacing GUID 1 by the GUID of
+			// its current voucher
+			completedAt := time.Now()
+			oldGUID := protocol.GUID{1}
+			guid := insertSeedVoucher(t, ownerKey)
+			if err := state.DB.Create(&db.DeviceOnboarding{GUID: oldGUID[:], NewGUID: guid[:], TO2Completed: true, TO2CompletedAt: &completedAt}).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			// It completes TO2 again, receiving another GUID
+			ov, err := newSeedVoucher(ownerKey, "test", [][]protocol.RvInstruction{{{Variable: protocol.RVBypass}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var vouchers fdo.OwnerVoucherPersistentState = state
+			if reset {
+				vouchers = db.OnboardingResetter{State: state}
+			}
+			if err := vouchers.ReplaceVoucher(t.Context(), guid, ov); err != nil {
+				t.Fatal(err)
+			}
+
+			var previous int64
+			if err := state.DB.Model(&db.DeviceOnboarding{}).Where("guid = ?", oldGUID[:]).Count(&previous).Error; err != nil {
+				t.Fatal(err)
+			}
+			if (previous == 0) != reset {
+				t.Fatalf("expected the record of the replaced GUID to be kept=%v, found %d", !reset, previous)
+			}
+			var record db.DeviceOnboarding
+			if err := state.DB.Where("guid = ?", guid[:]).First(&record).Error; err != nil {
+				t.Fatal(err)
+			}
+			newGUID := ov.Header.Val.GUID
+			if !record.TO2Completed || !bytes.Equal(record.NewGUID, newGUID[:]) {
+				t.Fatalf("expected the new onboarding to be recorded, got %+v", record)
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// A private key held by a PKCS#11 token, such as an HSM
+type PKCS11KeyConfig struct {
+	Module     string `mapstructure:"module"`      // path of the PKCS#11 library
+	TokenLabel string `mapstructure:"token_label"` // label of the token holding the key
+	PIN        string `mapstructure:"pin"`         // user PIN of the token
+	PINFile    string `mapstructure:"pin_file"`    // path of a file holding the user PIN
+	KeyLabel   string `mapstructure:"key_label"`   // CKA_LABEL of the private key
+	KeyID      string `mapstructure:"key_id"`      // hex encoded CKA_ID of the private key
+}
+
+// validate checks the settings, name being their configuration section
+func (p *PKCS11KeyConfig) validate(name string) error {
+	if p.TokenLabel == "" {
+		return fmt.Errorf("%s.token_label is required", name)
+	}
+	if p.KeyLabel == "" && p.KeyID == "" {
+		return fmt.Errorf("%[1]s.key_label or %[1]s.key_id is required", name)
+	}
+	if _, err := hex.DecodeString(p.KeyID); err != nil {
+		return fmt.Errorf("%s.key_id %q is not hex encoded: %w", name, p.KeyID, err)
+	}
+	return nil
+}
+
+// pkcs11PINEnv names the environment variable holding the user PIN of the
+// PKCS#11 tokens
+const pkcs11PINEnv = "FDO_PKCS11_PIN"
+
+// userPIN returns the user PIN of the token: the contents of pin_file, trailing
+// newline excluded, if set, otherwise pin, which pkcs11PINEnv overrides
+func (p *PKCS11KeyConfig) userPIN() (string, error) {
+	if p.PINFile == "" {
+		return p.PIN, nil
+	}
+	b, err := os.ReadFile(p.PINFile)
+	if err != nil {
+		return "", fmt.Errorf("reading PIN file: %w", err)
+	}
+	pin := strings.TrimRight(string(b), "\r\n")
+	if pin == "" {
+		return "", fmt.Errorf("PIN file %s is empty", p.PINFile)
+	}
+	return pin, nil
+}
+
+// pkcs11Signer signs with a private key that never leaves its PKCS#11
+// token. The public key is that of the certificate issued for the key, or
+// read from the token.
+type pkcs11Signer struct {
+	ctx    *pkcs11.Ctx
+	key    pkcs11.ObjectHandle
+	public crypto.PublicKey
+
+	// PKCS#11 sessions may not be used concurrently
+	mu      sync.Mutex
+	session pkcs11.SessionHandle
+}
+
+// openPKCS11Signer logs into the configured token and looks up the private
+// key matching public. A nil public key is read from the public key object
+// of the token sharing the label and ID of the private key.
+func openPKCS11Signer(config PKCS11KeyConfig, public crypto.PublicKey) (*pkcs11Signer, error) {
+	pin, err := config.userPIN()
+	if err != nil {
+		return nil, err
+	}
+	ctx := pkcs11.New(config.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("cannot load PKCS#11 module %s", config.Module)
+	}
+	if err := ctx.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		return nil, fmt.Errorf("initializing PKCS#11 module %s: %w", config.Module, err)
+	}
+	signer, err := openPKCS11Key(ctx, config, pin, public)
+	if err != nil {
+		_ = ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+	return signer, nil
+}
+
+func openPKCS11Key(ctx *pkcs11.Ctx, config PKCS11KeyConfig, pin string, public crypto.PublicKey) (*pkcs11Signer, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return nil, fmt.Errorf("listing PKCS#11 slots: %w", err)
+	}
+	slot, found := uint(0), false
+	for _, s := range slots {
+		info, err := ctx.GetTokenInfo(s)
+		if err == nil && info.Label == config.TokenLabel {
+			slot, found = s, true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no PKCS#11 token labelled %q", config.TokenLabel)
+	}
+
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, fmt.Errorf("opening PKCS#11 session: %w", err)
+	}
+	if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+		_ = ctx.CloseSession(session)
+		return nil, fmt.Errorf("logging into PKCS#11 token %q: %w", config.TokenLabel, err)
+	}
+
+	var match []*pkcs11.Attribute
+	if config.KeyLabel != "" {
+		match = append(match, pkcs11.NewAttribute(pkcs11.CKA_LABEL, config.KeyLabel))
+	}
+	if config.KeyID != "" {
+		id, _ := hex.DecodeString(config.KeyID) // checked by validate
+		match = append(match, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	}
+	key, err := findPKCS11Key(ctx, session, pkcs11.CKO_PRIVATE_KEY, match)
+	if err == nil && public == nil {
+		public, err = readPKCS11PublicKey(ctx, session, match)
+	}
+	if err == nil {
+		switch public.(type) {
+		case *ecdsa.PublicKey, *rsa.PublicKey:
+		default:
+			err = fmt.Errorf("unsupported PKCS#11 key type %T: must be ECDSA or RSA", public)
+		}
+	}
+	if err != nil {
+		_ = ctx.CloseSession(session)
+		return nil, fmt.Errorf("PKCS#11 token %q: %w", config.TokenLabel, err)
+	}
+	return &pkcs11Signer{ctx: ctx, key: key, public: public, session: session}, nil
+}
+
+// Close closes the session of the signer, logging out of the token, and
+// finalizes the PKCS#11 module. The signer cannot be used afterwards.
+func (s *pkcs11Signer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx == nil {
+		return nil
+	}
+	err := s.ctx.CloseSession(s.session)
+	if finalErr := s.ctx.Finalize(); err == nil {
+		err = finalErr
+	}
+	s.ctx.Destroy()
+	s.ctx = nil
+	return err
+}
+
+// closeSigner closes key if it holds resources, as a PKCS#11 signer does
+func closeSigner(key crypto.Signer) {
+	closer, ok := key.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		slog.Warn("Closing signing key failed", "err", err)
+	}
+}
+
+// findPKCS11Key returns the only key object of class matching the label and
+// ID attributes
+func findPKCS11Key(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, class uint, match []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	template := append([]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}, match...)
+	keys, err := findPKCS11Objects(ctx, session, template)
+	if err != nil {
+		return 0, err
+	}
+	if len(keys) != 1 {
+		kind := "private"
+		if class == pkcs11.CKO_PUBLIC_KEY {
+			kind = "public"
+		}
+		return 0, fmt.Errorf("expected one %s key matching the configured label and ID, found %d", kind, len(keys))
+	}
+	return keys[0], nil
+}
+
+// Object identifier of EC public keys in a SubjectPublicKeyInfo (RFC 5480)
+var oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+
+// readPKCS11PublicKey reads the EC or RSA public key object matching the
+// label and ID attributes
+func readPKCS11PublicKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, match []*pkcs11.Attribute) (crypto.PublicKey, error) {
+	object, err := findPKCS11Key(ctx, session, pkcs11.CKO_PUBLIC_KEY, match)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := ctx.GetAttributeValue(session, object, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err == nil {
+		return pkcs11ECPublicKey(attrs[0].Value, attrs[1].Value)
+	}
+	attrs, err = ctx.GetAttributeValue(session, object, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading PKCS#11 public key: not an EC or RSA key: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(attrs[1].Value)
+	if !exponent.IsInt64() || exponent.Int64() > math.MaxInt32 {
+		return nil, errors.New("PKCS#11 RSA public exponent is too large")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(attrs[0].Value), E: int(exponent.Int64())}, nil
+}
+
+func findPKCS11Objects(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, template []*pkcs11.Attribute) ([]pkcs11.ObjectHandle, error) {
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return nil, fmt.Errorf("searching PKCS#11 objects: %w", err)
+	}
+	// Two are enough to tell an ambiguous match
+	objects, _, err := ctx.FindObjects(session, 2)
+	if finalErr := ctx.FindObjectsFinal(session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("searching PKCS#11 objects: %w", err)
+	}
+	return objects, nil
+}
+
+// Public implements crypto.Signer
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign implements crypto.Signer, producing ASN.1 ECDSA signatures and
+// PKCS#1 v1.5 or PSS RSA signatures as crypto/ecdsa and crypto/rsa do
+func (s *pkcs11Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mechanism *pkcs11.Mechanism
+	input := digest
+	switch s.public.(type) {
+	case *ecdsa.PublicKey:
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	case *rsa.PublicKey:
+		if opts == nil {
+			return nil, errors.New("PKCS#11 RSA signatures require a hash function")
+		}
+		hash := opts.HashFunc()
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			params, ok := pkcs11PSSParams[hash]
+			if !ok {
+				return nil, fmt.Errorf("unsupported hash %v for PKCS#11 RSA-PSS signatures", hash)
+			}
+			saltLength := pss.SaltLength
+			if saltLength == rsa.PSSSaltLengthAuto || saltLength == rsa.PSSSaltLengthEqualsHash {
+				saltLength = hash.Size()
+			}
+			mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(params.hash, params.mgf, uint(saltLength)))
+			break
+		}
+		prefix, ok := pkcs1DigestInfoPrefixes[hash]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash %v for PKCS#11 RSA signatures", hash)
+		}
+		mechanism, input = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil), append(append([]byte{}, prefix...), digest...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx == nil {
+		return nil, errors.New("PKCS#11 sign: signer is closed")
+	}
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{mechanism}, s.key); err != nil {
+		return nil, fmt.Errorf("PKCS#11 sign: %w", err)
+	}
+	sig, err := s.ctx.Sign(s.session, input)
+	if err != nil {
+		return nil, fmt.Errorf("PKCS#11 sign: %w", err)
+	}
+	if mechanism.Mechanism == pkcs11.CKM_ECDSA {
+		return ecdsaSignatureASN1(sig)
+	}
+	return sig, nil
+}
+
+// ecdsaSignatureASN1 converts the r || s signature of CKM_ECDSA into the
+// ASN.1 encoding expected from a crypto.Signer
+func ecdsaSignatureASN1(raw []byte) ([]byte, error) {
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		return nil, fmt.Errorf("invalid PKCS#11 ECDSA signature of %d bytes", len(raw))
+	}
+	half := len(raw) / 2
+	return asn1.Marshal(struct{ R, S *big.Int }{
+		R: new(big.Int).SetBytes(raw[:half]),
+		S: new(big.Int).SetBytes(raw[half:]),
+	})
+}
+
+// DER encoded DigestInfo headers preceding the digest in PKCS#1 v1.5
+// signatures (RFC 8017, section 9.2)
+var pkcs1DigestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// pkcs11ECPublicKey decodes the CKA_EC_PARAMS and CKA_EC_POINT attributes
+// of an EC public key
+func pkcs11ECPublicKey(params, point []byte) (crypto.PublicKey, error) {
+	// CKA_EC_POINT is a DER encoded OCTET STRING, which some tokens omit:
+	// try the unwrapped point first, then the attribute as is
+	points := [][]byte{point}
+	var unwrapped []byte
+	if rest, err := asn1.Unmarshal(point, &unwrapped); err == nil && len(rest) == 0 {
+		points = [][]byte{unwrapped, point}
+	}
+	var err error
+	for _, point := range points {
+		// CKA_EC_PARAMS holds the DER encoded curve, as in a certificate
+		var spki []byte
+		spki, err = asn1.Marshal(struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}{
+			Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA, Parameters: asn1.RawValue{FullBytes: params}},
+			PublicKey: asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("encoding PKCS#11 EC public key: %w", err)
+		}
+		var public crypto.PublicKey
+		if public, err = x509.ParsePKIXPublicKey(spki); err == nil {
+			return public, nil
+		}
+	}
+	return nil, fmt.Errorf("parsing PKCS#11 EC public key: %w", err)
+}
+
+// Hash and mask generation mechanisms of CKM_RSA_PKCS_PSS for each hash
+var pkcs11PSSParams = map[crypto.Hash]struct{ hash, mgf uint }{
+	crypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+	crypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+	crypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+}
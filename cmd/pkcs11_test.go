@@ -0,0 +1,402 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/custom"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestDeviceCAConfig_PKCS11Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  DeviceCAConfig
+		wantErr string
+	}{
+		{name: "file key", config: DeviceCAConfig{KeyPath: "/path/ca.key"}},
+		{name: "key label", config: DeviceCAConfig{PKCS11: PKCS11KeyConfig{Module: "/lib/p11.so", TokenLabel: "fdo", KeyLabel: "ca"}}},
+		{name: "key id", config: DeviceCAConfig{PKCS11: PKCS11KeyConfig{Module: "/lib/p11.so", TokenLabel: "fdo", KeyID: "0a1b"}}},
+		{
+			name:    "with key file",
+			config:  DeviceCAConfig{KeyPath: "/path/ca.key", PKCS11: PKCS11KeyConfig{Module: "/lib/p11.so", TokenLabel: "fdo", KeyLabel: "ca"}},
+			wantErr: "device_ca.key cannot be used with device_ca.pkcs11",
+		},
+		{
+			name:    "no token",
+			config:  DeviceCAConfig{PKCS11: PKCS11KeyConfig{Module: "/lib/p11.so", KeyLabel: "ca"}},
+			wantErr: "token_label is required",
+		},
+		{
+			name:    "no key",
+			config:  DeviceCAConfig{PKCS11: PKCS11KeyConfig{Module: "/lib/p11.so", TokenLabel: "fdo"}},
+			wantErr: "key_label or device_ca.pkcs11.key_id is required",
+		},
+		{
+			name:    "invalid key id",
+			config:  DeviceCAConfig{PKCS11: PKCS11KeyConfig{Module: "/lib/p11.so", TokenLabel: "fdo", KeyID: "xyz"}},
+			wantErr: "is not hex encoded",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPKCS11UserPIN(t *testing.T) {
+	pinFile := filepath.Join(t.TempDir(), "pin")
+	if err := os.WriteFile(pinFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(empty, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if pin, err := (&PKCS11KeyConfig{PIN: "from-config"}).userPIN(); err != nil || pin != "from-config" {
+		t.Fatalf("expected the configured PIN, got %q, %v", pin, err)
+	}
+	if pin, err := (&PKCS11KeyConfig{PIN: "from-config", PINFile: pinFile}).userPIN(); err != nil || pin != "from-file" {
+		t.Fatalf("expected the PIN of the file, got %q, %v", pin, err)
+	}
+	if _, err := (&PKCS11KeyConfig{PINFile: empty}).userPIN(); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Fatalf("expected an empty PIN file error, got %v", err)
+	}
+
+	// The environment overrides the configured PIN of both sections
+	resetState(t)
+	stubRunE(t, ownerCmd)
+	t.Setenv(pkcs11PINEnv, "from-env")
+	cfg := "owner:\n  pkcs11:\n    pin: from-config\ndevice_ca:\n  pkcs11:\n    pin: from-config\n"
+	rootCmd.SetArgs([]string{"owner", "127.0.0.1:8043", "--config", writeYAMLConfig(t, cfg)})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if capturedConfig.Owner.PKCS11.PIN != "from-env" || capturedConfig.DeviceCA.PKCS11.PIN != "from-env" {
+		t.Fatalf("expected the PIN of the environment, got %q and %q", capturedConfig.Owner.PKCS11.PIN, capturedConfig.DeviceCA.PKCS11.PIN)
+	}
+}
+
+func TestECDSASignatureASN1(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := make([]byte, 48)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CKM_ECDSA returns r || s, each padded to the size of the curve order
+	raw := make([]byte, 96)
+	r.FillBytes(raw[:48])
+	s.FillBytes(raw[48:])
+
+	sig, err := ecdsaSignatureASN1(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest, sig) {
+		t.Fatal("converted signature does not verify")
+	}
+	if _, err := ecdsaSignatureASN1(raw[:95]); err == nil {
+		t.Fatal("expected an odd length signature to be rejected")
+	}
+}
+
+func TestPKCS11ECPublicKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CKA_EC_PARAMS is the named curve OID, CKA_EC_POINT the DER wrapped
+	// uncompressed point
+	params, err := asn1.Marshal(asn1.ObjectIdentifier{1, 3, 132, 0, 34})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdhKey, err := key.PublicKey.ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := ecdhKey.Bytes()
+	wrapped, err := asn1.Marshal(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, point := range map[string][]byte{"wrapped": wrapped, "raw": raw} {
+		public, err := pkcs11ECPublicKey(params, point)
+		if err != nil {
+			t.Fatalf("%s point: %v", name, err)
+		}
+		if !key.PublicKey.Equal(public) {
+			t.Fatalf("%s point: decoded public key does not match", name)
+		}
+	}
+}
+
+func TestPKCS1DigestInfoPrefixes(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hash, prefix := range pkcs1DigestInfoPrefixes {
+		h := hash.New()
+		h.Write([]byte("message"))
+		digest := h.Sum(nil)
+		// CKM_RSA_PKCS pads the DigestInfo given to it, as a zero hash does
+		sig, err := rsa.SignPKCS1v15(nil, key, 0, append(append([]byte{}, prefix...), digest...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, hash, digest, sig); err != nil {
+			t.Errorf("%v: DigestInfo prefix does not verify: %v", hash, err)
+		}
+	}
+}
+
+// newSoftHSMToken initializes a SoftHSM v2 token labelled fdo-test with the
+// user PIN 1234 and returns the PKCS#11 module, skipping the test if SoftHSM
+// is not installed. SOFTHSM2_MODULE overrides the module path.
+func newSoftHSMToken(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("softhsm2-util"); err != nil {
+		t.Skip("softhsm2-util not installed")
+	}
+	candidates := []string{
+		os.Getenv("SOFTHSM2_MODULE"),
+		"/usr/lib64/pkcs11/libsofthsm2.so",
+		"/usr/lib/softhsm/libsofthsm2.so",
+		"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+		"/usr/lib/aarch64-linux-gnu/softhsm/libsofthsm2.so",
+	}
+	var module string
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		if _, err := os.Stat(candidate); err == nil {
+			module = candidate
+			break
+		}
+	}
+	if module == "" {
+		t.Skip("SoftHSM PKCS#11 module not found, set SOFTHSM2_MODULE")
+	}
+
+	dir := t.TempDir()
+	tokens := filepath.Join(dir, "tokens")
+	if err := os.Mkdir(tokens, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	conf := filepath.Join(dir, "softhsm2.conf")
+	if err := os.WriteFile(conf, []byte("directories.tokendir = "+tokens+"\nobjectstore.backend = file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOFTHSM2_CONF", conf)
+	softHSMUtil(t, "--init-token", "--free", "--label", "fdo-test", "--pin", "1234", "--so-pin", "123456")
+	return module
+}
+
+// importSoftHSMKey imports the key pair into the fdo-test token
+func importSoftHSMKey(t *testing.T, key crypto.Signer, label, id string) {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	softHSMUtil(t, "--import", keyPath, "--token", "fdo-test", "--label", label, "--id", id, "--pin", "1234")
+}
+
+func softHSMUtil(t *testing.T, args ...string) {
+	t.Helper()
+	if out, err := exec.Command("softhsm2-util", args...).CombinedOutput(); err != nil {
+		t.Fatalf("softhsm2-util %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+func TestLoadDeviceCA_PKCS11SignsDeviceCertificate(t *testing.T) {
+	module := newSoftHSMToken(t)
+
+	// Only the certificate of the device CA is kept out of the token
+	caCert, caKey := newTestCA(t, "device CA", nil, nil)
+	importSoftHSMKey(t, caKey, "device-ca", "01")
+	certPath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DeviceCAConfig{
+		CertPath: certPath,
+		PKCS11:   PKCS11KeyConfig{Module: module, TokenLabel: "fdo-test", PIN: "1234", KeyLabel: "device-ca", KeyID: "01"},
+	}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	key, chain, err := loadDeviceCA(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sign the certificate of a device through the token
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "device"}}, deviceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := custom.SignDeviceCertificate(key, chain)(&custom.DeviceMfgInfo{CertInfo: cbor.X509CertificateRequest(*csr)})
+	if err != nil {
+		t.Fatalf("signing device certificate through PKCS#11: %v", err)
+	}
+	if err := certs[0].CheckSignatureFrom(caCert); err != nil {
+		t.Fatalf("device certificate does not verify with the device CA: %v", err)
+	}
+	if !certs[0].PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(deviceKey.Public()) {
+		t.Fatal("device certificate does not hold the device key")
+	}
+
+	// A certificate of another key is refused
+	otherCert, _ := newTestCA(t, "other CA", nil, nil)
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadDeviceCA(config); err == nil || !strings.Contains(err.Error(), "does not match certificate") {
+		t.Fatalf("expected mismatching certificate to be refused, got %v", err)
+	}
+}
+
+func TestOwnerConfig_PKCS11KeyValidation(t *testing.T) {
+	token := PKCS11KeyConfig{Module: "/lib/p11.so", TokenLabel: "fdo", KeyLabel: "owner"}
+	tests := []struct {
+		name    string
+		config  OwnerConfig
+		wantErr string
+	}{
+		{name: "key file", config: OwnerConfig{OwnerPrivateKey: "/path/owner.key"}},
+		{name: "PKCS#11 key", config: OwnerConfig{PKCS11: token}},
+		{name: "none", wantErr: "an owner private key file is required"},
+		{name: "both", config: OwnerConfig{OwnerPrivateKey: "/path/owner.key", PKCS11: token}, wantErr: "owner.key cannot be used with owner.pkcs11"},
+		{name: "no key label", config: OwnerConfig{PKCS11: PKCS11KeyConfig{Module: "/lib/p11.so", TokenLabel: "fdo"}}, wantErr: "owner.pkcs11.key_label or owner.pkcs11.key_id is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validateKey()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestOwnerServerState_PKCS11OwnerKey(t *testing.T) {
+	module := newSoftHSMToken(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		t.Fatal(err)
+	}
+	importSoftHSMKey(t, ecKey, "owner-ec", "02")
+	importSoftHSMKey(t, rsaKey, "owner-rsa", "03")
+	pinFile := filepath.Join(t.TempDir(), "pin")
+	if err := os.WriteFile(pinFile, []byte("1234\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := newTestCA(t, "device CA", nil, nil)
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		label   string
+		key     crypto.Signer
+		keyType protocol.KeyType
+		opts    crypto.SignerOpts
+	}{
+		{"owner-ec", ecKey, protocol.Secp384r1KeyType, nil},
+		{"owner-rsa", rsaKey, protocol.RsaPkcsKeyType, crypto.SHA384},
+		{"owner-rsa", rsaKey, protocol.RsaPssKeyType, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA384}},
+	} {
+		t.Run(tt.keyType.String(), func(t *testing.T) {
+			config := &OwnerServerConfig{
+				FDOServerConfig: FDOServerConfig{DB: DatabaseConfig{Type: "sqlite", DSN: ":memory:"}},
+				DeviceCA:        DeviceCAConfig{CertPath: caPath},
+				Owner:           OwnerConfig{PKCS11: PKCS11KeyConfig{Module: module, TokenLabel: "fdo-test", PINFile: pinFile, KeyLabel: tt.label}},
+			}
+			state, err := getOwnerServerState(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer state.close()
+			key, _, err := state.OwnerKey(context.Background(), tt.keyType, 3072)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := key.(*pkcs11Signer); !ok {
+				t.Fatalf("expected the owner key to be held by the token, got %T", key)
+			}
+			if !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(tt.key.Public()) {
+				t.Fatal("public key read from the token does not match the imported key")
+			}
+
+			// TO2.ProveOVHdr and TO2.SetupDevice are COSE Sign1 messages
+			// signed with the owner key
+			payload := []byte("TO2 payload")
+			var msg cose.Sign1[[]byte, []byte]
+			if err := msg.Sign(key, &payload, nil, tt.opts); err != nil {
+				t.Fatalf("signing through PKCS#11: %v", err)
+			}
+			if ok, err := msg.Verify(tt.key.Public(), &payload, nil); err != nil || !ok {
+				t.Fatalf("signature does not verify with the owner public key: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+// postgresDSNEnv names the environment variable holding the DSN of a running
+// PostgreSQL server for the integration tests, which are skipped without it
+const postgresDSNEnv = "FDO_TEST_POSTGRES_DSN"
+
+func TestPostgres_SeedAndFetchVoucher(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", postgresDSNEnv)
+	}
+	resetState(t)
+
+	dir := t.TempDir()
+	ownerKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "owner.key")
+	if err := os.WriteFile(keyPath, der, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := writeTOMLConfig(t, fmt.Sprintf(`
+[db]
+type = "postgres"
+dsn = %q
+
+[owner]
+key = %q
+`, dsn, keyPath))
+
+	// The command opens the store from the typed database configuration
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	t.Cleanup(func() { rootCmd.SetOut(nil) })
+	rootCmd.SetArgs([]string{"owner", "seed-voucher", "--config", path})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("seed-voucher failed: %v", err)
+	}
+	guid, err := hex.DecodeString(strings.TrimSpace(out.String()))
+	if err != nil {
+		t.Fatalf("unexpected seed-voucher output %q: %v", out.String(), err)
+	}
+
+	dbState, err := (&DatabaseConfig{Type: "postgres", DSN: dsn}).getState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := dbState.DB.Name(); name != "postgres" {
+		t.Fatalf("expected a postgres backed store, got %q", name)
+	}
+	t.Cleanup(func() {
+		dbState.DB.Where("guid = ?", guid).Delete(&db.DeviceOnboarding{})
+		dbState.DB.Where("guid = ?", guid).Delete(&db.Voucher{})
+	})
+
+	voucher, err := db.FetchVoucher(context.Background(), map[string]interface{}{"guid": guid})
+	if err != nil {
+		t.Fatalf("failed to fetch the seeded voucher: %v", err)
+	}
+	if len(voucher.CBOR) == 0 {
+		t.Fatal("fetched voucher has no CBOR")
+	}
+}
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServer_WritesReadyFileWhenListening(t *testing.T) {
+	readyFile := filepath.Join(t.TempDir(), "ready")
+	server := NewRendezvousServer(HTTPConfig{IP: "127.0.0.1", Port: "0", ReadyFile: readyFile}, http.NotFoundHandler())
+
+	errc := make(chan error, 1)
+	go func() { errc <- server.Start() }()
+
+	var addr string
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b, err := os.ReadFile(readyFile)
+		if err == nil {
+			addr = strings.TrimSpace(string(b))
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("ready file was not written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The file holds the bound address, which accepts connections
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("server not reachable at ready file address %q: %v", addr, err)
+	}
+	_ = resp.Body.Close()
+
+	// Shutting down removes the file
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("server returned error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+	if _, err := os.Stat(readyFile); !os.IsNotExist(err) {
+		t.Fatalf("expected ready file to be removed on shutdown, got %v", err)
+	}
+}
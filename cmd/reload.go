@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+
+	"github.com/spf13/viper"
+)
+
+// reloadConfig re-reads the configuration files, merged in the order they
+// were given, and applies the settings that can be changed while the server
+// is running: the log level, plus whatever the server specific apply
+// function handles. Other settings keep their startup values.
+func reloadConfig(apply func() error) error {
+	files := reloadableConfigFiles()
+	if len(files) == 0 {
+		return errors.New("no configuration file to reload")
+	}
+	if slices.Contains(files, "-") {
+		return errors.New("configuration read from stdin cannot be reloaded")
+	}
+	for i, file := range files {
+		viper.SetConfigFile(file)
+		read := viper.MergeInConfig
+		if i == 0 {
+			// replace the previous configuration so removed keys are unset
+			read = viper.ReadInConfig
+		}
+		if err := read(); err != nil {
+			return fmt.Errorf("configuration file %s read failed: %w", file, err)
+		}
+	}
+	if apply != nil {
+		if err := apply(); err != nil {
+			return err
+		}
+	}
+	setLogLevel(viper.GetString("log.level"))
+	return nil
+}
+
+// reloadableConfigFiles returns the configuration files given by --config,
+// or else the one found in the search paths
+func reloadableConfigFiles() []string {
+	if len(configFiles) > 0 {
+		return configFiles
+	}
+	if file := viper.ConfigFileUsed(); file != "" {
+		return []string{file}
+	}
+	return nil
+}
+
+// handleReloadSignal reloads the configuration each time the process
+// receives SIGHUP. The returned function stops handling the signal.
+func handleReloadSignal(apply func() error) (stop func()) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sighup:
+				slog.Info("Reloading configuration", "paths", reloadableConfigFiles())
+				if err := reloadConfig(apply); err != nil {
+					slog.Error("Configuration reload failed", "err", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sighup)
+		close(done)
+	}
+}
+
+// checkListenAddress returns a reload function for servers without
+// reloadable settings of their own, warning if the listen address changed.
+func checkListenAddress(current HTTPConfig) func() error {
+	return func() error {
+		var reloaded FDOServerConfig
+		if err := viper.Unmarshal(&reloaded); err != nil {
+			return fmt.Errorf("failed to unmarshal config: %w", err)
+		}
+		warnIfListenAddressChanged(current, reloaded.HTTP)
+		return nil
+	}
+}
+
+// warnIfListenAddressChanged logs a warning when a reloaded configuration
+// changes the listen address, which only takes effect on restart.
+func warnIfListenAddressChanged(current, reloaded HTTPConfig) {
+	if reloaded.ListenAddress() != current.ListenAddress() {
+		slog.Warn("Ignoring changed listen address until restart",
+			"current", current.ListenAddress(), "configured", reloaded.ListenAddress())
+	}
+}
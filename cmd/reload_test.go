@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestReload_SIGHUPChangesLogLevel(t *testing.T) {
+	resetState(t)
+	orig := logLevel.Level()
+	t.Cleanup(func() { logLevel.Set(orig) })
+
+	path := writeYAMLConfig(t, "log:\n  level: \"info\"\n")
+	viper.SetConfigFile(path)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	setLogLevel(viper.GetString("log.level"))
+
+	stop := handleReloadSignal(nil)
+	defer stop()
+
+	if err := os.WriteFile(path, []byte("log:\n  level: \"debug\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for logLevel.Level() != slog.LevelDebug {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected log level debug after SIGHUP, got %v", logLevel.Level())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReload_OwnerServiceInfo(t *testing.T) {
+	resetState(t)
+
+	cfg := `
+http:
+  ip: "127.0.0.1"
+  port: "8043"
+service_info:
+  fsims:
+    - fsim: "fdo.wget"
+      wget:
+        files:
+          - url: "https://example.com/v1.img"
+`
+	path := writeYAMLConfig(t, cfg)
+	viper.SetConfigFile(path)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	var current OwnerServerConfig
+	if err := viper.Unmarshal(&current); err != nil {
+		t.Fatal(err)
+	}
+	serviceInfo := new(atomic.Pointer[ServiceInfoConfig])
+	serviceInfo.Store(&current.ServiceInfo)
+	apply := func() error { return reloadOwnerConfig(&current, serviceInfo) }
+
+	// An invalid configuration is rejected and the current one kept
+	if err := os.WriteFile(path, []byte("service_info:\n  fsims:\n    - fsim: \"bogus\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfig(apply); err == nil {
+		t.Fatal("expected reload of invalid configuration to fail")
+	}
+	if got := serviceInfo.Load().WgetURLs(); len(got) != 1 || got[0] != "https://example.com/v1.img" {
+		t.Fatalf("expected original service_info to be kept, got %v", got)
+	}
+
+	// A valid configuration replaces service_info
+	if err := os.WriteFile(path, []byte(`
+service_info:
+  fsims:
+    - fsim: "fdo.wget"
+      wget:
+        files:
+          - url: "https://example.com/v2.img"
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfig(apply); err != nil {
+		t.Fatal(err)
+	}
+	if got := serviceInfo.Load().WgetURLs(); len(got) != 1 || got[0] != "https://example.com/v2.img" {
+		t.Fatalf("expected reloaded service_info, got %v", got)
+	}
+}
+
+func TestReload_MergesConfigFiles(t *testing.T) {
+	resetState(t)
+	orig := logLevel.Level()
+	t.Cleanup(func() { logLevel.Set(orig) })
+
+	base := writeYAMLConfig(t, "log:\n  level: \"info\"\ndb:\n  type: \"sqlite\"\n")
+	overlay := writeYAMLConfig(t, "log:\n  level: \"warn\"\n")
+	configFiles = []string{base, overlay}
+
+	if err := os.WriteFile(overlay, []byte("log:\n  level: \"debug\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfig(nil); err != nil {
+		t.Fatal(err)
+	}
+	if logLevel.Level() != slog.LevelDebug {
+		t.Fatalf("expected log level debug from the overlay, got %v", logLevel.Level())
+	}
+	if got := viper.GetString("db.type"); got != "sqlite" {
+		t.Fatalf("expected db.type from the base file, got %q", got)
+	}
+
+	configFiles = []string{base, "-"}
+	if err := reloadConfig(nil); err == nil {
+		t.Fatal("expected reload of a configuration read from stdin to fail")
+	}
+}
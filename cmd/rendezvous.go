@@ -5,10 +5,8 @@ package cmd
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"log/slog"
-	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -38,6 +36,9 @@ func (rv *RendezvousServerConfig) validate() error {
 	if err := rv.HTTP.validate(); err != nil {
 		return err
 	}
+	if err := rv.API.validate(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -45,6 +46,9 @@ func (rv *RendezvousServerConfig) validate() error {
 var rendezvousCmd = &cobra.Command{
 	Use:   "rendezvous http_address",
 	Short: "Serve an instance of the rendezvous server",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return setHTTPAddress(args)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var rvConfig RendezvousServerConfig
 		if err := viper.Unmarshal(&rvConfig); err != nil {
@@ -84,7 +88,7 @@ func (s *RendezvousServer) Start() error {
 		<-stop
 		slog.Debug("Shutting down server...")
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
 		defer cancel()
 
 		if err := srv.Shutdown(ctx); err != nil {
@@ -93,25 +97,28 @@ func (s *RendezvousServer) Start() error {
 	}()
 
 	// Listen and serve
-	lis, err := net.Listen("tcp", s.config.ListenAddress())
+	lis, err := listen(s.config)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = lis.Close() }()
 	slog.Info("Listening", "local", lis.Addr().String())
 
-	if s.config.UseTLS() {
-		preferredCipherSuites := []uint16{
-			tls.TLS_AES_256_GCM_SHA384,                  // TLS v1.3
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,   // TLS v1.2
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, // TLS v1.2
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, // TLS v1.2
+	if s.config.ReadyFile != "" {
+		removeReadyFile, err := writeReadyFile(s.config.ReadyFile, lis.Addr().String())
+		if err != nil {
+			return err
 		}
-		srv.TLSConfig = &tls.Config{
-			MinVersion:   tls.VersionTLS12,
-			CipherSuites: preferredCipherSuites,
+		defer removeReadyFile()
+	}
+
+	if s.config.UseTLS() {
+		tlsConfig, err := s.config.serverTLSConfig()
+		if err != nil {
+			return err
 		}
-		err := srv.ServeTLS(lis, s.config.CertPath, s.config.KeyPath)
+		srv.TLSConfig = tlsConfig
+		err = srv.ServeTLS(lis, s.config.CertPath, s.config.KeyPath)
 		if err != nil && err != http.ErrServerClosed {
 			return err
 		}
@@ -140,20 +147,31 @@ func serveRendezvous(config *RendezvousServerConfig) error {
 	// Create FDO responder
 	handler := &transport.Handler{
 		Tokens: state.DB,
-		TO0Responder: &fdo.TO0Server{
+		TO0Responder: errorLoggingResponder{&fdo.TO0Server{
 			Session: state.DB,
 			RVBlobs: state.DB,
-		},
-		TO1Responder: &fdo.TO1Server{
+		}},
+		TO1Responder: errorLoggingResponder{&fdo.TO1Server{
 			Session: state.DB,
 			RVBlobs: state.DB,
-		}}
+		}}}
 
-	httpHandler := api.NewHTTPHandler(handler, state.DB.DB).RegisterRoutes(nil)
+	trustedProxies, err := config.HTTP.trustedProxies()
+	if err != nil {
+		return err
+	}
+	httpHandler := api.NewHTTPHandler(handler, state.DB.DB).WithTrustedProxies(trustedProxies).
+		WithRootRedirect(config.HTTP.RootRedirect).WithMessageTimeout(config.HTTP.MessageTimeout).
+		WithMetrics(config.HTTP.Metrics).
+		WithCORS(config.HTTP.CORS.AllowedOrigins, config.HTTP.CORS.AllowedMethods, config.HTTP.CORS.AllowedHeaders).
+		RegisterRoutes(nil)
 
 	// Listen and serve
 	server := NewRendezvousServer(config.HTTP, httpHandler)
 
+	stopReload := handleReloadSignal(checkListenAddress(config.HTTP))
+	defer stopReload()
+
 	slog.Debug("Starting server on:", "addr", config.HTTP.ListenAddress())
 	return server.Start()
 }
@@ -8,12 +8,18 @@ import (
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
 	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fido-device-onboard/go-fdo/protocol"
 	"github.com/spf13/cobra"
@@ -23,8 +29,11 @@ import (
 
 var (
 	logLevel          slog.LevelVar
+	configFiles       []string    // given by --config, in merge order
 	configSearchPaths = []string{ // searched starting with index 0
+		"./",
 		"$HOME/.config/go-fdo-server/",
+		"$HOME/.go-fdo-server/",
 		"/etc/go-fdo-server/",
 		"/usr/share/go-fdo-server/",
 	}
@@ -47,60 +56,157 @@ var rootCmd = &cobra.Command{
 		if strings.ToLower(level) == "debug" {
 			logLevel.Set(slog.LevelDebug)
 		}
+		if cmd.Flags().Changed("log-format") {
+			format, _ := cmd.Flags().GetString("log-format")
+			_ = setLogFormat(format) // checked once the configuration is loaded
+		}
 
-		configFilePath, err := cmd.Flags().GetString("config")
+		configFilePaths, err := cmd.Flags().GetStringArray("config")
 		if err != nil {
 			return fmt.Errorf("failed to get config flag: %w", err)
 		}
-		if configFilePath != "" {
-			slog.Debug("Loading server configuration file", "path", configFilePath)
-			viper.SetConfigFile(configFilePath)
-			err = viper.ReadInConfig()
-			if err != nil {
-				return fmt.Errorf("configuration file read failed: %w", err)
+		configType, err := cmd.Flags().GetString("config-type")
+		if err != nil {
+			return fmt.Errorf("failed to get config-type flag: %w", err)
+		}
+		switch configType {
+		case "":
+		case "yaml", "json", "toml":
+			viper.SetConfigType(configType)
+		default:
+			return fmt.Errorf("unsupported config type %q (must be 'yaml', 'json' or 'toml')", configType)
+		}
+		configFiles = configFilePaths
+		if len(configFilePaths) > 0 {
+			// later files override the keys set by earlier ones
+			for _, configFilePath := range configFilePaths {
+				if err := mergeConfigFile(cmd, configFilePath, configType); err != nil {
+					return err
+				}
 			}
 		} else {
-			filename := cmd.Name() // base filename, no suffix e.g. "manufacturing"
-			viper.SetConfigName(filename)
-			for _, path := range configSearchPaths {
-				viper.AddConfigPath(path)
-			}
-			err = viper.ReadInConfig()
+			searchPaths, err := cmd.Flags().GetStringArray("config-dir")
 			if err != nil {
-				if errors.As(err, &viper.ConfigFileNotFoundError{}) {
-					// Config file not found is acceptable - try command-line flags
-					slog.Info("configuration file not found")
-				} else {
-					return fmt.Errorf("configuration file read failed: %w", err)
-				}
+				return fmt.Errorf("failed to get config-dir flag: %w", err)
+			}
+			if len(searchPaths) == 0 {
+				searchPaths = configSearchPaths
+			}
+			if err := discoverConfigFile(cmd.Name(), searchPaths); err != nil {
+				return err
 			}
 		}
 
-		switch strings.ToLower(viper.GetString("log.level")) {
-		case "debug":
-			logLevel.Set(slog.LevelDebug)
-		case "info":
-			logLevel.Set(slog.LevelInfo)
-		case "warn":
-			logLevel.Set(slog.LevelWarn)
-		case "error":
-			logLevel.Set(slog.LevelError)
+		setLogLevel(viper.GetString("log.level"))
+		if err := setLogFormat(viper.GetString("log.format")); err != nil {
+			return err
 		}
 
-		// Parse HTTP address from positional argument if provided
-		if len(args) > 0 {
-			ip, port, err := parseHTTPAddress(args[0])
-			if err != nil {
-				return fmt.Errorf("invalid http_address: %w", err)
-			}
-			viper.Set("http.ip", ip)
-			viper.Set("http.port", port)
+		if err := resolveDBPassword(cmd); err != nil {
+			return err
+		}
+		if err := validateDBPassword(viper.GetString("db.password"), viper.IsSet("db.password"), viper.GetString("db.password_policy")); err != nil {
+			return err
 		}
 
 		return nil
 	},
 }
 
+// mergeConfigFile merges the configuration file at path, or stdin for "-",
+// into the configuration read so far
+func mergeConfigFile(cmd *cobra.Command, path, configType string) error {
+	if path == "-" {
+		if configType == "" {
+			return errors.New("--config-type is required when reading the configuration from stdin")
+		}
+		slog.Debug("Loading server configuration from stdin", "type", configType)
+		if err := viper.MergeConfig(cmd.InOrStdin()); err != nil {
+			return fmt.Errorf("configuration read from stdin failed: %w", err)
+		}
+		return nil
+	}
+	slog.Debug("Loading server configuration file", "path", path)
+	viper.SetConfigFile(path)
+	if err := viper.MergeInConfig(); err != nil {
+		return fmt.Errorf("configuration file %s read failed: %w", path, err)
+	}
+	return nil
+}
+
+// discoverConfigFile reads the first configuration file found in dirs named
+// after the command (e.g. "owner.yaml") or, if there is none, the first one
+// named "config" (e.g. "config.yaml"). The configuration otherwise comes from
+// flags and environment only: finding no file is not an error. Unreadable
+// files are skipped, the search going on with the next candidate of the same
+// name, but a file read and found invalid is an error.
+func discoverConfigFile(command string, dirs []string) error {
+	for _, name := range []string{command, "config"} {
+		for _, dir := range dirs {
+			for _, ext := range viper.SupportedExts {
+				path := filepath.Join(os.ExpandEnv(dir), name+"."+ext)
+				if err := checkConfigFileReadable(path); errors.Is(err, fs.ErrNotExist) {
+					continue
+				} else if err != nil {
+					slog.Warn("Skipping unreadable configuration file", "path", path, "err", err)
+					continue
+				}
+				viper.SetConfigFile(path)
+				if err := viper.ReadInConfig(); err != nil {
+					return fmt.Errorf("configuration file %s read failed: %w", path, err)
+				}
+				slog.Debug("Loaded server configuration file", "path", path)
+				return nil
+			}
+		}
+	}
+	// Config file not found is acceptable - try command-line flags
+	slog.Info("configuration file not found", "searched", dirs)
+	return nil
+}
+
+// checkConfigFileReadable returns an error wrapping fs.ErrNotExist if there
+// is no file at path, or another error if it cannot be read
+func checkConfigFileReadable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// resolveDBPassword sets db.password from its sources, in order of
+// precedence: the --db-pass flag, the file named by db.password_file (or
+// --db-pass-file), the FDO_DB_PASS environment variable and the
+// configuration file. viper already orders the flag, environment and
+// configuration file, the file only needs to be slotted in.
+func resolveDBPassword(cmd *cobra.Command) error {
+	if cmd.Flags().Changed("db-pass") {
+		return nil
+	}
+	path := viper.GetString("db.password_file")
+	if path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("database configuration error: reading password file: %w", err)
+	}
+	password := strings.TrimRight(string(b), "\r\n")
+	if password == "" {
+		return fmt.Errorf("database configuration error: password file %s is empty", path)
+	}
+	viper.Set("db.password", password)
+	return nil
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -112,38 +218,127 @@ func Execute() {
 
 // Setup the root command line. Used by the unit tests to reset state between tests.
 func rootCmdInit() {
-	rootCmd.PersistentFlags().String("config", "", "Pathname of the configuration file")
+	rootCmd.PersistentFlags().StringArray("config", nil, "Pathname of the configuration file, or \"-\" to read it from stdin. May be repeated, later files overriding earlier ones")
+	rootCmd.PersistentFlags().StringArray("config-dir", nil, "Directory searched for the configuration file when --config is not set, instead of the default locations. May be repeated")
+	rootCmd.PersistentFlags().String("config-type", "", "Configuration file format (yaml, json or toml), overriding the file extension")
 	rootCmd.PersistentFlags().String("log-level", "info", "Set logging level (debug, info, warn, error)")
+	rootCmd.PersistentFlags().String("log-format", logFormatText, "Log output format (text or json)")
 	rootCmd.PersistentFlags().String("db-type", "sqlite", "Database type (sqlite or postgres)")
 	rootCmd.PersistentFlags().String("db-dsn", "", "Database DSN (connection string)")
+	rootCmd.PersistentFlags().String("db-pass", "", "Database password added to the DSN (prefer --db-pass-file or "+dbPasswordEnv+")")
+	rootCmd.PersistentFlags().String("db-pass-file", "", "Path of a file holding the database password")
+	rootCmd.PersistentFlags().String("db-pass-policy", dbPassPolicyStrict, "Complexity required of the database password: strict or none")
 	rootCmd.PersistentFlags().String("http-cert", "", "Path to server certificate")
 	rootCmd.PersistentFlags().String("http-key", "", "Path to server private key")
+	rootCmd.PersistentFlags().String("ready-file", "", "Path of a file to write once the server is listening (removed on shutdown)")
+	rootCmd.PersistentFlags().Bool("proxy-protocol", false, "Require a PROXY protocol (v1 or v2) header on every connection")
+	rootCmd.PersistentFlags().Duration("shutdown-timeout", 5*time.Second, "Time given to in-flight requests to complete on shutdown")
+	rootCmd.PersistentFlags().Duration("message-timeout", 0, "Deadline for handling each FDO protocol message (0 disables)")
+	rootCmd.PersistentFlags().Bool("metrics", false, "Serve Prometheus metrics at /metrics")
 	if err := viper.BindPFlag("log.level", rootCmd.PersistentFlags().Lookup("log-level")); err != nil {
 		panic(err)
 	}
+	if err := viper.BindPFlag("log.format", rootCmd.PersistentFlags().Lookup("log-format")); err != nil {
+		panic(err)
+	}
 	if err := viper.BindPFlag("db.type", rootCmd.PersistentFlags().Lookup("db-type")); err != nil {
 		panic(err)
 	}
 	if err := viper.BindPFlag("db.dsn", rootCmd.PersistentFlags().Lookup("db-dsn")); err != nil {
 		panic(err)
 	}
+	if err := viper.BindPFlag("db.password", rootCmd.PersistentFlags().Lookup("db-pass")); err != nil {
+		panic(err)
+	}
+	if err := viper.BindPFlag("db.password_file", rootCmd.PersistentFlags().Lookup("db-pass-file")); err != nil {
+		panic(err)
+	}
+	if err := viper.BindPFlag("db.password_policy", rootCmd.PersistentFlags().Lookup("db-pass-policy")); err != nil {
+		panic(err)
+	}
+	if err := viper.BindEnv("db.password", dbPasswordEnv); err != nil {
+		panic(err)
+	}
+	for _, key := range []string{"owner.pkcs11.pin", "device_ca.pkcs11.pin"} {
+		if err := viper.BindEnv(key, pkcs11PINEnv); err != nil {
+			panic(err)
+		}
+	}
 	if err := viper.BindPFlag("http.cert", rootCmd.PersistentFlags().Lookup("http-cert")); err != nil {
 		panic(err)
 	}
 	if err := viper.BindPFlag("http.key", rootCmd.PersistentFlags().Lookup("http-key")); err != nil {
 		panic(err)
 	}
+	if err := viper.BindPFlag("http.ready_file", rootCmd.PersistentFlags().Lookup("ready-file")); err != nil {
+		panic(err)
+	}
+	if err := viper.BindPFlag("http.proxy_protocol", rootCmd.PersistentFlags().Lookup("proxy-protocol")); err != nil {
+		panic(err)
+	}
+	if err := viper.BindPFlag("http.shutdown_timeout", rootCmd.PersistentFlags().Lookup("shutdown-timeout")); err != nil {
+		panic(err)
+	}
+	if err := viper.BindPFlag("http.message_timeout", rootCmd.PersistentFlags().Lookup("message-timeout")); err != nil {
+		panic(err)
+	}
+	if err := viper.BindPFlag("http.metrics", rootCmd.PersistentFlags().Lookup("metrics")); err != nil {
+		panic(err)
+	}
 }
 
 func init() {
-	rootLogger := slog.New(devlog.NewHandler(os.Stdout, &devlog.Options{
-		Level: &logLevel,
-	}))
-	slog.SetDefault(rootLogger)
-	viper.SetOptions(viper.WithLogger(rootLogger))
+	if err := setLogFormat(logFormatText); err != nil {
+		panic(err)
+	}
 	rootCmdInit()
 }
 
+// Log output formats
+const (
+	logFormatText = "text" // human readable, the default
+	logFormatJSON = "json" // one JSON object per record, for log aggregation
+)
+
+// newLogHandler returns the handler writing log records to w in the given
+// format, filtered by the server logging level
+func newLogHandler(w io.Writer, format string) (slog.Handler, error) {
+	switch strings.ToLower(format) {
+	case "", logFormatText:
+		return devlog.NewHandler(w, &devlog.Options{Level: &logLevel}), nil
+	case logFormatJSON:
+		return slog.NewJSONHandler(w, &slog.HandlerOptions{Level: &logLevel}), nil
+	default:
+		return nil, fmt.Errorf("unsupported log format %q (must be 'text' or 'json')", format)
+	}
+}
+
+// setLogFormat makes the default logger write to stdout in the given format
+func setLogFormat(format string) error {
+	handler, err := newLogHandler(os.Stdout, format)
+	if err != nil {
+		return err
+	}
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	viper.SetOptions(viper.WithLogger(logger))
+	return nil
+}
+
+// setLogLevel sets the server logging level. Unknown levels are ignored.
+func setLogLevel(level string) {
+	switch strings.ToLower(level) {
+	case "debug":
+		logLevel.Set(slog.LevelDebug)
+	case "info":
+		logLevel.Set(slog.LevelInfo)
+	case "warn":
+		logLevel.Set(slog.LevelWarn)
+	case "error":
+		logLevel.Set(slog.LevelError)
+	}
+}
+
 func parsePrivateKey(keyPath string) (crypto.Signer, error) {
 	b, err := os.ReadFile(keyPath)
 	if err != nil {
@@ -170,15 +365,51 @@ func parsePrivateKey(keyPath string) (crypto.Signer, error) {
 	return nil, fmt.Errorf("unable to parse private key %s: %v", keyPath, err)
 }
 
-func getPrivateKeyType(key any) (protocol.KeyType, error) {
-	switch ktype := key.(type) {
-	case *rsa.PrivateKey:
+// parseCertificateChain reads all PEM encoded certificates of a file, in the
+// order they appear. The chain must be given leaf first, each certificate
+// followed by its issuer. Files of more than maxBlocks PEM blocks are
+// rejected rather than parsed.
+func parseCertificateChain(path string, maxBlocks int) ([]*x509.Certificate, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var chain []*x509.Certificate
+	for {
+		var blk *pem.Block
+		blk, b = pem.Decode(b)
+		if blk == nil {
+			break
+		}
+		if len(chain) == maxBlocks {
+			return nil, fmt.Errorf("%s: more than %d PEM blocks, the maximum certificate chain length (device_ca.max_chain_length)", path, maxBlocks)
+		}
+		if blk.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("%s: PEM block %d is a %q, not a certificate", path, len(chain)+1, blk.Type)
+		}
+		cert, err := x509.ParseCertificate(blk.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: PEM block %d is not a valid certificate: %w", path, len(chain)+1, err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("%s: no PEM encoded certificate found", path)
+	}
+	return chain, nil
+}
+
+func getPrivateKeyType(key crypto.Signer) (protocol.KeyType, error) {
+	// The public key also types keys held by a PKCS#11 token
+	switch ktype := key.Public().(type) {
+	case *rsa.PublicKey:
 		switch ktype.N.BitLen() {
 		case 2048:
 			return protocol.Rsa2048RestrKeyType, nil
-			// case 3072: TODO: add support for 3072 bit keys
+		case 3072:
+			return protocol.RsaPkcsKeyType, nil
 		}
-	case *ecdsa.PrivateKey:
+	case *ecdsa.PublicKey:
 		switch ktype.Curve.Params().BitSize {
 		case 256:
 			return protocol.Secp256r1KeyType, nil
@@ -189,6 +420,35 @@ func getPrivateKeyType(key any) (protocol.KeyType, error) {
 	return 0, fmt.Errorf("unsupported key provided")
 }
 
+// writeReadyFile writes the address the server is listening on to path,
+// signalling readiness to orchestration scripts. The returned function
+// removes the file.
+func writeReadyFile(path, addr string) (remove func(), err error) {
+	if err := os.WriteFile(path, []byte(addr+"\n"), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write ready file %q: %w", path, err)
+	}
+	return func() {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Failed to remove ready file", "path", path, "err", err)
+		}
+	}, nil
+}
+
+// setHTTPAddress sets the HTTP address from the http_address positional
+// argument of the server commands, if provided
+func setHTTPAddress(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	ip, port, err := parseHTTPAddress(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid http_address: %w", err)
+	}
+	viper.Set("http.ip", ip)
+	viper.Set("http.port", port)
+	return nil
+}
+
 // parseHTTPAddress parses an address string in the format "host:port" and returns
 // the host and port components. Supports IPv4, IPv6 addresses, and DNS names.
 // Returns an error if the format is invalid.
@@ -205,3 +465,93 @@ func parseHTTPAddress(addr string) (ip, port string, err error) {
 	}
 	return host, portStr, nil
 }
+
+// defaultExternalAddress returns the host devices may reach a server
+// listening on listenIP at when no external address is configured: the
+// listen IP itself, or for a wildcard listen IP, which devices cannot
+// connect to, a routable address of one of the host's interfaces. Loopback
+// and link-local addresses, which devices cannot reach either, are refused.
+func defaultExternalAddress(listenIP string, interfaceAddrs func() ([]net.Addr, error)) (string, error) {
+	ip := net.ParseIP(listenIP)
+	if ip == nil {
+		return listenIP, nil
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return "", fmt.Errorf("listening on %s, which devices cannot reach, an external address must be configured", listenIP)
+	}
+	if !ip.IsUnspecified() {
+		return listenIP, nil
+	}
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return "", fmt.Errorf("listening on %s, failed to list the interface addresses: %w", listenIP, err)
+	}
+	routable := routableIP(ip.To4() == nil, addrs)
+	if routable == nil {
+		return "", fmt.Errorf("listening on %s and no routable interface address found, an external address must be configured", listenIP)
+	}
+	return routable.String(), nil
+}
+
+// routableIP returns the first global unicast address of addrs, preferring
+// IPv4 ones, loopback and link-local addresses never being global unicast.
+// IPv6 addresses are only considered with allowIPv6, e.g. when listening on
+// "::", which accepts both.
+func routableIP(allowIPv6 bool, addrs []net.Addr) net.IP {
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		prefix, ok := addr.(*net.IPNet)
+		if !ok || !prefix.IP.IsGlobalUnicast() {
+			continue
+		}
+		if prefix.IP.To4() != nil {
+			return prefix.IP
+		}
+		if allowIPv6 && ipv6 == nil {
+			ipv6 = prefix.IP
+		}
+	}
+	return ipv6
+}
+
+// parseExternalAddress parses the address devices reach the server at:
+// "host:port", "host" or ":port", IPv6 hosts in brackets when a port is
+// given. A missing host or port defaults to that of the listen address.
+func parseExternalAddress(addr, listenIP, listenPort string) (host string, port uint16, err error) {
+	portStr := listenPort
+	switch {
+	case net.ParseIP(addr) != nil:
+		// A bare IP address, possibly IPv6 with colons
+		host = addr
+	case strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]"):
+		host = addr[1 : len(addr)-1]
+		if net.ParseIP(host) == nil {
+			return "", 0, fmt.Errorf("invalid external address %q: %q is not an IP address", addr, host)
+		}
+	case strings.Contains(addr, ":"):
+		var p string
+		if host, p, err = net.SplitHostPort(addr); err != nil {
+			return "", 0, fmt.Errorf("invalid external address %q: %w", addr, err)
+		}
+		if p == "" {
+			return "", 0, fmt.Errorf("invalid external address %q: port cannot be empty", addr)
+		}
+		portStr = p
+	default:
+		host = addr
+	}
+	if host == "" {
+		host = listenIP
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		return "", 0, fmt.Errorf("invalid external address %q: a host is required when listening on %s", addr, listenIP)
+	}
+	if net.ParseIP(host) == nil && (len(host) > 253 || !hostnameRegexp.MatchString(host)) {
+		return "", 0, fmt.Errorf("invalid external address %q: %q is neither an IP address nor a hostname", addr, host)
+	}
+	n, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || n == 0 {
+		return "", 0, fmt.Errorf("invalid external address %q: port %q must be a number between 1 and 65535", addr, portStr)
+	}
+	return host, uint16(n), nil
+}
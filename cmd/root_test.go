@@ -0,0 +1,341 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"hermannm.dev/devlog"
+)
+
+func TestGetPrivateKeyType_RSA3072(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "mfg.key")
+	if err := os.WriteFile(keyPath, der, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := parsePrivateKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyType, err := getPrivateKeyType(key)
+	if err != nil {
+		t.Fatalf("3072 bit RSA key rejected: %v", err)
+	}
+	if keyType != protocol.RsaPkcsKeyType {
+		t.Fatalf("key type=%s, want %s", keyType, protocol.RsaPkcsKeyType)
+	}
+
+	// The manufacturer key is encoded in the voucher with the resolved type
+	pub, err := encodePublicKey(keyType, protocol.X509KeyEnc, key.Public(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub.Type != protocol.RsaPkcsKeyType {
+		t.Fatalf("encoded key type=%s, want %s", pub.Type, protocol.RsaPkcsKeyType)
+	}
+	if _, err := encodePublicKey(protocol.Rsa2048RestrKeyType, protocol.X509KeyEnc, key.Public(), nil); err == nil {
+		t.Fatal("expected error encoding a 3072 bit key as RSA2048RESTR")
+	}
+
+	// Vouchers can be extended to a 3072 bit RSA owner key
+	ov, err := newSeedVoucher(key, "test", [][]protocol.RvInstruction{{{Variable: protocol.RVBypass}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ov.Header.Val.ManufacturerKey.Type != protocol.RsaPkcsKeyType {
+		t.Fatalf("manufacturer key type=%s, want %s", ov.Header.Val.ManufacturerKey.Type, protocol.RsaPkcsKeyType)
+	}
+	if err := handlers.VerifyVoucher(ov, []crypto.PublicKey{key.Public()}, 0); err != nil {
+		t.Fatalf("voucher extended to a 3072 bit RSA key rejected: %v", err)
+	}
+}
+
+// newTestCA returns a CA certificate signed by parent, or self-signed if
+// parent is nil
+func newTestCA(t *testing.T, name string, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestParseCertificateChain(t *testing.T) {
+	root, rootKey := newTestCA(t, "root", nil, nil)
+	intermediate, _ := newTestCA(t, "intermediate", root, rootKey)
+
+	dir := t.TempDir()
+	write := func(name string, blocks ...*pem.Block) string {
+		t.Helper()
+		var b []byte
+		for _, blk := range blocks {
+			b = append(b, pem.EncodeToMemory(blk)...)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// The chain keeps the order of the file, leaf first
+	path := write("chain.pem",
+		&pem.Block{Type: "CERTIFICATE", Bytes: intermediate.Raw},
+		&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+	chain, err := parseCertificateChain(path, defaultMaxChainLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 || chain[0].Subject.CommonName != "intermediate" || chain[1].Subject.CommonName != "root" {
+		t.Fatalf("unexpected chain %v", chain)
+	}
+	if err := chain[0].CheckSignatureFrom(chain[1]); err != nil {
+		t.Fatalf("chain order broken: %v", err)
+	}
+
+	// The chain may be as long as the limit
+	if chain, err := parseCertificateChain(path, 2); err != nil || len(chain) != 2 {
+		t.Fatalf("expected a chain at the limit to be accepted, got %v", err)
+	}
+	if _, err := parseCertificateChain(path, 1); err == nil || !strings.Contains(err.Error(), "more than 1 PEM blocks") {
+		t.Fatalf("expected a chain over the limit to be rejected, got %v", err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "invalid certificate", path: write("invalid.pem",
+			&pem.Block{Type: "CERTIFICATE", Bytes: intermediate.Raw},
+			&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")}), wantErr: "PEM block 2 is not a valid certificate"},
+		{name: "not a certificate", path: write("key.pem",
+			&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}), wantErr: "PEM block 1 is a \"PRIVATE KEY\""},
+		{name: "empty", path: write("empty.pem"), wantErr: "no PEM encoded certificate"},
+		{name: "oversized", path: write("oversized.pem", slices.Repeat([]*pem.Block{{Type: "CERTIFICATE", Bytes: root.Raw}}, defaultMaxChainLength+1)...), wantErr: "more than 16 PEM blocks"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseCertificateChain(tt.path, defaultMaxChainLength); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseExternalAddress(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		addr     string
+		listenIP string
+		wantHost string
+		wantPort uint16
+		wantErr  string
+	}{
+		{name: "host and port", addr: "owner.example.com:9443", listenIP: "10.0.0.1", wantHost: "owner.example.com", wantPort: 9443},
+		{name: "no host", addr: ":9000", listenIP: "10.0.0.1", wantHost: "10.0.0.1", wantPort: 9000},
+		{name: "no port", addr: "owner.example.com", listenIP: "10.0.0.1", wantHost: "owner.example.com", wantPort: 8043},
+		{name: "ipv4 without port", addr: "192.168.1.5", listenIP: "10.0.0.1", wantHost: "192.168.1.5", wantPort: 8043},
+		{name: "ipv6 with brackets and port", addr: "[::1]:9443", listenIP: "10.0.0.1", wantHost: "::1", wantPort: 9443},
+		{name: "ipv6 with brackets", addr: "[fd00::5]", listenIP: "10.0.0.1", wantHost: "fd00::5", wantPort: 8043},
+		{name: "bare ipv6", addr: "fd00::5", listenIP: "10.0.0.1", wantHost: "fd00::5", wantPort: 8043},
+		{name: "no host on unspecified listen IP", addr: ":9000", listenIP: "0.0.0.0", wantErr: "a host is required"},
+		{name: "unspecified host", addr: "[::]:9000", listenIP: "10.0.0.1", wantErr: "a host is required"},
+		{name: "non numeric port", addr: "owner.example.com:abc", listenIP: "10.0.0.1", wantErr: "must be a number between 1 and 65535"},
+		{name: "port zero", addr: "owner.example.com:0", listenIP: "10.0.0.1", wantErr: "must be a number between 1 and 65535"},
+		{name: "port out of range", addr: "owner.example.com:70000", listenIP: "10.0.0.1", wantErr: "must be a number between 1 and 65535"},
+		{name: "empty port", addr: "owner.example.com:", listenIP: "10.0.0.1", wantErr: "port cannot be empty"},
+		{name: "unterminated bracket", addr: "[::1", listenIP: "10.0.0.1", wantErr: "invalid external address"},
+		{name: "bracketed hostname", addr: "[owner.example.com]", listenIP: "10.0.0.1", wantErr: "is not an IP address"},
+		{name: "invalid hostname", addr: "owner_example!:9443", listenIP: "10.0.0.1", wantErr: "neither an IP address nor a hostname"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, err := parseExternalAddress(tt.addr, tt.listenIP, "8043")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if host != tt.wantHost || port != tt.wantPort {
+				t.Fatalf("got %s/%d, want %s/%d", host, port, tt.wantHost, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestDefaultExternalAddress(t *testing.T) {
+	ipNet := func(cidr string) net.Addr {
+		ip, prefix, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefix.IP = ip
+		return prefix
+	}
+	interfaces := func(addrs ...net.Addr) func() ([]net.Addr, error) {
+		return func() ([]net.Addr, error) { return addrs, nil }
+	}
+	loopbackOnly := interfaces(ipNet("127.0.0.1/8"), ipNet("::1/128"), ipNet("fe80::1/64"))
+	multiHomed := interfaces(ipNet("127.0.0.1/8"), ipNet("2001:db8::5/64"), ipNet("192.168.1.5/24"), ipNet("10.0.0.5/8"))
+	ipv6Only := interfaces(ipNet("127.0.0.1/8"), ipNet("2001:db8::5/64"))
+
+	for _, tt := range []struct {
+		name       string
+		listenIP   string
+		interfaces func() ([]net.Addr, error)
+		want       string
+		wantErr    string
+	}{
+		{name: "specific listen IP", listenIP: "10.0.0.1", interfaces: loopbackOnly, want: "10.0.0.1"},
+		{name: "loopback listen IP", listenIP: "127.0.0.1", interfaces: multiHomed, wantErr: "devices cannot reach"},
+		{name: "ipv6 loopback listen IP", listenIP: "::1", interfaces: multiHomed, wantErr: "devices cannot reach"},
+		{name: "link-local listen IP", listenIP: "169.254.10.1", interfaces: multiHomed, wantErr: "devices cannot reach"},
+		{name: "wildcard with link-local addresses only", listenIP: "0.0.0.0", interfaces: interfaces(ipNet("169.254.10.1/16"), ipNet("fe80::1/64")), wantErr: "an external address must be configured"},
+		{name: "listen hostname", listenIP: "owner.example.com", interfaces: loopbackOnly, want: "owner.example.com"},
+		{name: "ipv4 wildcard", listenIP: "0.0.0.0", interfaces: multiHomed, want: "192.168.1.5"},
+		{name: "ipv6 wildcard prefers ipv4", listenIP: "::", interfaces: multiHomed, want: "192.168.1.5"},
+		{name: "ipv6 wildcard on ipv6 host", listenIP: "::", interfaces: ipv6Only, want: "2001:db8::5"},
+		{name: "ipv4 wildcard on ipv6 host", listenIP: "0.0.0.0", interfaces: ipv6Only, wantErr: "an external address must be configured"},
+		{name: "wildcard without routable address", listenIP: "0.0.0.0", interfaces: loopbackOnly, wantErr: "an external address must be configured"},
+		{name: "interface error", listenIP: "0.0.0.0", interfaces: func() ([]net.Addr, error) { return nil, errors.New("boom") }, wantErr: "boom"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := defaultExternalAddress(tt.listenIP, tt.interfaces)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %q, %v", tt.wantErr, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	// On this host a wildcard bind either resolves to a routable address or
+	// fails asking for an external address, never 0.0.0.0
+	got, err := defaultExternalAddress("0.0.0.0", net.InterfaceAddrs)
+	if err != nil {
+		if !strings.Contains(err.Error(), "external address") {
+			t.Fatalf("unexpected error %v", err)
+		}
+	} else if ip := net.ParseIP(got); ip == nil || ip.IsUnspecified() || ip.IsLoopback() {
+		t.Fatalf("expected a routable address, got %q", got)
+	}
+}
+
+func TestLogFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		config  string
+		json    bool
+		wantErr string
+	}{
+		{name: "default", json: false},
+		{name: "text flag", args: []string{"--log-format", "text"}, json: false},
+		{name: "json flag", args: []string{"--log-format", "json"}, json: true},
+		{name: "json config", config: "log:\n  format: json\n", json: true},
+		{name: "flag overrides config", args: []string{"--log-format", "text"}, config: "log:\n  format: json\n", json: false},
+		{name: "invalid", args: []string{"--log-format", "xml"}, wantErr: `unsupported log format "xml"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetState(t)
+			t.Cleanup(func() { resetState(t) })
+			stubRunE(t, rendezvousCmd)
+
+			args := append([]string{"rendezvous", "127.0.0.1:8041", "--log-level", "warn"}, tt.args...)
+			if tt.config != "" {
+				args = append(args, "--config", writeYAMLConfig(t, tt.config))
+			}
+			rootCmd.SetArgs(args)
+			err := rootCmd.Execute()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			handler := slog.Default().Handler()
+			switch handler.(type) {
+			case *slog.JSONHandler:
+				if !tt.json {
+					t.Fatal("expected text logs, got the JSON handler")
+				}
+			case *devlog.Handler:
+				if tt.json {
+					t.Fatal("expected JSON logs, got the text handler")
+				}
+			default:
+				t.Fatalf("unexpected log handler %T", handler)
+			}
+			// The configured level applies whatever the format
+			if handler.Enabled(context.Background(), slog.LevelInfo) || !handler.Enabled(context.Background(), slog.LevelWarn) {
+				t.Fatal("expected the log handler to apply the warn level")
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// rvInfoCmd groups tools for the rendezvous info stored in the database
+var rvInfoCmd = &cobra.Command{
+	Use:   "rvinfo",
+	Short: "Inspect the rendezvous info stored in the database",
+}
+
+// rvInfoDiffCmd compares an rvinfo file with the stored rvinfo
+var rvInfoDiffCmd = &cobra.Command{
+	Use:   "diff file",
+	Short: "Compare an rvinfo JSON file with the rvinfo stored in the database",
+	Long: `Compare an rvinfo JSON file, in the format accepted by the rvinfo API, with
+the rvinfo stored in the database, printing the changes the file would make
+to each RV directive: "+" for added instructions, "-" for removed ones and
+"~" for changed values.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var config FDOServerConfig
+		if err := viper.Unmarshal(&config); err != nil {
+			return fmt.Errorf("failed to unmarshal rvinfo config: %w", err)
+		}
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		candidate, err := db.ParseRvInfoJSON(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		if _, err := config.DB.getState(); err != nil {
+			return err
+		}
+		stored, err := db.FetchRvInfo()
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to fetch the stored rvinfo: %w", err)
+		}
+		printRvInfoDiff(cmd.OutOrStdout(), diffRvInfo(stored, candidate))
+		return nil
+	},
+}
+
+// A change to one instruction of an RV directive
+type rvInfoChange struct {
+	Directive int
+	// "+" for an added instruction, "-" for a removed one and "~" for a
+	// changed value
+	Op       string
+	Variable string
+	Old, New string
+}
+
+// diffRvInfo returns the changes turning the stored rvinfo into the
+// candidate one. Directives are compared by position, as devices try them
+// in order, and instructions within a directive by variable.
+func diffRvInfo(stored, candidate [][]protocol.RvInstruction) []rvInfoChange {
+	var changes []rvInfoChange
+	for i := range max(len(stored), len(candidate)) {
+		var old, cur []protocol.RvInstruction
+		if i < len(stored) {
+			old = stored[i]
+		}
+		if i < len(candidate) {
+			cur = candidate[i]
+		}
+		for _, inst := range old {
+			j := slices.IndexFunc(cur, func(c protocol.RvInstruction) bool { return c.Variable == inst.Variable })
+			switch {
+			case j < 0:
+				changes = append(changes, rvInfoChange{Directive: i, Op: "-", Variable: rvVarName(inst.Variable), Old: rvValueString(inst)})
+			case !slices.Equal(inst.Value, cur[j].Value):
+				changes = append(changes, rvInfoChange{Directive: i, Op: "~", Variable: rvVarName(inst.Variable), Old: rvValueString(inst), New: rvValueString(cur[j])})
+			}
+		}
+		for _, inst := range cur {
+			if !slices.ContainsFunc(old, func(o protocol.RvInstruction) bool { return o.Variable == inst.Variable }) {
+				changes = append(changes, rvInfoChange{Directive: i, Op: "+", Variable: rvVarName(inst.Variable), New: rvValueString(inst)})
+			}
+		}
+	}
+	return changes
+}
+
+// printRvInfoDiff writes the changes grouped by RV directive
+func printRvInfoDiff(w io.Writer, changes []rvInfoChange) {
+	if len(changes) == 0 {
+		_, _ = fmt.Fprintln(w, "rvinfo is unchanged")
+		return
+	}
+	for i, change := range changes {
+		if i == 0 || changes[i-1].Directive != change.Directive {
+			_, _ = fmt.Fprintf(w, "directive %d:\n", change.Directive)
+		}
+		switch change.Op {
+		case "-":
+			_, _ = fmt.Fprintf(w, "  - %s: %s\n", change.Variable, change.Old)
+		case "+":
+			_, _ = fmt.Fprintf(w, "  + %s: %s\n", change.Variable, change.New)
+		default:
+			_, _ = fmt.Fprintf(w, "  ~ %s: %s -> %s\n", change.Variable, change.Old, change.New)
+		}
+	}
+}
+
+func rvVarName(v protocol.RvVar) string {
+	if name, ok := db.RvVarNames[v]; ok {
+		return name
+	}
+	return strconv.Itoa(int(v))
+}
+
+// rvValueString returns the value of an RV instruction in a readable form,
+// "true" for the variables without a value
+func rvValueString(inst protocol.RvInstruction) string {
+	if len(inst.Value) == 0 {
+		return "true"
+	}
+	var prot uint8
+	if inst.Variable == protocol.RVProtocol && cbor.Unmarshal(inst.Value, &prot) == nil {
+		if name, ok := db.RvProtocolNames[prot]; ok {
+			return name
+		}
+	}
+	var val any
+	if err := cbor.Unmarshal(inst.Value, &val); err != nil {
+		return hex.EncodeToString(inst.Value)
+	}
+	switch v := val.(type) {
+	case string:
+		return strconv.Quote(v)
+	case []byte:
+		if inst.Variable == protocol.RVIPAddress {
+			return net.IP(v).String()
+		}
+		return hex.EncodeToString(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Set up the rvinfo command line. Used by the unit tests to reset state between tests.
+func rvInfoCmdInit() {
+	rootCmd.AddCommand(rvInfoCmd)
+	rvInfoCmd.AddCommand(rvInfoDiffCmd)
+}
+
+func init() {
+	rvInfoCmdInit()
+}
@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+func TestDiffRvInfo(t *testing.T) {
+	stored, err := db.ParseRvInfoJSON([]byte(`[
+		{"dns": "rv1.example.com", "protocol": "http", "owner_port": "8041"},
+		{"ip": "10.0.0.5", "device_port": "8041"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidate, err := db.ParseRvInfoJSON([]byte(`[
+		{"dns": "rv2.example.com", "protocol": "http", "owner_port": "8041", "delay_seconds": 30},
+		{"ip": "10.0.0.5", "device_port": "8041"},
+		{"dns": "rv3.example.com", "rv_bypass": true}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []rvInfoChange{
+		{Directive: 0, Op: "~", Variable: "dns", Old: `"rv1.example.com"`, New: `"rv2.example.com"`},
+		{Directive: 0, Op: "+", Variable: "delay_seconds", New: "30"},
+		{Directive: 2, Op: "+", Variable: "dns", New: `"rv3.example.com"`},
+		{Directive: 2, Op: "+", Variable: "rv_bypass", New: "true"},
+	}
+	if got := diffRvInfo(stored, candidate); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected changes\n got: %+v\nwant: %+v", got, want)
+	}
+
+	// The reverse diff removes what was added
+	want = []rvInfoChange{
+		{Directive: 0, Op: "~", Variable: "dns", Old: `"rv2.example.com"`, New: `"rv1.example.com"`},
+		{Directive: 0, Op: "-", Variable: "delay_seconds", Old: "30"},
+		{Directive: 2, Op: "-", Variable: "dns", Old: `"rv3.example.com"`},
+		{Directive: 2, Op: "-", Variable: "rv_bypass", Old: "true"},
+	}
+	if got := diffRvInfo(candidate, stored); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected reverse changes\n got: %+v\nwant: %+v", got, want)
+	}
+
+	if got := diffRvInfo(stored, stored); len(got) != 0 {
+		t.Fatalf("expected no changes, got %+v", got)
+	}
+}
+
+func TestRvInfoDiffCommand(t *testing.T) {
+	resetState(t)
+
+	dir := t.TempDir()
+	candidatePath := filepath.Join(dir, "rvinfo.json")
+	if err := os.WriteFile(candidatePath, []byte(`[{"ip": "192.168.1.10", "protocol": "https"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := writeTOMLConfig(t, fmt.Sprintf(`
+[db]
+type = "sqlite"
+dsn = "file:%s"
+`, filepath.Join(dir, "mfg.db")))
+
+	run := func() string {
+		t.Helper()
+		var out bytes.Buffer
+		rootCmd.SetOut(&out)
+		t.Cleanup(func() { rootCmd.SetOut(nil) })
+		rootCmd.SetArgs([]string{"rvinfo", "diff", candidatePath, "--config", path})
+		if err := rootCmd.Execute(); err != nil {
+			t.Fatalf("rvinfo diff failed: %v", err)
+		}
+		return out.String()
+	}
+
+	// Without stored rvinfo every instruction of the file is added
+	if got, want := run(), "directive 0:\n  + ip: 192.168.1.10\n  + protocol: https\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if err := db.InsertRvInfo([]byte(`[{"ip": "192.168.1.10", "protocol": "http"}]`)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run(), "directive 0:\n  ~ protocol: http -> https\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,212 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Configuration file structure used by the seed-voucher command
+type SeedVoucherConfig struct {
+	FDOServerConfig `mapstructure:",squash"`
+	Owner           OwnerConfig `mapstructure:"owner"`
+}
+
+// validate checks that required configuration is present
+func (s *SeedVoucherConfig) validate() error {
+	return s.Owner.validateKey()
+}
+
+// seedVoucherCmd inserts a synthetic voucher into the owner database. The
+// voucher is signed by a throwaway manufacturer key and no real device holds
+// its credential, so it is only useful for smoke testing a deployment.
+var seedVoucherCmd = &cobra.Command{
+	Use:    "seed-voucher",
+	Short:  "Generate a synthetic voucher extended to the owner and insert it (testing only)",
+	Hidden: true,
+	Args:   cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return viper.BindPFlag("owner.key", cmd.Flags().Lookup("owner-key"))
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var config SeedVoucherConfig
+		if err := viper.Unmarshal(&config); err != nil {
+			return fmt.Errorf("failed to unmarshal seed-voucher config: %w", err)
+		}
+		if err := config.validate(); err != nil {
+			return err
+		}
+		if _, err := config.DB.getState(); err != nil {
+			return err
+		}
+		ownerKey, err := config.Owner.loadOwnerKey()
+		if err != nil {
+			return err
+		}
+		defer closeSigner(ownerKey)
+		deviceInfo, err := cmd.Flags().GetString("device-info")
+		if err != nil {
+			return err
+		}
+
+		// Prefer the configured rendezvous info so the owner can perform
+		// TO0 for the seeded device, otherwise use bypass.
+		rvInfo, err := db.FetchRvInfo()
+		if err != nil || len(rvInfo) == 0 {
+			rvInfo = [][]protocol.RvInstruction{{{Variable: protocol.RVBypass}}}
+		}
+
+		ov, err := newSeedVoucher(ownerKey, deviceInfo, rvInfo)
+		if err != nil {
+			return err
+		}
+		ovBytes, err := cbor.Marshal(ov)
+		if err != nil {
+			return fmt.Errorf("failed to encode seed voucher: %w", err)
+		}
+		guid := ov.Header.Val.GUID
+		if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: ovBytes, DeviceInfo: deviceInfo, CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
+			return fmt.Errorf("failed to insert seed voucher: %w", err)
+		}
+
+		cmd.Println(hex.EncodeToString(guid[:]))
+		return nil
+	},
+}
+
+// newSeedVoucher builds a voucher for a freshly generated device and extends
+// it to ownerKey. The manufacturer key is generated with the same key type as
+// the owner key, as required for voucher extension.
+func newSeedVoucher(ownerKey crypto.Signer, deviceInfo string, rvInfo [][]protocol.RvInstruction) (*fdo.Voucher, error) {
+	keyType, err := getPrivateKeyType(ownerKey)
+	if err != nil {
+		return nil, err
+	}
+	mfgKey, err := generateKey(keyType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate manufacturer key: %w", err)
+	}
+	mfgPubKey, err := encodePublicKey(keyType, protocol.X509KeyEnc, mfgKey.Public(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceCert, err := newSeedDeviceCertificate()
+	if err != nil {
+		return nil, err
+	}
+	chainHash := sha512.Sum384(deviceCert.Raw)
+
+	var guid protocol.GUID
+	if _, err := rand.Read(guid[:]); err != nil {
+		return nil, err
+	}
+
+	header := fdo.VoucherHeader{
+		Version:         101,
+		GUID:            guid,
+		RvInfo:          rvInfo,
+		DeviceInfo:      deviceInfo,
+		ManufacturerKey: *mfgPubKey,
+		CertChainHash:   &protocol.Hash{Algorithm: protocol.Sha384Hash, Value: chainHash[:]},
+	}
+
+	// The device HMAC secret is discarded: no device will ever verify it
+	headerBytes, err := cbor.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	secret := make([]byte, 48)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha512.New384, secret)
+	_, _ = mac.Write(headerBytes)
+
+	ov := &fdo.Voucher{
+		Version:   101,
+		Header:    *cbor.NewBstr(header),
+		Hmac:      protocol.Hmac{Algorithm: protocol.HmacSha384Hash, Value: mac.Sum(nil)},
+		CertChain: &[]*cbor.X509Certificate{(*cbor.X509Certificate)(deviceCert)},
+	}
+
+	switch pub := ownerKey.Public().(type) {
+	case *ecdsa.PublicKey:
+		return fdo.ExtendVoucher(ov, mfgKey, pub, nil)
+	case *rsa.PublicKey:
+		return fdo.ExtendVoucher(ov, mfgKey, pub, nil)
+	default:
+		return nil, fmt.Errorf("unsupported owner key type %T", pub)
+	}
+}
+
+// generateKey creates a new private key of the given type
+func generateKey(keyType protocol.KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case protocol.Secp256r1KeyType:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case protocol.Secp384r1KeyType:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case protocol.Rsa2048RestrKeyType:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case protocol.RsaPkcsKeyType, protocol.RsaPssKeyType:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", keyType)
+	}
+}
+
+// newSeedDeviceCertificate generates a device key and a self-signed
+// certificate for it.
+func newSeedDeviceCertificate() (*x509.Certificate, error) {
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate device key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "go-fdo-server seed device"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, deviceKey.Public(), deviceKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create device certificate: %w", err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+// Set up the seed-voucher command line. Used by the unit tests to reset state between tests.
+func seedVoucherCmdInit() {
+	ownerCmd.AddCommand(seedVoucherCmd)
+
+	seedVoucherCmd.Flags().String("owner-key", "", "Owner private key path")
+	seedVoucherCmd.Flags().String("device-info", "seed-voucher", "DeviceInfo string recorded in the voucher")
+}
@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+)
+
+func TestOwnerSeedVoucher_RetrievableViaGET(t *testing.T) {
+	resetState(t)
+
+	dir := t.TempDir()
+	ownerKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "owner.key")
+	if err := os.WriteFile(keyPath, der, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := writeTOMLConfig(t, fmt.Sprintf(`
+[db]
+type = "sqlite"
+dsn = "file:%s"
+
+[owner]
+key = "%s"
+`, filepath.Join(dir, "owner.db"), keyPath))
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	t.Cleanup(func() { rootCmd.SetOut(nil) })
+	rootCmd.SetArgs([]string{"owner", "seed-voucher", "--config", path})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("seed-voucher failed: %v", err)
+	}
+	guid := strings.TrimSpace(out.String())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /owner/vouchers/{guid}", handlers.GetVoucherByGUIDHandler)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/owner/vouchers/"+guid, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	block, _ := pem.Decode(rec.Body.Bytes())
+	if block == nil || block.Type != "OWNERSHIP VOUCHER" {
+		t.Fatalf("expected an OWNERSHIP VOUCHER PEM block, got %q", rec.Body.String())
+	}
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(block.Bytes, &ov); err != nil {
+		t.Fatal(err)
+	}
+	if err := handlers.VerifyVoucher(&ov, []crypto.PublicKey{ownerKey.Public()}, 0); err != nil {
+		t.Fatalf("seeded voucher does not verify against the owner key: %v", err)
+	}
+}
@@ -0,0 +1,885 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/fsim"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// rawOwnerModule implements an owner module that sends a fixed list of
+// pre-encoded messages for a vendor-defined FSIM. Messages are written
+// verbatim, split across as many ServiceInfo rounds as the MTU requires.
+type rawOwnerModule struct {
+	messages []*serviceinfo.KV // Key holds the message name
+	next     int
+}
+
+var _ serviceinfo.OwnerModule = (*rawOwnerModule)(nil)
+
+func newRawOwnerModule(params *FSIMRawParams) (*rawOwnerModule, error) {
+	module := &rawOwnerModule{}
+	for _, msg := range params.Messages {
+		body, err := msg.body()
+		if err != nil {
+			return nil, fmt.Errorf("raw module %q: message %q: %w", params.Module, msg.Name, err)
+		}
+		module.messages = append(module.messages, &serviceinfo.KV{Key: msg.Name, Val: body})
+	}
+	return module, nil
+}
+
+// HandleInfo implements serviceinfo.OwnerModule.
+func (m *rawOwnerModule) HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error {
+	switch messageName {
+	case "active":
+		var deviceActive bool
+		if err := cbor.NewDecoder(messageBody).Decode(&deviceActive); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if !deviceActive {
+			return fmt.Errorf("device service info module is not active")
+		}
+		return nil
+	default:
+		// Device responses to raw modules are not interpreted
+		_, err := io.Copy(io.Discard, messageBody)
+		return err
+	}
+}
+
+// ProduceInfo implements serviceinfo.OwnerModule.
+func (m *rawOwnerModule) ProduceInfo(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+	// Complete only after the round following the last message so that any
+	// device response is delivered to this module.
+	if m.next == len(m.messages) {
+		return false, true, nil
+	}
+	for m.next < len(m.messages) {
+		msg := m.messages[m.next]
+		if len(msg.Val) > producer.Available(msg.Key) {
+			if len(producer.ServiceInfo()) == 0 {
+				return false, false, fmt.Errorf("message %q does not fit in the service info MTU", msg.Key)
+			}
+			return false, false, nil
+		}
+		if err := producer.WriteChunk(msg.Key, msg.Val); err != nil {
+			return false, false, err
+		}
+		m.next++
+	}
+	return false, false, nil
+}
+
+// loggedOwnerModule wraps an owner module to log the outcome of its operation
+// for a device once it completes or fails.
+type loggedOwnerModule struct {
+	serviceinfo.OwnerModule
+	name  string
+	guid  string
+	start time.Time
+	// Size of the file transferred when known, otherwise the bytes of the
+	// messages received from the device are reported
+	size     int64
+	received int64
+	logged   bool
+}
+
+func newLoggedOwnerModule(name string, guid protocol.GUID, module serviceinfo.OwnerModule) *loggedOwnerModule {
+	m := &loggedOwnerModule{OwnerModule: module, name: name, guid: hex.EncodeToString(guid[:]), start: time.Now(), size: -1}
+	switch op := module.(type) {
+	case *fsim.DownloadContents[*os.File]:
+		if info, err := op.Contents.Stat(); err == nil {
+			m.size = info.Size()
+		}
+	case *fsim.DownloadContents[*bytes.Reader]:
+		m.size = op.Contents.Size()
+	case *fsim.WgetCommand:
+		if op.Length > 0 {
+			m.size = op.Length
+		}
+	}
+	return m
+}
+
+// HandleInfo implements serviceinfo.OwnerModule.
+func (m *loggedOwnerModule) HandleInfo(ctx context.Context, messageName string, messageBody io.Reader) error {
+	body := &countingReader{Reader: messageBody}
+	err := m.OwnerModule.HandleInfo(ctx, messageName, body)
+	m.received += body.n
+	if err != nil {
+		m.logResult(err)
+	}
+	return err
+}
+
+// ProduceInfo implements serviceinfo.OwnerModule.
+func (m *loggedOwnerModule) ProduceInfo(ctx context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+	blockPeer, moduleDone, err := m.OwnerModule.ProduceInfo(ctx, producer)
+	if err != nil || moduleDone {
+		m.logResult(err)
+	}
+	return blockPeer, moduleDone, err
+}
+
+// logResult logs the outcome of the operation, once
+func (m *loggedOwnerModule) logResult(err error) {
+	if m.logged {
+		return
+	}
+	m.logged = true
+	bytes := m.size
+	if bytes < 0 {
+		bytes = m.received
+	}
+	attrs := []any{"module", m.name, "guid", m.guid, "bytes", bytes, "duration", time.Since(m.start)}
+	if err != nil {
+		slog.Warn("FSIM operation failed", append(attrs, "result", "failure", "err", err)...)
+		return
+	}
+	slog.Info("FSIM operation completed", append(attrs, "result", "success")...)
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// deviceFileSep returns the file separator to use when building paths on
+// the device. The separator reported in devmod ("sep") takes precedence over
+// the configured default.
+func deviceFileSep(devmod *serviceinfo.Devmod, serviceInfo *ServiceInfoConfig) string {
+	if devmod != nil && devmod.FileSep != "" {
+		return devmod.FileSep
+	}
+	if serviceInfo != nil && serviceInfo.DefaultFileSep != "" {
+		return serviceInfo.DefaultFileSep
+	}
+	return "/"
+}
+
+// devicePath converts a slash-separated path to use the device's file
+// separator.
+func devicePath(sep, name string) string {
+	if sep == "/" {
+		return name
+	}
+	return strings.ReplaceAll(name, "/", sep)
+}
+
+// ownerHandledModules returns the names of the service info modules for which
+// the owner has something configured, following the same precedence as
+// ownerModules. devmod is always handled.
+func ownerHandledModules(serviceInfo *ServiceInfoConfig) []string {
+	handled := []string{"devmod"}
+	if hasServiceInfoFsims(serviceInfo) {
+		for i := range serviceInfo.Fsims {
+			handled = append(handled, fsimModule(&serviceInfo.Fsims[i]))
+		}
+		return handled
+	}
+	return append(handled, flagFSIMModules()...)
+}
+
+// hasServiceInfoFsims reports whether the FSIM operations of serviceInfo,
+// rather than those of the command line flags, are performed
+func hasServiceInfoFsims(serviceInfo *ServiceInfoConfig) bool {
+	return serviceInfo != nil && len(serviceInfo.Fsims) > 0
+}
+
+// fsimModule returns the name of the service info module performing op
+func fsimModule(op *ServiceInfoOperation) string {
+	switch op.FSIM {
+	case rawFSIMType:
+		return op.Raw.Module
+	case timeSyncFSIMType, commandFSIMType:
+		return "fdo.command"
+	default:
+		return op.FSIM
+	}
+}
+
+// deviceFSIMs returns the FSIM operations of serviceInfo whose module the
+// device supports, in order, with their index
+func deviceFSIMs(modules []string, serviceInfo *ServiceInfoConfig) iter.Seq2[int, *ServiceInfoOperation] {
+	return func(yield func(int, *ServiceInfoOperation) bool) {
+		for i := range serviceInfo.Fsims {
+			op := &serviceInfo.Fsims[i]
+			if !slices.Contains(modules, fsimModule(op)) {
+				continue
+			}
+			if !yield(i, op) {
+				return
+			}
+		}
+	}
+}
+
+// flagFSIMModules returns the modules the command line flags configure
+// operations for, in the order flagModules performs them
+func flagFSIMModules() []string {
+	var modules []string
+	if len(downloadPaths) > 0 {
+		modules = append(modules, "fdo.download")
+	}
+	if len(uploads) > 0 {
+		modules = append(modules, "fdo.upload")
+	}
+	if len(wgetURLs) > 0 {
+		modules = append(modules, "fdo.wget")
+	}
+	if date {
+		modules = append(modules, "fdo.command")
+	}
+	return modules
+}
+
+// downloadFileName returns the name on the device of an fdo.download file
+// of op, before conversion to the device's file separator
+func downloadFileName(op *ServiceInfoOperation, file FSIMDownloadFileSpec) string {
+	name := file.Name
+	if name == "" {
+		name = filepath.Base(file.Path)
+	}
+	if op.Download.Dir != "" && !path.IsAbs(name) {
+		name = path.Join(op.Download.Dir, name)
+	}
+	return name
+}
+
+// checkDeviceModules returns an error if the device advertises any module
+// the owner does not handle.
+func checkDeviceModules(modules []string, serviceInfo *ServiceInfoConfig) error {
+	handled := ownerHandledModules(serviceInfo)
+	var unknown []string
+	for _, module := range modules {
+		if !slices.Contains(handled, module) {
+			unknown = append(unknown, module)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("device advertises service info modules not handled by the owner: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// devmodFieldNames returns the names of the devmod messages, e.g. "os" or
+// "sn", in the order of the serviceinfo.Devmod fields.
+func devmodFieldNames() []string {
+	t := reflect.TypeFor[serviceinfo.Devmod]()
+	names := make([]string, 0, t.NumField())
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("devmod"), ",")
+		names = append(names, name)
+	}
+	return names
+}
+
+// checkDevmodFields returns an error if the device did not report all of the
+// required devmod fields.
+func checkDevmodFields(devmod *serviceinfo.Devmod, required []string) error {
+	v := reflect.ValueOf(devmod).Elem()
+	var missing []string
+	for i, name := range devmodFieldNames() {
+		// Fields are strings or byte slices, which may be empty but not nil
+		if slices.Contains(required, name) && v.Field(i).Len() == 0 {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("device did not report required devmod fields: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// serviceInfoCapabilities describes the FSIM operations configured by
+// serviceInfo or, when it has none besides overrides, by command line flags.
+func serviceInfoCapabilities(serviceInfo *ServiceInfoConfig) handlers.ServiceInfoCapabilities {
+	var capabilities handlers.ServiceInfoCapabilities
+	if serviceInfo != nil && len(serviceInfo.Fsims) > 0 {
+		capabilities.FSIMs = fsimCapabilities(serviceInfo.Fsims)
+	} else {
+		capabilities.FSIMs = flagCapabilities()
+	}
+	if serviceInfo == nil {
+		return capabilities
+	}
+	for _, override := range serviceInfo.Overrides {
+		capabilities.Overrides = append(capabilities.Overrides, handlers.ServiceInfoOverrideCapability{
+			Device: override.Device,
+			GUIDs:  slices.Clone(override.GUIDs),
+			FSIMs:  fsimCapabilities(override.Fsims),
+		})
+	}
+	return capabilities
+}
+
+// flagCapabilities describes the FSIM operations configured by command line
+// flags
+func flagCapabilities() []handlers.FSIMCapability {
+	var capabilities []handlers.FSIMCapability
+	if len(downloads) > 0 {
+		capabilities = append(capabilities, handlers.FSIMCapability{Module: "fdo.download", Files: slices.Clone(downloads)})
+	}
+	if len(uploads) > 0 {
+		capabilities = append(capabilities, handlers.FSIMCapability{Module: "fdo.upload", Files: slices.Clone(uploads)})
+	}
+	if len(wgetURLs) > 0 {
+		wget := handlers.FSIMCapability{Module: "fdo.wget"}
+		for _, u := range wgetURLs {
+			wget.Files = append(wget.Files, path.Base(u.Path))
+			wget.URLs = append(wget.URLs, capabilityURL(u))
+		}
+		capabilities = append(capabilities, wget)
+	}
+	if date {
+		capabilities = append(capabilities, handlers.FSIMCapability{Module: "fdo.command", Commands: []string{"date --utc"}})
+	}
+	return capabilities
+}
+
+func fsimCapabilities(fsims []ServiceInfoOperation) []handlers.FSIMCapability {
+	capabilities := []handlers.FSIMCapability{}
+	for _, op := range fsims {
+		switch op.FSIM {
+		case wgetFSIMType:
+			wget := handlers.FSIMCapability{Module: "fdo.wget"}
+			for _, file := range op.Wget.Files {
+				u, err := parseWgetURL(file.URL)
+				if err != nil {
+					continue
+				}
+				name := file.Name
+				if name == "" {
+					name = path.Base(u.Path)
+				}
+				wget.Files = append(wget.Files, name)
+				wget.URLs = append(wget.URLs, capabilityURL(u))
+			}
+			capabilities = append(capabilities, wget)
+		case timeSyncFSIMType:
+			capabilities = append(capabilities, handlers.FSIMCapability{
+				Module:   "fdo.command",
+				Commands: []string{"date --utc --set @<owner time>"},
+			})
+		case downloadFSIMType:
+			download := handlers.FSIMCapability{Module: "fdo.download"}
+			for _, file := range op.Download.Files {
+				download.Files = append(download.Files, downloadFileName(&op, file))
+			}
+			capabilities = append(capabilities, download)
+		case uploadFSIMType:
+			capabilities = append(capabilities, handlers.FSIMCapability{Module: "fdo.upload", Files: slices.Clone(op.Upload.Files)})
+		case commandFSIMType:
+			capabilities = append(capabilities, handlers.FSIMCapability{
+				Module:   "fdo.command",
+				Commands: []string{strings.Join(append([]string{op.Command.Cmd}, op.Command.Args...), " ")},
+			})
+		case rawFSIMType:
+			raw := handlers.FSIMCapability{Module: op.Raw.Module}
+			for _, msg := range op.Raw.Messages {
+				raw.Messages = append(raw.Messages, msg.Name)
+			}
+			capabilities = append(capabilities, raw)
+		}
+	}
+	return capabilities
+}
+
+// fsimPlanStep is an FSIM operation planned for a device
+type fsimPlanStep struct {
+	Module string `json:"module"`
+	// File, URL, command or message of the operation
+	Operation string `json:"operation"`
+}
+
+// fsimPlan returns the FSIM operations ownerModules performs, in order, for
+// a device supporting modules
+func fsimPlan(modules []string, serviceInfo *ServiceInfoConfig) []fsimPlanStep {
+	plan := []fsimPlanStep{}
+	if !hasServiceInfoFsims(serviceInfo) {
+		for _, module := range flagFSIMModules() {
+			if !slices.Contains(modules, module) {
+				continue
+			}
+			for _, operation := range flagOperations(module) {
+				plan = append(plan, fsimPlanStep{Module: module, Operation: operation})
+			}
+		}
+		return plan
+	}
+	for _, op := range deviceFSIMs(modules, serviceInfo) {
+		for _, operation := range fsimOperations(op) {
+			plan = append(plan, fsimPlanStep{Module: fsimModule(op), Operation: operation})
+		}
+	}
+	return plan
+}
+
+// fsimOperations describes the operations of op, one per file, URL,
+// command or message, as performed by serviceInfoModules
+func fsimOperations(op *ServiceInfoOperation) []string {
+	var operations []string
+	switch op.FSIM {
+	case downloadFSIMType:
+		for _, file := range op.Download.Files {
+			operations = append(operations, downloadFileName(op, file))
+		}
+	case uploadFSIMType:
+		operations = slices.Clone(op.Upload.Files)
+	case wgetFSIMType:
+		for _, file := range op.Wget.Files {
+			if u, err := parseWgetURL(file.URL); err == nil {
+				operations = append(operations, capabilityURL(u))
+			}
+		}
+	case timeSyncFSIMType:
+		operations = []string{"date --utc --set @<owner time>"}
+	case commandFSIMType:
+		operations = []string{strings.Join(append([]string{op.Command.Cmd}, op.Command.Args...), " ")}
+	case rawFSIMType:
+		for _, msg := range op.Raw.Messages {
+			operations = append(operations, msg.Name)
+		}
+	}
+	return operations
+}
+
+// flagOperations describes the operations the command line flags configure
+// for module, as performed by flagModules
+func flagOperations(module string) []string {
+	switch module {
+	case "fdo.download":
+		return slices.Clone(downloads)
+	case "fdo.upload":
+		return slices.Clone(uploads)
+	case "fdo.wget":
+		var operations []string
+		for _, u := range wgetURLs {
+			operations = append(operations, capabilityURL(u))
+		}
+		return operations
+	case "fdo.command":
+		return []string{"date --utc"}
+	}
+	return nil
+}
+
+// capabilityURL returns u without user credentials, query or fragment, any
+// of which may carry secrets.
+func capabilityURL(u *url.URL) string {
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+}
+
+// timeSyncCommand returns an fdo.command module setting the device clock to
+// now, in UTC seconds since the epoch.
+func timeSyncCommand(now time.Time, params *FSIMTimeSyncParams) *fsim.RunCommand {
+	return &fsim.RunCommand{
+		Command: "date",
+		Args:    []string{"--utc", "--set", "@" + strconv.FormatInt(now.Unix(), 10)},
+		MayFail: params.MayFail,
+		Stderr:  os.Stderr,
+	}
+}
+
+// serviceInfoModules returns the owner modules of the FSIM operations of
+// serviceInfo, in order, skipping those whose module the device does not
+// support.
+func serviceInfoModules(ctx context.Context, devmod *serviceinfo.Devmod, modules []string, dbState *db.State, serviceInfo *ServiceInfoConfig) iter.Seq2[string, serviceinfo.OwnerModule] { //nolint:gocyclo
+	return func(yield func(string, serviceinfo.OwnerModule) bool) {
+		sep := deviceFileSep(devmod, serviceInfo)
+		var templateVars map[string]string
+		var downloads *downloadPrefetch
+		if serviceInfo.Strategy == parallelStrategy && slices.Contains(modules, "fdo.download") {
+			downloads = prefetchDownloads(serviceInfo.Fsims)
+			defer downloads.close()
+		}
+		for i, op := range deviceFSIMs(modules, serviceInfo) {
+			switch op.FSIM {
+			case downloadFSIMType:
+				for j, file := range op.Download.Files {
+					contents, f, err := downloads.open(i, j, file.Path)
+					if err != nil {
+						slog.Error("fdo.download: failed to open file", "path", file.Path, "err", err)
+						continue
+					}
+					if f != nil {
+						defer func() { _ = f.Close() }()
+					}
+					name := downloadFileName(op, file)
+					if file.Template {
+						if templateVars == nil {
+							if templateVars, err = downloadTemplateVars(ctx, devmod, dbState); err != nil {
+								slog.Error("fdo.download: failed to get device attributes", "path", file.Path, "err", err)
+								continue
+							}
+						}
+						if contents == nil {
+							if contents, err = io.ReadAll(f); err != nil {
+								slog.Error("fdo.download: failed to read template", "path", file.Path, "err", err)
+								continue
+							}
+						}
+						// The length and checksum sent to the device are those
+						// of the rendered contents
+						if !yield("fdo.download", &fsim.DownloadContents[*bytes.Reader]{
+							Name:         devicePath(sep, name),
+							Contents:     bytes.NewReader(renderDownloadTemplate(contents, templateVars)),
+							MustDownload: !file.MayFail,
+						}) {
+							return
+						}
+						continue
+					}
+					if contents != nil {
+						if !yield("fdo.download", &fsim.DownloadContents[*bytes.Reader]{
+							Name:         devicePath(sep, name),
+							Contents:     bytes.NewReader(contents),
+							MustDownload: !file.MayFail,
+						}) {
+							return
+						}
+						continue
+					}
+					if !yield("fdo.download", &fsim.DownloadContents[*os.File]{
+						Name:         devicePath(sep, name),
+						Contents:     f,
+						MustDownload: !file.MayFail,
+					}) {
+						return
+					}
+				}
+			case uploadFSIMType:
+				deviceUploadDir, err := getPerDeviceUploadDir(ctx, op.Upload.Dir, dbState)
+				if err != nil {
+					slog.Error("fdo.upload: failed to get per device upload directory", "err", err)
+					continue
+				}
+				for _, name := range op.Upload.Files {
+					if !yield("fdo.upload", &fsim.UploadRequest{
+						Dir:  deviceUploadDir,
+						Name: devicePath(sep, name),
+						// Store under the base name regardless of the device's separator
+						Rename: path.Base(name),
+						CreateTemp: func() (*os.File, error) {
+							return os.CreateTemp(deviceUploadDir, ".fdo-upload_*")
+						},
+					}) {
+						return
+					}
+				}
+			case wgetFSIMType:
+				for _, file := range op.Wget.Files {
+					url, err := parseWgetURL(file.URL)
+					if err != nil {
+						slog.Error("fdo.wget: invalid URL", "url", file.URL, "err", err)
+						continue
+					}
+					checksum, err := file.checksum()
+					if err != nil {
+						slog.Error("fdo.wget: invalid checksum", "url", file.URL, "err", err)
+						continue
+					}
+					name := file.Name
+					if name == "" {
+						name = path.Base(url.Path)
+					}
+					if !yield("fdo.wget", &fsim.WgetCommand{
+						Name:     devicePath(sep, name),
+						URL:      url,
+						Length:   file.Length,
+						Checksum: checksum,
+					}) {
+						return
+					}
+				}
+			case timeSyncFSIMType:
+				// The time is taken when the module starts, not when TO2 does
+				if !yield("fdo.command", timeSyncCommand(time.Now(), &op.TimeSync)) {
+					return
+				}
+			case commandFSIMType:
+				if !yield("fdo.command", runCommand(&op.Command)) {
+					return
+				}
+			case rawFSIMType:
+				module, err := newRawOwnerModule(&op.Raw)
+				if err != nil {
+					slog.Error("raw FSIM: failed to create module", "module", op.Raw.Module, "err", err)
+					continue
+				}
+				if !yield(op.Raw.Module, module) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// downloadTemplateRef matches the ${NAME} references of fdo.download
+// templates
+var downloadTemplateRef = regexp.MustCompile(`\$\{([A-Z_]+)\}`)
+
+// downloadTemplateVars returns the attributes of the onboarding device that
+// fdo.download templates may reference: its GUID, hex encoded, and the
+// serial number, device, OS and architecture reported in devmod.
+func downloadTemplateVars(ctx context.Context, devmod *serviceinfo.Devmod, dbState *db.State) (map[string]string, error) {
+	guid, err := dbState.GUID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting device GUID: %w", err)
+	}
+	vars := map[string]string{"GUID": hex.EncodeToString(guid[:])}
+	if devmod != nil {
+		vars["SERIAL"] = string(devmod.Serial)
+		vars["DEVICE"] = devmod.Device
+		vars["OS"] = devmod.Os
+		vars["ARCH"] = devmod.Arch
+	}
+	return vars, nil
+}
+
+// renderDownloadTemplate substitutes the references of contents to the
+// device attributes of vars. References to other names are left as they
+// are, so that e.g. shell scripts using their own variables can be
+// templated.
+func renderDownloadTemplate(contents []byte, vars map[string]string) []byte {
+	return downloadTemplateRef.ReplaceAllFunc(contents, func(ref []byte) []byte {
+		if value, ok := vars[string(ref[2:len(ref)-1])]; ok {
+			return []byte(value)
+		}
+		return ref
+	})
+}
+
+// openDownloadSource opens an fdo.download source on the owner server
+var openDownloadSource = os.Open
+
+// maxPrefetchedDownloadSize is the size of the largest fdo.download source
+// read ahead by the parallel strategy. Larger sources are only opened ahead,
+// then read as they are sent, not to hold them in memory.
+const maxPrefetchedDownloadSize = 16 << 20
+
+// prefetchedDownload is an fdo.download source being read in the background
+type prefetchedDownload struct {
+	ready    chan struct{} // closed once contents or file, and err are set
+	contents []byte        // the source read ahead, if small enough
+	file     *os.File      // the source opened ahead, if too large to read
+	err      error
+	taken    bool
+}
+
+// downloadPrefetch holds the fdo.download sources of a list of FSIM
+// operations, read concurrently so that slow sources do not delay
+// onboarding one after another
+type downloadPrefetch struct {
+	files [][]*prefetchedDownload // indexed by operation, then file
+}
+
+// prefetchDownloads starts reading the fdo.download sources of fsims
+func prefetchDownloads(fsims []ServiceInfoOperation) *downloadPrefetch {
+	p := &downloadPrefetch{files: make([][]*prefetchedDownload, len(fsims))}
+	for i, op := range fsims {
+		if op.FSIM != downloadFSIMType {
+			continue
+		}
+		p.files[i] = make([]*prefetchedDownload, len(op.Download.Files))
+		for j, file := range op.Download.Files {
+			download := &prefetchedDownload{ready: make(chan struct{})}
+			p.files[i][j] = download
+			go func() {
+				defer close(download.ready)
+				download.contents, download.file, download.err = readDownloadSource(file.Path)
+			}()
+		}
+	}
+	return p
+}
+
+// readDownloadSource reads an fdo.download source of at most
+// maxPrefetchedDownloadSize bytes, or returns it opened if it is larger
+func readDownloadSource(path string) ([]byte, *os.File, error) {
+	f, err := openDownloadSource(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	if info.Size() > maxPrefetchedDownloadSize {
+		return nil, f, nil
+	}
+	defer func() { _ = f.Close() }()
+	contents, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	return contents, nil, nil
+}
+
+// open returns the j-th download source of the i-th operation, either its
+// contents if read ahead or the opened file, waiting for it to be read if
+// prefetched, or opening it now if not. A nil prefetch always opens the
+// source now.
+func (p *downloadPrefetch) open(i, j int, path string) ([]byte, *os.File, error) {
+	if p == nil || i >= len(p.files) || j >= len(p.files[i]) {
+		f, err := openDownloadSource(path)
+		return nil, f, err
+	}
+	download := p.files[i][j]
+	<-download.ready
+	download.taken = true
+	return download.contents, download.file, download.err
+}
+
+// close closes the prefetched sources that were never handed out, e.g.
+// when onboarding stops early
+func (p *downloadPrefetch) close() {
+	for _, files := range p.files {
+		for _, download := range files {
+			<-download.ready
+			if !download.taken && download.file != nil {
+				_ = download.file.Close()
+			}
+		}
+	}
+}
+
+// runCommand returns the fdo.command module running a configured command
+func runCommand(params *FSIMCommandParams) *fsim.RunCommand {
+	cmd := &fsim.RunCommand{
+		Command: params.Cmd,
+		Args:    slices.Clone(params.Args),
+		MayFail: params.MayFail,
+	}
+	if params.ReturnStdout {
+		cmd.Stdout = os.Stdout
+	}
+	if params.ReturnStderr {
+		cmd.Stderr = os.Stderr
+	}
+	return cmd
+}
+
+// fsimSourcesCheckInterval is how often fsimSourcesCheck checks the FSIM
+// sources in the background
+const fsimSourcesCheckInterval = 30 * time.Second
+
+// errFSIMSourcesPending is the readiness of the FSIM sources until they are
+// first checked
+var errFSIMSourcesPending = errors.New("FSIM sources not checked yet")
+
+// fsimSourcesCheck is a readiness check verifying that the files sent with
+// fdo.download, by flag or configuration, are readable and, with
+// checkURLs, that the fdo.wget URLs respond. Devices would otherwise fail
+// TO2 when retrieving them. The sources are checked every interval in the
+// background until stop is called, readiness probes reporting the result
+// of the last check rather than waiting on slow or unreachable URLs.
+func fsimSourcesCheck(serviceInfo *atomic.Pointer[ServiceInfoConfig], checkURLs bool, interval time.Duration) (check handlers.ReadinessCheck, stop func()) {
+	var result atomic.Pointer[error]
+	result.Store(&errFSIMSourcesPending)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			err := checkFSIMSources(ctx, serviceInfo.Load(), checkURLs)
+			if ctx.Err() != nil {
+				return
+			}
+			result.Store(&err)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	check = handlers.ReadinessCheck{
+		Name: "fsim_sources",
+		Check: func(context.Context) error {
+			return *result.Load()
+		},
+	}
+	return check, func() {
+		cancel()
+		<-done
+	}
+}
+
+// checkFSIMSources returns the errors of the FSIM sources checked by
+// fsimSourcesCheck. As they are reported by the unauthenticated readiness
+// endpoint, download files are named by their base name and URLs by
+// capabilityURL.
+func checkFSIMSources(ctx context.Context, serviceInfo *ServiceInfoConfig, checkURLs bool) error {
+	var errs []error
+	paths := slices.Clone(downloadPaths)
+	if serviceInfo != nil {
+		paths = append(paths, serviceInfo.DownloadPaths()...)
+	}
+	for _, downloadPath := range paths {
+		file, err := os.Open(downloadPath)
+		if err != nil {
+			var pathErr *os.PathError
+			if errors.As(err, &pathErr) {
+				err = pathErr.Err
+			}
+			errs = append(errs, fmt.Errorf("fdo.download: %s: %w", filepath.Base(downloadPath), err))
+			continue
+		}
+		_ = file.Close()
+	}
+	if checkURLs {
+		errs = append(errs, checkWgetURLs(ctx, wgetSourceURLs(serviceInfo), 5*time.Second)...)
+	}
+	return errors.Join(errs...)
+}
+
+// wgetSourceURLs returns the distinct URLs retrieved by devices with
+// fdo.wget, from the command line and the service_info configuration
+func wgetSourceURLs(serviceInfo *ServiceInfoConfig) []string {
+	var urls []string
+	for _, u := range wgetURLs {
+		urls = append(urls, u.String())
+	}
+	if serviceInfo != nil {
+		urls = append(urls, serviceInfo.WgetURLs()...)
+	}
+	slices.Sort(urls)
+	return slices.Compact(urls)
+}
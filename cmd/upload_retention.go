@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Longest time between two upload cleanup passes
+const maxUploadCleanupInterval = time.Hour
+
+// Owner policy for files uploaded by devices
+type OwnerUploadConfig struct {
+	// Delete uploaded files older than this, zero keeps them forever
+	Retention time.Duration `mapstructure:"retention"`
+}
+
+func (u *OwnerUploadConfig) validate() error {
+	if u.Retention < 0 {
+		return errors.New("owner.upload.retention cannot be negative")
+	}
+	return nil
+}
+
+// removeExpiredUploads deletes the regular files below dir that were last
+// modified before now minus retention. Directories are left in place.
+func removeExpiredUploads(dir string, retention time.Duration, now time.Time) error {
+	cutoff := now.Add(-retention)
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !info.ModTime().Before(cutoff) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("upload cleanup: failed to remove file", "path", path, "err", err)
+			return nil
+		}
+		slog.Info("upload cleanup: removed expired file", "path", path, "size", info.Size(), "modified", info.ModTime())
+		return nil
+	})
+}
+
+// startUploadCleanup periodically removes uploads older than retention from
+// dir until the returned function is called.
+func startUploadCleanup(dir string, retention time.Duration) (stop func()) {
+	interval := min(retention, maxUploadCleanupInterval)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := removeExpiredUploads(dir, retention, time.Now()); err != nil {
+				slog.Warn("upload cleanup failed", "dir", dir, "err", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestRemoveExpiredUploads(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "device1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	files := map[string]time.Duration{
+		"old.log":         48 * time.Hour,
+		"device1/old.bin": 25 * time.Hour,
+		"device1/new.bin": 23 * time.Hour,
+		"new.log":         time.Minute,
+	}
+	for name, age := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(-age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := removeExpiredUploads(dir, 24*time.Hour, now); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, age := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		switch {
+		case age > 24*time.Hour && !errors.Is(err, fs.ErrNotExist):
+			t.Errorf("expected %s to be removed, got err=%v", name, err)
+		case age < 24*time.Hour && err != nil:
+			t.Errorf("expected %s to remain: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "device1")); err != nil {
+		t.Errorf("expected device directory to remain: %v", err)
+	}
+}
+
+func TestOwnerUploadRetentionConfig(t *testing.T) {
+	resetState(t)
+
+	path := writeYAMLConfig(t, "owner:\n  upload:\n    retention: \"720h\"\n")
+	viper.SetConfigFile(path)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	var config OwnerServerConfig
+	if err := viper.Unmarshal(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Owner.Upload.Retention != 30*24*time.Hour {
+		t.Fatalf("expected retention of 720h, got %v", config.Owner.Upload.Retention)
+	}
+
+	negative := OwnerUploadConfig{Retention: -time.Hour}
+	if err := negative.validate(); err == nil {
+		t.Fatal("expected negative retention to be rejected")
+	}
+}
@@ -0,0 +1,231 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Configuration file structure checked by the validate-config command
+type ValidateConfig struct {
+	FDOServerConfig `mapstructure:",squash"`
+	ServiceInfo     ServiceInfoConfig `mapstructure:"service_info"`
+	// Sections whose key files are checked against a server's capabilities
+	Owner         OwnerConfig         `mapstructure:"owner"`
+	Manufacturing ManufacturingConfig `mapstructure:"manufacturing"`
+	DeviceCA      DeviceCAConfig      `mapstructure:"device_ca"`
+}
+
+var (
+	// validate-config command line flags
+	checkURLs       bool
+	strictURLCheck  bool
+	urlCheckTimeout time.Duration
+	againstServer   string
+)
+
+// validateConfigCmd represents the validate-config command
+var validateConfigCmd = &cobra.Command{
+	Use:   "validate-config",
+	Short: "Validate a server configuration file without starting a server",
+	Long: `Validate a server configuration file without starting a server. The
+database, HTTP, API and service_info settings are checked, including the
+FSIM parameters and the files and directories they refer to, and every
+problem found is reported. The command fails if any problem is found.
+
+With --against-server, the TLS cipher suites and the key types of the key
+files of the configuration are also checked against the capabilities of a
+running server, e.g. before deploying the configuration to it.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var config ValidateConfig
+		if err := viper.Unmarshal(&config); err != nil {
+			return fmt.Errorf("failed to unmarshal config: %w", err)
+		}
+		problems := config.problems()
+
+		if checkURLs {
+			failures := checkWgetURLs(cmd.Context(), config.ServiceInfo.WgetURLs(), urlCheckTimeout)
+			for _, err := range failures {
+				cmd.Printf("WARNING: %v\n", err)
+			}
+			if strictURLCheck && len(failures) > 0 {
+				problems = append(problems, fmt.Errorf("%d wget URL(s) are not reachable", len(failures)))
+			}
+		}
+
+		if againstServer != "" {
+			problems = append(problems, config.serverProblems(againstServer, urlCheckTimeout)...)
+		}
+
+		if len(problems) > 0 {
+			cmd.Printf("Configuration has %d problem(s):\n", len(problems))
+			for _, problem := range problems {
+				cmd.Printf("  - %v\n", problem)
+			}
+			return fmt.Errorf("invalid configuration: %d problem(s) found", len(problems))
+		}
+		cmd.Println("Configuration is valid")
+		return nil
+	},
+}
+
+// problems returns every problem found in the configuration
+func (v *ValidateConfig) problems() []error {
+	var problems []error
+	for _, validate := range []func() error{v.DB.validate, v.HTTP.validate, v.API.validate} {
+		if err := validate(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return append(problems, v.ServiceInfo.problems()...)
+}
+
+// serverProblems returns the settings of the configuration not supported
+// by the build of the server at serverURL: TLS cipher suites and the key
+// types of the key files
+func (v *ValidateConfig) serverProblems(serverURL string, timeout time.Duration) []error {
+	capabilities, err := fetchCapabilities(serverURL, timeout)
+	if err != nil {
+		return []error{err}
+	}
+	var problems []error
+	for _, suite := range v.HTTP.TLS.CipherSuites {
+		if !slices.Contains(capabilities.CipherSuites, suite) {
+			problems = append(problems, fmt.Errorf("http.tls.cipher_suites: %q is not supported by %s", suite, serverURL))
+		}
+	}
+	for _, key := range v.keyFiles() {
+		signer, err := parsePrivateKey(key.path)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: cannot read key %q: %w", key.setting, key.path, err))
+			continue
+		}
+		keyType, err := getPrivateKeyType(signer)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: key %q: %w", key.setting, key.path, err))
+			continue
+		}
+		if !slices.ContainsFunc(capabilities.KeyTypes, func(name string) bool {
+			supported, err := protocol.ParseKeyType(name)
+			return err == nil && supported == keyType
+		}) {
+			problems = append(problems, fmt.Errorf("%s: key type %s of %q is not supported by %s", key.setting, keyType, key.path, serverURL))
+		}
+	}
+	return problems
+}
+
+// A private key file of the configuration and the setting naming it
+type configKeyFile struct {
+	setting string
+	path    string
+}
+
+// keyFiles returns the private key files of the configuration. Keys held
+// by PKCS#11 tokens are not included.
+func (v *ValidateConfig) keyFiles() []configKeyFile {
+	var keys []configKeyFile
+	add := func(setting, path string) {
+		if path != "" {
+			keys = append(keys, configKeyFile{setting: setting, path: path})
+		}
+	}
+	add("owner.key", v.Owner.OwnerPrivateKey)
+	for i, key := range v.Owner.AdditionalKeys {
+		add(fmt.Sprintf("owner.additional_keys[%d].key", i), key.Key)
+	}
+	add("manufacturing.key", v.Manufacturing.ManufacturerKeyPath)
+	for i, key := range v.Manufacturing.AdditionalKeys {
+		add(fmt.Sprintf("manufacturing.additional_keys[%d].key", i), key.Key)
+	}
+	add("device_ca.key", v.DeviceCA.KeyPath)
+	return keys
+}
+
+// fetchCapabilities retrieves the capabilities of the server at serverURL
+func fetchCapabilities(serverURL string, timeout time.Duration) (*handlers.CapabilitiesResponse, error) {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(strings.TrimSuffix(serverURL, "/") + "/api/v1/capabilities")
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the capabilities of %s: %w", serverURL, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot get the capabilities of %s: status %s", serverURL, resp.Status)
+	}
+	var capabilities handlers.CapabilitiesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&capabilities); err != nil {
+		return nil, fmt.Errorf("invalid capabilities of %s: %w", serverURL, err)
+	}
+	return &capabilities, nil
+}
+
+// checkWgetURLs issues a HEAD request to each URL and returns an error for
+// each URL that cannot be reached or responds with an error status. URLs are
+// named by capabilityURL, as user credentials or query parameters may carry
+// secrets.
+func checkWgetURLs(ctx context.Context, urls []string, timeout time.Duration) []error {
+	client := &http.Client{Timeout: timeout}
+	var failures []error
+	for _, u := range urls {
+		if err := checkWgetURL(ctx, client, u); err != nil {
+			failures = append(failures, err)
+		}
+	}
+	return failures
+}
+
+func checkWgetURL(ctx context.Context, client *http.Client, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("wget URL is invalid: %w", err)
+	}
+	name := capabilityURL(u)
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("wget URL %q is invalid: %w", name, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error of the client names the URL in full
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("wget URL %q is not reachable: %w", name, err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("wget URL %q returned status %s", name, resp.Status)
+	}
+	return nil
+}
+
+// Set up the validate-config command line. Used by the unit tests to reset state between tests.
+func validateConfigCmdInit() {
+	rootCmd.AddCommand(validateConfigCmd)
+
+	validateConfigCmd.Flags().BoolVar(&checkURLs, "check-urls", false, "Check that configured fdo.wget URLs are reachable")
+	validateConfigCmd.Flags().BoolVar(&strictURLCheck, "strict", false, "Fail validation if a URL check fails")
+	validateConfigCmd.Flags().DurationVar(&urlCheckTimeout, "url-timeout", 5*time.Second, "Timeout for each URL check and the --against-server request")
+	validateConfigCmd.Flags().StringVar(&againstServer, "against-server", "", "Check that the server at `url` supports the key types and TLS cipher suites of the configuration")
+}
+
+func init() {
+	validateConfigCmdInit()
+}
@@ -0,0 +1,334 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// wgetURLServers returns a URL served by a running test server and a URL
+// whose server has been shut down.
+func wgetURLServers(t *testing.T) (reachable, unreachable string) {
+	t.Helper()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("expected HEAD request, got %s", r.Method)
+		}
+	}))
+	t.Cleanup(up.Close)
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	return up.URL + "/image.bin", down.URL + "/image.bin"
+}
+
+func writeWgetConfig(t *testing.T, urls ...string) string {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("db:\n  type: \"sqlite\"\n  dsn: \"file:test.db\"\n")
+	b.WriteString("http:\n  ip: \"127.0.0.1\"\n  port: \"8043\"\n")
+	b.WriteString("service_info:\n  fsims:\n    - fsim: \"fdo.wget\"\n      wget:\n        files:\n")
+	for _, u := range urls {
+		fmt.Fprintf(&b, "          - url: %q\n", u)
+	}
+	return writeYAMLConfig(t, b.String())
+}
+
+func TestCheckWgetURLs(t *testing.T) {
+	reachable, unreachable := wgetURLServers(t)
+
+	failures := checkWgetURLs(context.Background(), []string{reachable, unreachable}, time.Second)
+	if len(failures) != 1 {
+		t.Fatalf("expected 1 failure, got %d: %v", len(failures), failures)
+	}
+	if !strings.Contains(failures[0].Error(), unreachable) {
+		t.Fatalf("expected failure for %q, got %v", unreachable, failures[0])
+	}
+}
+
+func TestValidateConfig_CheckURLs(t *testing.T) {
+	reachable, unreachable := wgetURLServers(t)
+
+	tests := []struct {
+		name    string
+		args    []string
+		urls    []string
+		wantErr bool
+		wantOut string
+	}{
+		{
+			name:    "reachable URL",
+			args:    []string{"--check-urls"},
+			urls:    []string{reachable},
+			wantOut: "Configuration is valid",
+		},
+		{
+			name:    "unreachable URL warns",
+			args:    []string{"--check-urls"},
+			urls:    []string{reachable, unreachable},
+			wantOut: "WARNING",
+		},
+		{
+			name:    "unreachable URL fails in strict mode",
+			args:    []string{"--check-urls", "--strict"},
+			urls:    []string{reachable, unreachable},
+			wantErr: true,
+		},
+		{
+			name:    "URLs not checked by default",
+			urls:    []string{unreachable},
+			wantOut: "Configuration is valid",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetState(t)
+			path := writeWgetConfig(t, tt.urls...)
+
+			var out bytes.Buffer
+			rootCmd.SetOut(&out)
+			t.Cleanup(func() { rootCmd.SetOut(nil) })
+			rootCmd.SetArgs(append([]string{"validate-config", "--config", path, "--url-timeout", "1s"}, tt.args...))
+
+			err := rootCmd.Execute()
+			if tt.wantErr && err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(out.String(), tt.wantOut) {
+				t.Fatalf("expected output to contain %q, got %q", tt.wantOut, out.String())
+			}
+		})
+	}
+}
+
+func TestValidateConfig_Report(t *testing.T) {
+	download := filepath.Join(t.TempDir(), "payload.bin")
+	if err := os.WriteFile(download, []byte("payload"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	uploadDir := t.TempDir()
+	const base = "db:\n  type: \"sqlite\"\n  dsn: \"file:test.db\"\nhttp:\n  ip: \"127.0.0.1\"\n  port: \"8043\"\n"
+
+	tests := []struct {
+		name   string
+		config string
+		want   []string // expected problems, none for a valid configuration
+	}{
+		{
+			name: "valid",
+			config: base + fmt.Sprintf(`service_info:
+  fsims:
+    - fsim: "fdo.download"
+      download:
+        files:
+          - path: %q
+    - fsim: "fdo.upload"
+      upload:
+        dir: %q
+        files: ["log.txt"]
+`, download, uploadDir),
+		},
+		{
+			name:   "database",
+			config: "db:\n  type: \"mysql\"\n  dsn: \"file:test.db\"\nhttp:\n  ip: \"127.0.0.1\"\n  port: \"8043\"\n",
+			want:   []string{"unsupported database type: mysql"},
+		},
+		{
+			name:   "http",
+			config: "db:\n  dsn: \"file:test.db\"\nhttp:\n  ip: \"127.0.0.1\"\n  port: \"70000\"\n",
+			want:   []string{`the server's HTTP port "70000" must be a number between 1 and 65535`},
+		},
+		{
+			name:   "api",
+			config: base + "api:\n  timeout: \"-1s\"\n",
+			want:   []string{"api.timeout must not be negative"},
+		},
+		{
+			name:   "fsim parameters",
+			config: base + "service_info:\n  fsims:\n    - fsim: \"raw\"\n      raw:\n        module: \"nodot\"\n        messages: [{name: \"active\", value: \"9Q==\"}]\n",
+			want:   []string{`service_info.fsims[0]: invalid raw module name "nodot"`},
+		},
+		{
+			name:   "missing download file",
+			config: base + "service_info:\n  fsims:\n    - fsim: \"fdo.download\"\n      download:\n        files:\n          - path: \"/no/such/file\"\n",
+			want:   []string{`service_info.fsims[0]: cannot access download file "/no/such/file"`},
+		},
+		{
+			name:   "missing upload directory",
+			config: base + "service_info:\n  fsims:\n    - fsim: \"fdo.upload\"\n      upload:\n        dir: \"/no/such/dir\"\n        files: [\"log.txt\"]\n",
+			want:   []string{`service_info.fsims[0]: cannot access upload directory "/no/such/dir"`},
+		},
+		{
+			name:   "overrides",
+			config: base + "service_info:\n  overrides:\n    - guids: [\"not-a-guid\"]\n",
+			want:   []string{`service_info.overrides[0]: invalid GUID "not-a-guid"`},
+		},
+		{
+			name: "every problem reported",
+			config: "db:\n  dsn: \"file:test.db\"\nhttp:\n  ip: \"127.0.0.1\"\n  port: \"0\"\n" +
+				"service_info:\n  strategy: \"random\"\n  fsims:\n    - fsim: \"fdo.command\"\n      command: {}\n    - fsim: \"fdo.unknown\"\n",
+			want: []string{
+				`the server's HTTP port "0"`,
+				`service_info.strategy must be "sequential" or "parallel", got "random"`,
+				"service_info.fsims[0]: fdo.command requires a command",
+				`service_info.fsims[1]: unsupported fsim type "fdo.unknown"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetState(t)
+			path := writeYAMLConfig(t, tt.config)
+
+			var out bytes.Buffer
+			rootCmd.SetOut(&out)
+			t.Cleanup(func() { rootCmd.SetOut(nil) })
+			rootCmd.SetArgs([]string{"validate-config", "--config", path})
+
+			err := rootCmd.Execute()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v\n%s", err, out.String())
+				}
+				if !strings.Contains(out.String(), "Configuration is valid") {
+					t.Fatalf("expected the configuration to be reported valid, got %q", out.String())
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error, got nil\n%s", out.String())
+			}
+			if want := fmt.Sprintf("Configuration has %d problem(s):", len(tt.want)); !strings.Contains(out.String(), want) {
+				t.Fatalf("expected output to contain %q, got %q", want, out.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), "  - "+want) {
+					t.Errorf("expected output to report %q, got %q", want, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestValidateConfig_AgainstServer(t *testing.T) {
+	// A server build without P-256 keys and with a single cipher suite
+	capabilities := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/capabilities" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(handlers.CapabilitiesResponse{
+			KeyTypes:     []string{"SECP384R1", "RSAPKCS"},
+			CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+		})
+	}))
+	defer capabilities.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	writeKey := func(curve elliptic.Curve) string {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "key.der")
+		if err := os.WriteFile(path, der, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	p256, p384 := writeKey(elliptic.P256()), writeKey(elliptic.P384())
+	const base = "db:\n  type: \"sqlite\"\n  dsn: \"file:test.db\"\nhttp:\n  ip: \"127.0.0.1\"\n  port: \"8043\"\n"
+
+	tests := []struct {
+		name   string
+		server string
+		config string
+		want   []string
+	}{
+		{
+			name:   "supported",
+			server: capabilities.URL,
+			config: base + "  tls:\n    cipher_suites: [\"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384\"]\n" +
+				fmt.Sprintf("owner:\n  key: %q\ndevice_ca:\n  key: %q\n", p384, p384),
+		},
+		{
+			name:   "unsupported",
+			server: capabilities.URL + "/",
+			config: base + "  tls:\n    cipher_suites: [\"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384\", \"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256\"]\n" +
+				fmt.Sprintf("manufacturing:\n  key: %q\n  additional_keys:\n    - key: %q\n", p384, p256),
+			want: []string{
+				`http.tls.cipher_suites: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" is not supported by ` + capabilities.URL,
+				fmt.Sprintf("manufacturing.additional_keys[0].key: key type %s of %q is not supported", protocol.Secp256r1KeyType, p256),
+			},
+		},
+		{
+			name:   "unreadable key",
+			server: capabilities.URL,
+			config: base + "owner:\n  key: \"/no/such/key\"\n",
+			want:   []string{`owner.key: cannot read key "/no/such/key"`},
+		},
+		{
+			name:   "server unreachable",
+			server: down.URL,
+			config: base,
+			want:   []string{"cannot get the capabilities of " + down.URL},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetState(t)
+			path := writeYAMLConfig(t, tt.config)
+
+			var out bytes.Buffer
+			rootCmd.SetOut(&out)
+			t.Cleanup(func() { rootCmd.SetOut(nil) })
+			rootCmd.SetArgs([]string{"validate-config", "--config", path, "--against-server", tt.server, "--url-timeout", "1s"})
+
+			err := rootCmd.Execute()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v\n%s", err, out.String())
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error, got nil\n%s", out.String())
+			}
+			if want := fmt.Sprintf("Configuration has %d problem(s):", len(tt.want)); !strings.Contains(out.String(), want) {
+				t.Fatalf("expected output to contain %q, got %q", want, out.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), "  - "+want) {
+					t.Errorf("expected output to report %q, got %q", want, out.String())
+				}
+			}
+		})
+	}
+}
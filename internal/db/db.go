@@ -4,6 +4,9 @@
 package db
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -11,7 +14,9 @@ import (
 	"math"
 	"net"
 	"strconv"
+	"time"
 
+	"github.com/fido-device-onboard/go-fdo"
 	"github.com/fido-device-onboard/go-fdo/cbor"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 	"gorm.io/gorm"
@@ -31,11 +36,11 @@ var (
 // - "guid" (expects []byte)
 // - "device_info" (expects string)
 // If more than one voucher matches, an error is returned.
-func FetchVoucher(filters map[string]interface{}) (*Voucher, error) {
+func FetchVoucher(ctx context.Context, filters map[string]interface{}) (*Voucher, error) {
 	if len(filters) == 0 {
 		return nil, fmt.Errorf("no filters provided")
 	}
-	list, err := QueryVouchers(filters, true)
+	list, err := queryVouchers(db.WithContext(ctx), filters, true)
 	if err != nil {
 		return nil, err
 	}
@@ -50,9 +55,14 @@ func FetchVoucher(filters map[string]interface{}) (*Voucher, error) {
 
 // QueryVouchers returns owner vouchers matching optional filters.
 // If includeCBOR is true, the CBOR column is selected and populated.
-// Results are ordered by updated_at DESC.
-func QueryVouchers(filters map[string]interface{}, includeCBOR bool) ([]Voucher, error) {
-	query := db.Model(&Voucher{})
+// Results are ordered by updated_at DESC. The query is served by the read
+// replica, if configured.
+func QueryVouchers(ctx context.Context, filters map[string]interface{}, includeCBOR bool) ([]Voucher, error) {
+	return queryVouchers(readDB().WithContext(ctx), filters, includeCBOR)
+}
+
+func queryVouchers(conn *gorm.DB, filters map[string]interface{}, includeCBOR bool) ([]Voucher, error) {
+	query := conn.Model(&Voucher{})
 
 	// Apply filters
 	if v, ok := filters["guid"]; ok {
@@ -86,17 +96,139 @@ func QueryVouchers(filters map[string]interface{}, includeCBOR bool) ([]Voucher,
 	return list, nil
 }
 
+// QueryArchivedVouchers returns the vouchers archived on deletion, most
+// recently archived first. If includeCBOR is true, the CBOR column is
+// selected and populated.
+func QueryArchivedVouchers(ctx context.Context, includeCBOR bool) ([]ArchivedVoucher, error) {
+	query := db.WithContext(ctx).Model(&ArchivedVoucher{}).Order("archived_at DESC")
+	if !includeCBOR {
+		query = query.Omit("cbor")
+	}
+	var list []ArchivedVoucher
+	if err := query.Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// FetchArchivedVoucher returns the archived voucher of a GUID, or
+// gorm.ErrRecordNotFound.
+func FetchArchivedVoucher(ctx context.Context, guid []byte) (*ArchivedVoucher, error) {
+	var voucher ArchivedVoucher
+	if err := db.WithContext(ctx).Where("guid = ?", guid).First(&voucher).Error; err != nil {
+		return nil, err
+	}
+	return &voucher, nil
+}
+
 func InsertVoucher(voucher Voucher) error {
-	return db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Create(&voucher).Error; err != nil {
-			return err
-		}
-		// Ensure onboarding tracking row exists atomically with voucher insert
-		rec := DeviceOnboarding{GUID: voucher.GUID}
-		return tx.Where("guid = ?", voucher.GUID).FirstOrCreate(&rec).Error
+	return insertVoucher(voucher, false)
+}
+
+// InsertQuarantinedVoucher inserts a voucher whose device may not onboard
+// until it is approved with ApproveVoucher.
+func InsertQuarantinedVoucher(voucher Voucher) error {
+	return insertVoucher(voucher, true)
+}
+
+func insertVoucher(voucher Voucher, quarantine bool) error {
+	return withRetry(context.Background(), func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&voucher).Error; err != nil {
+				return err
+			}
+			if quarantine {
+				if err := tx.Create(&VoucherQuarantine{GUID: voucher.GUID}).Error; err != nil {
+					return err
+				}
+			}
+			// Ensure onboarding tracking row exists atomically with voucher insert
+			rec := DeviceOnboarding{GUID: voucher.GUID}
+			return tx.Where("guid = ?", voucher.GUID).FirstOrCreate(&rec).Error
+		})
+	})
+}
+
+// IsVoucherQuarantined returns whether the device of a voucher is waiting
+// for approval before it may onboard. The primary database is queried so
+// that an approval is seen immediately.
+func IsVoucherQuarantined(ctx context.Context, guid []byte) (bool, error) {
+	var count int64
+	if err := db.WithContext(ctx).Model(&VoucherQuarantine{}).Where("guid = ?", guid).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// IsDeviceOnboarded returns whether the device of the voucher with the given
+// GUID already completed TO2, either keeping the GUID or receiving it as the
+// replacement of a previous one.
+func IsDeviceOnboarded(ctx context.Context, guid []byte) (bool, error) {
+	var count int64
+	if err := db.WithContext(ctx).Model(&DeviceOnboarding{}).
+		Where("to2_completed = ? AND (guid = ? OR new_guid = ?)", true, guid, guid).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// voucherExpired reports whether a voucher created at createdAt is expired,
+// its device not having completed TO2 within expiry. An expiry of 0 disables
+// expiry.
+func voucherExpired(createdAt time.Time, to2Completed bool, expiry time.Duration) bool {
+	return expiry > 0 && !to2Completed && time.Since(createdAt) > expiry
+}
+
+// IsVoucherExpired returns whether the voucher with the given GUID is older
+// than expiry without its device having completed TO2. An expiry of 0
+// disables expiry. Returns gorm.ErrRecordNotFound if there is no such
+// voucher.
+func IsVoucherExpired(ctx context.Context, guid []byte, expiry time.Duration) (bool, error) {
+	var row struct {
+		CreatedAt    time.Time
+		TO2Completed sql.NullBool
+	}
+	err := db.WithContext(ctx).Table("vouchers").
+		Select("vouchers.created_at, device_onboarding.to2_completed").
+		Joins("LEFT JOIN device_onboarding ON device_onboarding.new_guid = vouchers.guid").
+		Where("vouchers.guid = ?", guid).
+		Take(&row).Error
+	if err != nil {
+		return false, err
+	}
+	return voucherExpired(row.CreatedAt, row.TO2Completed.Bool, expiry), nil
+}
+
+// ApproveVoucher releases the voucher with the given GUID from quarantine,
+// allowing its device to onboard. Approving a voucher which is not
+// quarantined has no effect. Returns gorm.ErrRecordNotFound if there is no
+// such voucher.
+func ApproveVoucher(ctx context.Context, guid []byte) error {
+	return withRetry(ctx, func() error {
+		return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Select("guid").Where("guid = ?", guid).First(&Voucher{}).Error; err != nil {
+				return err
+			}
+			return tx.Where("guid = ?", guid).Delete(&VoucherQuarantine{}).Error
+		})
 	})
 }
 
+// CountVouchers returns the number of vouchers created at or after since. A
+// zero since counts all vouchers.
+func CountVouchers(ctx context.Context, since time.Time) (int64, error) {
+	query := db.WithContext(ctx).Model(&Voucher{})
+	if !since.IsZero() {
+		query = query.Where("created_at >= ?", since)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 // IsTO2Completed returns whether a device has completed TO2.
 func IsTO2Completed(guid []byte) (bool, error) {
 	var rec DeviceOnboarding
@@ -128,9 +260,37 @@ func ListPendingTO0Vouchers(includeCBOR bool) ([]Voucher, error) {
 	return list, nil
 }
 
+// SetTO0Registered records that the voucher with the given GUID has been
+// registered with a rendezvous server by TO0.
+func SetTO0Registered(ctx context.Context, guid []byte) error {
+	now := time.Now()
+	return withRetry(ctx, func() error {
+		return db.WithContext(ctx).Where(DeviceOnboarding{GUID: guid}).
+			Assign(DeviceOnboarding{TO0RegisteredAt: &now}).
+			FirstOrCreate(&DeviceOnboarding{}).Error
+	})
+}
+
+// ListUnregisteredVouchers returns the vouchers inserted before the given
+// time whose devices have not completed TO2 and which were never registered
+// with a rendezvous server by TO0. The query is served by the read replica,
+// if configured.
+func ListUnregisteredVouchers(ctx context.Context, insertedBefore time.Time) ([]Voucher, error) {
+	var list []Voucher
+	if err := readDB().WithContext(ctx).Model(&Voucher{}).Select("vouchers.*").
+		Joins("LEFT JOIN device_onboarding ON device_onboarding.guid = vouchers.guid").
+		Where("device_onboarding.to2_completed IS NOT TRUE").
+		Where("device_onboarding.to0_registered_at IS NULL").
+		Where("vouchers.created_at <= ?", insertedBefore).
+		Order("vouchers.created_at").
+		Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
 func InsertOwnerInfo(data []byte) error {
-	// check the data can be parsed into []protocol.RvTO2Addr
-	if _, err := parseHumanToTO2AddrsJSON(data); err != nil {
+	if err := validateOwnerInfo(data); err != nil {
 		return fmt.Errorf("%w: %v", ErrInvalidOwnerInfo, err)
 	}
 
@@ -138,9 +298,12 @@ func InsertOwnerInfo(data []byte) error {
 		ID:    1,
 		Value: data,
 	}
-	tx := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&ownerInfo)
-	if tx.Error != nil {
+	var tx *gorm.DB
+	if err := withRetry(context.Background(), func() error {
+		tx = db.Clauses(clause.OnConflict{DoNothing: true}).Create(&ownerInfo)
 		return tx.Error
+	}); err != nil {
+		return err
 	}
 	if tx.RowsAffected == 0 {
 		return gorm.ErrDuplicatedKey
@@ -149,14 +312,16 @@ func InsertOwnerInfo(data []byte) error {
 }
 
 func UpdateOwnerInfo(data []byte) error {
-	// check the data can be parsed into []protocol.RvTO2Addr
-	if _, err := parseHumanToTO2AddrsJSON(data); err != nil {
+	if err := validateOwnerInfo(data); err != nil {
 		return fmt.Errorf("%w: %v", ErrInvalidOwnerInfo, err)
 	}
 
-	tx := db.Model(&OwnerInfo{}).Where("id = ?", 1).Update("value", data)
-	if tx.Error != nil {
+	var tx *gorm.DB
+	if err := withRetry(context.Background(), func() error {
+		tx = db.Model(&OwnerInfo{}).Where("id = ?", 1).Update("value", data)
 		return tx.Error
+	}); err != nil {
+		return err
 	}
 	if tx.RowsAffected == 0 {
 		return gorm.ErrRecordNotFound
@@ -183,8 +348,7 @@ func FetchOwnerInfo() ([]protocol.RvTO2Addr, error) {
 }
 
 func InsertRvInfo(data []byte) error {
-	// check the data can be parsed into [][]protocol.RvInstruction
-	if _, err := parseHumanReadableRvJSON(data); err != nil {
+	if err := validateRvInfo(data); err != nil {
 		return fmt.Errorf("%w: %v", ErrInvalidRvInfo, err)
 	}
 
@@ -192,9 +356,12 @@ func InsertRvInfo(data []byte) error {
 		ID:    1,
 		Value: data,
 	}
-	tx := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&rvInfo)
-	if tx.Error != nil {
+	var tx *gorm.DB
+	if err := withRetry(context.Background(), func() error {
+		tx = db.Clauses(clause.OnConflict{DoNothing: true}).Create(&rvInfo)
 		return tx.Error
+	}); err != nil {
+		return err
 	}
 	if tx.RowsAffected == 0 {
 		return gorm.ErrDuplicatedKey
@@ -203,14 +370,118 @@ func InsertRvInfo(data []byte) error {
 }
 
 func UpdateRvInfo(data []byte) error {
-	// check the data can be parsed into [][]protocol.RvInstruction
-	if _, err := parseHumanReadableRvJSON(data); err != nil {
+	if err := validateRvInfo(data); err != nil {
 		return fmt.Errorf("%w: %v", ErrInvalidRvInfo, err)
 	}
 
-	tx := db.Model(&RvInfo{}).Where("id = ?", 1).Update("value", data)
-	if tx.Error != nil {
+	var tx *gorm.DB
+	if err := withRetry(context.Background(), func() error {
+		tx = db.Model(&RvInfo{}).Where("id = ?", 1).Update("value", data)
 		return tx.Error
+	}); err != nil {
+		return err
+	}
+	if tx.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// PatchRvInfo merges patch into the stored rvinfo and returns the result.
+// The patch is a JSON array of directives like the rvinfo itself: keys of
+// its i-th directive replace the same keys of the stored i-th directive, a
+// null value removes the key, and directives beyond the stored ones are
+// appended. Keys the patch does not name are left intact. A patch that
+// changes nothing leaves the stored rvinfo untouched.
+//
+// Directives are matched by position only, not by their protocol or
+// address: a patch written against an rvinfo whose directives have since
+// been reordered, inserted or removed changes other directives than
+// intended. Clients patch the positions of the rvinfo they last read.
+func PatchRvInfo(patch []byte) ([]byte, error) {
+	var patchDirectives []map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &patchDirectives); err != nil {
+		return nil, fmt.Errorf("%w: invalid rvinfo patch JSON: %v", ErrInvalidRvInfo, err)
+	}
+
+	var merged []byte
+	err := withRetry(context.Background(), func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			var rvInfo RvInfo
+			if err := tx.Where("id = ?", 1).First(&rvInfo).Error; err != nil {
+				return err
+			}
+			var changed bool
+			var err error
+			merged, changed, err = mergeRvInfo(rvInfo.Value, patchDirectives)
+			if err != nil {
+				return err
+			}
+			if !changed {
+				return nil
+			}
+			if err := validateRvInfo(merged); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidRvInfo, err)
+			}
+			return tx.Model(&RvInfo{}).Where("id = ?", 1).Update("value", merged).Error
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// mergeRvInfo applies the patch directives to the stored rvinfo by position,
+// see PatchRvInfo, and reports whether anything changed. The stored rvinfo is
+// returned as is when nothing did.
+func mergeRvInfo(stored []byte, patch []map[string]json.RawMessage) ([]byte, bool, error) {
+	var directives []map[string]json.RawMessage
+	if err := json.Unmarshal(stored, &directives); err != nil {
+		return nil, false, fmt.Errorf("stored rvinfo is not valid JSON: %w", err)
+	}
+
+	changed := false
+	for i, patchDirective := range patch {
+		if i == len(directives) {
+			directives = append(directives, map[string]json.RawMessage{})
+			changed = true
+		}
+		for key, value := range patchDirective {
+			old, exists := directives[i][key]
+			if string(value) == "null" {
+				if exists {
+					delete(directives[i], key)
+					changed = true
+				}
+				continue
+			}
+			if !exists || !bytes.Equal(old, value) {
+				directives[i][key] = value
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return stored, false, nil
+	}
+
+	merged, err := json.Marshal(directives)
+	if err != nil {
+		return nil, false, err
+	}
+	return merged, true, nil
+}
+
+// DeleteRvInfo removes the stored rvInfo. Returns gorm.ErrRecordNotFound if
+// no rvInfo has been configured.
+func DeleteRvInfo() error {
+	var tx *gorm.DB
+	if err := withRetry(context.Background(), func() error {
+		tx = db.Where("id = ?", 1).Delete(&RvInfo{})
+		return tx.Error
+	}); err != nil {
+		return err
 	}
 	if tx.RowsAffected == 0 {
 		return gorm.ErrRecordNotFound
@@ -228,13 +499,37 @@ func FetchRvInfoJSON() ([]byte, error) {
 
 // ListDevices returns devices known to the owner service, combining voucher
 // metadata with TO2 onboarding state (if any) from device_onboarding.
-// Devices are ordered by most recently updated voucher first.
-func ListDevices(filters map[string]interface{}) ([]Device, error) {
-	var out []Device
+// Devices are ordered by most recently updated voucher first. The query is
+// served by the read replica, if configured.
+//
+// Devices whose voucher is older than onboardingExpiry without them having
+// completed TO2 are reported as expired, an onboardingExpiry of 0 disabling
+// expiry. Rows that cannot be decoded, including those holding a corrupt
+// voucher, are skipped and reported as warnings instead of failing the whole
+// list.
+func ListDevices(ctx context.Context, filters map[string]interface{}, onboardingExpiry time.Duration) ([]Device, []DeviceWarning, error) {
+	out := []Device{}
+	warnings, err := ForEachDevice(ctx, filters, onboardingExpiry, func(device Device) error {
+		out = append(out, device)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, warnings, nil
+}
 
-	query := db.Table("vouchers").
-		Select("vouchers.guid, device_onboarding.guid as old_guid, vouchers.device_info, vouchers.created_at, vouchers.updated_at, device_onboarding.to2_completed, device_onboarding.to2_completed_at").
+// ForEachDevice calls fn with each device of ListDevices as it is read,
+// without holding the whole list in memory, stopping at the first error of
+// fn.
+func ForEachDevice(ctx context.Context, filters map[string]interface{}, onboardingExpiry time.Duration, fn func(Device) error) ([]DeviceWarning, error) {
+	// The GUID is selected first so it is available even if a later column
+	// fails to decode
+	query := readDB().WithContext(ctx).Table("vouchers").
+		Select("vouchers.guid, device_onboarding.guid as old_guid, vouchers.device_info, device_onboarding.serial_number, device_onboarding.os, device_onboarding.arch, device_labels.label, voucher_quarantine.guid IS NOT NULL AS quarantined, vouchers.created_at, vouchers.updated_at, device_onboarding.to2_completed, device_onboarding.to2_completed_at, device_onboarding.mtu, vouchers.cbor").
 		Joins("LEFT JOIN device_onboarding ON device_onboarding.new_guid = vouchers.guid").
+		Joins("LEFT JOIN device_labels ON device_labels.guid = vouchers.guid").
+		Joins("LEFT JOIN voucher_quarantine ON voucher_quarantine.guid = vouchers.guid").
 		Order("vouchers.updated_at DESC")
 
 	// Apply filters
@@ -245,11 +540,91 @@ func ListDevices(filters map[string]interface{}) ([]Device, error) {
 		}
 		query = query.Where("device_onboarding.guid = ?", b)
 	}
+	if v, ok := filters["serial_number"]; ok {
+		serials, ok := v.([]string)
+		if !ok {
+			return nil, fmt.Errorf("invalid type for serial_number filter; want []string")
+		}
+		query = query.Where("device_onboarding.serial_number IN ?", serials)
+	}
+	if v, ok := filters["label"]; ok {
+		label, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid type for label filter; want string")
+		}
+		query = query.Where("device_labels.label = ?", label)
+	}
 
-	if err := query.Scan(&out).Error; err != nil {
+	rows, err := query.Rows()
+	if err != nil {
 		return nil, err
 	}
-	return out, nil
+	defer func() { _ = rows.Close() }()
+
+	var warnings []DeviceWarning
+	for rows.Next() {
+		var (
+			device       Device
+			oldGUID      []byte
+			deviceInfo   sql.NullString
+			serialNumber sql.NullString
+			os           sql.NullString
+			arch         sql.NullString
+			label        sql.NullString
+			quarantined  sql.NullBool
+			to2Completed sql.NullBool
+			voucherCBOR  []byte
+		)
+		if err := rows.Scan(&device.GUID, &oldGUID, &deviceInfo, &serialNumber, &os, &arch, &label, &quarantined,
+			&device.CreatedAt, &device.UpdatedAt, &to2Completed, &device.TO2CompletedAt, &device.MTU, &voucherCBOR); err != nil {
+			warnings = append(warnings, DeviceWarning{GUID: device.GUID, Error: err.Error()})
+			continue
+		}
+		if len(voucherCBOR) > 0 {
+			var ov fdo.Voucher
+			if err := cbor.Unmarshal(voucherCBOR, &ov); err != nil {
+				warnings = append(warnings, DeviceWarning{GUID: device.GUID, Error: fmt.Sprintf("invalid voucher: %v", err)})
+				continue
+			}
+		}
+		device.OldGUID = oldGUID
+		device.DeviceInfo = deviceInfo.String
+		device.SerialNumber = serialNumber.String
+		device.OS = os.String
+		device.Arch = arch.String
+		device.Label = label.String
+		device.Quarantined = quarantined.Bool
+		device.TO2Completed = to2Completed.Bool
+		device.Expired = voucherExpired(device.CreatedAt, device.TO2Completed, onboardingExpiry)
+		if err := fn(device); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return warnings, nil
+}
+
+// SetDeviceLabel sets the label of the device owning the voucher with the
+// given GUID, replacing any previous label. An empty label removes it, as
+// does removing the voucher.
+// Returns gorm.ErrRecordNotFound if there is no such voucher.
+func SetDeviceLabel(ctx context.Context, guid []byte, label string) error {
+	return withRetry(ctx, func() error {
+		return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Select("guid").Where("guid = ?", guid).First(&Voucher{}).Error; err != nil {
+				return err
+			}
+			if label == "" {
+				return tx.Where("guid = ?", guid).Delete(&DeviceLabel{}).Error
+			}
+			return tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "guid"}},
+				DoUpdates: clause.AssignmentColumns([]string{"label", "updated_at"}),
+			}).Create(&DeviceLabel{GUID: guid, Label: label}).Error
+		})
+	})
 }
 
 // FetchRvInfo reads the rvinfo JSON (stored as text) and converts it into
@@ -262,6 +637,26 @@ func FetchRvInfo() ([][]protocol.RvInstruction, error) {
 	return parseHumanReadableRvJSON(rvInfo)
 }
 
+// FetchDeviceMfgInfo returns the manufacturing info a device sent in DI,
+// or gorm.ErrRecordNotFound if none was saved for its GUID
+func FetchDeviceMfgInfo(ctx context.Context, guid []byte) (*DeviceMfgInfo, error) {
+	var info DeviceMfgInfo
+	if err := db.WithContext(ctx).Where("guid = ?", guid).First(&info).Error; err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// ParseRvInfoJSON converts rvinfo JSON, in the format stored by the rvinfo
+// API, into [][]protocol.RvInstruction without storing it.
+func ParseRvInfoJSON(data []byte) ([][]protocol.RvInstruction, error) {
+	rvInfo, err := parseHumanReadableRvJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRvInfo, err)
+	}
+	return rvInfo, nil
+}
+
 func encodeRvValue(rvVar protocol.RvVar, val any) ([]byte, error) {
 	switch v := val.(type) {
 	case string:
@@ -296,29 +691,64 @@ func encodeRvValue(rvVar protocol.RvVar, val any) ([]byte, error) {
 	}
 }
 
+// RvVarNames are the names of the RV variables in rvinfo JSON, the keys of
+// rvHuman
+var RvVarNames = map[protocol.RvVar]string{
+	protocol.RVDevOnly:    "dev_only",
+	protocol.RVOwnerOnly:  "owner_only",
+	protocol.RVIPAddress:  "ip",
+	protocol.RVDevPort:    "device_port",
+	protocol.RVOwnerPort:  "owner_port",
+	protocol.RVDns:        "dns",
+	protocol.RVSvCertHash: "sv_cert_hash",
+	protocol.RVClCertHash: "cl_cert_hash",
+	protocol.RVUserInput:  "user_input",
+	protocol.RVWifiSsid:   "wifi_ssid",
+	protocol.RVWifiPw:     "wifi_pw",
+	protocol.RVMedium:     "medium",
+	protocol.RVProtocol:   "protocol",
+	protocol.RVDelaysec:   "delay_seconds",
+	protocol.RVBypass:     "rv_bypass",
+	protocol.RVExtRV:      "ext_rv",
+}
+
+// RvProtocolNames are the names of the RV protocols in rvinfo JSON
+var RvProtocolNames = map[uint8]string{
+	protocol.RVProtRest:    "rest",
+	protocol.RVProtHTTP:    "http",
+	protocol.RVProtHTTPS:   "https",
+	protocol.RVProtTCP:     "tcp",
+	protocol.RVProtTLS:     "tls",
+	protocol.RVProtCoapTCP: "coap+tcp",
+	protocol.RVProtCoapUDP: "coap",
+}
+
+// rvHuman is one human-readable RV directive of an rvinfo JSON array
+type rvHuman struct {
+	DNS          string  `json:"dns"`
+	IP           string  `json:"ip"`
+	Protocol     string  `json:"protocol"`
+	Medium       string  `json:"medium"`
+	DevicePort   string  `json:"device_port"`
+	OwnerPort    string  `json:"owner_port"`
+	WifiSSID     string  `json:"wifi_ssid"`
+	WifiPW       string  `json:"wifi_pw"`
+	DevOnly      bool    `json:"dev_only"`
+	OwnerOnly    bool    `json:"owner_only"`
+	RvBypass     bool    `json:"rv_bypass"`
+	DelaySeconds *uint32 `json:"delay_seconds"`
+	SvCertHash   string  `json:"sv_cert_hash"`
+	ClCertHash   string  `json:"cl_cert_hash"`
+	UserInput    string  `json:"user_input"`
+	ExtRV        string  `json:"ext_rv"`
+}
+
 // parseHumanReadableRvJSON parses a JSON like
 // [{"dns":"fdo.example.com","device_port":"8082","owner_port":"8082","protocol":"http","ip":"127.0.0.1"}]
 // into [][]protocol.RvInstruction. It maps human-readable keys to RV variables
-// and converts protocol strings to the appropriate numeric code.
+// and converts protocol strings to the appropriate numeric code. Errors name
+// the offending directive by its index in the array.
 func parseHumanReadableRvJSON(rawJSON []byte) ([][]protocol.RvInstruction, error) {
-	type rvHuman struct {
-		DNS          string  `json:"dns"`
-		IP           string  `json:"ip"`
-		Protocol     string  `json:"protocol"`
-		Medium       string  `json:"medium"`
-		DevicePort   string  `json:"device_port"`
-		OwnerPort    string  `json:"owner_port"`
-		WifiSSID     string  `json:"wifi_ssid"`
-		WifiPW       string  `json:"wifi_pw"`
-		DevOnly      bool    `json:"dev_only"`
-		OwnerOnly    bool    `json:"owner_only"`
-		RvBypass     bool    `json:"rv_bypass"`
-		DelaySeconds *uint32 `json:"delay_seconds"`
-		SvCertHash   string  `json:"sv_cert_hash"`
-		ClCertHash   string  `json:"cl_cert_hash"`
-		UserInput    string  `json:"user_input"`
-		ExtRV        string  `json:"ext_rv"`
-	}
 	var items []rvHuman
 	if err := json.Unmarshal(rawJSON, &items); err != nil {
 		return nil, fmt.Errorf("invalid rvinfo JSON: %w", err)
@@ -326,142 +756,176 @@ func parseHumanReadableRvJSON(rawJSON []byte) ([][]protocol.RvInstruction, error
 
 	out := make([][]protocol.RvInstruction, 0, len(items))
 	for i, item := range items {
-		group := make([]protocol.RvInstruction, 0)
-
-		// Spec requires at least one of DNS or IP to be present for an RV entry
-		if item.DNS == "" && item.IP == "" {
-			return nil, fmt.Errorf("rvinfo[%d]: at least one of dns or ip must be specified", i)
+		group, err := parseHumanReadableRvDirective(item)
+		if err != nil {
+			return nil, fmt.Errorf("rvinfo[%d]: %w", i, err)
 		}
+		out = append(out, group)
+	}
+	return out, nil
+}
 
-		if item.DNS != "" {
-			enc, err := encodeRvValue(protocol.RVDns, item.DNS)
-			if err != nil {
-				return nil, err
-			}
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVDns, Value: enc})
+// parseHumanReadableRvDirective converts one RV directive into its RV
+// instructions
+func parseHumanReadableRvDirective(item rvHuman) ([]protocol.RvInstruction, error) {
+	group := make([]protocol.RvInstruction, 0)
+
+	// Spec requires at least one of DNS or IP to be present for an RV entry
+	if item.DNS == "" && item.IP == "" {
+		return nil, errors.New("at least one of dns or ip must be specified")
+	}
+
+	if item.DNS != "" {
+		enc, err := encodeRvValue(protocol.RVDns, item.DNS)
+		if err != nil {
+			return nil, err
 		}
-		if item.IP != "" {
-			enc, err := encodeRvValue(protocol.RVIPAddress, item.IP)
-			if err != nil {
-				return nil, err
-			}
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVIPAddress, Value: enc})
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVDns, Value: enc})
+	}
+	if item.IP != "" {
+		enc, err := encodeRvValue(protocol.RVIPAddress, item.IP)
+		if err != nil {
+			return nil, err
 		}
-		if item.Protocol != "" {
-			code, err := protocolCodeFromString(item.Protocol)
-			if err != nil {
-				return nil, err
-			}
-			enc, err := encodeRvValue(protocol.RVProtocol, uint8(code))
-			if err != nil {
-				return nil, err
-			}
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVProtocol, Value: enc})
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVIPAddress, Value: enc})
+	}
+	if item.Protocol != "" {
+		code, err := protocolCodeFromString(item.Protocol)
+		if err != nil {
+			return nil, err
 		}
-		if item.Medium != "" {
-			m, err := parseMediumValue(item.Medium)
-			if err != nil {
-				return nil, fmt.Errorf("medium: %w", err)
-			}
-			enc, err := encodeRvValue(protocol.RVMedium, uint8(m))
-			if err != nil {
-				return nil, err
-			}
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVMedium, Value: enc})
+		enc, err := encodeRvValue(protocol.RVProtocol, uint8(code))
+		if err != nil {
+			return nil, err
 		}
-		if item.DevicePort != "" {
-			num, err := parsePortValue(item.DevicePort)
-			if err != nil {
-				return nil, fmt.Errorf("device_port: %w", err)
-			}
-			enc, err := encodeRvValue(protocol.RVDevPort, num)
-			if err != nil {
-				return nil, err
-			}
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVDevPort, Value: enc})
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVProtocol, Value: enc})
+	}
+	if item.Medium != "" {
+		m, err := parseMediumValue(item.Medium)
+		if err != nil {
+			return nil, fmt.Errorf("medium: %w", err)
 		}
-		if item.OwnerPort != "" {
-			num, err := parsePortValue(item.OwnerPort)
-			if err != nil {
-				return nil, fmt.Errorf("owner_port: %w", err)
-			}
-			enc, err := encodeRvValue(protocol.RVOwnerPort, num)
-			if err != nil {
-				return nil, err
-			}
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVOwnerPort, Value: enc})
+		enc, err := encodeRvValue(protocol.RVMedium, uint8(m))
+		if err != nil {
+			return nil, err
 		}
-		if item.WifiSSID != "" {
-			enc, err := encodeRvValue(protocol.RVWifiSsid, item.WifiSSID)
-			if err != nil {
-				return nil, err
-			}
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVWifiSsid, Value: enc})
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVMedium, Value: enc})
+	}
+	if item.DevicePort != "" {
+		num, err := parsePortValue(item.DevicePort)
+		if err != nil {
+			return nil, fmt.Errorf("device_port: %w", err)
 		}
-		if item.WifiPW != "" {
-			enc, err := encodeRvValue(protocol.RVWifiPw, item.WifiPW)
-			if err != nil {
-				return nil, err
-			}
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVWifiPw, Value: enc})
+		enc, err := encodeRvValue(protocol.RVDevPort, num)
+		if err != nil {
+			return nil, err
 		}
-		if item.DevOnly {
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVDevOnly})
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVDevPort, Value: enc})
+	}
+	if item.OwnerPort != "" {
+		num, err := parsePortValue(item.OwnerPort)
+		if err != nil {
+			return nil, fmt.Errorf("owner_port: %w", err)
 		}
-		if item.OwnerOnly {
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVOwnerOnly})
+		enc, err := encodeRvValue(protocol.RVOwnerPort, num)
+		if err != nil {
+			return nil, err
 		}
-		if item.RvBypass {
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVBypass})
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVOwnerPort, Value: enc})
+	}
+	if item.WifiSSID != "" {
+		enc, err := encodeRvValue(protocol.RVWifiSsid, item.WifiSSID)
+		if err != nil {
+			return nil, err
 		}
-		if item.DelaySeconds != nil {
-			secs := uint64(*item.DelaySeconds)
-			enc, err := encodeRvValue(protocol.RVDelaysec, secs)
-			if err != nil {
-				return nil, err
-			}
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVDelaysec, Value: enc})
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVWifiSsid, Value: enc})
+	}
+	if item.WifiPW != "" {
+		enc, err := encodeRvValue(protocol.RVWifiPw, item.WifiPW)
+		if err != nil {
+			return nil, err
 		}
-		if item.SvCertHash != "" {
-			b, err := hex.DecodeString(item.SvCertHash)
-			if err != nil {
-				return nil, fmt.Errorf("sv_cert_hash: %w", err)
-			}
-			enc, err := cbor.Marshal(b)
-			if err != nil {
-				return nil, err
-			}
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVSvCertHash, Value: enc})
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVWifiPw, Value: enc})
+	}
+	if item.DevOnly {
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVDevOnly})
+	}
+	if item.OwnerOnly {
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVOwnerOnly})
+	}
+	if item.RvBypass {
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVBypass})
+	}
+	if item.DelaySeconds != nil {
+		secs := uint64(*item.DelaySeconds)
+		enc, err := encodeRvValue(protocol.RVDelaysec, secs)
+		if err != nil {
+			return nil, err
 		}
-		if item.ClCertHash != "" {
-			b, err := hex.DecodeString(item.ClCertHash)
-			if err != nil {
-				return nil, fmt.Errorf("cl_cert_hash: %w", err)
-			}
-			enc, err := cbor.Marshal(b)
-			if err != nil {
-				return nil, err
-			}
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVClCertHash, Value: enc})
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVDelaysec, Value: enc})
+	}
+	if item.SvCertHash != "" {
+		b, err := hex.DecodeString(item.SvCertHash)
+		if err != nil {
+			return nil, fmt.Errorf("sv_cert_hash: %w", err)
 		}
-		if item.UserInput != "" {
-			enc, err := encodeRvValue(protocol.RVUserInput, item.UserInput)
-			if err != nil {
-				return nil, err
-			}
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVUserInput, Value: enc})
+		enc, err := cbor.Marshal(b)
+		if err != nil {
+			return nil, err
 		}
-		if item.ExtRV != "" {
-			enc, err := encodeRvValue(protocol.RVExtRV, item.ExtRV)
-			if err != nil {
-				return nil, err
-			}
-			group = append(group, protocol.RvInstruction{Variable: protocol.RVExtRV, Value: enc})
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVSvCertHash, Value: enc})
+	}
+	if item.ClCertHash != "" {
+		b, err := hex.DecodeString(item.ClCertHash)
+		if err != nil {
+			return nil, fmt.Errorf("cl_cert_hash: %w", err)
 		}
+		enc, err := cbor.Marshal(b)
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVClCertHash, Value: enc})
+	}
+	if item.UserInput != "" {
+		enc, err := encodeRvValue(protocol.RVUserInput, item.UserInput)
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVUserInput, Value: enc})
+	}
+	if item.ExtRV != "" {
+		enc, err := encodeRvValue(protocol.RVExtRV, item.ExtRV)
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, protocol.RvInstruction{Variable: protocol.RVExtRV, Value: enc})
+	}
+	return group, nil
+}
 
-		out = append(out, group)
+// validateRvInfo checks rvinfo before it is stored. On top of requiring it
+// to parse into [][]protocol.RvInstruction, it rejects unknown keys, which
+// the parser silently drops, and rvinfo that no device or owner could use.
+func validateRvInfo(data []byte) error {
+	if _, err := parseHumanReadableRvJSON(data); err != nil {
+		return err
 	}
-	return out, nil
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var items []rvHuman
+	if err := decoder.Decode(&items); err != nil {
+		return fmt.Errorf("invalid rvinfo JSON: %w", err)
+	}
+	if len(items) == 0 {
+		return errors.New("at least one rv directive is required")
+	}
+	for i, item := range items {
+		if item.DevOnly && item.OwnerOnly {
+			return fmt.Errorf("rvinfo[%d]: dev_only and owner_only are mutually exclusive", i)
+		}
+	}
+	return nil
 }
 
 func parsePortValue(v any) (uint16, error) {
@@ -492,24 +956,12 @@ func parsePortValue(v any) (uint16, error) {
 }
 
 func protocolCodeFromString(s string) (uint8, error) {
-	switch s {
-	case "rest":
-		return uint8(protocol.RVProtRest), nil
-	case "http":
-		return uint8(protocol.RVProtHTTP), nil
-	case "https":
-		return uint8(protocol.RVProtHTTPS), nil
-	case "tcp":
-		return uint8(protocol.RVProtTCP), nil
-	case "tls":
-		return uint8(protocol.RVProtTLS), nil
-	case "coap+tcp":
-		return uint8(protocol.RVProtCoapTCP), nil
-	case "coap":
-		return uint8(protocol.RVProtCoapUDP), nil
-	default:
-		return 0, fmt.Errorf("unsupported protocol %q", s)
+	for code, name := range RvProtocolNames {
+		if name == s {
+			return code, nil
+		}
 	}
+	return 0, fmt.Errorf("unsupported protocol %q", s)
 }
 
 func parseMediumValue(v any) (uint8, error) {
@@ -530,69 +982,112 @@ func parseMediumValue(v any) (uint8, error) {
 	}
 }
 
+// to2Human is one human-readable owner address of an owner info JSON array
+type to2Human struct {
+	DNS      string `json:"dns"`
+	IP       string `json:"ip"`
+	Port     string `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
 // ParseHumanToTO2AddrsJSON parses a JSON like
 // [{"dns":"fdo.example.com","port":"8082","protocol":"http","ip":"127.0.0.1"}]
 // into []protocol.RvTO2Addr.
 func parseHumanToTO2AddrsJSON(rawJSON []byte) ([]protocol.RvTO2Addr, error) {
-	// Strongly-typed JSON for validation
-	type to2Human struct {
-		DNS      string `json:"dns"`
-		IP       string `json:"ip"`
-		Port     string `json:"port"`
-		Protocol string `json:"protocol"`
-	}
-	var items []to2Human
-	if err := json.Unmarshal(rawJSON, &items); err != nil {
-		return nil, fmt.Errorf("invalid TO2 addrs JSON: %w", err)
+	return parseTO2Addrs(rawJSON, false)
+}
+
+// validateOwnerInfo checks owner info before it is stored: every entry must
+// parse in strict mode and the list must hold at least one address, as TO0
+// has nothing to register for the devices otherwise.
+func validateOwnerInfo(data []byte) error {
+	addrs, err := parseTO2Addrs(data, true)
+	if err != nil {
+		return err
 	}
+	if len(addrs) == 0 {
+		return errors.New("at least one owner address is required")
+	}
+	return nil
+}
 
-	out := make([]protocol.RvTO2Addr, 0, len(items))
-	for i, item := range items {
-		var (
-			ipPtr  *net.IP
-			dnsPtr *string
-			port   uint16
-			proto  protocol.TransportProtocol
-		)
+// parseTO2Addrs parses owner info, naming the offending entry by its index
+// in errors. With strict, unknown keys are rejected.
+func parseTO2Addrs(rawJSON []byte, strict bool) ([]protocol.RvTO2Addr, error) {
+	var entries []json.RawMessage
+	if err := json.Unmarshal(rawJSON, &entries); err != nil {
+		return nil, fmt.Errorf("invalid TO2 addrs JSON: %w", err)
+	}
 
-		if item.IP != "" {
-			ip := net.ParseIP(item.IP)
-			if ip == nil {
-				return nil, fmt.Errorf("invalid ip %q", item.IP)
-			}
-			ipPtr = &ip
-		}
-		if item.DNS != "" {
-			dns := item.DNS
-			dnsPtr = &dns
+	out := make([]protocol.RvTO2Addr, 0, len(entries))
+	for i, entry := range entries {
+		addr, err := parseTO2Addr(entry, strict)
+		if err != nil {
+			return nil, fmt.Errorf("to2[%d]: %w", i, err)
 		}
-		// Spec: A given RVTO2Addr must have at least one of RVIP or RVDNS
-		if ipPtr == nil && dnsPtr == nil {
-			return nil, fmt.Errorf("to2[%d]: at least one of dns or ip must be specified", i)
+		out = append(out, addr)
+	}
+	return out, nil
+}
+
+// parseTO2Addr parses one owner address, a JSON object like
+// {"dns":"owner.example.com","port":"8043","protocol":"http"}
+func parseTO2Addr(entry json.RawMessage, strict bool) (protocol.RvTO2Addr, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(entry), []byte("{")) {
+		return protocol.RvTO2Addr{}, errors.New("entry must be an object with dns, ip, port and protocol keys")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(entry))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+	var item to2Human
+	if err := decoder.Decode(&item); err != nil {
+		return protocol.RvTO2Addr{}, fmt.Errorf("invalid TO2 addrs JSON: %w", err)
+	}
+
+	var (
+		ipPtr  *net.IP
+		dnsPtr *string
+		port   uint16
+		proto  protocol.TransportProtocol
+	)
+
+	if item.IP != "" {
+		ip := net.ParseIP(item.IP)
+		if ip == nil {
+			return protocol.RvTO2Addr{}, fmt.Errorf("invalid ip %q", item.IP)
 		}
-		if item.Port != "" {
-			p, err := parsePortValue(item.Port)
-			if err != nil {
-				return nil, fmt.Errorf("port: %w", err)
-			}
-			port = p
+		ipPtr = &ip
+	}
+	if item.DNS != "" {
+		dns := item.DNS
+		dnsPtr = &dns
+	}
+	// Spec: A given RVTO2Addr must have at least one of RVIP or RVDNS
+	if ipPtr == nil && dnsPtr == nil {
+		return protocol.RvTO2Addr{}, errors.New("at least one of dns or ip must be specified")
+	}
+	if item.Port != "" {
+		p, err := parsePortValue(item.Port)
+		if err != nil {
+			return protocol.RvTO2Addr{}, fmt.Errorf("port: %w", err)
 		}
-		if item.Protocol != "" {
-			tp, err := transportProtocolFromString(item.Protocol)
-			if err != nil {
-				return nil, err
-			}
-			proto = tp
+		port = p
+	}
+	if item.Protocol != "" {
+		tp, err := transportProtocolFromString(item.Protocol)
+		if err != nil {
+			return protocol.RvTO2Addr{}, err
 		}
-
-		out = append(out, protocol.RvTO2Addr{
-			IPAddress:         ipPtr,
-			DNSAddress:        dnsPtr,
-			Port:              port,
-			TransportProtocol: proto,
-		})
+		proto = tp
 	}
-	return out, nil
+
+	return protocol.RvTO2Addr{
+		IPAddress:         ipPtr,
+		DNSAddress:        dnsPtr,
+		Port:              port,
+		TransportProtocol: proto,
+	}, nil
 }
 
 func transportProtocolFromString(s string) (protocol.TransportProtocol, error) {
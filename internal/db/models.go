@@ -212,10 +212,17 @@ func (RvInfo) TableName() string {
 
 // DeviceOnboarding tracks TO2 completion per device GUID
 type DeviceOnboarding struct {
-	GUID           GUID `gorm:"primaryKey"`
-	NewGUID        GUID `gorm:"index"`
-	TO2Completed   bool `gorm:"type:boolean;not null;default:false"`
+	GUID           GUID   `gorm:"primaryKey"`
+	NewGUID        GUID   `gorm:"index"`
+	SerialNumber   string `gorm:"type:text;index"` // normalized devmod serial, see utils.NormalizeSerial
+	OS             string `gorm:"type:text"`       // devmod os
+	Arch           string `gorm:"type:text"`       // devmod arch
+	TO2Completed   bool   `gorm:"type:boolean;not null;default:false"`
 	TO2CompletedAt *time.Time
+	// Max service info size negotiated in the device's last TO2 session
+	MTU *int `gorm:"type:integer"`
+	// Last successful TO0 registration with a rendezvous server
+	TO0RegisteredAt *time.Time
 }
 
 // TableName specifies the table name for DeviceOnboarding model
@@ -223,14 +230,83 @@ func (DeviceOnboarding) TableName() string {
 	return "device_onboarding"
 }
 
+// DeviceLabel is an operator assigned name for the device owning a voucher
+type DeviceLabel struct {
+	GUID      GUID      `gorm:"primaryKey"`
+	Label     string    `gorm:"type:text;not null;index"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime:milli"`
+}
+
+// TableName specifies the table name for DeviceLabel model
+func (DeviceLabel) TableName() string {
+	return "device_labels"
+}
+
+// VoucherQuarantine marks a voucher whose device may not onboard until an
+// operator approves it
+type VoucherQuarantine struct {
+	GUID      GUID      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"autoCreateTime:milli"`
+}
+
+// TableName specifies the table name for VoucherQuarantine model
+func (VoucherQuarantine) TableName() string {
+	return "voucher_quarantine"
+}
+
+// ArchivedVoucher keeps a voucher deleted through the owner API when
+// vouchers are archived rather than deleted, for compliance
+type ArchivedVoucher struct {
+	GUID       GUID      `json:"guid" gorm:"primaryKey"`
+	CBOR       []byte    `json:"cbor,omitempty"`
+	DeviceInfo string    `json:"device_info" gorm:"type:text"`
+	CreatedAt  time.Time `json:"created_at"` // when the voucher was inserted
+	ArchivedAt time.Time `json:"archived_at" gorm:"autoCreateTime:milli"`
+}
+
+// TableName specifies the table name for ArchivedVoucher model
+func (ArchivedVoucher) TableName() string {
+	return "archived_vouchers"
+}
+
+// DeviceMfgInfo keeps the manufacturing info a device sent in DI.AppStart,
+// for audit and debugging
+type DeviceMfgInfo struct {
+	GUID         GUID      `json:"guid" gorm:"primaryKey"`
+	KeyType      int       `json:"key_type" gorm:"type:integer;not null"`
+	KeyEncoding  int       `json:"key_encoding" gorm:"type:integer;not null"`
+	SerialNumber string    `json:"serial_number" gorm:"type:text"`
+	DeviceInfo   string    `json:"device_info" gorm:"type:text"`
+	CSR          []byte    `json:"csr,omitempty"` // DER encoded certificate request
+	CreatedAt    time.Time `json:"created_at" gorm:"autoCreateTime:milli"`
+}
+
+// TableName specifies the table name for DeviceMfgInfo model
+func (DeviceMfgInfo) TableName() string {
+	return "device_mfg_info"
+}
+
 // Device is a projection used by the owner API to expose
 // voucher metadata together with TO2 onboarding state for each device.
 type Device struct {
 	GUID           GUID       `json:"guid" gorm:"column:guid"`
 	OldGUID        GUID       `json:"old_guid" gorm:"column:old_guid"`
 	DeviceInfo     string     `json:"device_info" gorm:"column:device_info"`
+	SerialNumber   string     `json:"serial_number,omitempty" gorm:"column:serial_number"`
+	OS             string     `json:"os,omitempty" gorm:"column:os"`
+	Arch           string     `json:"arch,omitempty" gorm:"column:arch"`
+	Label          string     `json:"label,omitempty" gorm:"column:label"`
+	Quarantined    bool       `json:"quarantined,omitempty" gorm:"column:quarantined"`
+	Expired        bool       `json:"expired,omitempty" gorm:"-"`
 	CreatedAt      time.Time  `json:"created_at" gorm:"column:created_at"`
 	UpdatedAt      time.Time  `json:"updated_at" gorm:"column:updated_at"`
 	TO2Completed   bool       `json:"to2_completed" gorm:"column:to2_completed"`
 	TO2CompletedAt *time.Time `json:"to2_completed_at,omitempty" gorm:"column:to2_completed_at"`
+	MTU            *int       `json:"mtu,omitempty" gorm:"column:mtu"`
+}
+
+// DeviceWarning reports a device row that could not be decoded
+type DeviceWarning struct {
+	GUID  GUID   `json:"guid"`
+	Error string `json:"error"`
 }
@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"fmt"
+	"log/slog"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// replica serves the management API list queries when a read replica is
+// configured. Writes and the FDO protocols always use the primary.
+var replica *gorm.DB
+
+// InitReadReplica connects to a read-only replica of the database used by
+// the management API list queries. The replica schema is expected to be
+// maintained by replication from the primary, so no migration is performed.
+func InitReadReplica(dbType, dsn string) error {
+	var dialector gorm.Dialector
+	switch dbType {
+	case "sqlite":
+		dialector = sqlite.Open(dsn)
+	case "postgres":
+		dialector = postgres.Open(dsn)
+	default:
+		return fmt.Errorf("unsupported database type: %s", dbType)
+	}
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to connect to read replica: %w", err)
+	}
+	replica = gormDB
+	slog.Info("Read replica initialized successfully", "type", dbType)
+	return nil
+}
+
+// readDB returns the connection used for management API list queries
+func readDB() *gorm.DB {
+	if replica != nil {
+		return replica
+	}
+	return db
+}
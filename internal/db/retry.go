@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: (C) 2025 Red Hat Inc.
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+)
+
+// DefaultRetryAttempts is the number of attempts made for a write
+// operation failing with a transient error, unless changed with
+// SetRetryAttempts.
+const DefaultRetryAttempts = 3
+
+// Delay before the first retry, doubled for each following one
+const retryBaseDelay = 20 * time.Millisecond
+
+var retryAttempts atomic.Int32
+
+func init() {
+	retryAttempts.Store(DefaultRetryAttempts)
+}
+
+// SetRetryAttempts sets the number of attempts made for a write operation
+// failing with a transient error. A value of 1 disables retries.
+func SetRetryAttempts(n int) {
+	retryAttempts.Store(int32(max(n, 1)))
+}
+
+// isTransientError reports whether err is a database error that may succeed
+// if the operation is retried: a locked SQLite database, or a Postgres
+// serialization failure or deadlock.
+func isTransientError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// serialization_failure, deadlock_detected
+		return pgErr.Code == "40001" || pgErr.Code == "40P01"
+	}
+	return err != nil && strings.Contains(err.Error(), "database is locked")
+}
+
+// withRetry runs the write operation op, retrying it with exponential
+// backoff while it fails with a transient error. op must be safe to repeat,
+// e.g. a single statement or a transaction.
+func withRetry(ctx context.Context, op func() error) error {
+	attempts := int(retryAttempts.Load())
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= attempts || !isTransientError(err) {
+			return err
+		}
+		slog.Debug("Retrying database operation after transient error", "attempt", attempt, "err", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/gorm"
+)
+
+// injectCreateErrors makes the next n create statements fail with err
+func injectCreateErrors(t *testing.T, state *State, n int, err error) *int {
+	t.Helper()
+	calls := new(int)
+	if cbErr := state.DB.Callback().Create().Before("gorm:create").Register("test:inject_error", func(tx *gorm.DB) {
+		*calls++
+		if *calls <= n {
+			_ = tx.AddError(err)
+		}
+	}); cbErr != nil {
+		t.Fatal(cbErr)
+	}
+	return calls
+}
+
+func TestInsertVoucher_RetriesTransientError(t *testing.T) {
+	state, err := InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := injectCreateErrors(t, state, 1, sqlite3.Error{Code: sqlite3.ErrBusy})
+
+	guid := protocol.GUID{1}
+	if err := InsertVoucher(Voucher{GUID: guid[:], DeviceInfo: "test", CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
+		t.Fatalf("expected insert to succeed on retry, got %v", err)
+	}
+	if *calls < 2 {
+		t.Fatalf("expected the insert to be retried, got %d create calls", *calls)
+	}
+	if _, err := FetchVoucher(context.Background(), map[string]interface{}{"guid": guid[:]}); err != nil {
+		t.Fatalf("voucher not stored: %v", err)
+	}
+}
+
+func TestInsertVoucher_GivesUpAfterMaxAttempts(t *testing.T) {
+	state, err := InitDb("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetRetryAttempts(2)
+	t.Cleanup(func() { SetRetryAttempts(DefaultRetryAttempts) })
+	calls := injectCreateErrors(t, state, 10, errors.New("database is locked"))
+
+	guid := protocol.GUID{2}
+	if err := InsertVoucher(Voucher{GUID: guid[:], DeviceInfo: "test", CreatedAt: time.Now(), UpdatedAt: time.Now()}); err == nil {
+		t.Fatal("expected insert to fail")
+	}
+	if *calls != 2 {
+		t.Fatalf("expected 2 attempts, got %d", *calls)
+	}
+}
+
+func TestWithRetry_PermanentErrorNotRetried(t *testing.T) {
+	attempts := 0
+	permanent := errors.New("constraint failed")
+	err := withRetry(context.Background(), func() error {
+		attempts++
+		return permanent
+	})
+	if !errors.Is(err, permanent) || attempts != 1 {
+		t.Fatalf("expected a single attempt returning the error, got %d attempts: %v", attempts, err)
+	}
+}
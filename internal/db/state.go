@@ -13,10 +13,12 @@ import (
 
 	"github.com/fido-device-onboard/go-fdo"
 	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/custom"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // State implements all FDO server state persistence interfaces using GORM
@@ -47,6 +49,7 @@ func InitDb(dbType, dsn string) (*State, error) {
 
 	// Set the global db variable for backward compatibility
 	db = gormDB
+	replica = nil
 
 	state := &State{
 		DB:     gormDB,
@@ -71,6 +74,10 @@ func InitDb(dbType, dsn string) (*State, error) {
 		&OwnerInfo{},
 		&RvInfo{},
 		&DeviceOnboarding{},
+		&DeviceLabel{},
+		&VoucherQuarantine{},
+		&DeviceMfgInfo{},
+		&ArchivedVoucher{},
 	)
 	if err != nil {
 		slog.Error("Failed to migrate database schema", "error", err)
@@ -91,6 +98,12 @@ func InitDb(dbType, dsn string) (*State, error) {
 
 // Close closes the database connection
 func (s *State) Close() error {
+	if replica != nil {
+		if sqlDB, err := replica.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+		replica = nil
+	}
 	sqlDB, err := s.DB.DB()
 	if err != nil {
 		return err
@@ -111,6 +124,11 @@ var _ interface {
 	fdo.OwnerKeyPersistentState
 } = (*State)(nil)
 
+// The optional interface of fdo.DIServer storing the device's DI info
+var _ interface {
+	SetDeviceSelfInfo(context.Context, *custom.DeviceMfgInfo) error
+} = (*State)(nil)
+
 // TokenService implementation
 
 // NewToken creates a new session token
@@ -127,7 +145,7 @@ func (s *State) NewToken(ctx context.Context, proto protocol.Protocol) (string,
 		Protocol: int(proto),
 	}
 
-	if err := s.DB.Create(&session).Error; err != nil {
+	if err := withRetry(ctx, func() error { return s.DB.Create(&session).Error }); err != nil {
 		return "", fmt.Errorf("failed to create session: %w", err)
 	}
 
@@ -148,9 +166,12 @@ func (s *State) InvalidateToken(ctx context.Context) error {
 		return fdo.ErrInvalidSession
 	}
 
-	result := s.DB.Where("id = ?", decoded).Delete(&Session{})
-	if result.Error != nil {
+	var result *gorm.DB
+	if err := withRetry(ctx, func() error {
+		result = s.DB.Where("id = ?", decoded).Delete(&Session{})
 		return result.Error
+	}); err != nil {
+		return err
 	}
 	if result.RowsAffected == 0 {
 		return fdo.ErrNotFound
@@ -215,9 +236,67 @@ func (s *State) SetDeviceCertChain(ctx context.Context, chain []*x509.Certificat
 		X509Chain: chainBytes,
 	}
 
-	return s.DB.Where("session = ?", sessionID).
-		Assign(map[string]interface{}{"x509_chain": chainBytes}).
-		FirstOrCreate(&deviceInfo).Error
+	return withRetry(ctx, func() error {
+		return s.DB.Where("session = ?", sessionID).
+			Assign(map[string]interface{}{"x509_chain": chainBytes}).
+			FirstOrCreate(&deviceInfo).Error
+	})
+}
+
+// SetDeviceSelfInfo stores the manufacturing info sent by the device in
+// DI.AppStart with the DI session, see MfgInfoRecorder. It updates the
+// device info created by SetDeviceCertChain, which DI calls first.
+func (s *State) SetDeviceSelfInfo(ctx context.Context, info *custom.DeviceMfgInfo) error {
+	if info == nil {
+		return nil
+	}
+	sessionID, err := s.getSessionID(ctx)
+	if err != nil {
+		return err
+	}
+	values := map[string]interface{}{
+		"key_type":      int(info.KeyType),
+		"key_encoding":  int(info.KeyEncoding),
+		"serial_number": info.SerialNumber,
+		"info_string":   info.DeviceInfo,
+		"csr":           info.CertInfo.Raw,
+	}
+	return withRetry(ctx, func() error {
+		return s.DB.Model(&DeviceInfo{}).Where("session = ?", sessionID).Updates(values).Error
+	})
+}
+
+// saveDeviceMfgInfo copies the manufacturing info of a DI session, stored
+// by SetDeviceSelfInfo, to the device of the given GUID so that it outlives
+// the session, replacing any info saved before for the GUID. Nothing is
+// saved for devices that sent no info.
+func saveDeviceMfgInfo(tx *gorm.DB, sessionID []byte, guid protocol.GUID) error {
+	var deviceInfo DeviceInfo
+	if err := tx.Where("session = ?", sessionID).First(&deviceInfo).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		return err
+	}
+	if deviceInfo.KeyType == nil || deviceInfo.KeyEncoding == nil {
+		return nil
+	}
+	mfgInfo := DeviceMfgInfo{
+		GUID:        guid[:],
+		KeyType:     *deviceInfo.KeyType,
+		KeyEncoding: *deviceInfo.KeyEncoding,
+		CSR:         deviceInfo.CSR,
+	}
+	if deviceInfo.SerialNumber != nil {
+		mfgInfo.SerialNumber = *deviceInfo.SerialNumber
+	}
+	if deviceInfo.InfoString != nil {
+		mfgInfo.DeviceInfo = *deviceInfo.InfoString
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "guid"}},
+		DoUpdates: clause.AssignmentColumns([]string{"key_type", "key_encoding", "serial_number", "device_info", "csr", "created_at"}),
+	}).Create(&mfgInfo).Error
 }
 
 func (s *State) GetReplacementGUID(ctx context.Context) (protocol.GUID, error) {
@@ -276,7 +355,7 @@ func (s *State) SetIncompleteVoucherHeader(ctx context.Context, ovh *fdo.Voucher
 		Header:  headerBytes,
 	}
 
-	return s.DB.Save(&incompleteVoucher).Error
+	return withRetry(ctx, func() error { return s.DB.Save(&incompleteVoucher).Error })
 }
 
 // IncompleteVoucherHeader retrieves an incomplete voucher header
@@ -316,7 +395,7 @@ func (s *State) SetTO0SignNonce(ctx context.Context, nonce protocol.Nonce) error
 		Nonce:   nonce[:],
 	}
 
-	return s.DB.Save(&to0Session).Error
+	return withRetry(ctx, func() error { return s.DB.Save(&to0Session).Error })
 }
 
 // TO0SignNonce retrieves the TO0 sign nonce
@@ -353,7 +432,7 @@ func (s *State) SetTO1ProofNonce(ctx context.Context, nonce protocol.Nonce) erro
 		Nonce:   nonce[:],
 	}
 
-	return s.DB.Save(&to1Session).Error
+	return withRetry(ctx, func() error { return s.DB.Save(&to1Session).Error })
 }
 
 // TO1ProofNonce retrieves the TO1 proof nonce
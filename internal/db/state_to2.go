@@ -9,6 +9,7 @@ import (
 	"fmt"
 
 	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
 	"github.com/fido-device-onboard/go-fdo/cbor"
 	"github.com/fido-device-onboard/go-fdo/kex"
 	"github.com/fido-device-onboard/go-fdo/protocol"
@@ -30,9 +31,11 @@ func (s *State) SetGUID(ctx context.Context, guid protocol.GUID) error {
 		GUID:    guid[:],
 	}
 
-	return s.DB.Where("session = ?", sessionID).
-		Assign(map[string]interface{}{"guid": guid[:]}).
-		FirstOrCreate(&to2Session).Error
+	return withRetry(ctx, func() error {
+		return s.DB.Where("session = ?", sessionID).
+			Assign(map[string]interface{}{"guid": guid[:]}).
+			FirstOrCreate(&to2Session).Error
+	})
 }
 
 // GUID retrieves the GUID associated with the TO2 session
@@ -67,8 +70,10 @@ func (s *State) SetRvInfo(ctx context.Context, rvInfo [][]protocol.RvInstruction
 		return fmt.Errorf("failed to marshal rvInfo: %w", err)
 	}
 
-	return s.DB.Model(&TO2Session{}).Where("session = ?", sessionID).
-		Update("rv_info", rvInfoBytes).Error
+	return withRetry(ctx, func() error {
+		return s.DB.Model(&TO2Session{}).Where("session = ?", sessionID).
+			Update("rv_info", rvInfoBytes).Error
+	})
 }
 
 // RvInfo retrieves the rendezvous instructions
@@ -110,9 +115,11 @@ func (s *State) SetReplacementGUID(ctx context.Context, guid protocol.GUID) erro
 		GUID:    guid[:],
 	}
 
-	return s.DB.Where("session = ?", sessionID).
-		Assign(map[string]interface{}{"guid": guid[:]}).
-		FirstOrCreate(&replacementVoucher).Error
+	return withRetry(ctx, func() error {
+		return s.DB.Where("session = ?", sessionID).
+			Assign(map[string]interface{}{"guid": guid[:]}).
+			FirstOrCreate(&replacementVoucher).Error
+	})
 }
 
 // ReplacementGUID retrieves the device GUID to persist at the end of TO2
@@ -147,8 +154,10 @@ func (s *State) SetReplacementHmac(ctx context.Context, hmac protocol.Hmac) erro
 		return fmt.Errorf("failed to marshal hmac: %w", err)
 	}
 
-	return s.DB.Model(&ReplacementVoucher{}).Where("session = ?", sessionID).
-		Update("hmac", hmacBytes).Error
+	return withRetry(ctx, func() error {
+		return s.DB.Model(&ReplacementVoucher{}).Where("session = ?", sessionID).
+			Update("hmac", hmacBytes).Error
+	})
 }
 
 // ReplacementHmac retrieves the voucher HMAC to persist at the end of TO2
@@ -197,7 +206,7 @@ func (s *State) SetXSession(ctx context.Context, suite kex.Suite, sess kex.Sessi
 		CBOR:    sessBytes,
 	}
 
-	return s.DB.Save(&keyExchange).Error
+	return withRetry(ctx, func() error { return s.DB.Save(&keyExchange).Error })
 }
 
 // XSession returns the current key exchange/encryption session
@@ -242,8 +251,10 @@ func (s *State) SetProveDeviceNonce(ctx context.Context, nonce protocol.Nonce) e
 		return err
 	}
 
-	return s.DB.Model(&TO2Session{}).Where("session = ?", sessionID).
-		Update("prove_device", nonce[:]).Error
+	return withRetry(ctx, func() error {
+		return s.DB.Model(&TO2Session{}).Where("session = ?", sessionID).
+			Update("prove_device", nonce[:]).Error
+	})
 }
 
 // ProveDeviceNonce returns the Nonce used in TO2.ProveDevice and TO2.Done
@@ -277,8 +288,10 @@ func (s *State) SetSetupDeviceNonce(ctx context.Context, nonce protocol.Nonce) e
 		return err
 	}
 
-	return s.DB.Model(&TO2Session{}).Where("session = ?", sessionID).
-		Update("setup_device", nonce[:]).Error
+	return withRetry(ctx, func() error {
+		return s.DB.Model(&TO2Session{}).Where("session = ?", sessionID).
+			Update("setup_device", nonce[:]).Error
+	})
 }
 
 // SetupDeviceNonce returns the Nonce used in TO2.SetupDevice and TO2.Done2
@@ -313,8 +326,27 @@ func (s *State) SetMTU(ctx context.Context, mtu uint16) error {
 	}
 
 	mtuInt := int(mtu)
-	return s.DB.Model(&TO2Session{}).Where("session = ?", sessionID).
-		Update("mtu", mtuInt).Error
+	return withRetry(ctx, func() error {
+		return s.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&TO2Session{}).Where("session = ?", sessionID).
+				Update("mtu", mtuInt).Error; err != nil {
+				return err
+			}
+
+			// Record the MTU against the device for the device inventory,
+			// to help debug service info chunking
+			var to2Session TO2Session
+			if err := tx.Where("session = ?", sessionID).First(&to2Session).Error; err != nil {
+				return err
+			}
+			if to2Session.GUID == nil {
+				return nil
+			}
+			return tx.Where(DeviceOnboarding{GUID: to2Session.GUID}).
+				Assign(DeviceOnboarding{MTU: &mtuInt}).
+				FirstOrCreate(&DeviceOnboarding{}).Error
+		})
+	})
 }
 
 // MTU returns the max service info size the device may receive
@@ -356,12 +388,34 @@ func (s *State) SetDevmod(ctx context.Context, devmod serviceinfo.Devmod, module
 		return fmt.Errorf("failed to marshal modules: %w", err)
 	}
 
-	return s.DB.Model(&TO2Session{}).Where("session = ?", sessionID).
-		Updates(map[string]interface{}{
-			"devmod":          devmodBytes,
-			"modules":         modulesBytes,
-			"devmod_complete": complete,
-		}).Error
+	return withRetry(ctx, func() error {
+		return s.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&TO2Session{}).Where("session = ?", sessionID).
+				Updates(map[string]interface{}{
+					"devmod":          devmodBytes,
+					"modules":         modulesBytes,
+					"devmod_complete": complete,
+				}).Error; err != nil {
+				return err
+			}
+			if len(devmod.Serial) == 0 && devmod.Os == "" && devmod.Arch == "" {
+				return nil
+			}
+
+			// Record the serial number against the device so it can be
+			// searched, and its platform for the device inventory
+			var to2Session TO2Session
+			if err := tx.Where("session = ?", sessionID).First(&to2Session).Error; err != nil {
+				return err
+			}
+			if to2Session.GUID == nil {
+				return nil
+			}
+			return tx.Where(DeviceOnboarding{GUID: to2Session.GUID}).
+				Assign(DeviceOnboarding{SerialNumber: utils.NormalizeSerial(devmod.Serial), OS: devmod.Os, Arch: devmod.Arch}).
+				FirstOrCreate(&DeviceOnboarding{}).Error
+		})
+	})
 }
 
 // Devmod returns the device info and module support
@@ -39,7 +39,7 @@ func (s *State) SetRVBlob(ctx context.Context, voucher *fdo.Voucher, to1d *cose.
 		Exp:     exp,
 	}
 
-	return s.DB.Save(&rvBlob).Error
+	return withRetry(ctx, func() error { return s.DB.Save(&rvBlob).Error })
 }
 
 // RVBlob returns the owner rendezvous blob for a device
@@ -88,7 +88,45 @@ func (s *State) NewVoucher(ctx context.Context, ov *fdo.Voucher) error {
 		UpdatedAt:  now,
 	}
 
-	return s.DB.Create(&voucher).Error
+	return withRetry(ctx, func() error { return s.DB.Create(&voucher).Error })
+}
+
+// MfgInfoRecorder stores the vouchers of DI like State, saving the
+// manufacturing info the device sent in DI with its voucher, in the same
+// transaction
+type MfgInfoRecorder struct {
+	*State
+}
+
+// AddVoucher stores the voucher of a newly initialized device with its
+// manufacturing info
+func (r MfgInfoRecorder) AddVoucher(ctx context.Context, ov *fdo.Voucher) error {
+	voucherBytes, err := cbor.Marshal(ov)
+	if err != nil {
+		return fmt.Errorf("failed to marshal voucher: %w", err)
+	}
+
+	now := time.Now()
+	voucher := Voucher{
+		GUID:       ov.Header.Val.GUID[:],
+		DeviceInfo: ov.Header.Val.DeviceInfo,
+		CBOR:       voucherBytes,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	sessionID, err := r.getSessionID(ctx)
+	if err != nil {
+		return err
+	}
+	return withRetry(ctx, func() error {
+		return r.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&voucher).Error; err != nil {
+				return err
+			}
+			return saveDeviceMfgInfo(tx, sessionID, ov.Header.Val.GUID)
+		})
+	})
 }
 
 // OwnerVoucherPersistentState implementation
@@ -109,11 +147,29 @@ func (s *State) AddVoucher(ctx context.Context, ov *fdo.Voucher) error {
 		UpdatedAt:  now,
 	}
 
-	return s.DB.Create(&voucher).Error
+	return withRetry(ctx, func() error { return s.DB.Create(&voucher).Error })
 }
 
 // ReplaceVoucher stores a new voucher, possibly deleting or marking the previous voucher as replaced
 func (s *State) ReplaceVoucher(ctx context.Context, guid protocol.GUID, ov *fdo.Voucher) error {
+	return s.replaceVoucher(ctx, guid, ov, false)
+}
+
+// OnboardingResetter replaces vouchers like State, forgetting the previous
+// onboarding of a device that onboards again: the records of the GUIDs it
+// replaced before are deleted in the transaction completing its TO2, so a
+// device failing TO2 keeps its previous onboarding.
+type OnboardingResetter struct {
+	*State
+}
+
+// ReplaceVoucher stores a new voucher, forgetting the GUIDs the previous
+// one replaced
+func (r OnboardingResetter) ReplaceVoucher(ctx context.Context, guid protocol.GUID, ov *fdo.Voucher) error {
+	return r.replaceVoucher(ctx, guid, ov, true)
+}
+
+func (s *State) replaceVoucher(ctx context.Context, guid protocol.GUID, ov *fdo.Voucher, reset bool) error {
 	voucherBytes, err := cbor.Marshal(ov)
 	if err != nil {
 		return fmt.Errorf("failed to marshal voucher: %w", err)
@@ -132,43 +188,88 @@ func (s *State) ReplaceVoucher(ctx context.Context, guid protocol.GUID, ov *fdo.
 	completedAt := time.Now()
 	replacement := DeviceOnboarding{GUID: guid[:], NewGUID: ov.Header.Val.GUID[:], TO2Completed: true, TO2CompletedAt: &completedAt}
 
-	return s.DB.Transaction(func(tx *gorm.DB) error {
-		// Delete the old voucher row (by original GUID), then create the new voucher
-		if err := tx.Where("guid = ?", guid[:]).Delete(&Voucher{}).Error; err != nil {
-			return err
-		}
-		if err := tx.Create(&voucher).Error; err != nil {
-			return err
-		}
-		// Update onboarding completion and new GUID
-		return tx.Where("guid = ?", guid[:]).
-			Assign(replacement).
-			FirstOrCreate(&DeviceOnboarding{}).Error
+	return withRetry(ctx, func() error {
+		return s.DB.Transaction(func(tx *gorm.DB) error {
+			// Delete the old voucher row (by original GUID), then create the new voucher
+			if err := tx.Where("guid = ?", guid[:]).Delete(&Voucher{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&voucher).Error; err != nil {
+				return err
+			}
+			if reset {
+				if err := tx.Where("new_guid = ? AND guid <> ?", guid[:], guid[:]).Delete(&DeviceOnboarding{}).Error; err != nil {
+					return err
+				}
+			}
+			// Update onboarding completion and new GUID
+			return tx.Where("guid = ?", guid[:]).
+				Assign(replacement).
+				FirstOrCreate(&DeviceOnboarding{}).Error
+		})
 	})
 }
 
 // RemoveVoucher untracks a voucher, possibly by deleting it or marking it as removed
 // TODO: we should mark the voucher as removed instead of deleting it
 func (s *State) RemoveVoucher(ctx context.Context, guid protocol.GUID) (*fdo.Voucher, error) {
+	return s.removeVoucher(ctx, guid, false)
+}
+
+// VoucherArchiver removes vouchers like State, moving each to the archive
+// of deleted vouchers instead of deleting it
+type VoucherArchiver struct {
+	*State
+}
+
+// RemoveVoucher untracks a voucher, keeping it in the archive
+func (a VoucherArchiver) RemoveVoucher(ctx context.Context, guid protocol.GUID) (*fdo.Voucher, error) {
+	return a.removeVoucher(ctx, guid, true)
+}
+
+func (s *State) removeVoucher(ctx context.Context, guid protocol.GUID, archive bool) (*fdo.Voucher, error) {
 	var ov fdo.Voucher
-	if err := s.DB.Transaction(func(tx *gorm.DB) error {
-		var voucher Voucher
-		if err := tx.Where("guid = ?", guid[:]).First(&voucher).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				return fdo.ErrNotFound
+	if err := withRetry(ctx, func() error {
+		return s.DB.Transaction(func(tx *gorm.DB) error {
+			var voucher Voucher
+			if err := tx.Where("guid = ?", guid[:]).First(&voucher).Error; err != nil {
+				if err == gorm.ErrRecordNotFound {
+					return fdo.ErrNotFound
+				}
+				return err
 			}
-			return err
-		}
-		// Parse the voucher before deleting
-		if err := cbor.Unmarshal(voucher.CBOR, &ov); err != nil {
-			return fmt.Errorf("failed to unmarshal voucher: %w", err)
-		}
-		// Delete the voucher
-		if err := tx.Where("guid = ?", guid[:]).Delete(&Voucher{}).Error; err != nil {
-			return err
-		}
-		// Delete the onboarding tracking row for this GUID (best-effort)
-		return tx.Where("guid = ?", guid[:]).Delete(&DeviceOnboarding{}).Error
+			// Parse the voucher before deleting
+			if err := cbor.Unmarshal(voucher.CBOR, &ov); err != nil {
+				return fmt.Errorf("failed to unmarshal voucher: %w", err)
+			}
+			if archive {
+				// A voucher archived before, then inserted again, is replaced
+				archived := ArchivedVoucher{
+					GUID:       voucher.GUID,
+					CBOR:       voucher.CBOR,
+					DeviceInfo: voucher.DeviceInfo,
+					CreatedAt:  voucher.CreatedAt,
+				}
+				if err := tx.Where("guid = ?", guid[:]).Delete(&ArchivedVoucher{}).Error; err != nil {
+					return err
+				}
+				if err := tx.Create(&archived).Error; err != nil {
+					return err
+				}
+			}
+			// Delete the voucher
+			if err := tx.Where("guid = ?", guid[:]).Delete(&Voucher{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("guid = ?", guid[:]).Delete(&VoucherQuarantine{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("guid = ?", guid[:]).Delete(&DeviceLabel{}).Error; err != nil {
+				return err
+			}
+			// Delete the onboarding tracking row for this GUID (best-effort)
+			return tx.Where("guid = ?", guid[:]).Delete(&DeviceOnboarding{}).Error
+		})
 	}); err != nil {
 		return nil, err
 	}
@@ -268,7 +369,7 @@ func (s *State) AddOwnerKey(keyType protocol.KeyType, key crypto.PrivateKey, cha
 		ownerKey.RsaBits = &rsaBits
 	}
 
-	return s.DB.Save(&ownerKey).Error
+	return withRetry(context.Background(), func() error { return s.DB.Save(&ownerKey).Error })
 }
 
 // AddManufacturerKey adds a manufacturer key to the database
@@ -303,7 +404,7 @@ func (s *State) AddManufacturerKey(keyType protocol.KeyType, key crypto.PrivateK
 		mfgKey.RsaBits = &rsaBits
 	}
 
-	return s.DB.Save(&mfgKey).Error
+	return withRetry(context.Background(), func() error { return s.DB.Save(&mfgKey).Error })
 }
 
 // ManufacturerKey returns the private key matching a given key type and optionally its certificate chain
@@ -6,6 +6,7 @@ package to0
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
@@ -14,6 +15,7 @@ import (
 	"github.com/fido-device-onboard/go-fdo-server/internal/db"
 	"github.com/fido-device-onboard/go-fdo-server/internal/tls"
 	"github.com/fido-device-onboard/go-fdo/protocol"
+	"gorm.io/gorm"
 )
 
 // to0Client is the minimal interface used from the TO0 client.
@@ -30,7 +32,10 @@ var (
 	fetchOwnerInfo = db.FetchOwnerInfo
 )
 
-func RegisterRvBlob(rvInfo [][]protocol.RvInstruction, to0Guid string, voucherState fdo.OwnerVoucherPersistentState, keyState fdo.OwnerKeyPersistentState, insecureTLS bool, defaultTTL uint32) (uint32, error) { // Parse to0-guid flag
+// RegisterRvBlob registers the TO2 addresses of the owner for the device with
+// the first rendezvous server of rvInfo accepting them. The addresses are
+// those of the owner info, or defaultTO2Addrs when no owner info was set.
+func RegisterRvBlob(rvInfo [][]protocol.RvInstruction, to0Guid string, voucherState fdo.OwnerVoucherPersistentState, keyState fdo.OwnerKeyPersistentState, insecureTLS bool, defaultTTL uint32, defaultTO2Addrs []protocol.RvTO2Addr) (uint32, error) { // Parse to0-guid flag
 	guidBytes, err := hex.DecodeString(to0Guid)
 	if err != nil {
 		return 0, fmt.Errorf("error parsing hex GUID of device to register RV blob: %w", err)
@@ -43,6 +48,9 @@ func RegisterRvBlob(rvInfo [][]protocol.RvInstruction, to0Guid string, voucherSt
 
 	// Retrieve owner info from DB
 	to2Addrs, err := fetchOwnerInfo()
+	if errors.Is(err, gorm.ErrRecordNotFound) && len(defaultTO2Addrs) > 0 {
+		to2Addrs, err = defaultTO2Addrs, nil
+	}
 	if err != nil {
 		return 0, fmt.Errorf("error fetching ownerinfo: %w", err)
 	}
@@ -52,6 +60,7 @@ func RegisterRvBlob(rvInfo [][]protocol.RvInstruction, to0Guid string, voucherSt
 		return 0, fmt.Errorf("no RV info found that is usable for the owner")
 	}
 
+	var errs []error
 	for _, rv := range ownerRvInfo {
 		if len(rv.URLs) == 0 {
 			slog.Error("no usable rendezvous URLs were found for RV directive", "rv", rv)
@@ -63,11 +72,15 @@ func RegisterRvBlob(rvInfo [][]protocol.RvInstruction, to0Guid string, voucherSt
 			)
 			if err != nil {
 				slog.Error("failed registering 'RVTO2Addr' to rendezvous server", "url", url.String(), "error", err)
+				errs = append(errs, fmt.Errorf("%s: %w", url, err))
 				continue
 			}
 			slog.Info("successfully registered 'RVTO2Addr' to rendezvous server", "url", url.String(), "duration", time.Duration(refresh)*time.Second)
 			return refresh, nil
 		}
 	}
+	if len(errs) > 0 {
+		return 0, fmt.Errorf("unable to register any 'RVTO2Addr' URL for guid='%x': %w", guid, errors.Join(errs...))
+	}
 	return 0, fmt.Errorf("unable to register any 'RVTO2Addr' URL for guid='%x'", guid)
 }
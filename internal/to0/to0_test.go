@@ -9,15 +9,18 @@ import (
 	"github.com/fido-device-onboard/go-fdo"
 	"github.com/fido-device-onboard/go-fdo/cbor"
 	"github.com/fido-device-onboard/go-fdo/protocol"
+	"gorm.io/gorm"
 )
 
 type countingClient struct {
 	calls     int
 	succeedOn int
+	to2Addrs  []protocol.RvTO2Addr
 }
 
 func (c *countingClient) RegisterBlob(ctx context.Context, transport fdo.Transport, guid protocol.GUID, to2Addrs []protocol.RvTO2Addr) (uint32, error) {
 	c.calls++
+	c.to2Addrs = to2Addrs
 	if c.calls == c.succeedOn {
 		return 123, nil
 	}
@@ -67,7 +70,7 @@ func TestRegisterRvBlob_BreaksAfterFirstSuccess(t *testing.T) {
 	defer func() { newTO0Client = oldNew }()
 
 	// Act
-	refresh, err := RegisterRvBlob(rvInfo, "00112233445566778899aabbccddeeff", nil, nil, false, 300)
+	refresh, err := RegisterRvBlob(rvInfo, "00112233445566778899aabbccddeeff", nil, nil, false, 300, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -80,3 +83,43 @@ func TestRegisterRvBlob_BreaksAfterFirstSuccess(t *testing.T) {
 		t.Fatalf("expected 2 RegisterBlob calls, got %d", cc.calls)
 	}
 }
+
+func TestRegisterRvBlob_DefaultTO2Addrs(t *testing.T) {
+	dns, _ := cbor.Marshal("rv.example.com")
+	rvInfo := [][]protocol.RvInstruction{{{Variable: protocol.RVDns, Value: dns}}}
+
+	oldFetch := fetchOwnerInfo
+	fetchOwnerInfo = func() ([]protocol.RvTO2Addr, error) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	defer func() { fetchOwnerInfo = oldFetch }()
+
+	oldMakeTransport := makeTransport
+	makeTransport = func(baseURL string, _ *tls.Config, _ bool) fdo.Transport { return nil }
+	defer func() { makeTransport = oldMakeTransport }()
+
+	cc := &countingClient{succeedOn: 1}
+	oldNew := newTO0Client
+	newTO0Client = func(v fdo.OwnerVoucherPersistentState, k fdo.OwnerKeyPersistentState, defaultTTL uint32) to0Client {
+		return cc
+	}
+	defer func() { newTO0Client = oldNew }()
+
+	// Without owner info nor default addresses there is nothing to register
+	if _, err := RegisterRvBlob(rvInfo, "00112233445566778899aabbccddeeff", nil, nil, false, 300, nil); err == nil {
+		t.Fatal("expected an error without owner info")
+	}
+	if cc.calls != 0 {
+		t.Fatalf("expected no RegisterBlob call, got %d", cc.calls)
+	}
+
+	// The default addresses are registered when no owner info was set
+	host := "owner.example.com"
+	defaults := []protocol.RvTO2Addr{{DNSAddress: &host, Port: 9443, TransportProtocol: protocol.HTTPSTransport}}
+	if _, err := RegisterRvBlob(rvInfo, "00112233445566778899aabbccddeeff", nil, nil, false, 300, defaults); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cc.to2Addrs) != 1 || *cc.to2Addrs[0].DNSAddress != host || cc.to2Addrs[0].Port != 9443 {
+		t.Fatalf("unexpected TO2 addresses registered: %+v", cc.to2Addrs)
+	}
+}
@@ -4,7 +4,11 @@
 package utils
 
 import (
+	"encoding/hex"
 	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 func IsValidGUID(guidHex string) bool {
@@ -12,3 +16,26 @@ func IsValidGUID(guidHex string) bool {
 	re := regexp.MustCompile("^[a-fA-F0-9]{32}$")
 	return re.MatchString(guidHex)
 }
+
+// NormalizeSerial returns the canonical representation of a devmod serial
+// number, which a device may send as either a tstr or a bstr. Serials made
+// up of printable UTF-8 are kept as text, anything else is hex encoded.
+func NormalizeSerial(serial []byte) string {
+	if utf8.Valid(serial) && strings.IndexFunc(string(serial), func(r rune) bool { return !unicode.IsPrint(r) }) < 0 {
+		return string(serial)
+	}
+	return hex.EncodeToString(serial)
+}
+
+// SerialQueryCandidates returns the canonical serial numbers that a search
+// term may refer to: the term itself as text and, if it is hex, the
+// normalized form of the bytes it encodes.
+func SerialQueryCandidates(query string) []string {
+	candidates := []string{query}
+	if b, err := hex.DecodeString(query); err == nil && len(b) > 0 {
+		if normalized := NormalizeSerial(b); normalized != query {
+			candidates = append(candidates, normalized)
+		}
+	}
+	return candidates
+}
@@ -2,3 +2,11 @@ package version
 
 // VERSION is the build version, set at build time using -ldflags.
 var VERSION = "unknown"
+
+// COMMIT and BUILD_DATE identify the source revision and the time of the
+// build. They are set at build time using -ldflags; when unset, the VCS
+// information embedded by the Go toolchain is used instead.
+var (
+	COMMIT     = ""
+	BUILD_DATE = ""
+)
@@ -48,7 +48,7 @@ verify_uploads() {
 get_device_guid() {
   local owner_url=$1
   local guid=$2
-  local device_guid=$(curl -s "${owner_url}/api/v1/owner/devices?old_guid=${guid}" | jq -r '.[0].guid')
+  local device_guid=$(curl -s "${owner_url}/api/v1/owner/devices?old_guid=${guid}" | jq -r '.devices[0].guid')
   echo "${device_guid}"
 }
 